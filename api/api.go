@@ -1,29 +1,48 @@
 package api
 
 import (
+	"context"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/tahminator/go-react-template/api/auth"
 	"github.com/tahminator/go-react-template/api/file"
 	"github.com/tahminator/go-react-template/api/gemini"
 	"github.com/tahminator/go-react-template/api/github"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
+	"github.com/tahminator/go-react-template/database/repository/merge_session"
 	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
 	"github.com/tahminator/go-react-template/database/repository/session"
 	"github.com/tahminator/go-react-template/database/repository/user"
-	"google.golang.org/genai"
+	"github.com/tahminator/go-react-template/llm"
+	"github.com/tahminator/go-react-template/mergesession"
 )
 
-func NewRouter(eng *gin.Engine, db *pgxpool.Pool, geminiClient *genai.Client) *gin.RouterGroup {
+func NewRouter(eng *gin.Engine, db *pgxpool.Pool, provider llm.LLMProvider, embedder rag.Embedder, weights rag.RetrievalWeights) *gin.RouterGroup {
 	r := eng.Group("/api")
 
 	userRepository := user.NewPostgresUserRepository(db)
 	sessionRepository := session.NewPostgresSessionRepository(db)
-	repoChunksRepository := repo_chunks.NewPostgresRepoChunksRepository(db)
+	repoChunksRepository := repo_chunks.NewPostgresRepoChunksRepository(db, embedder)
+	repoIndexRepository := repo_index.NewPostgresRepoIndexRepository(db)
+	repoSymbolsRepository := repo_symbols.NewPostgresRepoSymbolsRepository(db)
+	resolutionCacheRepository := resolution_cache.NewPostgresResolutionCacheRepository(db)
+	acceptedResolutionsRepository := accepted_resolutions.NewPostgresAcceptedResolutionsRepository(db, embedder)
+	mergeSessionRepository := merge_session.NewPostgresMergeSessionRepository(db)
+
+	retriever := rag.NewRetriever(repoChunksRepository, weights)
+	indexer := rag.NewIndexer(embedder, repoChunksRepository, repoIndexRepository, repoSymbolsRepository)
+	indexer.Start(context.Background(), rag.DefaultIndexWorkers)
+	mergeSessions := mergesession.NewManager(mergeSessionRepository, indexer)
 
 	auth.NewRouter(r, userRepository, sessionRepository)
-	gemini.NewRouter(r, geminiClient, repoChunksRepository)
-	github.NewRouter(r, userRepository, sessionRepository)
-	file.NewRouter(r, userRepository, sessionRepository)
+	gemini.NewRouter(r, userRepository, sessionRepository, provider, repoChunksRepository, retriever, repoSymbolsRepository, repoIndexRepository, resolutionCacheRepository, acceptedResolutionsRepository)
+	github.NewRouter(r, userRepository, sessionRepository, indexer, acceptedResolutionsRepository, mergeSessions)
+	file.NewRouter(r, userRepository, sessionRepository, mergeSessions)
 
 	return r
 }
@@ -1,10 +1,12 @@
 package file
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -13,6 +15,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/tahminator/go-react-template/database/repository/session"
 	"github.com/tahminator/go-react-template/database/repository/user"
+	"github.com/tahminator/go-react-template/gitrepo"
+	"github.com/tahminator/go-react-template/mergesession"
 	"github.com/tahminator/go-react-template/utils"
 )
 
@@ -60,6 +64,7 @@ const (
 func NewRouter(eng *gin.RouterGroup,
 	userRepository user.UserRepository,
 	sessionRepository session.SessionRepository,
+	mergeSessions *mergesession.Manager,
 ) *gin.RouterGroup {
 	r := eng.Group("/file")
 
@@ -74,6 +79,8 @@ func NewRouter(eng *gin.RouterGroup,
 		c.Next()
 	})
 
+	// --- GET /file/data/{owner}/{repo}/{file}?sessionId=...
+	// sessionId reads the file from a merge session's worktree
 	r.GET("/data/*path", func(c *gin.Context) {
 		ao := c.MustGet("ao").(*utils.AuthenticationObject)
 
@@ -82,8 +89,10 @@ func NewRouter(eng *gin.RouterGroup,
 			relPath = relPath[1:]
 		}
 
-		base := filepath.Join("repos", ao.User.Id.String())
-		fullPath := filepath.Join(base, relPath)
+		fullPath, _, ok := dataPath(c, mergeSessions, ao.User.Id, relPath, false)
+		if !ok {
+			return
+		}
 
 		data, err := os.ReadFile(fullPath)
 		if err != nil {
@@ -94,6 +103,7 @@ func NewRouter(eng *gin.RouterGroup,
 		c.Data(http.StatusOK, "text/plain; charset=utf-8", data)
 	})
 
+	// --- POST /file/data/{owner}/{repo}/{file}?sessionId=...
 	r.POST("/data/*path", func(c *gin.Context) {
 		type Req struct {
 			Content string `json:"content"`
@@ -111,8 +121,11 @@ func NewRouter(eng *gin.RouterGroup,
 			relPath = relPath[1:]
 		}
 
-		base := filepath.Join("repos", ao.User.Id.String())
-		fullPath := filepath.Join(base, relPath)
+		fullPath, unlock, ok := dataPath(c, mergeSessions, ao.User.Id, relPath, true)
+		if !ok {
+			return
+		}
+		defer unlock()
 		permissions := os.FileMode(0o644)
 
 		err := os.WriteFile(fullPath, []byte(body.Content), permissions)
@@ -124,8 +137,59 @@ func NewRouter(eng *gin.RouterGroup,
 		c.JSON(http.StatusOK, utils.Success("ok", gin.H{}))
 	})
 
+	// --- GET /file/conflict/*path?repoName=...&sessionId=...
+	// path is relative to the repo root. The sides are read from the index,
+	// so they survive edits to the working-tree file. sessionId reads them
+	// from a merge session's worktree.
+	r.GET("/conflict/*path", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+		repoName := strings.TrimSpace(c.Query("repoName"))
+		if repoName == "" || strings.ContainsAny(repoName, `/\`) || repoName == "." || repoName == ".." {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+			return
+		}
+		relPath := path.Clean(strings.TrimPrefix(c.Param("path"), "/"))
+		if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "path must be a safe relative path"})
+			return
+		}
+
+		ghUsername, err := getGithubUsername(c, userRepository, ao.User.Id)
+		if err != nil || ghUsername == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "github username not set for user"})
+			return
+		}
+
+		dir, _, err := mergeSessions.Checkout(c.Request.Context(), ao.User.Id, ghUsername, repoName, c.Query("sessionId"))
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		repo, err := gitrepo.Open(dir)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "repo not found on disk"})
+			return
+		}
+
+		conflict, err := gitrepo.ReadConflict(c.Request.Context(), repo, relPath)
+		if errors.Is(err, gitrepo.ErrNotConflicted) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file is not conflicted"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read conflict", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("ok", conflict))
+	})
+
+	// --- GET /file/tree/generate?repoName=...&sessionId=...
+	// sessionId reads the tree of a merge session's worktree instead of the
+	// clone itself.
 	r.GET("/tree/generate", func(c *gin.Context) {
-		handleGetFileTree(c, userRepository)
+		handleGetFileTree(c, userRepository, mergeSessions)
 	})
 
 	return r
@@ -143,7 +207,7 @@ func getGithubUsername(c *gin.Context, userRepository user.UserRepository, userI
 	return strings.TrimSpace(*u.GithubUsername), nil
 }
 
-func handleGetFileTree(c *gin.Context, userRepository user.UserRepository) {
+func handleGetFileTree(c *gin.Context, userRepository user.UserRepository, mergeSessions *mergesession.Manager) {
 	ao := c.MustGet("ao").(*utils.AuthenticationObject)
 	userIDStr := ao.User.Id.String()
 	repoName := strings.TrimSpace(c.Query("repoName"))
@@ -173,9 +237,17 @@ func handleGetFileTree(c *gin.Context, userRepository user.UserRepository) {
 		return
 	}
 
-	// 2) Resolve repo path on disk: repos/{userId}/{githubUsername}/{repoName}
+	// 2) Resolve repo path on disk: repos/{userId}/{githubUsername}/{repoName},
+	// or the worktree of the merge session asked for
+	repoPath, mergeSession, err := mergeSessions.Checkout(c.Request.Context(), userID, ghUsername, repoName, c.Query("sessionId"))
+	if err != nil {
+		c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 	base := filepath.Join("repos", userID.String())
-	repoPath := filepath.Join(base, ghUsername, repoName)
+	if mergeSession != nil {
+		base = filepath.Dir(mergeSession.Worktree)
+	}
 
 	// Security: clean and ensure inside base
 	cleanRepoPath := filepath.Clean(repoPath)
@@ -199,50 +271,17 @@ func handleGetFileTree(c *gin.Context, userRepository user.UserRepository) {
 		return
 	}
 
-	// ------------------------------
-	// GIT FLOW: fetch -> merge -> conflict handling (or force conflict mode)
-	// ------------------------------
-
-	// git fetch
-	if code, _, errOut, err := utils.RunCommand(fmt.Sprintf(`git -C %q fetch`, cleanRepoPath)); err != nil || code != 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "git fetch failed", "details": errOut})
+	// The tree is a pure read: merges are started by merge sessions, and
+	// only a merge, rebase, cherry-pick or revert in progress has conflicts
+	// to report.
+	repo, err := gitrepo.Open(cleanRepoPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open repository"})
 		return
 	}
-
-	// Try a straight merge first
-	code, _, _, _ := utils.RunCommand(fmt.Sprintf(`git -C %q merge`, cleanRepoPath))
 	conflictedMap := map[string]bool{}
-
-	// Always decide based on actual merge state (MERGE_HEAD), not only exit code.
-	if isMidMerge(cleanRepoPath) {
-		// We are in merge-conflict mode → collect conflicted files
-		conflictedMap = collectConflicts(cleanRepoPath)
-	} else if code == 0 {
-		// Clean merge; nothing conflicted
-	} else {
-		// Not in merge mode and merge didn't succeed cleanly → force-conflict path
-
-		// 1) Find incoming files
-		changed := getChangedFiles(cleanRepoPath) // HEAD..FETCH_HEAD
-		paths := splitNonEmptyLines(changed)
-
-		// 2) Stage those paths (if any)
-		for _, p := range paths {
-			utils.RunCommand(fmt.Sprintf(`git -C %q add -- %q`, cleanRepoPath, p))
-		}
-
-		// 3) Marker commit (only if we added something)
-		if len(paths) > 0 {
-			utils.RunCommand(fmt.Sprintf(`git -C %q commit -m %q`, cleanRepoPath, "This commit is a merge conflict"))
-		}
-
-		// 4) Attempt merge to trigger conflicts
-		utils.RunCommand(fmt.Sprintf(`git -C %q merge`, cleanRepoPath))
-
-		// 5) If we are now in merge mode, collect conflicts
-		if isMidMerge(cleanRepoPath) {
-			conflictedMap = collectConflicts(cleanRepoPath)
-		}
+	if inProgress(c.Request.Context(), repo) {
+		conflictedMap = collectConflicts(c.Request.Context(), repo)
 	}
 
 	// 3) Build **children** of the repo root (array), conflict-aware
@@ -256,6 +295,57 @@ func handleGetFileTree(c *gin.Context, userRepository user.UserRepository) {
 	c.JSON(http.StatusOK, children)
 }
 
+// dataPath maps a /data path, {owner}/{repo}/{file}, to disk, writing the
+// error response itself if it cannot. With a sessionId query the file is in
+// the session's worktree rather than the clone. For a write the checkout is
+// locked as by LockCheckout until the returned unlock is called; for a read
+// unlock does nothing.
+func dataPath(c *gin.Context, mergeSessions *mergesession.Manager, userID uuid.UUID, relPath string, write bool) (string, func(), bool) {
+	sessionId := strings.TrimSpace(c.Query("sessionId"))
+	if sessionId == "" && !write {
+		return filepath.Join("repos", userID.String(), relPath), func() {}, true
+	}
+
+	parts := strings.SplitN(path.Clean(relPath), "/", 3)
+	if len(parts) < 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path must be owner/repo/file"})
+		return "", nil, false
+	}
+	var dir string
+	unlock := func() {}
+	var err error
+	if write {
+		dir, _, unlock, err = mergeSessions.LockCheckout(c.Request.Context(), userID, parts[0], parts[1], sessionId)
+	} else {
+		dir, _, err = mergeSessions.Checkout(c.Request.Context(), userID, parts[0], parts[1], sessionId)
+	}
+	if err != nil {
+		c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+		return "", nil, false
+	}
+	fullPath := filepath.Join(dir, parts[2])
+	sep := string(os.PathSeparator)
+	if !strings.HasPrefix(fullPath+sep, filepath.Clean(dir)+sep) {
+		unlock()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path escapes the repo root"})
+		return "", nil, false
+	}
+	return fullPath, unlock, true
+}
+
+func checkoutErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, mergesession.ErrInvalidSession):
+		return http.StatusBadRequest
+	case errors.Is(err, mergesession.ErrNoSession):
+		return http.StatusNotFound
+	case errors.Is(err, mergesession.ErrFinished):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // buildRepoChildren returns the mixed list of files/dirs directly under repo root
 func buildRepoChildren(repoAbsPath string, conflicted map[string]bool) ([]any, error) {
 	entries, err := os.ReadDir(repoAbsPath)
@@ -278,8 +368,8 @@ func buildRepoChildren(repoAbsPath string, conflicted map[string]bool) ([]any, e
 	var children []any
 
 	for _, entry := range entries {
-		// Skip .git
-		if entry.IsDir() && entry.Name() == ".git" {
+		// Skip .git, a file in a merge session's worktree
+		if entry.Name() == ".git" {
 			continue
 		}
 
@@ -337,8 +427,8 @@ func buildDirectoryTree(absPath string, relPath string, conflicted map[string]bo
 	})
 
 	for _, entry := range entries {
-		// Skip .git directory to avoid noise/size
-		if entry.IsDir() && entry.Name() == ".git" {
+		// Skip .git to avoid noise/size
+		if entry.Name() == ".git" {
 			continue
 		}
 
@@ -418,68 +508,23 @@ func mapExtToCodeExtension(filename string) CodeExtension {
 	}
 }
 
-// getGitDir returns the absolute path to the repo's git dir (handles worktrees)
-func getGitDir(repoPath string) (string, error) {
-	_, out, _, err := utils.RunCommand(fmt.Sprintf(`git -C %q rev-parse --git-dir`, repoPath))
-	if err != nil {
-		return "", err
-	}
-	dir := strings.TrimSpace(out)
-	if dir == "" {
-		return "", os.ErrNotExist
-	}
-	// rev-parse may return a relative path like ".git"
-	if !filepath.IsAbs(dir) {
-		dir = filepath.Join(repoPath, dir)
-	}
-	return filepath.Clean(dir), nil
-}
-
-// isMidMerge returns true iff $GIT_DIR/MERGE_HEAD exists
-func isMidMerge(repoPath string) bool {
-	gitDir, err := getGitDir(repoPath)
-	if err != nil {
-		return false
-	}
-	mergeHead := filepath.Join(gitDir, "MERGE_HEAD")
-	if st, err := os.Stat(mergeHead); err == nil && !st.IsDir() {
-		return true
-	}
-	return false
-}
-
-// getChangedFiles returns newline-separated files for HEAD..FETCH_HEAD (string)
-func getChangedFiles(repoPath string) string {
-	_, out, _, _ := utils.RunCommand(fmt.Sprintf(`git -C %q diff --name-only HEAD..FETCH_HEAD`, repoPath))
-	return strings.TrimSpace(out)
+// inProgress returns true iff a merge, rebase, cherry-pick or revert is in
+// progress
+func inProgress(ctx context.Context, repo gitrepo.GitRepo) bool {
+	state, err := repo.InProgress(ctx)
+	return err == nil && state != nil
 }
 
 // collectConflicts returns a set of conflicted file paths (relative to repo root)
-func collectConflicts(repoPath string) map[string]bool {
+func collectConflicts(ctx context.Context, repo gitrepo.GitRepo) map[string]bool {
 	m := map[string]bool{}
-	_, out, _, _ := utils.RunCommand(fmt.Sprintf(`git -C %q diff --name-only --diff-filter=U`, repoPath))
-	for _, line := range splitNonEmptyLines(out) {
-		rel := filepath.ToSlash(strings.TrimSpace(line))
-		if rel != "" {
-			m[rel] = true
-		}
-	}
-	return m
-}
-
-// splitNonEmptyLines trims and splits a blob into non-empty lines
-func splitNonEmptyLines(s string) []string {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return nil
+	entries, err := repo.Unmerged(ctx)
+	if err != nil {
+		log.Printf("failed to read unmerged paths of %s: %v", repo.Path(), err)
+		return m
 	}
-	lines := strings.Split(s, "\n")
-	out := make([]string, 0, len(lines))
-	for _, ln := range lines {
-		ln = strings.TrimSpace(ln)
-		if ln != "" {
-			out = append(out, ln)
-		}
+	for _, e := range entries {
+		m[e.Path] = true
 	}
-	return out
+	return m
 }
@@ -0,0 +1,276 @@
+package gemini
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPromptBudget is the prompt size in tokens when a request does not
+	// set one. It leaves room for the response in the context windows of the
+	// models we deploy.
+	DefaultPromptBudget = 32000
+	MinPromptBudget     = 1000
+
+	// an item cut to fit keeps at least this many tokens, otherwise it is
+	// dropped instead
+	minTruncatedTokens = 64
+)
+
+// ErrPromptTooLarge is returned when the sections a prompt cannot do without
+// already exceed its budget.
+var ErrPromptTooLarge = errors.New("prompt does not fit the budget")
+
+// Sections of a prompt, as reported in PromptStats.
+const (
+	SectionInstructions     = "instructions"
+	SectionConflict         = "conflict"
+	SectionNearby           = "nearby_context"
+	SectionRetrieved        = "retrieved"
+	SectionCrossReferences  = "cross_references"
+	SectionHistory          = "history"
+	SectionAcceptedExamples = "accepted_examples"
+)
+
+// Shares of the budget left after the required sections. A section that
+// needs less than its share passes the rest on to the others.
+const (
+	shareNearby           = 0.3
+	shareRetrieved        = 0.45
+	shareCrossReferences  = 0.25
+	shareAcceptedExamples = 0.2
+)
+
+// EstimateTokens approximates a tokenizer at four characters per token, which
+// is close for English and code and errs high for dense identifiers.
+func EstimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+type PromptSection struct {
+	Name      string `json:"name"`
+	Tokens    int    `json:"tokens"`
+	Budget    int    `json:"budget"`
+	Items     int    `json:"items"`
+	Dropped   int    `json:"dropped"`
+	Duplicate int    `json:"duplicate"`
+	Truncated bool   `json:"truncated"`
+}
+
+// PromptStats describes how a prompt was assembled. Tokens can exceed Budget
+// only when the required sections alone do.
+type PromptStats struct {
+	Budget   int             `json:"budget"`
+	Tokens   int             `json:"tokens"`
+	Sections []PromptSection `json:"sections"`
+}
+
+// span is the part of a file an item shows. Items with no source are never
+// treated as overlapping.
+type span struct {
+	source     string
+	start, end int
+}
+
+func (s span) overlaps(o span) bool {
+	return s.source != "" && s.source == o.source && s.start <= o.end && o.start <= s.end
+}
+
+type promptItem struct {
+	text string
+	span span
+}
+
+type promptSection struct {
+	name      string
+	header    string
+	share     float64 // 0 for required sections
+	items     []promptItem
+	duplicate int
+	builder   *promptBuilder
+}
+
+// promptBuilder assembles a prompt from sections within a token budget.
+// Required sections are always included in full. Optional sections share what
+// is left; their items are added best first and the tail is dropped, or cut
+// short, once a section runs out of budget. Items that show a span already in
+// the prompt, or repeat an earlier item, are skipped when added.
+type promptBuilder struct {
+	budget   int
+	sections []*promptSection
+	claimed  []span
+	seen     map[string]bool
+}
+
+func newPromptBuilder(budget int) *promptBuilder {
+	if budget <= 0 {
+		budget = DefaultPromptBudget
+	}
+	return &promptBuilder{
+		budget: max(budget, MinPromptBudget),
+		seen:   map[string]bool{},
+	}
+}
+
+// required adds a section that is never truncated. Its spans are claimed so
+// optional items repeating them are skipped.
+func (b *promptBuilder) required(name, text string, spans ...span) {
+	s := &promptSection{name: name, builder: b}
+	s.items = []promptItem{{text: text}}
+	b.sections = append(b.sections, s)
+	b.claimed = append(b.claimed, spans...)
+}
+
+// section adds an optional section; header is written before its items if
+// any are kept.
+func (b *promptBuilder) section(name, header string, share float64) *promptSection {
+	s := &promptSection{name: name, header: header, share: share, builder: b}
+	b.sections = append(b.sections, s)
+	return s
+}
+
+// add appends an item, lower ranked than those before it. It reports false
+// if the item was skipped as a duplicate.
+func (s *promptSection) add(text string, sp span) bool {
+	b := s.builder
+	if b.seen[text] {
+		s.duplicate++
+		return false
+	}
+	for _, c := range b.claimed {
+		if c.overlaps(sp) {
+			s.duplicate++
+			return false
+		}
+	}
+	b.seen[text] = true
+	b.claimed = append(b.claimed, sp)
+	s.items = append(s.items, promptItem{text: text, span: sp})
+	return true
+}
+
+func (s *promptSection) want() int {
+	if len(s.items) == 0 {
+		return 0
+	}
+	n := EstimateTokens(s.header)
+	for _, item := range s.items {
+		n += EstimateTokens(item.text)
+	}
+	return n
+}
+
+func (b *promptBuilder) build() (string, PromptStats) {
+	stats := PromptStats{Budget: b.budget}
+
+	// required sections come off the top; the rest is split by share
+	remaining := b.budget
+	totalShare := 0.0
+	for _, s := range b.sections {
+		if s.share == 0 {
+			remaining -= s.want()
+		} else if len(s.items) > 0 {
+			totalShare += s.share
+		}
+	}
+	remaining = max(remaining, 0)
+
+	alloc := make([]int, len(b.sections))
+	left := remaining
+	for i, s := range b.sections {
+		if s.share == 0 || len(s.items) == 0 {
+			continue
+		}
+		alloc[i] = min(s.want(), int(float64(remaining)*s.share/totalShare))
+		left -= alloc[i]
+	}
+	for i, s := range b.sections {
+		if s.share == 0 || left <= 0 {
+			continue
+		}
+		extra := min(s.want()-alloc[i], left)
+		alloc[i] += extra
+		left -= extra
+	}
+
+	var sb strings.Builder
+	for i, s := range b.sections {
+		stat := PromptSection{Name: s.name, Duplicate: s.duplicate}
+		if s.share == 0 {
+			for _, item := range s.items {
+				sb.WriteString(item.text)
+			}
+			stat.Tokens = s.want()
+			stat.Budget = stat.Tokens
+			stat.Items = len(s.items)
+		} else {
+			stat.Budget = alloc[i]
+			text, kept, truncated := s.fit(alloc[i])
+			sb.WriteString(text)
+			stat.Tokens = EstimateTokens(text)
+			stat.Items = kept
+			stat.Dropped = len(s.items) - kept
+			stat.Truncated = truncated
+		}
+		stats.Tokens += stat.Tokens
+		stats.Sections = append(stats.Sections, stat)
+	}
+
+	return sb.String(), stats
+}
+
+// fit renders as many items as budget allows, cutting the first item that
+// does not fit at a line boundary when enough budget is left for it to be
+// useful. It reports how many items were kept, including a cut one.
+func (s *promptSection) fit(budget int) (string, int, bool) {
+	if len(s.items) == 0 {
+		return "", 0, false
+	}
+	used := EstimateTokens(s.header)
+	if used >= budget {
+		return "", 0, false
+	}
+
+	var sb strings.Builder
+	sb.WriteString(s.header)
+	kept := 0
+	for _, item := range s.items {
+		cost := EstimateTokens(item.text)
+		if used+cost <= budget {
+			sb.WriteString(item.text)
+			used += cost
+			kept++
+			continue
+		}
+		if budget-used >= minTruncatedTokens {
+			sb.WriteString(truncateToTokens(item.text, budget-used))
+			return sb.String(), kept + 1, true
+		}
+		break
+	}
+	if kept == 0 {
+		return "", 0, false
+	}
+	return sb.String(), kept, false
+}
+
+const truncationNote = "... (truncated)\n\n"
+
+// truncateToTokens cuts text to about tokens, ending on a whole line.
+func truncateToTokens(text string, tokens int) string {
+	limit := (tokens - EstimateTokens(truncationNote)) * 4
+	if limit <= 0 {
+		return ""
+	}
+	cut := 0
+	for i, r := range text {
+		if i >= limit {
+			break
+		}
+		if r == '\n' {
+			cut = i + 1
+		}
+	}
+	return text[:cut] + truncationNote
+}
@@ -0,0 +1,194 @@
+package gemini
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
+	"github.com/tahminator/go-react-template/database/repository/user"
+)
+
+// ErrNotRepoOwner is returned when someone other than the repo's owner tries
+// to purge its shared cache.
+var ErrNotRepoOwner = errors.New("only the repo's owner may purge its resolution cache")
+
+// CacheMaxAge is how long a cached resolution is kept without being hit.
+// Entries a changed prompt template or model can no longer hit are evicted
+// once it passes.
+const CacheMaxAge = 30 * 24 * time.Hour
+
+// promptVersion identifies the hunk prompt template in use, with the note on
+// the operation's sides if any. It is part of every fingerprint, so editing a
+// template makes what it produced miss until CacheMaxAge evicts it.
+func promptVersion(opts HunkOptions) string {
+	template := HunkPrompt
+	if opts.Structured {
+		template = StructuredHunkPrompt
+	}
+	if note := operationSides[opts.Operation]; note != "" {
+		template += note
+	}
+	sum := sha256.Sum256([]byte(template))
+	return hex.EncodeToString(sum[:8])
+}
+
+// modelName is the provider and model a request generates with, falling back
+// to the provider's default when the request did not pick one.
+func (gs *GeminiService) modelName() string {
+	model := gs.options.Model
+	if m, ok := gs.provider.(interface{ DefaultModel() string }); ok && model == "" {
+		model = m.DefaultModel()
+	}
+	return gs.provider.Name() + "/" + model
+}
+
+// hunkFingerprint keys a hunk's resolution. Sides are compared after
+// normalizeHunkText, the language is the file extension, and the user's
+// request is included since it changes what a correct answer is.
+func hunkFingerprint(h *conflict.Hunk, filePath, userQuery, version, model string) string {
+	language := strings.ToLower(path.Ext(filePath))
+	if language == "" {
+		language = path.Base(filePath)
+	}
+	base := ""
+	if h.HasBase {
+		base = normalizeHunkText(h.Base)
+	}
+
+	hash := sha256.New()
+	// length-prefixed so no two different tuples hash the same input
+	for _, part := range []string{
+		normalizeHunkText(h.Ours),
+		fmt.Sprint(h.HasBase), base,
+		normalizeHunkText(h.Theirs),
+		language,
+		strings.TrimSpace(userQuery),
+		version,
+		model,
+	} {
+		fmt.Fprintf(hash, "%d:%s;", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// normalizeHunkText ignores line endings, trailing whitespace and blank lines
+// around the text, none of which change how a hunk should be resolved.
+func normalizeHunkText(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// resolveCached fills the results of pending hunks that have a cached
+// resolution and returns the hunks still left for the model. scope is the
+// caller's indexed repo; the cache is keyed by its owner/repo, so everyone
+// working on the repo hits the same entries. Cache failures are logged and
+// treated as misses.
+func (gs *GeminiService) resolveCached(
+	ctx context.Context,
+	scope *repo_index.RepoIndex,
+	pending []*conflict.Hunk,
+	keys map[int]string,
+	results []HunkResult,
+	opts HunkOptions,
+) []*conflict.Hunk {
+	fingerprints := make([]string, 0, len(pending))
+	for _, h := range pending {
+		fingerprints = append(fingerprints, keys[h.Index])
+	}
+	cached, err := gs.cacheRepo.GetResolutions(ctx, scope.Owner, scope.Repo, fingerprints)
+	if err != nil {
+		log.Printf("resolution cache: %v", err)
+		return pending
+	}
+	byKey := make(map[string]resolution_cache.Resolution, len(cached))
+	for _, c := range cached {
+		byKey[c.Fingerprint] = c
+	}
+
+	var left []*conflict.Hunk
+	for _, h := range pending {
+		c, ok := byKey[keys[h.Index]]
+		if !ok {
+			left = append(left, h)
+			continue
+		}
+		res := &results[h.Index]
+		res.Cached = true
+		if opts.Structured {
+			// review depends on this request's threshold, so it is redone
+			applyHunkAnswer(res, h, &hunkAnswer{
+				Resolved:   &c.Resolved,
+				Strategy:   &c.ModelStrategy,
+				Rationale:  &c.Rationale,
+				Confidence: c.Confidence,
+				Risks:      &c.Risks,
+			}, opts.ReviewThreshold)
+		} else {
+			res.Strategy = StrategyAI
+			res.Resolved = cleanHunkResponse(c.Resolved, h)
+		}
+	}
+	return left
+}
+
+// cacheResolutions stores the model's resolutions of hunks and evicts the
+// repo's entries that went unused for CacheMaxAge. Failed and unusable replies
+// are never cached.
+func (gs *GeminiService) cacheResolutions(ctx context.Context, scope *repo_index.RepoIndex, hunks []*conflict.Hunk, keys map[int]string, results []HunkResult, opts HunkOptions) {
+	version, model := promptVersion(opts), gs.modelName()
+	for _, h := range hunks {
+		res := results[h.Index]
+		if res.Strategy != StrategyAI {
+			continue
+		}
+		err := gs.cacheRepo.PutResolution(ctx, &resolution_cache.Resolution{
+			Owner:         scope.Owner,
+			Repo:          scope.Repo,
+			Fingerprint:   keys[h.Index],
+			PromptVersion: version,
+			Model:         model,
+			Resolved:      res.Resolved,
+			ModelStrategy: res.ModelStrategy,
+			Rationale:     res.Rationale,
+			Confidence:    res.Confidence,
+			Risks:         res.Risks,
+		})
+		if err != nil {
+			log.Printf("resolution cache: %v", err)
+		}
+	}
+	if _, err := gs.cacheRepo.EvictResolutions(ctx, scope.Owner, scope.Repo, time.Now().Add(-CacheMaxAge)); err != nil {
+		log.Printf("resolution cache: %v", err)
+	}
+}
+
+// PurgeResolutionCache drops every cached resolution of the repo indexed as
+// repoId, for everyone working on it. The index must be u's own, and since
+// the entries are shared u must also be the repo's owner on GitHub.
+func (gs *GeminiService) PurgeResolutionCache(ctx context.Context, u *user.User, repoId uuid.UUID) (int64, error) {
+	index, err := gs.OwnedRepo(ctx, u.Id, repoId)
+	if err != nil {
+		return 0, err
+	}
+	if index == nil {
+		return 0, ErrRepoNotFound
+	}
+	// GitHub logins are case-insensitive
+	if u.GithubUsername == nil || !strings.EqualFold(strings.TrimSpace(*u.GithubUsername), index.Owner) {
+		return 0, ErrNotRepoOwner
+	}
+	return gs.cacheRepo.DeleteRepoResolutions(ctx, index.Owner, index.Repo)
+}
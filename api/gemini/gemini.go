@@ -1,20 +1,179 @@
 package gemini
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
 	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
-	"google.golang.org/genai"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
+	"github.com/tahminator/go-react-template/database/repository/session"
+	"github.com/tahminator/go-react-template/database/repository/user"
+	"github.com/tahminator/go-react-template/gitrepo"
+	"github.com/tahminator/go-react-template/llm"
+	"github.com/tahminator/go-react-template/utils"
 )
 
+// modelOptions lets any request pick the model, temperature and thinking budget,
+// and cap the prompt size in tokens. Unset fields fall back to the deployment
+// defaults.
+type modelOptions struct {
+	Model          string   `json:"model"`
+	Temperature    *float32 `json:"temperature"`
+	ThinkingBudget *int32   `json:"thinking_budget"`
+	PromptBudget   int      `json:"prompt_budget"`
+}
+
+func (m modelOptions) llm() llm.Options {
+	return llm.Options{
+		Model:          m.Model,
+		Temperature:    m.Temperature,
+		ThinkingBudget: m.ThinkingBudget,
+	}
+}
+
+// parseRepoId reads the optional repo_id naming the indexed repository (the id
+// reported by /github/index) to draw context from. Without one the prompt
+// carries no repository context.
+func parseRepoId(s string) (uuid.UUID, error) {
+	if s == "" {
+		return uuid.Nil, nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid repo_id: %w", err)
+	}
+	return id, nil
+}
+
+// ownedRepo resolves a request's repo_id to the caller's indexed repository,
+// or nil if there is none, before anything is retrieved from it. It writes the
+// error response and returns false when the id is invalid or names a repo the
+// caller did not index.
+func ownedRepo(c *gin.Context, service *GeminiService, raw string) (*repo_index.RepoIndex, bool) {
+	repoId, err := parseRepoId(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	ao := c.MustGet("ao").(*utils.AuthenticationObject)
+	repo, err := service.OwnedRepo(c.Request.Context(), ao.User.Id, repoId)
+	if errors.Is(err, ErrRepoNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return repo, true
+}
+
+type hunksRequest struct {
+	ConflictContent string         `json:"conflict_content" binding:"required"`
+	FilePath        string         `json:"file_path" binding:"required"`
+	UserQuery       string         `json:"user_query"`
+	RepoId          string         `json:"repo_id"`
+	ContextLines    *int           `json:"context_lines"`
+	AutoResolve     *bool          `json:"auto_resolve"`
+	Strategy        string         `json:"strategy"`
+	Strategies      map[int]string `json:"strategies"`
+	Structured      bool           `json:"structured"`
+	ReviewThreshold *float64       `json:"review_threshold"`
+	UseCache        *bool          `json:"use_cache"`
+	// Operation is the merge, rebase, cherry-pick or revert that stopped on
+	// the conflict, as reported by /github/operation; merge by default.
+	Operation string `json:"operation"`
+	modelOptions
+}
+
+// parse fills in defaults and checks the request, returning the options for
+// ResolveHunks.
+func (req *hunksRequest) parse() (HunkOptions, error) {
+	if req.UserQuery == "" {
+		req.UserQuery = "resolve this merge conflict hunk"
+	}
+	opts := HunkOptions{
+		ContextLines:    DefaultContextLines,
+		AutoResolve:     true,
+		Strategies:      map[int]conflict.Strategy{},
+		Structured:      req.Structured,
+		ReviewThreshold: DefaultReviewThreshold,
+		UseCache:        true,
+	}
+	if req.UseCache != nil {
+		opts.UseCache = *req.UseCache
+	}
+	if req.Operation != "" {
+		operation, err := gitrepo.ParseOperation(req.Operation)
+		if err != nil {
+			return HunkOptions{}, err
+		}
+		opts.Operation = operation
+	}
+	if req.ReviewThreshold != nil {
+		if *req.ReviewThreshold < 0 || *req.ReviewThreshold > 1 {
+			return HunkOptions{}, fmt.Errorf("review_threshold must be between 0 and 1")
+		}
+		opts.ReviewThreshold = *req.ReviewThreshold
+	}
+	if req.ContextLines != nil && *req.ContextLines >= 0 {
+		opts.ContextLines = *req.ContextLines
+	}
+	if req.AutoResolve != nil {
+		opts.AutoResolve = *req.AutoResolve
+	}
+	if req.Strategy != "" {
+		strategy, err := conflict.ParseStrategy(req.Strategy)
+		if err != nil {
+			return HunkOptions{}, err
+		}
+		opts.Strategy = strategy
+	}
+	for idx, name := range req.Strategies {
+		strategy, err := conflict.ParseStrategy(name)
+		if err != nil {
+			return HunkOptions{}, fmt.Errorf("hunk %d: %w", idx, err)
+		}
+		opts.Strategies[idx] = strategy
+	}
+	return opts, nil
+}
+
 func NewRouter(eng *gin.RouterGroup,
-	geminiClient *genai.Client,
+	userRepository user.UserRepository,
+	sessionRepository session.SessionRepository,
+	provider llm.LLMProvider,
 	repoChunksRepo repo_chunks.RepoChunksRepository,
+	retriever *rag.Retriever,
+	symbolsRepo repo_symbols.RepoSymbolsRepository,
+	indexRepo repo_index.RepoIndexRepository,
+	cacheRepo resolution_cache.ResolutionCacheRepository,
+	acceptedRepo accepted_resolutions.AcceptedResolutionsRepository,
 ) *gin.RouterGroup {
 	r := eng.Group("/gemini")
 
-	service := NewGeminiService(geminiClient, repoChunksRepo)
+	service := NewGeminiService(provider, repoChunksRepo, retriever, symbolsRepo, indexRepo, cacheRepo, acceptedRepo)
+
+	// resolutions draw on the caller's indexed repositories and spend model
+	// quota, so every route needs a login
+	r.Use(func(c *gin.Context) {
+		ao, err := utils.ValidateRequest(c, userRepository, sessionRepository)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			c.Abort()
+			return
+		}
+		c.Set("ao", ao)
+		c.Next()
+	})
 
 	r.GET("/test", func(c *gin.Context) {
 		message := c.Query("message")
@@ -22,7 +181,17 @@ func NewRouter(eng *gin.RouterGroup,
 			message = "solve two-sum for me"
 		}
 
-		StreamGeminiResponse(c, geminiClient, message)
+		service.WithOptions(llm.Options{Model: c.Query("model")}).StreamPrompt(c, message)
+	})
+
+	r.GET("/streams/:id", func(c *gin.Context) {
+		streamId, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid stream id"})
+			return
+		}
+
+		service.ResumeStream(c, streamId)
 	})
 
 	r.POST("/resolve-conflicts-file-stream", func(c *gin.Context) {
@@ -30,7 +199,8 @@ func NewRouter(eng *gin.RouterGroup,
 			ConflictContent string `json:"conflict_content" binding:"required"`
 			FilePath        string `json:"file_path" binding:"required"`
 			UserQuery       string `json:"user_query"`
-			RepoHash        string `json:"repo_hash"`
+			RepoId          string `json:"repo_id"`
+			modelOptions
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -41,16 +211,118 @@ func NewRouter(eng *gin.RouterGroup,
 		if req.UserQuery == "" {
 			req.UserQuery = "resolve all merge conflicts in this code"
 		}
-		if req.RepoHash == "" {
-			req.RepoHash = "default"
+		repo, ok := ownedRepo(c, service, req.RepoId)
+		if !ok {
+			return
+		}
+
+		service.WithOptions(req.llm()).WithPromptBudget(req.PromptBudget).StreamResolveConflictsToFile(c, req.ConflictContent, req.FilePath, req.UserQuery, repo)
+	})
+
+	r.POST("/resolve-conflicts-file", func(c *gin.Context) {
+		var req struct {
+			ConflictContent string `json:"conflict_content" binding:"required"`
+			FilePath        string `json:"file_path" binding:"required"`
+			UserQuery       string `json:"user_query"`
+			RepoId          string `json:"repo_id"`
+			RepairAttempts  *int   `json:"repair_attempts"`
+			modelOptions
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		c.Writer.Header().Set("Content-Type", "text/event-stream")
-		c.Writer.Header().Set("Cache-Control", "no-cache")
-		c.Writer.Header().Set("Connection", "keep-alive")
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		if req.UserQuery == "" {
+			req.UserQuery = "resolve all merge conflicts in this code"
+		}
+		repo, ok := ownedRepo(c, service, req.RepoId)
+		if !ok {
+			return
+		}
+		repairAttempts := DefaultRepairAttempts
+		if req.RepairAttempts != nil {
+			repairAttempts = *req.RepairAttempts
+		}
+
+		result, err := service.WithOptions(req.llm()).WithPromptBudget(req.PromptBudget).ResolveConflictsToFileWithRepair(c.Request.Context(), req.ConflictContent, req.FilePath, req.UserQuery, repo, repairAttempts)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("resolved", result))
+	})
+
+	r.POST("/resolve-hunks", func(c *gin.Context) {
+		var req hunksRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts, err := req.parse()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		repo, ok := ownedRepo(c, service, req.RepoId)
+		if !ok {
+			return
+		}
+
+		result, err := service.WithOptions(req.llm()).WithPromptBudget(req.PromptBudget).ResolveHunks(c.Request.Context(), req.ConflictContent, req.FilePath, req.UserQuery, repo, opts)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("resolved", result))
+	})
+
+	r.POST("/resolve-hunks-stream", func(c *gin.Context) {
+		var req hunksRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		opts, err := req.parse()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		repo, ok := ownedRepo(c, service, req.RepoId)
+		if !ok {
+			return
+		}
+
+		service.WithOptions(req.llm()).WithPromptBudget(req.PromptBudget).StreamResolveHunks(c, req.ConflictContent, req.FilePath, req.UserQuery, repo, opts)
+	})
+
+	// the cache is shared, so only the repo's owner may purge it
+	r.DELETE("/cache", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+		repoId, err := parseRepoId(c.Query("repo_id"))
+		if err != nil || repoId == uuid.Nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "repo_id is required"})
+			return
+		}
+
+		deleted, err := service.PurgeResolutionCache(c.Request.Context(), ao.User, repoId)
+		if errors.Is(err, ErrRepoNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, ErrNotRepoOwner) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
-		service.StreamResolveConflictsToFile(c, req.ConflictContent, req.FilePath, req.UserQuery, req.RepoHash)
+		c.JSON(http.StatusOK, utils.Success("purged", gin.H{"deleted": deleted}))
 	})
 
 	return r
@@ -0,0 +1,353 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/gitrepo"
+	"github.com/tahminator/go-react-template/validation"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultContextLines = 10
+
+	maxConcurrentHunks = 4
+
+	StrategyAI         = "ai"
+	StrategyUnresolved = "unresolved"
+)
+
+// HunkOptions controls how ResolveHunks treats each hunk before falling back to the model.
+type HunkOptions struct {
+	ContextLines int
+	// AutoResolve applies conflict.Hunk.AutoResolve rules before asking the model.
+	AutoResolve bool
+	// Strategy, when set, resolves every hunk without a Strategies entry.
+	Strategy conflict.Strategy
+	// Strategies pins a deterministic strategy per hunk index.
+	Strategies map[int]conflict.Strategy
+	// Structured asks the model for a JSON reply per hunk carrying a
+	// rationale and confidence, which is validated before it is used.
+	Structured bool
+	// ReviewThreshold is the confidence below which a structured hunk is
+	// flagged for review.
+	ReviewThreshold float64
+	// UseCache reuses and stores model resolutions in the repo's resolution
+	// cache. It has no effect without a repo.
+	UseCache bool
+	// Operation is what stopped on the conflict. The sides of a rebase or
+	// revert do not mean what they do in a merge, so the prompt says what
+	// they are.
+	Operation gitrepo.Operation
+}
+
+type HunkResult struct {
+	Index        int            `json:"index"`
+	Range        conflict.Range `json:"range"`
+	OursLabel    string         `json:"ours_label"`
+	TheirsLabel  string         `json:"theirs_label"`
+	Strategy     string         `json:"strategy"`
+	Rule         conflict.Rule  `json:"rule,omitempty"`
+	AutoResolved bool           `json:"auto_resolved"`
+	Resolved     string         `json:"resolved"`
+	Error        string         `json:"error,omitempty"`
+	// Prompt describes the prompt sent for this hunk, if it reached the model.
+	Prompt *PromptStats `json:"prompt,omitempty"`
+	// Cached is set when an earlier model resolution was reused.
+	Cached bool `json:"cached"`
+
+	// Set in structured mode from the model's reply. ModelStrategy is the
+	// strategy the model says it used.
+	ModelStrategy string   `json:"model_strategy,omitempty"`
+	Rationale     string   `json:"rationale,omitempty"`
+	Confidence    *float64 `json:"confidence,omitempty"`
+	Risks         []string `json:"risks,omitempty"`
+	// NeedsReview marks a hunk a person must check before the result is used.
+	NeedsReview  bool   `json:"needs_review"`
+	ReviewReason string `json:"review_reason,omitempty"`
+}
+
+type HunkResolution struct {
+	FilePath      string       `json:"file_path"`
+	Content       string       `json:"content"`
+	Hunks         []HunkResult `json:"hunks"`
+	AutoResolved  int          `json:"auto_resolved"`
+	ModelResolved int          `json:"model_resolved"`
+	CacheHits     int          `json:"cache_hits"`
+	// ReviewHunks lists the hunks flagged for review; while NeedsReview is
+	// set the content must not be accepted unseen.
+	NeedsReview bool              `json:"needs_review"`
+	ReviewHunks []int             `json:"review_hunks"`
+	Validation  validation.Result `json:"validation"`
+}
+
+// ResolveHunks resolves every conflict hunk on its own and splices the results back
+// into the clean regions, which are never sent through the model. Hunks are first
+// offered to the deterministic strategies in opts; only the rest reach the model.
+// A hunk the model fails on keeps its markers and is reported as unresolved.
+// In structured mode a reply that fails validation counts as a failure, and
+// low-confidence hunks are flagged for review. With opts.UseCache, hunks
+// resolved before in the same repo, by anyone working on it, are answered from
+// the cache.
+func (gs *GeminiService) ResolveHunks(
+	ctx context.Context,
+	conflictContent string,
+	filePath string,
+	userQuery string,
+	repo *repo_index.RepoIndex,
+	opts HunkOptions,
+) (*HunkResolution, error) {
+	return gs.resolveHunks(ctx, conflictContent, filePath, userQuery, repo, opts, nil)
+}
+
+// resolveHunks implements ResolveHunks, reporting progress to run when it is
+// not nil.
+func (gs *GeminiService) resolveHunks(
+	ctx context.Context,
+	conflictContent string,
+	filePath string,
+	userQuery string,
+	repo *repo_index.RepoIndex,
+	opts HunkOptions,
+	run *streamRun,
+) (*HunkResolution, error) {
+
+	parsed, err := conflict.Parse(conflictContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse conflicts: %w", err)
+	}
+
+	results := make([]HunkResult, len(parsed.Hunks))
+	var pending []*conflict.Hunk
+	for i, h := range parsed.Hunks {
+		results[i] = HunkResult{
+			Index:       h.Index,
+			Range:       h.Range,
+			OursLabel:   h.OursLabel,
+			TheirsLabel: h.TheirsLabel,
+		}
+		if !resolveDeterministic(&results[i], h, opts) {
+			pending = append(pending, h)
+		}
+	}
+
+	// only requests for the caller's own repo read or write its cache
+	var keys map[int]string
+	if opts.UseCache && gs.cacheRepo != nil && repo != nil && len(pending) > 0 {
+		version, model := promptVersion(opts), gs.modelName()
+		keys = make(map[int]string, len(pending))
+		for _, h := range pending {
+			keys[h.Index] = hunkFingerprint(h, filePath, userQuery, version, model)
+		}
+		pending = gs.resolveCached(ctx, repo, pending, keys, results, opts)
+	}
+
+	run.progress(ProgressEvent{Stage: StageResolving, Hunks: len(parsed.Hunks), Pending: len(pending)})
+	for i, h := range parsed.Hunks {
+		if !slices.Contains(pending, h) {
+			run.emit(EventHunkResolved, results[i])
+		}
+	}
+
+	var similarChunks []repo_chunks.SimilarChunk
+	var defs []repo_symbols.Definition
+	var refs []repo_symbols.Reference
+	if len(pending) > 0 {
+		similarChunks = gs.retrieveConflictContext(ctx, userQuery, parsed, repo, 5)
+		defs, refs = gs.crossReferences(ctx, filePath, parsed, repo)
+	}
+
+	// each hunk costs a model call plus embedding and lookups for its
+	// examples, so a file with many conflicts only runs a few at a time
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentHunks)
+	for _, h := range pending {
+		res := &results[h.Index]
+		g.Go(func() error {
+			run.emit(EventHunkStarted, HunkStartedEvent{Index: h.Index, Range: h.Range})
+			var onToken func(string)
+			if run != nil {
+				onToken = func(text string) {
+					run.emit(EventToken, TokenEvent{Hunk: &h.Index, Text: text})
+				}
+			}
+
+			examples := gs.acceptedExamples(ctx, repo, h)
+			prompt, stats := buildHunkPrompt(gs.promptBudget, parsed, h, filePath, userQuery, opts, examples, similarChunks, defs, refs)
+			res.Prompt = &stats
+			if opts.Structured {
+				gs.resolveStructured(ctx, res, h, prompt, opts.ReviewThreshold, onToken)
+			} else if response, err := gs.generate(ctx, prompt, gs.options, onToken); err != nil {
+				res.Strategy = StrategyUnresolved
+				res.Error = err.Error()
+			} else {
+				res.Strategy = StrategyAI
+				res.Resolved = cleanHunkResponse(response, h)
+			}
+			run.emit(EventHunkResolved, *res)
+			return nil
+		})
+	}
+	g.Wait()
+	if keys != nil {
+		gs.cacheResolutions(ctx, repo, pending, keys, results, opts)
+	}
+
+	out := &HunkResolution{
+		FilePath:    filePath,
+		Hunks:       results,
+		ReviewHunks: []int{},
+	}
+	resolved := make(map[int]string, len(results))
+	for _, res := range results {
+		if res.NeedsReview {
+			out.ReviewHunks = append(out.ReviewHunks, res.Index)
+		}
+		// a cached hunk keeps the model's strategy but cost no model call
+		switch {
+		case res.Strategy == StrategyUnresolved:
+			continue
+		case res.AutoResolved:
+			out.AutoResolved++
+		case res.Cached:
+			out.CacheHits++
+		case res.Strategy == StrategyAI:
+			out.ModelResolved++
+		}
+		resolved[res.Index] = res.Resolved
+	}
+	out.NeedsReview = len(out.ReviewHunks) > 0
+	out.Content = parsed.Resolve(resolved)
+	out.Validation = validation.Validate(filePath, out.Content)
+
+	return out, nil
+}
+
+// resolveDeterministic fills res from a pinned strategy or an AutoResolve rule.
+// It reports false when the hunk still needs the model.
+func resolveDeterministic(res *HunkResult, h *conflict.Hunk, opts HunkOptions) bool {
+	strategy, pinned := opts.Strategies[h.Index]
+	if !pinned && opts.Strategy != "" {
+		strategy, pinned = opts.Strategy, true
+	}
+	if pinned {
+		text, err := h.Apply(strategy)
+		if err != nil {
+			res.Strategy = StrategyUnresolved
+			res.Error = err.Error()
+			return true
+		}
+		res.Strategy = string(strategy)
+		res.Resolved = text
+		return true
+	}
+
+	if !opts.AutoResolve {
+		return false
+	}
+	text, rule, ok := h.AutoResolve()
+	if !ok {
+		return false
+	}
+	res.Strategy = string(conflict.StrategyOurs)
+	if rule == conflict.RuleOursEqualsBase {
+		res.Strategy = string(conflict.StrategyTheirs)
+	}
+	res.Rule = rule
+	res.AutoResolved = true
+	res.Resolved = text
+	return true
+}
+
+func buildHunkPrompt(
+	budget int,
+	parsed *conflict.File,
+	h *conflict.Hunk,
+	filePath string,
+	userQuery string,
+	opts HunkOptions,
+	examples []accepted_resolutions.SimilarResolution,
+	similarChunks []repo_chunks.SimilarChunk,
+	defs []repo_symbols.Definition,
+	refs []repo_symbols.Reference,
+) (string, PromptStats) {
+	b := newPromptBuilder(budget)
+	instructions := HunkPrompt
+	if opts.Structured {
+		instructions = StructuredHunkPrompt
+	}
+	b.required(SectionInstructions, instructions+"\n\nUser Request: "+userQuery+"\n\n")
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("File: %s (hunk %d of %d, lines %d-%d)\n\n", filePath, h.Index+1, len(parsed.Hunks), h.Range.StartLine, h.Range.EndLine))
+	if note := operationSides[opts.Operation]; note != "" {
+		sb.WriteString(note + "\n\n")
+	}
+	sb.WriteString(fmt.Sprintf("OURS (%s):\n%s\n", h.OursLabel, h.Ours))
+	if h.HasBase {
+		sb.WriteString(fmt.Sprintf("BASE (%s):\n%s\n", h.BaseLabel, h.Base))
+	}
+	sb.WriteString(fmt.Sprintf("THEIRS (%s):\n%s\n", h.TheirsLabel, h.Theirs))
+	b.required(SectionConflict, sb.String(), span{source: filePath, start: h.Range.StartLine, end: h.Range.EndLine})
+
+	// before and after are both needed to place the hunk, so they are one
+	// item and the whole window is claimed
+	nearby := b.section(SectionNearby, "", shareNearby)
+	nearby.add(
+		"LINES BEFORE THE HUNK:\n"+parsed.ContextBefore(h, opts.ContextLines)+"\n"+
+			"LINES AFTER THE HUNK:\n"+parsed.ContextAfter(h, opts.ContextLines)+"\n",
+		span{source: filePath, start: h.Range.StartLine - opts.ContextLines, end: h.Range.EndLine + opts.ContextLines},
+	)
+
+	addAcceptedExamples(b, examples)
+	addCrossReferences(b, defs, refs)
+	addRetrievedChunks(b, "REPOSITORY CONTEXT:\n", similarChunks, true)
+
+	return b.build()
+}
+
+// resolveStructured asks for a JSON reply and fills res from it. A reply
+// that cannot be used leaves the hunk unresolved and flagged for review.
+func (gs *GeminiService) resolveStructured(ctx context.Context, res *HunkResult, h *conflict.Hunk, prompt string, threshold float64, onToken func(string)) {
+	opts := gs.options
+	opts.ResponseSchema = hunkResponseSchema
+	response, err := gs.generate(ctx, prompt, opts, onToken)
+	if err != nil {
+		res.Strategy = StrategyUnresolved
+		res.Error = err.Error()
+		return
+	}
+
+	answer, err := parseHunkAnswer(response)
+	if err != nil {
+		res.Strategy = StrategyUnresolved
+		res.Error = err.Error()
+		res.NeedsReview = true
+		res.ReviewReason = "the model's reply failed validation"
+		return
+	}
+	applyHunkAnswer(res, h, answer, threshold)
+}
+
+// cleanHunkResponse strips a wrapping code fence if the model added one anyway
+// and makes the line ending at the end match the original sides.
+func cleanHunkResponse(response string, h *conflict.Hunk) string {
+	out := strings.Trim(stripCodeFence(response), "\r\n")
+	if out == "" {
+		return ""
+	}
+
+	eol := "\n"
+	if strings.HasSuffix(h.Ours, "\r\n") || strings.HasSuffix(h.Theirs, "\r\n") {
+		eol = "\r\n"
+	}
+	return out + eol
+}
@@ -0,0 +1,81 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tahminator/go-react-template/llm"
+)
+
+const conflictedFile = `package demo
+
+func Greet(name string) string {
+<<<<<<< HEAD
+	return "hi " + name
+=======
+	return "hello, " + name + "!"
+>>>>>>> origin/main
+}
+`
+
+const resolvedFile = `package demo
+
+func Greet(name string) string {
+	return "hi, " + name + "!"
+}
+`
+
+func TestResolveHunksStructuredFlagsLowConfidence(t *testing.T) {
+	answer, _ := json.Marshal(map[string]any{
+		"resolved":   "\treturn \"hi, \" + name + \"!\"\n",
+		"strategy":   "merged",
+		"rationale":  "keeps the shorter greeting from ours and the punctuation from theirs",
+		"confidence": 0.4,
+		"risks":      []string{"callers may match on the exact greeting"},
+	})
+	provider := llm.NewFakeProvider(string(answer), "Here is the resolution: return name")
+	gs := newTestService(provider)
+	opts := HunkOptions{Structured: true, ReviewThreshold: DefaultReviewThreshold}
+
+	got, err := gs.ResolveHunks(context.Background(), conflictedFile, "main.go", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != resolvedFile {
+		t.Errorf("content:\n%s\nwant:\n%s", got.Content, resolvedFile)
+	}
+	if !got.NeedsReview || !slices.Equal(got.ReviewHunks, []int{0}) {
+		t.Errorf("expected hunk 0 flagged for review, got needs_review=%v review_hunks=%v", got.NeedsReview, got.ReviewHunks)
+	}
+	hunk := got.Hunks[0]
+	if hunk.Strategy != StrategyAI || hunk.ModelStrategy != ModelStrategyMerged {
+		t.Errorf("strategy %q, model strategy %q", hunk.Strategy, hunk.ModelStrategy)
+	}
+	if hunk.Confidence == nil || *hunk.Confidence != 0.4 || len(hunk.Risks) != 1 || hunk.Rationale == "" {
+		t.Errorf("structured fields not carried through: %+v", hunk)
+	}
+	if !strings.Contains(hunk.ReviewReason, "confidence 0.40") {
+		t.Errorf("review reason %q", hunk.ReviewReason)
+	}
+
+	calls := provider.Calls()
+	if len(calls) != 1 || calls[0].Options.ResponseSchema == nil {
+		t.Fatalf("expected one call asking for a JSON schema, got %+v", calls)
+	}
+
+	// free text instead of JSON leaves the hunk unresolved
+	got, err = gs.ResolveHunks(context.Background(), conflictedFile, "main.go", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hunk = got.Hunks[0]
+	if hunk.Strategy != StrategyUnresolved || !hunk.NeedsReview || !strings.Contains(hunk.Error, "invalid structured response") {
+		t.Errorf("expected an unresolved hunk flagged for review, got %+v", hunk)
+	}
+	if !strings.Contains(got.Content, "<<<<<<<") {
+		t.Errorf("unresolved hunk must keep its markers:\n%s", got.Content)
+	}
+}
@@ -1,5 +1,7 @@
 package gemini
 
+import "github.com/tahminator/go-react-template/gitrepo"
+
 const Prompt = `You are a Git merge conflict resolution expert. Your job is to analyze merge conflicts and provide the complete resolved file content.
 
 CRITICAL RULES:
@@ -19,3 +21,52 @@ When resolving conflicts:
 
 Output the complete resolved file as it should appear after successful merge resolution.
 `
+
+const HunkPrompt = `You are a Git merge conflict resolution expert. You are given ONE conflict hunk from a file, the lines surrounding it, and optionally some repository context.
+
+CRITICAL RULES:
+1. Output ONLY the lines that should replace the conflict hunk
+2. Do NOT include any merge conflict markers (<<<<<<<, |||||||, =======, >>>>>>>)
+3. Do NOT repeat the surrounding lines; they are kept as-is
+4. Ensure the result fits syntactically between the surrounding lines
+5. NO explanations, comments, or code fences outside of the resolved lines
+
+When resolving the hunk:
+- "OURS" is the version on the current branch, "THEIRS" is the incoming version
+- "BASE" (when present) is the common ancestor; use it to tell which side changed what
+- Combine features from both sides when beneficial
+- Preserve indentation and style of the surrounding code
+`
+
+const RepairPrompt = `You previously produced a merge conflict resolution that failed validation. Fix ONLY the reported problems.
+
+CRITICAL RULES:
+1. Output ONLY the complete corrected file content
+2. Remove ALL remaining merge conflict markers (<<<<<<<, |||||||, =======, >>>>>>>)
+3. Keep every part of the previous resolution that is not related to a reported problem
+4. NO explanations, comments, or code fences outside of the file content
+`
+
+const StructuredHunkPrompt = `You are a Git merge conflict resolution expert. You are given ONE conflict hunk from a file, the lines surrounding it, and optionally some repository context.
+
+Reply with a single JSON object and nothing else:
+- "resolved": the lines that should replace the conflict hunk, without conflict markers, code fences or the surrounding lines
+- "strategy": "ours" or "theirs" if you kept one side unchanged, "base" if you reverted to the common ancestor, "union" if you kept ours followed by theirs, otherwise "merged"
+- "rationale": one or two sentences on why this resolution is correct
+- "confidence": a number from 0 to 1; use a low value when the intent of either side is unclear or the sides make incompatible changes
+- "risks": short descriptions of anything a reviewer should check, or an empty list
+
+When resolving the hunk:
+- "OURS" is the version on the current branch, "THEIRS" is the incoming version
+- "BASE" (when present) is the common ancestor; use it to tell which side changed what
+- Combine features from both sides when beneficial
+- Preserve indentation and style of the surrounding code
+`
+
+// operationSides overrides what the hunk prompts say OURS and THEIRS are for
+// operations other than a merge.
+var operationSides = map[gitrepo.Operation]string{
+	gitrepo.OperationRebase:     `This conflict stopped a rebase: "OURS" is the branch being rebased onto and "THEIRS" is the commit being replayed on top of it, so THEIRS holds the change this step applies.`,
+	gitrepo.OperationCherryPick: `This conflict stopped a cherry-pick: "OURS" is the current branch and "THEIRS" is the picked commit, whose change is being applied.`,
+	gitrepo.OperationRevert:     `This conflict stopped a revert: "BASE" is the reverted commit and "THEIRS" its parent, so going from BASE to THEIRS undoes the commit; apply that to "OURS", the current branch.`,
+}
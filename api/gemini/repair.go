@@ -0,0 +1,158 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/validation"
+)
+
+const (
+	DefaultRepairAttempts = 2
+	MaxRepairAttempts     = 5
+)
+
+type RepairAttempt struct {
+	Attempt    int               `json:"attempt"`
+	Candidate  string            `json:"candidate"`
+	Validation validation.Result `json:"validation"`
+	Error      string            `json:"error,omitempty"`
+	// Prompt describes the prompt that produced Candidate.
+	Prompt PromptStats `json:"prompt"`
+}
+
+type RepairResult struct {
+	FilePath string          `json:"file_path"`
+	Content  string          `json:"content"`
+	Valid    bool            `json:"valid"`
+	Chosen   int             `json:"chosen"`
+	Reason   string          `json:"reason"`
+	Attempts []RepairAttempt `json:"attempts"`
+}
+
+// ResolveConflictsToFileWithRepair resolves the whole file and then runs up to
+// repairAttempts rounds of validate -> feed diagnostics back -> regenerate.
+// Every attempt is recorded. The first candidate that validates wins; if none
+// does, the one with the fewest diagnostics is returned.
+func (gs *GeminiService) ResolveConflictsToFileWithRepair(
+	ctx context.Context,
+	conflictContent string,
+	filePath string,
+	userQuery string,
+	repo *repo_index.RepoIndex,
+	repairAttempts int,
+) (*RepairResult, error) {
+	prompt, stats := gs.buildFilePrompt(ctx, conflictContent, filePath, userQuery, repo)
+
+	candidate, err := gs.generateResponse(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate resolved file: %w", err)
+	}
+
+	return gs.repairLoop(ctx, filePath, stripCodeFence(candidate), stats, repairAttempts), nil
+}
+
+func (gs *GeminiService) repairLoop(ctx context.Context, filePath, candidate string, stats PromptStats, repairAttempts int) *RepairResult {
+	if repairAttempts < 0 {
+		repairAttempts = 0
+	}
+	if repairAttempts > MaxRepairAttempts {
+		repairAttempts = MaxRepairAttempts
+	}
+
+	result := &RepairResult{FilePath: filePath}
+
+	for attempt := 0; ; attempt++ {
+		check := validation.Validate(filePath, candidate)
+		result.Attempts = append(result.Attempts, RepairAttempt{
+			Attempt:    attempt,
+			Candidate:  candidate,
+			Validation: check,
+			Prompt:     stats,
+		})
+
+		if check.Valid {
+			result.Content = candidate
+			result.Valid = true
+			result.Chosen = attempt
+			if attempt == 0 {
+				result.Reason = "initial resolution passed validation"
+			} else {
+				result.Reason = fmt.Sprintf("repair attempt %d passed validation", attempt)
+			}
+			return result
+		}
+		if attempt >= repairAttempts {
+			break
+		}
+
+		prompt, repairStats, err := buildRepairPrompt(gs.promptBudget, filePath, candidate, check)
+		if err != nil {
+			result.Attempts[len(result.Attempts)-1].Error = err.Error()
+			break
+		}
+		stats = repairStats
+		repaired, err := gs.generateResponse(ctx, prompt)
+		if err != nil {
+			result.Attempts[len(result.Attempts)-1].Error = err.Error()
+			break
+		}
+		candidate = stripCodeFence(repaired)
+	}
+
+	best := 0
+	for i, a := range result.Attempts {
+		if len(a.Validation.Diagnostics) < len(result.Attempts[best].Validation.Diagnostics) {
+			best = i
+		}
+	}
+	result.Content = result.Attempts[best].Candidate
+	result.Chosen = best
+	result.Reason = fmt.Sprintf("no candidate passed validation after %d attempt(s); returning the one with the fewest diagnostics", len(result.Attempts))
+	return result
+}
+
+// buildRepairPrompt asks for a fix of candidate. The model rewrites the whole
+// file from the candidate, so it is never truncated; when it does not fit the
+// budget the repair fails instead.
+func buildRepairPrompt(budget int, filePath, candidate string, check validation.Result) (string, PromptStats, error) {
+	b := newPromptBuilder(budget)
+	b.required(SectionInstructions, RepairPrompt+fmt.Sprintf("\n\nFile: %s\n\n", filePath))
+
+	var sb strings.Builder
+	sb.WriteString("VALIDATION ERRORS:\n")
+	for _, d := range check.Diagnostics {
+		sb.WriteString(fmt.Sprintf("- [%s] line %d", d.Source, d.Line))
+		if d.Column > 0 {
+			sb.WriteString(fmt.Sprintf(":%d", d.Column))
+		}
+		sb.WriteString(": " + d.Message + "\n")
+	}
+	b.required(SectionConflict, sb.String())
+
+	b.required(SectionHistory, "\nPREVIOUS RESOLUTION:\n"+candidate+"\n")
+
+	prompt, stats := b.build()
+	if stats.Tokens > stats.Budget {
+		return "", stats, fmt.Errorf("%w: repair needs %d tokens, budget is %d", ErrPromptTooLarge, stats.Tokens, stats.Budget)
+	}
+	return prompt, stats, nil
+}
+
+// stripCodeFence removes a ```lang ... ``` wrapper the model sometimes adds
+// despite being told not to.
+func stripCodeFence(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "```") {
+		return s
+	}
+	nl := strings.IndexByte(trimmed, '\n')
+	if nl < 0 {
+		return ""
+	}
+	body := strings.TrimRight(trimmed[nl+1:], "\n")
+	body = strings.TrimSuffix(body, "```")
+	return strings.TrimRight(body, "\n") + "\n"
+}
@@ -0,0 +1,140 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tahminator/go-react-template/llm"
+)
+
+const conflicted = "<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> feature\n"
+
+func newTestService(provider llm.LLMProvider) *GeminiService {
+	return NewGeminiService(provider, nil, nil, nil, nil, nil, nil)
+}
+
+func TestRepairLoopKeepsAValidFirstCandidate(t *testing.T) {
+	provider := llm.NewFakeProvider("unused\n")
+	result := newTestService(provider).repairLoop(context.Background(), "main.go", "package main\n", PromptStats{}, 2)
+
+	if !result.Valid || result.Chosen != 0 || len(result.Attempts) != 1 || result.Content != "package main\n" {
+		t.Errorf("result: %+v", result)
+	}
+	if calls := provider.Calls(); len(calls) != 0 {
+		t.Errorf("a valid candidate was sent back to the model %d time(s)", len(calls))
+	}
+}
+
+func TestRepairLoopFeedsDiagnosticsBack(t *testing.T) {
+	provider := llm.NewFakeProvider("```go\npackage main\n\nfunc f() {}\n```")
+	result := newTestService(provider).repairLoop(context.Background(), "main.go", "package main\n\nfunc f() {\n", PromptStats{}, 2)
+
+	if !result.Valid || result.Chosen != 1 || len(result.Attempts) != 2 {
+		t.Fatalf("result: %+v", result)
+	}
+	if result.Content != "package main\n\nfunc f() {}\n" {
+		t.Errorf("the code fence was kept: %q", result.Content)
+	}
+	if result.Reason != "repair attempt 1 passed validation" {
+		t.Errorf("reason: %q", result.Reason)
+	}
+
+	calls := provider.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("got %d repair prompts", len(calls))
+	}
+	prompt := calls[0].Prompt
+	first := result.Attempts[0].Validation.Diagnostics
+	if len(first) == 0 || !strings.Contains(prompt, "[go] line ") || !strings.Contains(prompt, first[0].Message) {
+		t.Errorf("the diagnostics of %v are missing from the repair prompt:\n%s", first, prompt)
+	}
+	if !strings.Contains(prompt, "PREVIOUS RESOLUTION:\npackage main\n\nfunc f() {\n") {
+		t.Errorf("the failed candidate is missing from the repair prompt:\n%s", prompt)
+	}
+}
+
+func TestRepairLoopReturnsTheCandidateWithFewestDiagnostics(t *testing.T) {
+	// three markers, then one, then two
+	provider := llm.NewFakeProvider("x\n>>>>>>> feature\n", "<<<<<<< HEAD\n>>>>>>> feature\n")
+	result := newTestService(provider).repairLoop(context.Background(), "notes.txt", conflicted, PromptStats{}, 2)
+
+	if result.Valid || len(result.Attempts) != 3 {
+		t.Fatalf("result: %+v", result)
+	}
+	if result.Chosen != 1 || result.Content != "x\n>>>>>>> feature\n" {
+		t.Errorf("chose attempt %d: %q", result.Chosen, result.Content)
+	}
+	for i, want := range []int{3, 1, 2} {
+		if got := len(result.Attempts[i].Validation.Diagnostics); got != want {
+			t.Errorf("attempt %d has %d diagnostics, want %d", i, got, want)
+		}
+	}
+}
+
+func TestRepairLoopBoundsAttempts(t *testing.T) {
+	for _, tc := range []struct {
+		requested, want int
+	}{
+		{-1, 1},
+		{0, 1},
+		{1, 2},
+		{MaxRepairAttempts + 10, MaxRepairAttempts + 1},
+	} {
+		provider := llm.NewFakeProvider(conflicted)
+		result := newTestService(provider).repairLoop(context.Background(), "notes.txt", conflicted, PromptStats{}, tc.requested)
+		if len(result.Attempts) != tc.want || len(provider.Calls()) != tc.want-1 {
+			t.Errorf("%d repair attempts: made %d attempts and %d calls, want %d attempts", tc.requested, len(result.Attempts), len(provider.Calls()), tc.want)
+		}
+		if result.Valid || result.Chosen != 0 {
+			t.Errorf("%d repair attempts: %+v", tc.requested, result)
+		}
+	}
+}
+
+func TestRepairLoopRecordsModelErrors(t *testing.T) {
+	provider := llm.NewFakeProvider()
+	provider.Respond = func(string) (string, error) {
+		return "", errors.New("quota exceeded")
+	}
+	result := newTestService(provider).repairLoop(context.Background(), "notes.txt", conflicted, PromptStats{}, 3)
+
+	if len(result.Attempts) != 1 || result.Attempts[0].Error != "quota exceeded" {
+		t.Fatalf("attempts: %+v", result.Attempts)
+	}
+	if result.Valid || result.Content != conflicted {
+		t.Errorf("result: %+v", result)
+	}
+}
+
+func TestRepairLoopFailsWhenTheCandidateDoesNotFit(t *testing.T) {
+	provider := llm.NewFakeProvider("unused\n")
+	candidate := conflicted + strings.Repeat("filler line\n", MinPromptBudget)
+	result := newTestService(provider).WithPromptBudget(MinPromptBudget).repairLoop(context.Background(), "notes.txt", candidate, PromptStats{}, 2)
+
+	if calls := provider.Calls(); len(calls) != 0 {
+		t.Fatalf("sent %d repair prompts, the first one truncated:\n%s", len(calls), calls[0].Prompt)
+	}
+	if len(result.Attempts) != 1 || !strings.Contains(result.Attempts[0].Error, ErrPromptTooLarge.Error()) {
+		t.Fatalf("attempts: %+v", result.Attempts)
+	}
+	if result.Valid || result.Content != candidate {
+		t.Errorf("result: %+v", result)
+	}
+}
+
+func TestStripCodeFence(t *testing.T) {
+	for in, want := range map[string]string{
+		"package main\n":               "package main\n",
+		"```go\npackage main\n```":     "package main\n",
+		"\n```\na\nb\n```\n\n":         "a\nb\n",
+		"```":                          "",
+		"text with ``` inside\n":       "text with ``` inside\n",
+		"```json\n{\"a\": 1}\n\n```\n": "{\"a\": 1}\n",
+	} {
+		if got := stripCodeFence(in); got != want {
+			t.Errorf("stripCodeFence(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -2,32 +2,114 @@ package gemini
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"path"
+	"slices"
 	"strings"
 
-	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
 	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
-	"google.golang.org/genai"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
+	"github.com/tahminator/go-react-template/llm"
+)
+
+// ErrRepoNotFound is returned for a repo the caller has not indexed. Another
+// user's index is reported the same way as a missing one, so ids cannot be
+// probed.
+var ErrRepoNotFound = errors.New("repo index not found")
+
+const (
+	maxDefinitions = 10
+	maxCallSites   = 20
+	maxExamples    = 3
 )
 
 type GeminiService struct {
-	client         *genai.Client
+	provider       llm.LLMProvider
 	repoChunksRepo repo_chunks.RepoChunksRepository
+	retriever      *rag.Retriever
+	symbolsRepo    repo_symbols.RepoSymbolsRepository
+	indexRepo      repo_index.RepoIndexRepository
+	cacheRepo      resolution_cache.ResolutionCacheRepository
+	acceptedRepo   accepted_resolutions.AcceptedResolutionsRepository
+	options        llm.Options
+	promptBudget   int
+	streams        *streamHub
 }
 
-func NewGeminiService(client *genai.Client, repoChunksRepo repo_chunks.RepoChunksRepository) *GeminiService {
+func NewGeminiService(
+	provider llm.LLMProvider,
+	repoChunksRepo repo_chunks.RepoChunksRepository,
+	retriever *rag.Retriever,
+	symbolsRepo repo_symbols.RepoSymbolsRepository,
+	indexRepo repo_index.RepoIndexRepository,
+	cacheRepo resolution_cache.ResolutionCacheRepository,
+	acceptedRepo accepted_resolutions.AcceptedResolutionsRepository,
+) *GeminiService {
 	return &GeminiService{
-		client:         client,
+		provider:       provider,
 		repoChunksRepo: repoChunksRepo,
+		retriever:      retriever,
+		symbolsRepo:    symbolsRepo,
+		indexRepo:      indexRepo,
+		cacheRepo:      cacheRepo,
+		acceptedRepo:   acceptedRepo,
+		promptBudget:   DefaultPromptBudget,
+		streams:        newStreamHub(),
 	}
 }
 
+// WithOptions returns a copy of the service that generates with the given
+// per-request model options. Unset fields keep the provider's defaults.
+func (gs *GeminiService) WithOptions(opts llm.Options) *GeminiService {
+	clone := *gs
+	clone.options = opts
+	return &clone
+}
+
+// OwnedRepo returns the repository indexed as repoId if userId indexed it.
+// Resolutions only draw context from a repository returned here; uuid.Nil
+// asks for none and returns nil.
+func (gs *GeminiService) OwnedRepo(ctx context.Context, userId, repoId uuid.UUID) (*repo_index.RepoIndex, error) {
+	if repoId == uuid.Nil {
+		return nil, nil
+	}
+	if gs.indexRepo == nil {
+		return nil, ErrRepoNotFound
+	}
+	index, err := gs.indexRepo.GetRepoIndexById(ctx, repoId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up repo %s: %w", repoId, err)
+	}
+	if index == nil || index.UserId != userId {
+		return nil, ErrRepoNotFound
+	}
+	return index, nil
+}
+
+// WithPromptBudget returns a copy of the service that keeps prompts within
+// tokens. Zero keeps the current budget.
+func (gs *GeminiService) WithPromptBudget(tokens int) *GeminiService {
+	clone := *gs
+	if tokens > 0 {
+		clone.promptBudget = tokens
+	}
+	return &clone
+}
+
 func (gs *GeminiService) ResolveMergeConflictsWithRAG(
 	ctx context.Context,
 	userQuery string,
-	repoHash string,
+	repoId uuid.UUID,
 ) (string, error) {
-	conflictChunks, err := gs.repoChunksRepo.GetConflictChunks(ctx, repoHash)
+	conflictChunks, err := gs.repoChunksRepo.GetConflictChunks(ctx, repoId)
 	if err != nil {
 		return "", fmt.Errorf("failed to get conflict chunks: %w", err)
 	}
@@ -35,7 +117,7 @@ func (gs *GeminiService) ResolveMergeConflictsWithRAG(
 	var contextChunks []repo_chunks.SimilarChunk
 	if len(conflictChunks) > 0 {
 		for _, conflict := range conflictChunks {
-			ctxChunks, err := gs.repoChunksRepo.GetContextChunks(ctx, repoHash, conflict.Source, conflict.LineStart, conflict.LineEnd, 10)
+			ctxChunks, err := gs.repoChunksRepo.GetContextChunks(ctx, repoId, conflict.Source, conflict.LineStart, conflict.LineEnd, 10)
 			if err != nil {
 				continue
 			}
@@ -43,36 +125,45 @@ func (gs *GeminiService) ResolveMergeConflictsWithRAG(
 		}
 	}
 
-	similarFunctions, err := gs.repoChunksRepo.GetSimilarFunctions(ctx, userQuery, repoHash, 5)
+	similarFunctions, err := gs.repoChunksRepo.GetSimilarFunctions(ctx, userQuery, repoId, 5)
 	if err != nil {
 		similarFunctions = []repo_chunks.SimilarChunk{}
 	}
 
-	prompt := Prompt + "\n\nUser Request: " + userQuery + "\n\n"
+	b := newPromptBuilder(gs.promptBudget)
+	b.required(SectionInstructions, Prompt+"\n\nUser Request: "+userQuery+"\n\n")
 
 	if len(conflictChunks) > 0 {
-		prompt += "MERGE CONFLICTS DETECTED:\n"
+		var sb strings.Builder
+		var spans []span
+		sb.WriteString("MERGE CONFLICTS DETECTED:\n")
 		for i, chunk := range conflictChunks {
-			prompt += fmt.Sprintf("Conflict %d in %s (lines %d-%d):\n", i+1, chunk.Source, chunk.LineStart, chunk.LineEnd)
-			prompt += fmt.Sprintf("Section: %s\n", chunk.ConflictSection)
-			prompt += fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk)
+			sb.WriteString(fmt.Sprintf("Conflict %d in %s (lines %d-%d):\n", i+1, chunk.Source, chunk.LineStart, chunk.LineEnd))
+			sb.WriteString(fmt.Sprintf("Section: %s\n", chunk.ConflictSection))
+			sb.WriteString(fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk))
+			spans = append(spans, chunkSpan(chunk))
 		}
+		b.required(SectionConflict, sb.String(), spans...)
 	}
 
-	if len(contextChunks) > 0 {
-		prompt += "RELEVANT CONTEXT:\n"
-		for i, chunk := range contextChunks {
-			prompt += fmt.Sprintf("Context %d from %s:\n%s\n\n", i+1, chunk.Source, chunk.Chunk)
+	nearby := b.section(SectionNearby, "RELEVANT CONTEXT:\n", shareNearby)
+	n := 1
+	for _, chunk := range contextChunks {
+		if nearby.add(fmt.Sprintf("Context %d from %s:\n%s\n\n", n, chunkLocation(chunk), chunk.Chunk), chunkSpan(chunk)) {
+			n++
 		}
 	}
 
-	if len(similarFunctions) > 0 {
-		prompt += "SIMILAR FUNCTIONS FOR REFERENCE:\n"
-		for i, chunk := range similarFunctions {
-			prompt += fmt.Sprintf("Function %d from %s:\n%s\n\n", i+1, chunk.Source, chunk.Chunk)
+	functions := b.section(SectionRetrieved, "SIMILAR FUNCTIONS FOR REFERENCE:\n", shareRetrieved)
+	n = 1
+	for _, chunk := range similarFunctions {
+		if functions.add(fmt.Sprintf("Function %d from %s:\n%s\n\n", n, chunkLocation(chunk), chunk.Chunk), chunkSpan(chunk)) {
+			n++
 		}
 	}
 
+	prompt, _ := b.build()
+
 	response, err := gs.generateResponse(ctx, prompt)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate response: %w", err)
@@ -86,25 +177,9 @@ func (gs *GeminiService) ResolveConflictsToFile(
 	conflictContent string,
 	filePath string,
 	userQuery string,
-	repoHash string,
+	repo *repo_index.RepoIndex,
 ) (string, error) {
-	similarChunks, err := gs.repoChunksRepo.GetSimilarChunks(ctx, userQuery, repoHash, 5)
-	if err != nil {
-		similarChunks = []repo_chunks.SimilarChunk{}
-	}
-
-	prompt := Prompt + "\n\nUser Request: " + userQuery + "\n\n"
-	prompt += fmt.Sprintf("File: %s\n", filePath)
-	prompt += "CONFLICTED FILE CONTENT:\n"
-	prompt += conflictContent + "\n\n"
-
-	if len(similarChunks) > 0 {
-		prompt += "REPOSITORY CONTEXT:\n"
-		for i, chunk := range similarChunks {
-			prompt += fmt.Sprintf("Context %d from %s (similarity: %.3f):\n", i+1, chunk.Source, chunk.Distance)
-			prompt += fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk)
-		}
-	}
+	prompt, _ := gs.buildFilePrompt(ctx, conflictContent, filePath, userQuery, repo)
 
 	response, err := gs.generateResponse(ctx, prompt)
 	if err != nil {
@@ -114,57 +189,57 @@ func (gs *GeminiService) ResolveConflictsToFile(
 	return response, nil
 }
 
-func (gs *GeminiService) StreamResolveConflictsToFile(
-	c *gin.Context,
+// buildFilePrompt assembles the whole-file resolution prompt shared by the
+// blocking, streaming and self-repairing resolvers. repo is the caller's
+// repository from OwnedRepo, or nil for no repository context.
+func (gs *GeminiService) buildFilePrompt(
+	ctx context.Context,
 	conflictContent string,
 	filePath string,
 	userQuery string,
-	repoHash string,
-) {
-	similarChunks, err := gs.repoChunksRepo.GetSimilarChunks(c.Request.Context(), userQuery, repoHash, 5)
-	if err != nil {
-		similarChunks = []repo_chunks.SimilarChunk{}
+	repo *repo_index.RepoIndex,
+) (string, PromptStats) {
+	parsed, _ := conflict.Parse(conflictContent)
+
+	similarChunks := gs.retrieveConflictContext(ctx, userQuery, parsed, repo, 5)
+	defs, refs := gs.crossReferences(ctx, filePath, parsed, repo)
+	var examples []accepted_resolutions.SimilarResolution
+	if parsed != nil {
+		examples = gs.acceptedExamples(ctx, repo, parsed.Hunks...)
 	}
 
-	prompt := Prompt + "\n\nUser Request: " + userQuery + "\n\n"
-	prompt += fmt.Sprintf("File: %s\n", filePath)
-	prompt += "CONFLICTED FILE CONTENT:\n"
-	prompt += conflictContent + "\n\n"
+	b := newPromptBuilder(gs.promptBudget)
+	b.required(SectionInstructions, Prompt+"\n\nUser Request: "+userQuery+"\n\n")
 
-	if len(similarChunks) > 0 {
-		prompt += "REPOSITORY CONTEXT:\n"
-		for i, chunk := range similarChunks {
-			prompt += fmt.Sprintf("Context %d from %s (similarity: %.3f):\n", i+1, chunk.Source, chunk.Distance)
-			prompt += fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk)
-		}
-	}
+	conflictSection := fmt.Sprintf("File: %s\n", filePath)
+	conflictSection += describeHunks(parsed)
+	conflictSection += "CONFLICTED FILE CONTENT:\n"
+	conflictSection += conflictContent + "\n\n"
+	// the whole file is in the prompt, so retrieved chunks of it are redundant
+	b.required(SectionConflict, conflictSection, span{source: filePath, start: 1, end: math.MaxInt})
 
-	StreamGeminiResponse(c, gs.client, prompt)
+	addAcceptedExamples(b, examples)
+	addCrossReferences(b, defs, refs)
+	addRetrievedChunks(b, "REPOSITORY CONTEXT:\n", similarChunks, true)
+
+	return b.build()
 }
 
 func (gs *GeminiService) ResolveConflictsWithSemanticSearch(
 	ctx context.Context,
 	userQuery string,
-	repoHash string,
+	repoId uuid.UUID,
 	k int,
 ) (string, error) {
-	similarChunks, err := gs.repoChunksRepo.GetSimilarChunks(ctx, userQuery, repoHash, k)
+	similarChunks, err := gs.repoChunksRepo.GetSimilarChunks(ctx, userQuery, repoId, k)
 	if err != nil {
 		return "", fmt.Errorf("failed to get similar chunks: %w", err)
 	}
 
-	prompt := Prompt + "\n\nUser Request: " + userQuery + "\n\n"
-
-	if len(similarChunks) > 0 {
-		prompt += "REPOSITORY CONTEXT (from semantic search):\n"
-		for i, chunk := range similarChunks {
-			prompt += fmt.Sprintf("Context %d from %s (similarity: %.3f):\n", i+1, chunk.Source, chunk.Distance)
-			if chunk.FileType == "conflict" {
-				prompt += fmt.Sprintf("Conflict section: %s\n", chunk.ConflictSection)
-			}
-			prompt += fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk)
-		}
-	}
+	b := newPromptBuilder(gs.promptBudget)
+	b.required(SectionInstructions, Prompt+"\n\nUser Request: "+userQuery+"\n\n")
+	addRetrievedChunks(b, "REPOSITORY CONTEXT (from semantic search):\n", similarChunks, false)
+	prompt, _ := b.build()
 
 	response, err := gs.generateResponse(ctx, prompt)
 	if err != nil {
@@ -177,27 +252,19 @@ func (gs *GeminiService) ResolveConflictsWithSemanticSearch(
 func (gs *GeminiService) ResolveConflictsWithThreshold(
 	ctx context.Context,
 	userQuery string,
-	repoHash string,
+	repoId uuid.UUID,
 	k int,
 	maxDistance float64,
 ) (string, error) {
-	similarChunks, err := gs.repoChunksRepo.GetSimilarChunksWithThreshold(ctx, userQuery, repoHash, k, maxDistance)
+	similarChunks, err := gs.repoChunksRepo.GetSimilarChunksWithThreshold(ctx, userQuery, repoId, k, maxDistance)
 	if err != nil {
 		return "", fmt.Errorf("failed to get similar chunks with threshold: %w", err)
 	}
 
-	prompt := Prompt + "\n\nUser Request: " + userQuery + "\n\n"
-
-	if len(similarChunks) > 0 {
-		prompt += "REPOSITORY CONTEXT (filtered by similarity threshold):\n"
-		for i, chunk := range similarChunks {
-			prompt += fmt.Sprintf("Context %d from %s (similarity: %.3f):\n", i+1, chunk.Source, chunk.Distance)
-			if chunk.FileType == "conflict" {
-				prompt += fmt.Sprintf("Conflict section: %s\n", chunk.ConflictSection)
-			}
-			prompt += fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk)
-		}
-	}
+	b := newPromptBuilder(gs.promptBudget)
+	b.required(SectionInstructions, Prompt+"\n\nUser Request: "+userQuery+"\n\n")
+	addRetrievedChunks(b, "REPOSITORY CONTEXT (filtered by similarity threshold):\n", similarChunks, false)
+	prompt, _ := b.build()
 
 	response, err := gs.generateResponse(ctx, prompt)
 	if err != nil {
@@ -269,29 +336,23 @@ func (gs *GeminiService) InteractiveResolution(
 }
 
 func (gs *GeminiService) generateResponse(ctx context.Context, prompt string) (string, error) {
-	thinkingBudget := int32(0)
-	iter := gs.client.Models.GenerateContentStream(
-		ctx,
-		"gemini-2.5-flash",
-		genai.Text(prompt),
-		&genai.GenerateContentConfig{
-			ThinkingConfig: &genai.ThinkingConfig{
-				ThinkingBudget: &thinkingBudget,
-			},
-		},
-	)
+	return gs.provider.Generate(ctx, prompt, gs.options)
+}
 
+// generate runs prompt with opts, passing the output to onToken as it
+// arrives when onToken is set.
+func (gs *GeminiService) generate(ctx context.Context, prompt string, opts llm.Options, onToken func(string)) (string, error) {
+	if onToken == nil {
+		return gs.provider.Generate(ctx, prompt, opts)
+	}
 	var response strings.Builder
-	for resp, err := range iter {
+	for text, err := range gs.provider.Stream(ctx, prompt, opts) {
 		if err != nil {
-			return "", fmt.Errorf("streaming error: %w", err)
-		}
-
-		if resp != nil && resp.Text() != "" {
-			response.WriteString(resp.Text())
+			return "", err
 		}
+		response.WriteString(text)
+		onToken(text)
 	}
-
 	return response.String(), nil
 }
 
@@ -356,8 +417,8 @@ func (gs *GeminiService) isValidShellCommand(line string) bool {
 	return false
 }
 
-func (gs *GeminiService) GetConflictFiles(ctx context.Context, repoHash string) ([]string, error) {
-	conflictChunks, err := gs.repoChunksRepo.GetConflictChunks(ctx, repoHash)
+func (gs *GeminiService) GetConflictFiles(ctx context.Context, repoId uuid.UUID) ([]string, error) {
+	conflictChunks, err := gs.repoChunksRepo.GetConflictChunks(ctx, repoId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get conflict chunks: %w", err)
 	}
@@ -375,13 +436,13 @@ func (gs *GeminiService) GetConflictFiles(ctx context.Context, repoHash string)
 	return files, nil
 }
 
-func (gs *GeminiService) GetRepoContext(ctx context.Context, repoHash string) (string, error) {
-	count, err := gs.repoChunksRepo.GetRepoChunksCount(ctx, repoHash)
+func (gs *GeminiService) GetRepoContext(ctx context.Context, repoId uuid.UUID) (string, error) {
+	count, err := gs.repoChunksRepo.GetRepoChunksCount(ctx, repoId)
 	if err != nil {
 		return "", fmt.Errorf("failed to get repo chunks count: %w", err)
 	}
 
-	sampleChunks, err := gs.repoChunksRepo.GetSimilarChunks(ctx, "repository structure", repoHash, 10)
+	sampleChunks, err := gs.repoChunksRepo.GetSimilarChunks(ctx, "repository structure", repoId, 10)
 	if err != nil {
 		return "", fmt.Errorf("failed to get sample chunks: %w", err)
 	}
@@ -396,3 +457,196 @@ func (gs *GeminiService) GetRepoContext(ctx context.Context, repoHash string) (s
 
 	return context.String(), nil
 }
+
+// retrieveConflictContext fetches repository context for a conflicted file,
+// fusing vector search over the hunk text with lexical matches on the
+// identifiers the hunks touch. Retrieval failures leave the prompt without
+// context rather than failing the resolution, as does a nil repo.
+func (gs *GeminiService) retrieveConflictContext(
+	ctx context.Context,
+	userQuery string,
+	parsed *conflict.File,
+	repo *repo_index.RepoIndex,
+	k int,
+) []repo_chunks.SimilarChunk {
+	if repo == nil {
+		return []repo_chunks.SimilarChunk{}
+	}
+	chunks, err := gs.retriever.Retrieve(ctx, repo.Id, rag.RetrievalQuery{
+		Text:        conflictQuery(userQuery, parsed),
+		Identifiers: rag.ConflictIdentifiers(parsed),
+	}, k)
+	if err != nil {
+		return []repo_chunks.SimilarChunk{}
+	}
+	return chunks
+}
+
+// crossReferences looks up the definitions of identifiers the hunks use and
+// the call sites of functions either side changed, excluding the conflicted
+// file itself, which is already in the prompt. They come only from repo, the
+// caller's own index from OwnedRepo; without one there are none. Lookup
+// failures are skipped.
+func (gs *GeminiService) crossReferences(
+	ctx context.Context,
+	filePath string,
+	parsed *conflict.File,
+	repo *repo_index.RepoIndex,
+) ([]repo_symbols.Definition, []repo_symbols.Reference) {
+	if gs.symbolsRepo == nil || repo == nil || parsed == nil || !parsed.HasConflicts() {
+		return nil, nil
+	}
+
+	var defs []repo_symbols.Definition
+	if names := rag.ConflictIdentifiers(parsed); len(names) > 0 {
+		found, err := gs.symbolsRepo.GetDefinitions(ctx, repo.Id, names, maxDefinitions)
+		if err == nil {
+			defs = slices.DeleteFunc(found, func(d repo_symbols.Definition) bool { return d.Source == filePath })
+		}
+	}
+
+	var refs []repo_symbols.Reference
+	if changed := rag.ChangedFunctions(parsed); len(changed) > 0 {
+		found, err := gs.symbolsRepo.GetReferences(ctx, repo.Id, path.Dir(filePath), changed, maxCallSites)
+		if err == nil {
+			refs = slices.DeleteFunc(found, func(r repo_symbols.Reference) bool { return r.Source == filePath })
+		}
+	}
+
+	return defs, refs
+}
+
+// acceptedExamples looks up resolutions people accepted in repo, the caller's
+// own index from OwnedRepo, for hunks like the given ones. Lookup failures
+// leave the prompt without examples.
+func (gs *GeminiService) acceptedExamples(ctx context.Context, repo *repo_index.RepoIndex, hunks ...*conflict.Hunk) []accepted_resolutions.SimilarResolution {
+	if gs.acceptedRepo == nil || repo == nil || len(hunks) == 0 {
+		return nil
+	}
+
+	var query strings.Builder
+	for _, h := range hunks {
+		query.WriteString(accepted_resolutions.EmbeddingText(h.Ours, h.Theirs))
+		query.WriteString("\n")
+	}
+	examples, err := gs.acceptedRepo.GetSimilarResolutions(ctx, repo.Id, query.String(), maxExamples)
+	if err != nil {
+		return nil
+	}
+	return examples
+}
+
+// addAcceptedExamples adds accepted resolutions, nearest first, so the model
+// can follow how conflicts like these were resolved before. Who accepted them
+// is left out: the model has no use for it.
+func addAcceptedExamples(b *promptBuilder, examples []accepted_resolutions.SimilarResolution) {
+	section := b.section(SectionAcceptedExamples, "PREVIOUSLY ACCEPTED RESOLUTIONS IN THIS REPO:\n", shareAcceptedExamples)
+	n := 1
+	for _, e := range examples {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Example %d from %s, accepted", n, e.Source))
+		if e.Edited {
+			sb.WriteString(" after editing the suggested resolution")
+		}
+		sb.WriteString(":\n")
+		sb.WriteString(fmt.Sprintf("OURS:\n%s\n", e.Ours))
+		if e.Base != nil {
+			sb.WriteString(fmt.Sprintf("BASE:\n%s\n", *e.Base))
+		}
+		sb.WriteString(fmt.Sprintf("THEIRS:\n%s\n", e.Theirs))
+		sb.WriteString(fmt.Sprintf("ACCEPTED:\n%s\n\n", e.Final))
+		if section.add(sb.String(), span{}) {
+			n++
+		}
+	}
+}
+
+// addCrossReferences adds definitions and call sites as one section, call
+// sites first: when a signature changes, its callers are what break.
+func addCrossReferences(b *promptBuilder, defs []repo_symbols.Definition, refs []repo_symbols.Reference) {
+	xrefs := b.section(SectionCrossReferences, "CROSS REFERENCES:\n", shareCrossReferences)
+	for _, r := range refs {
+		in := ""
+		if r.Caller != "" {
+			in = " in " + r.Caller
+		}
+		text := fmt.Sprintf("- %s:%d%s uses %s: %s\n", r.Source, r.Line, in, r.DefName, r.Snippet)
+		xrefs.add(text, span{source: r.Source, start: r.Line, end: r.Line})
+	}
+	for _, d := range defs {
+		text := fmt.Sprintf("- definition of %s %s.%s at %s:%d-%d\n%s\n", d.Kind, d.Package, d.Name, d.Source, d.LineStart, d.LineEnd, d.Signature)
+		xrefs.add(text, span{source: d.Source, start: d.LineStart, end: d.LineEnd})
+	}
+}
+
+// addRetrievedChunks adds chunks in rank order as the retrieved section.
+func addRetrievedChunks(b *promptBuilder, header string, chunks []repo_chunks.SimilarChunk, fused bool) {
+	retrieved := b.section(SectionRetrieved, header, shareRetrieved)
+	n := 1
+	for _, chunk := range chunks {
+		var text string
+		if fused {
+			text = fmt.Sprintf("Context %d from %s (relevance: %.4f):\n", n, chunkLocation(chunk), chunk.Score)
+		} else {
+			text = fmt.Sprintf("Context %d from %s (similarity: %.3f):\n", n, chunkLocation(chunk), chunk.Distance)
+		}
+		if chunk.FileType == "conflict" {
+			text += fmt.Sprintf("Conflict section: %s\n", chunk.ConflictSection)
+		}
+		text += fmt.Sprintf("Content:\n%s\n\n", chunk.Chunk)
+		if retrieved.add(text, chunkSpan(chunk)) {
+			n++
+		}
+	}
+}
+
+func chunkSpan(chunk repo_chunks.SimilarChunk) span {
+	return span{source: chunk.Source, start: chunk.LineStart, end: chunk.LineEnd}
+}
+
+// conflictQuery builds the similarity search query from the user's request and
+// the text of every hunk side, so retrieval targets the code actually in conflict.
+func conflictQuery(userQuery string, parsed *conflict.File) string {
+	if parsed == nil || !parsed.HasConflicts() {
+		return userQuery
+	}
+
+	var sb strings.Builder
+	sb.WriteString(userQuery)
+	for _, h := range parsed.Hunks {
+		sb.WriteString("\n")
+		sb.WriteString(h.Ours)
+		sb.WriteString(h.Theirs)
+	}
+	return sb.String()
+}
+
+// describeHunks lists where each hunk sits and which branches it is between.
+// Returns an empty string when the content could not be parsed.
+func describeHunks(parsed *conflict.File) string {
+	if parsed == nil || !parsed.HasConflicts() {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("CONFLICT HUNKS (%d):\n", len(parsed.Hunks)))
+	for _, h := range parsed.Hunks {
+		sb.WriteString(fmt.Sprintf("- Hunk %d: lines %d-%d, ours=%q theirs=%q", h.Index+1, h.Range.StartLine, h.Range.EndLine, h.OursLabel, h.TheirsLabel))
+		if h.HasBase {
+			sb.WriteString(fmt.Sprintf(" base=%q", h.BaseLabel))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// chunkLocation renders where a retrieved chunk comes from, e.g.
+// "api/server.go:40-72 (Server.Start)".
+func chunkLocation(chunk repo_chunks.SimilarChunk) string {
+	loc := fmt.Sprintf("%s:%d-%d", chunk.Source, chunk.LineStart, chunk.LineEnd)
+	if chunk.Symbol != "" {
+		loc += " (" + chunk.Symbol + ")"
+	}
+	return loc
+}
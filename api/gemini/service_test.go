@@ -0,0 +1,97 @@
+package gemini
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/llm"
+)
+
+func TestChunkLocation(t *testing.T) {
+	for _, tc := range []struct {
+		chunk repo_chunks.SimilarChunk
+		want  string
+	}{
+		{repo_chunks.SimilarChunk{Source: "api/server.go", LineStart: 40, LineEnd: 72, Symbol: "Server.Start"}, "api/server.go:40-72 (Server.Start)"},
+		{repo_chunks.SimilarChunk{Source: "README.md", LineStart: 1, LineEnd: 12}, "README.md:1-12"},
+	} {
+		if got := chunkLocation(tc.chunk); got != tc.want {
+			t.Errorf("chunkLocation(%+v) = %q, want %q", tc.chunk, got, tc.want)
+		}
+	}
+}
+
+// fakeSymbols records which repos symbols were looked up in.
+type fakeSymbols struct {
+	repo_symbols.RepoSymbolsRepository
+	repos []uuid.UUID
+}
+
+func (f *fakeSymbols) GetDefinitions(ctx context.Context, repoId uuid.UUID, names []string, limit int) ([]repo_symbols.Definition, error) {
+	f.repos = append(f.repos, repoId)
+	return []repo_symbols.Definition{
+		{Source: "greet.go", Name: "Greet"},
+		{Source: "main.go", Name: "main"},
+	}, nil
+}
+
+func (f *fakeSymbols) GetReferences(ctx context.Context, repoId uuid.UUID, dir string, names []string, limit int) ([]repo_symbols.Reference, error) {
+	f.repos = append(f.repos, repoId)
+	return []repo_symbols.Reference{{Source: "welcome.go", DefName: "Greet"}}, nil
+}
+
+func TestCrossReferencesComeFromTheCallersRepo(t *testing.T) {
+	parsed, err := conflict.Parse("package main\n\nfunc Greet(name string) string {\n<<<<<<< HEAD\n\treturn \"hello \" + name\n=======\n\treturn \"hi \" + name\n>>>>>>> feature\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := &fakeSymbols{}
+	gs := NewGeminiService(llm.NewFakeProvider(), nil, nil, symbols, nil, nil, nil)
+
+	if defs, refs := gs.crossReferences(context.Background(), "main.go", parsed, nil); defs != nil || refs != nil || symbols.repos != nil {
+		t.Fatalf("looked up symbols without a repo: %v %v in %v", defs, refs, symbols.repos)
+	}
+
+	repo := &repo_index.RepoIndex{Id: uuid.New()}
+	defs, refs := gs.crossReferences(context.Background(), "main.go", parsed, repo)
+	if len(symbols.repos) != 2 || symbols.repos[0] != repo.Id || symbols.repos[1] != repo.Id {
+		t.Errorf("looked up symbols in %v, want %s", symbols.repos, repo.Id)
+	}
+	// the conflicted file is already in the prompt
+	if len(defs) != 1 || defs[0].Source != "greet.go" || len(refs) != 1 {
+		t.Errorf("cross references: %+v %+v", defs, refs)
+	}
+}
+
+// fakeAccepted records which repos examples were looked up in.
+type fakeAccepted struct {
+	accepted_resolutions.AcceptedResolutionsRepository
+	repos []uuid.UUID
+}
+
+func (f *fakeAccepted) GetSimilarResolutions(ctx context.Context, repoId uuid.UUID, text string, k int) ([]accepted_resolutions.SimilarResolution, error) {
+	f.repos = append(f.repos, repoId)
+	return nil, nil
+}
+
+func TestAcceptedExamplesComeFromTheCallersRepo(t *testing.T) {
+	accepted := &fakeAccepted{}
+	gs := NewGeminiService(llm.NewFakeProvider(), nil, nil, nil, nil, nil, accepted)
+	h := &conflict.Hunk{Ours: "a\n", Theirs: "b\n"}
+
+	gs.acceptedExamples(context.Background(), nil, h)
+	if accepted.repos != nil {
+		t.Fatalf("looked up examples without a repo: %v", accepted.repos)
+	}
+	repo := &repo_index.RepoIndex{Id: uuid.New()}
+	gs.acceptedExamples(context.Background(), repo, h)
+	if len(accepted.repos) != 1 || accepted.repos[0] != repo.Id {
+		t.Errorf("looked up examples in %v, want %s", accepted.repos, repo.Id)
+	}
+}
@@ -1,47 +1,405 @@
 package gemini
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"google.golang.org/genai"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/utils"
+	"github.com/tahminator/go-react-template/validation"
 )
 
-func StreamGeminiResponse(c *gin.Context, geminiClient *genai.Client, message string) { // if error, close the stream and print the error in the backend
-	thinkingBudget := int32(0)
-	iter := geminiClient.Models.GenerateContentStream(
-		c.Request.Context(),
-		"gemini-2.5-flash",
-		genai.Text(message),
-		&genai.GenerateContentConfig{
-			ThinkingConfig: &genai.ThinkingConfig{
-				ThinkingBudget: &thinkingBudget,
-			},
-		},
-	)
-
-	c.Header("Content-Type", "text/plain")
+// Event types of the resolution stream. Every stream ends with exactly one
+// done or error event.
+const (
+	EventProgress     = "progress"
+	EventHunkStarted  = "hunk_started"
+	EventToken        = "token"
+	EventHunkResolved = "hunk_resolved"
+	EventValidation   = "validation"
+	EventError        = "error"
+	EventDone         = "done"
+)
+
+// Stages reported in progress events.
+const (
+	StageRetrieving = "retrieving"
+	StageGenerating = "generating"
+	StageResolving  = "resolving"
+)
+
+// Sources of an error event, so clients can tell a failing model from a bad
+// request without reading the message.
+const (
+	ErrorSourceModel   = "model"
+	ErrorSourceRequest = "request"
+)
+
+// StreamIdHeader names the stream a response belongs to; pass it to
+// /gemini/streams/:id with Last-Event-ID to resume.
+const StreamIdHeader = "X-Stream-Id"
+
+const (
+	// HeartbeatInterval is how often an idle stream sends a comment line so
+	// proxies do not close it.
+	HeartbeatInterval = 15 * time.Second
+
+	// a stream keeps running after its client disconnects, for at most
+	// streamTimeout, and can be resumed until streamRetention after it ends
+	streamTimeout   = 10 * time.Minute
+	streamRetention = 5 * time.Minute
+
+	// runs keep going without a client, so how many may run at once is
+	// bounded per user and overall, and so is what each one buffers
+	maxStreamsPerUser = 4
+	maxStreams        = 64
+	maxStreamBytes    = 8 << 20
+)
+
+var (
+	ErrTooManyStreams = errors.New("too many resolutions are streaming; wait for one to finish")
+	ErrStreamTooLarge = errors.New("the stream outgrew its buffer and was stopped")
+)
+
+type ProgressEvent struct {
+	StreamId string       `json:"stream_id"`
+	Stage    string       `json:"stage"`
+	Hunks    int          `json:"hunks,omitempty"`
+	Pending  int          `json:"pending,omitempty"`
+	Prompt   *PromptStats `json:"prompt,omitempty"`
+}
+
+type HunkStartedEvent struct {
+	Index int            `json:"index"`
+	Range conflict.Range `json:"range"`
+}
+
+// TokenEvent is a piece of model output. Hunk is set when hunks are resolved
+// concurrently, so tokens of different hunks can be told apart.
+type TokenEvent struct {
+	Hunk *int   `json:"hunk,omitempty"`
+	Text string `json:"text"`
+}
+
+type ErrorEvent struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// DoneEvent carries the final content; Resolution is set for hunk streams.
+type DoneEvent struct {
+	Content    string          `json:"content"`
+	Resolution *HunkResolution `json:"resolution,omitempty"`
+}
+
+type streamEvent struct {
+	id    int
+	event string
+	data  []byte
+}
+
+// streamRun buffers every event of one stream so a client that lost its
+// connection can pick up where it left off.
+type streamRun struct {
+	id    uuid.UUID
+	owner uuid.UUID
+	// cancel stops fn, once the run has buffered maxStreamBytes
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	events []streamEvent
+	size   int
+	closed bool
+	// closed and replaced whenever an event is added
+	wake chan struct{}
+}
+
+// emit appends an event. Event ids count up from 1 within a stream. A nil
+// run discards events, so blocking callers can share streaming code paths.
+func (r *streamRun) emit(event string, data any) {
+	if r == nil {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("stream %s: failed to encode %s event: %v", r.id, event, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	// the error takes the place of the event that did not fit and ends the
+	// run, so it still ends with exactly one error or done event
+	if r.size+len(payload) > maxStreamBytes {
+		event = EventError
+		payload, _ = json.Marshal(ErrorEvent{Source: ErrorSourceModel, Message: ErrStreamTooLarge.Error()})
+		r.closed = true
+		r.cancel()
+	}
+	r.size += len(payload)
+	r.events = append(r.events, streamEvent{id: len(r.events) + 1, event: event, data: payload})
+	close(r.wake)
+	r.wake = make(chan struct{})
+}
+
+// progress emits a progress event stamped with the stream id.
+func (r *streamRun) progress(p ProgressEvent) {
+	if r == nil {
+		return
+	}
+	p.StreamId = r.id.String()
+	r.emit(EventProgress, p)
+}
+
+func (r *streamRun) fail(source string, err error) {
+	r.emit(EventError, ErrorEvent{Source: source, Message: err.Error()})
+}
+
+func (r *streamRun) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	close(r.wake)
+	r.wake = make(chan struct{})
+}
+
+// since returns the events after id, whether the run has ended, and a
+// channel that is closed when that changes.
+func (r *streamRun) since(id int) ([]streamEvent, bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id = max(0, min(id, len(r.events)))
+	return r.events[id:], r.closed, r.wake
+}
+
+type streamHub struct {
+	mu   sync.Mutex
+	runs map[uuid.UUID]*streamRun
+	// running counts the runs whose fn has not returned, per owner
+	running map[uuid.UUID]int
+	total   int
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{runs: map[uuid.UUID]*streamRun{}, running: map[uuid.UUID]int{}}
+}
+
+// start runs fn in the background for owner and returns its stream, or
+// ErrTooManyStreams if owner or the hub already has as many runs going as
+// allowed. fn keeps going if the client that started it goes away; the run
+// is forgotten streamRetention after fn returns.
+func (h *streamHub) start(ctx context.Context, owner uuid.UUID, fn func(ctx context.Context, run *streamRun)) (*streamRun, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), streamTimeout)
+	run := &streamRun{id: uuid.New(), owner: owner, cancel: cancel, wake: make(chan struct{})}
+
+	h.mu.Lock()
+	if h.running[owner] >= maxStreamsPerUser || h.total >= maxStreams {
+		h.mu.Unlock()
+		cancel()
+		return nil, ErrTooManyStreams
+	}
+	h.runs[run.id] = run
+	h.running[owner]++
+	h.total++
+	h.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		defer run.close()
+		defer h.finished(owner)
+		fn(ctx, run)
+	}()
+	go func() {
+		<-ctx.Done()
+		time.AfterFunc(streamRetention, func() {
+			h.mu.Lock()
+			delete(h.runs, run.id)
+			h.mu.Unlock()
+		})
+	}()
+	return run, nil
+}
+
+func (h *streamHub) finished(owner uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.total--
+	h.running[owner]--
+	if h.running[owner] == 0 {
+		delete(h.running, owner)
+	}
+}
+
+// get returns a run of owner; the runs of others are not found.
+func (h *streamHub) get(id, owner uuid.UUID) (*streamRun, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	run, ok := h.runs[id]
+	if !ok || run.owner != owner {
+		return nil, false
+	}
+	return run, true
+}
+
+// serveStream writes the events of run after lastId as Server-Sent Events
+// until the run ends or the client disconnects, sending a heartbeat comment
+// whenever the stream has been idle for HeartbeatInterval.
+func serveStream(c *gin.Context, run *streamRun, lastId int) {
+	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
 	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Allow-Headers", "Cache-Control")
-
+	c.Header("Access-Control-Allow-Headers", "Cache-Control, Last-Event-ID")
+	c.Header("Access-Control-Expose-Headers", StreamIdHeader)
+	c.Header(StreamIdHeader, run.id.String())
+	c.Status(http.StatusOK)
 	c.Writer.Flush()
 
-	for resp, err := range iter {
-		if err != nil {
-			log.Printf("Gemini streaming error: %v", err)
-			c.Writer.WriteString("Error: " + err.Error())
-			c.Writer.Flush()
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		events, closed, wake := run.since(lastId)
+		for _, e := range events {
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.event, e.data)
+			lastId = e.id
+		}
+		c.Writer.Flush()
+		if closed {
 			return
 		}
 
-		if resp != nil && resp.Text() != "" {
-			chunk := resp.Text()
-			c.Writer.WriteString(chunk)
+		select {
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
 			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
 		}
 	}
+}
 
-	c.Writer.Flush()
+// lastEventId reads the id a resuming client saw last, from the standard
+// Last-Event-ID header or, for clients that cannot set headers, the
+// last_event_id query parameter.
+func lastEventId(c *gin.Context) (int, error) {
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("last_event_id")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid last event id %q", raw)
+	}
+	return id, nil
+}
+
+// stream starts fn as a run of the signed-in user and serves it from the
+// first event.
+func (gs *GeminiService) stream(c *gin.Context, fn func(ctx context.Context, run *streamRun)) {
+	ao := c.MustGet("ao").(*utils.AuthenticationObject)
+	run, err := gs.streams.start(c.Request.Context(), ao.User.Id, fn)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	serveStream(c, run, 0)
+}
+
+// StreamPrompt streams the model's reply to prompt as token events.
+func (gs *GeminiService) StreamPrompt(c *gin.Context, prompt string) {
+	gs.stream(c, func(ctx context.Context, run *streamRun) {
+		run.progress(ProgressEvent{Stage: StageGenerating})
+		response, err := gs.generate(ctx, prompt, gs.options, func(text string) {
+			run.emit(EventToken, TokenEvent{Text: text})
+		})
+		if err != nil {
+			run.fail(ErrorSourceModel, err)
+			return
+		}
+		run.emit(EventDone, DoneEvent{Content: response})
+	})
+}
+
+// ResumeStream replays the events of a stream of the signed-in user after the
+// client's last event id and follows it if it is still running.
+func (gs *GeminiService) ResumeStream(c *gin.Context, streamId uuid.UUID) {
+	lastId, err := lastEventId(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ao := c.MustGet("ao").(*utils.AuthenticationObject)
+	run, ok := gs.streams.get(streamId, ao.User.Id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "stream not found or expired"})
+		return
+	}
+	serveStream(c, run, lastId)
+}
+
+// StreamResolveConflictsToFile resolves the whole file, streaming the model's
+// output as it arrives and validating the result once it is complete.
+func (gs *GeminiService) StreamResolveConflictsToFile(
+	c *gin.Context,
+	conflictContent string,
+	filePath string,
+	userQuery string,
+	repo *repo_index.RepoIndex,
+) {
+	gs.stream(c, func(ctx context.Context, run *streamRun) {
+		run.progress(ProgressEvent{Stage: StageRetrieving})
+		prompt, stats := gs.buildFilePrompt(ctx, conflictContent, filePath, userQuery, repo)
+
+		run.progress(ProgressEvent{Stage: StageGenerating, Prompt: &stats})
+		response, err := gs.generate(ctx, prompt, gs.options, func(text string) {
+			run.emit(EventToken, TokenEvent{Text: text})
+		})
+		if err != nil {
+			run.fail(ErrorSourceModel, err)
+			return
+		}
+
+		content := stripCodeFence(response)
+		run.emit(EventValidation, validation.Validate(filePath, content))
+		run.emit(EventDone, DoneEvent{Content: content})
+	})
+}
+
+// StreamResolveHunks is ResolveHunks with progress: each hunk reports when
+// it starts and is resolved, and model output is streamed per hunk.
+func (gs *GeminiService) StreamResolveHunks(
+	c *gin.Context,
+	conflictContent string,
+	filePath string,
+	userQuery string,
+	repo *repo_index.RepoIndex,
+	opts HunkOptions,
+) {
+	gs.stream(c, func(ctx context.Context, run *streamRun) {
+		result, err := gs.resolveHunks(ctx, conflictContent, filePath, userQuery, repo, opts, run)
+		if err != nil {
+			run.fail(ErrorSourceRequest, err)
+			return
+		}
+		run.emit(EventValidation, result.Validation)
+		run.emit(EventDone, DoneEvent{Content: result.Content, Resolution: result})
+	})
 }
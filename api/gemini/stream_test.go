@@ -0,0 +1,272 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/database/repository/user"
+	"github.com/tahminator/go-react-template/llm"
+	"github.com/tahminator/go-react-template/utils"
+)
+
+// blockedRun is the fn of a run that waits until release is closed.
+func blockedRun(release <-chan struct{}) func(context.Context, *streamRun) {
+	return func(ctx context.Context, run *streamRun) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func waitClosed(run *streamRun) {
+	for {
+		_, closed, wake := run.since(0)
+		if closed {
+			return
+		}
+		<-wake
+	}
+}
+
+func TestStreamHubCapsRunsPerUser(t *testing.T) {
+	hub := newStreamHub()
+	owner, other := uuid.New(), uuid.New()
+	release := make(chan struct{})
+
+	var runs []*streamRun
+	for range maxStreamsPerUser {
+		run, err := hub.start(context.Background(), owner, blockedRun(release))
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, run)
+	}
+	if _, err := hub.start(context.Background(), owner, blockedRun(release)); !errors.Is(err, ErrTooManyStreams) {
+		t.Errorf("run past the cap: %v", err)
+	}
+	if _, err := hub.start(context.Background(), other, blockedRun(release)); err != nil {
+		t.Errorf("another user's run: %v", err)
+	}
+
+	close(release)
+	// a run stops counting before it closes
+	for _, run := range runs {
+		waitClosed(run)
+	}
+	if _, err := hub.start(context.Background(), owner, blockedRun(release)); err != nil {
+		t.Errorf("run once the others finished: %v", err)
+	}
+}
+
+func TestStreamHubOnlyReturnsTheOwnersRuns(t *testing.T) {
+	hub := newStreamHub()
+	owner := uuid.New()
+	run, err := hub.start(context.Background(), owner, func(context.Context, *streamRun) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := hub.get(run.id, owner); !ok || got != run {
+		t.Errorf("the owner's run was not found")
+	}
+	if _, ok := hub.get(run.id, uuid.New()); ok {
+		t.Errorf("another user found the run")
+	}
+}
+
+func TestStreamRunStopsAtItsBufferLimit(t *testing.T) {
+	hub := newStreamHub()
+	chunk := strings.Repeat("x", 1<<20)
+	stopped := make(chan error, 1)
+	run, err := hub.start(context.Background(), uuid.New(), func(ctx context.Context, run *streamRun) {
+		for ctx.Err() == nil {
+			run.emit(EventToken, TokenEvent{Text: chunk})
+		}
+		stopped <- ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-stopped; !errors.Is(err, context.Canceled) {
+		t.Errorf("the run was not cancelled: %v", err)
+	}
+	waitClosed(run)
+	events, _, _ := run.since(0)
+	last := events[len(events)-1]
+	var failure ErrorEvent
+	if err := json.Unmarshal(last.data, &failure); err != nil || last.event != EventError || failure.Message != ErrStreamTooLarge.Error() {
+		t.Errorf("last event %s: %s", last.event, last.data)
+	}
+	if run.size > maxStreamBytes+len(last.data) {
+		t.Errorf("buffered %d bytes, the limit is %d", run.size, maxStreamBytes)
+	}
+	for _, e := range events[:len(events)-1] {
+		if e.event != EventToken {
+			t.Errorf("unexpected %s event before the error", e.event)
+		}
+	}
+}
+
+// serveAs runs handler for a GET of target made by the user with id userId.
+func serveAs(userId uuid.UUID, target string, handler func(c *gin.Context)) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	c.Set("ao", &utils.AuthenticationObject{User: &user.User{Id: userId}})
+	handler(c)
+	return w
+}
+
+type sseEvent struct {
+	id    int
+	event string
+	data  string
+}
+
+func (e sseEvent) decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(e.data), v); err != nil {
+		t.Fatalf("%s event: %v: %s", e.event, err, e.data)
+	}
+}
+
+// readEvents parses a Server-Sent Events body, skipping comments.
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		var e sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				fmt.Sscan(value, &e.id)
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			}
+		}
+		if e.event != "" {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		t.Fatalf("no events in stream:\n%s", body)
+	}
+	return events
+}
+
+func TestStreamResolveHunksReportsProgressAndResumes(t *testing.T) {
+	gs := newTestService(llm.NewFakeProvider("\treturn \"hi, \" + name + \"!\"\n"))
+	owner := uuid.New()
+
+	w := serveAs(owner, "/", func(c *gin.Context) {
+		gs.StreamResolveHunks(c, conflictedFile, "main.go", "", nil, HunkOptions{})
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream: status %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type %q", ct)
+	}
+
+	events := readEvents(t, w.Body.String())
+	var types []string
+	for _, e := range events {
+		if len(types) == 0 || types[len(types)-1] != e.event {
+			types = append(types, e.event)
+		}
+	}
+	want := []string{EventProgress, EventHunkStarted, EventToken, EventHunkResolved, EventValidation, EventDone}
+	if !slices.Equal(types, want) {
+		t.Fatalf("event sequence %v, want %v", types, want)
+	}
+
+	var progress ProgressEvent
+	events[0].decode(t, &progress)
+	if progress.Stage != StageResolving || progress.Hunks != 1 || progress.Pending != 1 {
+		t.Errorf("progress %+v", progress)
+	}
+	if progress.StreamId != w.Header().Get(StreamIdHeader) {
+		t.Errorf("progress names stream %q, header %q", progress.StreamId, w.Header().Get(StreamIdHeader))
+	}
+	var token TokenEvent
+	events[2].decode(t, &token)
+	if token.Hunk == nil || *token.Hunk != 0 {
+		t.Errorf("token is not attributed to hunk 0: %+v", token)
+	}
+	var done DoneEvent
+	events[len(events)-1].decode(t, &done)
+	if done.Content != resolvedFile || done.Resolution == nil || done.Resolution.ModelResolved != 1 {
+		t.Errorf("done: %+v", done)
+	}
+
+	streamId := uuid.MustParse(progress.StreamId)
+	resume := func(userId, streamId uuid.UUID, target string) *httptest.ResponseRecorder {
+		return serveAs(userId, target, func(c *gin.Context) { gs.ResumeStream(c, streamId) })
+	}
+
+	// a client that saw the first two events picks up from the third
+	w = resume(owner, streamId, "/?last_event_id=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("resume: status %d: %s", w.Code, w.Body.String())
+	}
+	resumed := readEvents(t, w.Body.String())
+	if len(resumed) != len(events)-2 || resumed[0].id != 3 || resumed[0].data != events[2].data {
+		t.Errorf("resumed %d events starting at id %d, want %d starting at 3", len(resumed), resumed[0].id, len(events)-2)
+	}
+
+	if w := resume(owner, streamId, "/?last_event_id=two"); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed last event id: status %d", w.Code)
+	}
+	if w := resume(owner, uuid.New(), "/"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown stream: status %d", w.Code)
+	}
+	if w := resume(uuid.New(), streamId, "/"); w.Code != http.StatusNotFound {
+		t.Errorf("another user's stream: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamResolveReportsModelErrorsAsEvents(t *testing.T) {
+	provider := llm.NewFakeProvider()
+	provider.Respond = func(prompt string) (string, error) {
+		return "", fmt.Errorf("model overloaded")
+	}
+	gs := newTestService(provider)
+
+	w := serveAs(uuid.New(), "/", func(c *gin.Context) {
+		gs.StreamResolveConflictsToFile(c, conflictedFile, "main.go", "", nil)
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream: status %d: %s", w.Code, w.Body.String())
+	}
+
+	events := readEvents(t, w.Body.String())
+	last := events[len(events)-1]
+	if last.event != EventError {
+		t.Fatalf("stream must end with an error event, got %+v", events)
+	}
+	var streamErr ErrorEvent
+	last.decode(t, &streamErr)
+	if streamErr.Source != ErrorSourceModel || !strings.Contains(streamErr.Message, "model overloaded") {
+		t.Errorf("error event %+v", streamErr)
+	}
+	for _, e := range events {
+		if e.event == EventToken || e.event == EventDone {
+			t.Errorf("unexpected %s event after a model failure", e.event)
+		}
+	}
+}
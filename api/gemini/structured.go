@@ -0,0 +1,160 @@
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/tahminator/go-react-template/conflict"
+)
+
+const (
+	// DefaultReviewThreshold is the confidence below which a structured
+	// resolution must be reviewed by a person.
+	DefaultReviewThreshold = 0.7
+
+	// ModelStrategyMerged is what the model reports when it wrote the hunk
+	// itself rather than keeping a side.
+	ModelStrategyMerged = "merged"
+)
+
+// modelStrategies are the strategies a structured reply may report.
+var modelStrategies = []string{
+	string(conflict.StrategyOurs),
+	string(conflict.StrategyTheirs),
+	string(conflict.StrategyBase),
+	string(conflict.StrategyUnion),
+	ModelStrategyMerged,
+}
+
+// hunkResponseSchema is the JSON Schema for a structured hunk reply. Every
+// property is required and no others are allowed, as strict OpenAI-compatible
+// servers demand; the confidence range is checked in parseHunkAnswer since
+// not all of them accept minimum and maximum.
+var hunkResponseSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"resolved": map[string]any{
+			"type":        "string",
+			"description": "The lines that replace the conflict hunk, without conflict markers.",
+		},
+		"strategy": map[string]any{
+			"type": "string",
+			"enum": modelStrategies,
+		},
+		"rationale": map[string]any{
+			"type": "string",
+		},
+		"confidence": map[string]any{
+			"type":        "number",
+			"description": "From 0 (a guess) to 1 (certain).",
+		},
+		"risks": map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string"},
+		},
+	},
+	"required":             []string{"resolved", "strategy", "rationale", "confidence", "risks"},
+	"additionalProperties": false,
+}
+
+// hunkAnswer is a structured hunk reply. Fields are pointers so a missing
+// field can be told apart from an empty one.
+type hunkAnswer struct {
+	Resolved   *string   `json:"resolved"`
+	Strategy   *string   `json:"strategy"`
+	Rationale  *string   `json:"rationale"`
+	Confidence *float64  `json:"confidence"`
+	Risks      *[]string `json:"risks"`
+}
+
+// parseHunkAnswer decodes and checks a structured reply. Anything that makes
+// the reply unusable is an error: malformed JSON, unknown or missing fields,
+// a confidence outside [0, 1], an unknown strategy or leftover markers.
+func parseHunkAnswer(response string) (*hunkAnswer, error) {
+	dec := json.NewDecoder(strings.NewReader(stripCodeFence(response)))
+	dec.DisallowUnknownFields()
+
+	var answer hunkAnswer
+	if err := dec.Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid structured response: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid structured response: trailing data after the JSON object")
+	}
+
+	var missing []string
+	if answer.Resolved == nil {
+		missing = append(missing, "resolved")
+	}
+	if answer.Strategy == nil {
+		missing = append(missing, "strategy")
+	}
+	if answer.Rationale == nil {
+		missing = append(missing, "rationale")
+	}
+	if answer.Confidence == nil {
+		missing = append(missing, "confidence")
+	}
+	if answer.Risks == nil {
+		missing = append(missing, "risks")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("invalid structured response: missing %s", strings.Join(missing, ", "))
+	}
+
+	if c := *answer.Confidence; c < 0 || c > 1 {
+		return nil, fmt.Errorf("invalid structured response: confidence %v is outside [0, 1]", c)
+	}
+	if !slices.Contains(modelStrategies, *answer.Strategy) {
+		return nil, fmt.Errorf("invalid structured response: unknown strategy %q", *answer.Strategy)
+	}
+	if conflict.HasMarkers(*answer.Resolved) {
+		return nil, fmt.Errorf("invalid structured response: resolved text still contains conflict markers")
+	}
+	return &answer, nil
+}
+
+// applyHunkAnswer fills res from a checked reply and decides whether the
+// hunk needs a person to look at it.
+func applyHunkAnswer(res *HunkResult, h *conflict.Hunk, answer *hunkAnswer, threshold float64) {
+	res.Strategy = StrategyAI
+	res.ModelStrategy = *answer.Strategy
+	res.Rationale = *answer.Rationale
+	res.Confidence = answer.Confidence
+	res.Risks = *answer.Risks
+	res.Resolved = cleanHunkResponse(*answer.Resolved, h)
+
+	var reasons []string
+	if c := *answer.Confidence; c < threshold {
+		reasons = append(reasons, fmt.Sprintf("confidence %.2f is below the review threshold %.2f", c, threshold))
+	}
+	if resolved := strings.TrimSpace(*answer.Resolved); strings.HasPrefix(resolved, "```") && !strings.HasPrefix(strings.TrimSpace(h.Ours), "```") {
+		reasons = append(reasons, "resolved text was wrapped in a code fence")
+	}
+	// the model says it kept a side; make sure it actually did
+	if *answer.Strategy != ModelStrategyMerged {
+		if want, err := h.Apply(conflict.Strategy(*answer.Strategy)); err != nil {
+			reasons = append(reasons, err.Error())
+		} else if !sameLines(want, res.Resolved) {
+			reasons = append(reasons, fmt.Sprintf("reported strategy %q does not match the resolved text", *answer.Strategy))
+		}
+	}
+
+	if len(reasons) > 0 {
+		res.NeedsReview = true
+		res.ReviewReason = strings.Join(reasons, "; ")
+	}
+}
+
+// sameLines compares hunk texts ignoring line endings and surrounding blank
+// lines.
+func sameLines(a, b string) bool {
+	norm := func(s string) string {
+		return strings.Trim(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	}
+	return norm(a) == norm(b)
+}
@@ -3,12 +3,12 @@ package github
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,12 +17,18 @@ import (
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	gh "github.com/google/go-github/v75/github"
 
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
 	"github.com/tahminator/go-react-template/database/repository/session"
 	"github.com/tahminator/go-react-template/database/repository/user"
+	"github.com/tahminator/go-react-template/gitexec"
+	"github.com/tahminator/go-react-template/gitrepo"
+	"github.com/tahminator/go-react-template/mergesession"
 	"github.com/tahminator/go-react-template/utils"
+	"github.com/tahminator/go-react-template/validation"
 )
 
-func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, sessionRepository session.SessionRepository) *gin.RouterGroup {
+func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, sessionRepository session.SessionRepository, indexer rag.RepoIndexer, acceptedRepo accepted_resolutions.AcceptedResolutionsRepository, mergeSessions *mergesession.Manager) *gin.RouterGroup {
 	r := eng.Group("/github")
 
 	r.Use(func(c *gin.Context) {
@@ -248,6 +254,17 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 			return
 		}
 
+		// Indexing failures must not fail the clone; the status endpoint reports them.
+		index, err := indexer.Enqueue(c.Request.Context(), rag.IndexJob{
+			UserId: userID,
+			Owner:  body.Owner,
+			Repo:   body.Repo,
+			Path:   destPath,
+		})
+		if err != nil {
+			log.Printf("failed to enqueue indexing for %s/%s: %v", body.Owner, body.Repo, err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"message":        "cloned",
 			"owner":          body.Owner,
@@ -255,15 +272,106 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 			"default_branch": defaultBranch,
 			"shallow":        true,
 			"destination":    destPath,
+			"index":          index,
 		})
 	})
 
+	// --- GET /github/index?repoName=...&owner=...
+	// owner defaults to the user's GitHub username, matching the file tree. The
+	// row id is the repo_id the /gemini endpoints retrieve context from.
+	r.GET("/index", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+		repoName := strings.TrimSpace(c.Query("repoName"))
+		if err := validateSlug(repoName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+			return
+		}
+		owner := strings.TrimSpace(c.Query("owner"))
+		if owner == "" && ao.User.GithubUsername != nil {
+			owner = strings.TrimSpace(*ao.User.GithubUsername)
+		}
+		if err := validateSlug(owner); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+			return
+		}
+
+		index, err := indexer.Status(c.Request.Context(), ao.User.Id, owner, repoName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to load index status"))
+			return
+		}
+		if index == nil {
+			c.JSON(http.StatusNotFound, utils.Failure("repo has not been indexed"))
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("ok", index))
+	})
+
+	// --- PUT /github/index/settings
+	// learnFromAccepted turns recording accepted resolutions, and offering them
+	// to the model as examples, on or off for the repo.
+	r.PUT("/index/settings", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+		type req struct {
+			RepoName          string `json:"repoName"`
+			Owner             string `json:"owner"`
+			LearnFromAccepted *bool  `json:"learnFromAccepted"`
+		}
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json body"})
+			return
+		}
+		repoName := strings.TrimSpace(body.RepoName)
+		if err := validateSlug(repoName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+			return
+		}
+		owner := strings.TrimSpace(body.Owner)
+		if owner == "" && ao.User.GithubUsername != nil {
+			owner = strings.TrimSpace(*ao.User.GithubUsername)
+		}
+		if err := validateSlug(owner); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+			return
+		}
+		if body.LearnFromAccepted == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "learnFromAccepted is required"})
+			return
+		}
+
+		index, err := indexer.Status(c.Request.Context(), ao.User.Id, owner, repoName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to load index status"))
+			return
+		}
+		if index == nil {
+			c.JSON(http.StatusNotFound, utils.Failure("repo has not been indexed"))
+			return
+		}
+
+		index, err = indexer.SetLearnFromAccepted(c.Request.Context(), index.Id, *body.LearnFromAccepted)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to update index settings"))
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("ok", index))
+	})
+
 	// --- POST /github/commit
+	// sessionId writes the file into a merge session's worktree, completes
+	// the session and pushes its target branch; without one the clone is
+	// committed and pushed as it is.
 	r.POST("/commit", func(c *gin.Context) {
 		type Req struct {
 			RepoName    string `json:"repoName"`
 			NewFileData string `json:"newFileData"`
 			Path        string `json:"path"`
+			SessionId   string `json:"sessionId"`
 		}
 
 		var body Req
@@ -273,19 +381,38 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 			c.JSON(http.StatusBadRequest, gin.H{"error": "repo name and/or new file data should not be empty"})
 			return
 		}
+		body.RepoName = strings.TrimSpace(body.RepoName)
+		if err := validateSlug(body.RepoName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+			return
+		}
+		relPath := filepath.Clean(strings.TrimPrefix(body.Path, "/"))
+		if relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(os.PathSeparator)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "path must be a safe relative path"})
+			return
+		}
 
 		ao := c.MustGet("ao").(*utils.AuthenticationObject)
-		githubUsername := ao.User.GithubUsername
+		if ao.User.GithubUsername == nil || strings.TrimSpace(*ao.User.GithubUsername) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "github username not set for user"})
+			return
+		}
+		githubUsername := strings.TrimSpace(*ao.User.GithubUsername)
 		githubToken := ao.User.GithubToken
-		userId := ao.User.Id.String()
 
-		base := filepath.Join("repos", userId, *githubUsername, body.RepoName)
-		path := body.Path
-		if len(path) > 0 && path[0] == '/' {
-			path = path[1:]
+		ctx := c.Request.Context()
+		base, session, unlock, err := mergeSessions.LockCheckout(ctx, ao.User.Id, githubUsername, body.RepoName, body.SessionId)
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), utils.Failure(err.Error()))
+			return
+		}
+		defer unlock()
+		if st, err := os.Stat(base); err != nil || !st.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "repo not found on disk"})
+			return
 		}
-		fullPath := filepath.Join(base, path)
 
+		fullPath := filepath.Join(base, relPath)
 		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
 			c.JSON(http.StatusInternalServerError, utils.Failure("failed to create parent directories"))
 			return
@@ -295,33 +422,71 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 			return
 		}
 
-		status, stdout, stderr, err := utils.RunCommand(fmt.Sprintf(
-			"cd %s && [ -f \"$(git rev-parse --git-dir)/MERGE_HEAD\" ] && echo true || echo false", base))
-		if err != nil || stderr != "" || status != 0 {
-			c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
+		remote := pushURL(githubUsername, *githubToken, body.RepoName)
+
+		// the merge is committed by completing the session, which moves the
+		// clone's target branch; that branch is what gets pushed
+		if session != nil {
+			if _, err := gitexec.Run(ctx, base, "add", "--", filepath.ToSlash(relPath)); err != nil {
+				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository: "+err.Error()))
+				return
+			}
+			unlock()
+
+			state, err := mergeSessions.Complete(ctx, session)
+			if err != nil {
+				c.JSON(sessionErrorStatus(err), utils.Failure(err.Error()))
+				return
+			}
+			clone := mergesession.ClonePath(ao.User.Id, githubUsername, body.RepoName)
+			unlockClone, err := gitrepo.Lock(ctx, clone)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, utils.Failure(err.Error()))
+				return
+			}
+			defer unlockClone()
+			target := "refs/heads/" + session.TargetBranch
+			if _, err := gitexec.Run(ctx, clone, "push", remote, target+":"+target); err != nil {
+				c.JSON(http.StatusInternalServerError, utils.Failure("failed to push repository: "+err.Error()))
+				return
+			}
+			c.JSON(http.StatusOK, utils.Success("ok", state))
 			return
 		}
 
-		inMergeMode, err := strconv.ParseBool(strings.TrimSpace(stdout))
+		repo, err := gitrepo.Open(base)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
+			return
+		}
+		state, err := repo.InProgress(ctx)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
 			return
 		}
+		// a rebase, cherry-pick or revert commits as it goes; it is finished
+		// through /github/operation instead
+		if state != nil && state.Operation != gitrepo.OperationMerge {
+			c.JSON(http.StatusConflict, utils.Failure("a "+string(state.Operation)+" is in progress; continue or abort it first"))
+			return
+		}
 
-		if inMergeMode {
-			status, stdout, stderr, err := utils.RunCommand(fmt.Sprintf("cd %s && git diff --name-only --diff-filter=U", base))
-			if err != nil || stderr != "" || status != 0 {
+		if state != nil {
+			unmerged, err := repo.Unmerged(ctx)
+			if err != nil {
 				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
 				return
 			}
-			lines := strings.Split(strings.TrimSpace(stdout), "\n")
-			if len(lines) > 0 && lines[0] != "" {
+			if len(unmerged) > 0 {
 				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
 				return
 			}
-			url := fmt.Sprintf("https://%s:%s@github.com/%s/%s.git", *githubUsername, *githubToken, *githubUsername, body.RepoName)
-			status, _, _, err = utils.RunCommand(fmt.Sprintf("cd %s && (git commit -m 'Test fix' || true) && git push %s", base, url))
-			if status != 0 || err != nil {
+			// a merge always has its merge commit to make
+			if _, err := gitexec.Run(ctx, base, "commit", "-m", "Test fix"); err != nil {
+				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository: "+err.Error()))
+				return
+			}
+			if _, err := gitexec.Run(ctx, base, "push", remote); err != nil {
 				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
 				return
 			}
@@ -330,14 +495,26 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 		}
 
 		// Normal path
-		status, _, _, err = utils.RunCommand(fmt.Sprintf("cd %s && git fetch && git merge", base))
-		if status != 0 || err != nil {
-			c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
+		for _, args := range [][]string{{"fetch"}, {"merge"}, {"add", "."}} {
+			if _, err := gitexec.Run(ctx, base, args...); err != nil {
+				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
+				return
+			}
+		}
+		// nothing staged is not an error: there is just nothing to commit
+		_, err = gitexec.Run(ctx, base, "diff", "--cached", "--quiet")
+		switch gitexec.ExitCode(err) {
+		case 0:
+		case 1:
+			if _, err := gitexec.Run(ctx, base, "commit", "-m", "Test msg"); err != nil {
+				c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository: "+err.Error()))
+				return
+			}
+		default:
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository: "+err.Error()))
 			return
 		}
-		url := fmt.Sprintf("https://%s:%s@github.com/%s/%s.git", *githubUsername, *githubToken, *githubUsername, body.RepoName)
-		status, _, _, err = utils.RunCommand(fmt.Sprintf("cd %s && git add . && (git commit -m 'Test msg' || true) && git push %s", base, url))
-		if status != 0 || err != nil {
+		if _, err := gitexec.Run(ctx, base, "push", remote); err != nil {
 			c.JSON(http.StatusInternalServerError, utils.Failure("failed to commit repository"))
 			return
 		}
@@ -345,11 +522,20 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 	})
 
 	// --- POST /github/merge/accept
+	// sessionId stages the file in a merge session's worktree instead of the
+	// clone.
 	r.POST("/merge/accept", func(c *gin.Context) {
 		type req struct {
 			NewFileData string `json:"newFileData"`
 			FullPath    string `json:"fullPath"`
 			RepoName    string `json:"repoName"`
+			SessionId   string `json:"sessionId"`
+			Force       bool   `json:"force"`
+			// ConflictContent is the file as it was before it was resolved;
+			// it defaults to what is on disk. Proposal is the resolution the
+			// model suggested, if any.
+			ConflictContent string `json:"conflictContent"`
+			Proposal        string `json:"proposal"`
 		}
 
 		var body req
@@ -399,8 +585,14 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 		}
 		owner := strings.TrimSpace(*u.GithubUsername)
 
-		base := filepath.Join("repos", ao.User.Id.String(), owner)
-		repoAbs := filepath.Join(base, body.RepoName)
+		// the file and the index change together, and only while the session
+		// is still in progress: its worktree is gone once it finishes
+		repoAbs, _, unlock, err := mergeSessions.LockCheckout(c.Request.Context(), ao.User.Id, owner, body.RepoName, body.SessionId)
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		defer unlock()
 		if st, err := os.Stat(repoAbs); err != nil || !st.IsDir() {
 			c.JSON(http.StatusNotFound, gin.H{"error": "repo not found on disk"})
 			return
@@ -417,6 +609,30 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 			return
 		}
 
+		posixRel := filepath.ToSlash(relClean)
+
+		// Nothing is written unless the content passes validation or the caller
+		// explicitly overrides it.
+		result := validation.Validate(posixRel, body.NewFileData)
+		if !result.Valid && !body.Force {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":      "merged file failed validation",
+				"repoName":   body.RepoName,
+				"fullPath":   posixRel,
+				"staged":     false,
+				"validation": result,
+			})
+			return
+		}
+
+		if body.ConflictContent == "" {
+			if data, err := os.ReadFile(fileAbsClean); err == nil {
+				body.ConflictContent = string(data)
+			}
+		}
+
+		// the root exists, checked under the lock, so only directories below
+		// it are ever created
 		if err := os.MkdirAll(filepath.Dir(fileAbsClean), 0o755); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create parent directories"})
 			return
@@ -426,25 +642,34 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 			return
 		}
 
-		posixRel := filepath.ToSlash(relClean)
-		if code, _, errOut, err := utils.RunCommand(fmt.Sprintf(`git -C %q add -- %q`, repoAbsClean, posixRel)); err != nil || code != 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "git add failed", "details": errOut})
+		if res, err := gitexec.Run(c.Request.Context(), repoAbsClean, "add", "--", posixRel); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "git add failed", "details": res.Stderr})
 			return
 		}
 
+		unlock()
+
+		learned := learnAccepted(c.Request.Context(), indexer, acceptedRepo, u, owner, body.RepoName, posixRel,
+			body.ConflictContent, body.Proposal, body.NewFileData)
+
 		c.JSON(http.StatusOK, gin.H{
-			"message":  "ok",
-			"repoName": body.RepoName,
-			"fullPath": posixRel,
-			"staged":   true,
+			"message":    "ok",
+			"repoName":   body.RepoName,
+			"fullPath":   posixRel,
+			"staged":     true,
+			"forced":     !result.Valid,
+			"validation": result,
+			"learned":    learned,
 		})
 	})
 
 	// --- POST /github/merge/decline
+	// sessionId aborts a merge session, removing its worktree
 	r.POST("/merge/decline", func(c *gin.Context) {
 		type req struct {
-			FullPath string `json:"fullPath"`
-			RepoName string `json:"repoName"`
+			FullPath  string `json:"fullPath"`
+			RepoName  string `json:"repoName"`
+			SessionId string `json:"sessionId"`
 		}
 
 		var body req
@@ -484,33 +709,65 @@ func NewRouter(eng *gin.RouterGroup, userRepository user.UserRepository, session
 		}
 		owner := strings.TrimSpace(*u.GithubUsername)
 
-		base := filepath.Join("repos", ao.User.Id.String(), owner, body.RepoName)
+		base, session, err := mergeSessions.Checkout(c.Request.Context(), ao.User.Id, owner, body.RepoName, body.SessionId)
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
 		if st, err := os.Stat(base); err != nil || !st.IsDir() {
 			c.JSON(http.StatusNotFound, gin.H{"error": "repo not found on disk"})
 			return
 		}
 
-		cmd := fmt.Sprintf("cd %s && git merge --abort && git reset --hard HEAD~1", base)
-		status, stdout, stderr, err := utils.RunCommand(cmd)
-		if err != nil || status != 0 {
+		// abort whatever stopped on conflicts; no commit is undone
+		repo, err := gitrepo.Open(base)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open repository"})
+			return
+		}
+		state, err := repo.InProgress(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read repository state"})
+			return
+		}
+		if state == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": gitrepo.ErrNoOperation.Error()})
+			return
+		}
+		if err := driveOperation(c.Request.Context(), mergeSessions, session, repo, gitrepo.ActionAbort); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":   "failed to decline merge",
-				"details": stderr,
+				"details": err.Error(),
 			})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"message":  "merge declined",
-			"repoName": body.RepoName,
-			"action":   "merge-abort-reset",
-			"stdout":   stdout,
+			"message":   "merge declined",
+			"repoName":  body.RepoName,
+			"action":    string(state.Operation) + "-abort",
+			"operation": state.Operation,
 		})
 	})
 
+	sessionRoutes(r, mergeSessions)
+	operationRoutes(r, mergeSessions)
+
 	return r
 }
 
+// pushURL is the authenticated remote of the user's fork. It holds the
+// token, so it must only ever be logged through gitexec.Redact.
+func pushURL(username, token, repoName string) string {
+	u := url.URL{
+		Scheme: "https",
+		User:   url.UserPassword(username, token),
+		Host:   "github.com",
+		Path:   "/" + username + "/" + repoName + ".git",
+	}
+	return u.String()
+}
+
 func validateSlug(s string) error {
 	re := regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
 	if !re.MatchString(s) {
@@ -0,0 +1,91 @@
+package github
+
+import (
+	"context"
+	"log"
+
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
+	"github.com/tahminator/go-react-template/database/repository/user"
+)
+
+// acceptedHunks pairs each hunk of the conflicted file with the text it was
+// accepted as and, when one was sent, the text the model proposed. Hunks the
+// accepted file did not resolve are left out, as is everything when the
+// accepted file cannot be lined up with the conflicted one.
+func acceptedHunks(conflictContent, proposal, final string) []accepted_resolutions.AcceptedResolution {
+	parsed, err := conflict.Parse(conflictContent)
+	if err != nil || !parsed.HasConflicts() {
+		return nil
+	}
+	accepted, ok := parsed.Match(final)
+	if !ok {
+		return nil
+	}
+	var proposed map[int]string
+	if proposal != "" {
+		proposed, _ = parsed.Match(proposal)
+	}
+
+	var out []accepted_resolutions.AcceptedResolution
+	for _, h := range parsed.Hunks {
+		text := accepted[h.Index]
+		if conflict.HasMarkers(text) {
+			continue
+		}
+		r := accepted_resolutions.AcceptedResolution{
+			HunkIndex: h.Index,
+			Ours:      h.Ours,
+			Theirs:    h.Theirs,
+			Final:     text,
+		}
+		if h.HasBase {
+			r.Base = &h.Base
+		}
+		if p, ok := proposed[h.Index]; ok && !conflict.HasMarkers(p) {
+			r.Proposal = &p
+			r.Edited = p != text
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// learnAccepted records the accepted hunks of a file against the repo's index
+// so later resolutions can use them as examples. Nothing is recorded for repos
+// that were never indexed or have learning turned off. Failures are logged,
+// never returned: the merge was already accepted.
+func learnAccepted(
+	ctx context.Context,
+	indexer rag.RepoIndexer,
+	acceptedRepo accepted_resolutions.AcceptedResolutionsRepository,
+	u *user.User,
+	owner, repoName, path string,
+	conflictContent, proposal, final string,
+) int {
+	if acceptedRepo == nil || conflictContent == "" {
+		return 0
+	}
+	index, err := indexer.Status(ctx, u.Id, owner, repoName)
+	if err != nil {
+		log.Printf("failed to load index of %s/%s: %v", owner, repoName, err)
+		return 0
+	}
+	if index == nil || !index.LearnFromAccepted {
+		return 0
+	}
+
+	resolutions := acceptedHunks(conflictContent, proposal, final)
+	for i := range resolutions {
+		resolutions[i].RepoId = index.Id
+		resolutions[i].Source = path
+		resolutions[i].AuthorId = u.Id
+		resolutions[i].Author = owner
+	}
+	if err := acceptedRepo.InsertResolutions(ctx, resolutions); err != nil {
+		log.Printf("failed to record accepted resolutions of %s/%s: %v", owner, repoName, err)
+		return 0
+	}
+	return len(resolutions)
+}
@@ -0,0 +1,184 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tahminator/go-react-template/database/repository/merge_session"
+	"github.com/tahminator/go-react-template/gitrepo"
+	"github.com/tahminator/go-react-template/mergesession"
+	"github.com/tahminator/go-react-template/utils"
+)
+
+// operationStatus is the operation a clone is in the middle of and the
+// paths it left conflicted.
+type operationStatus struct {
+	*gitrepo.OperationState
+	Conflicts []string `json:"conflicts"`
+}
+
+// operationRoutes registers the endpoints that report and drive a merge,
+// rebase, cherry-pick or revert stopped on conflicts. Each step's conflicts
+// are resolved like a merge's, through /gemini and /github/merge/accept.
+func operationRoutes(r *gin.RouterGroup, sessions *mergesession.Manager) {
+	// --- GET /github/operation?repoName=...&owner=...&sessionId=...
+	// the payload is null when nothing is in progress. sessionId reads a
+	// merge session's worktree instead of the clone.
+	r.GET("/operation", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+		repo, _, ok := openCheckout(c, sessions, ao, c.Query("repoName"), c.Query("owner"), c.Query("sessionId"))
+		if !ok {
+			return
+		}
+		status, err := readOperation(c.Request.Context(), repo)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to read repository state"))
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("ok", status))
+	})
+
+	// --- POST /github/operation/{continue,skip,abort}
+	// reports what is in progress afterwards, e.g. the next step of a rebase
+	for _, action := range []gitrepo.Action{gitrepo.ActionContinue, gitrepo.ActionSkip, gitrepo.ActionAbort} {
+		r.POST("/operation/"+string(action), func(c *gin.Context) {
+			ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+			type req struct {
+				RepoName  string `json:"repoName"`
+				Owner     string `json:"owner"`
+				SessionId string `json:"sessionId"`
+			}
+			var body req
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json body"})
+				return
+			}
+			repo, session, ok := openCheckout(c, sessions, ao, body.RepoName, body.Owner, body.SessionId)
+			if !ok {
+				return
+			}
+
+			ctx := c.Request.Context()
+			if err := driveOperation(ctx, sessions, session, repo, action); err != nil {
+				c.JSON(operationErrorStatus(err), utils.Failure(err.Error()))
+				return
+			}
+			status, err := readOperation(ctx, repo)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, utils.Failure("failed to read repository state"))
+				return
+			}
+
+			c.JSON(http.StatusOK, utils.Success("ok", status))
+		})
+	}
+}
+
+// openCheckout opens the user's clone of owner/repoName, or the worktree
+// of the merge session sessionId names, writing the error response itself
+// if it cannot. owner defaults to the user's GitHub username.
+func openCheckout(
+	c *gin.Context,
+	sessions *mergesession.Manager,
+	ao *utils.AuthenticationObject,
+	repoName, owner, sessionId string,
+) (gitrepo.GitRepo, *merge_session.MergeSession, bool) {
+	repoName = strings.TrimSpace(repoName)
+	if err := validateSlug(repoName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+		return nil, nil, false
+	}
+	owner = strings.TrimSpace(owner)
+	if owner == "" && ao.User.GithubUsername != nil {
+		owner = strings.TrimSpace(*ao.User.GithubUsername)
+	}
+	if err := validateSlug(owner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+		return nil, nil, false
+	}
+
+	dir, session, err := sessions.Checkout(c.Request.Context(), ao.User.Id, owner, repoName, sessionId)
+	if err != nil {
+		c.JSON(sessionErrorStatus(err), utils.Failure(err.Error()))
+		return nil, nil, false
+	}
+	if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "repo not found on disk"})
+		return nil, nil, false
+	}
+	repo, err := gitrepo.Open(dir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open repository"})
+		return nil, nil, false
+	}
+	return repo, session, true
+}
+
+func readOperation(ctx context.Context, repo gitrepo.GitRepo) (*operationStatus, error) {
+	state, err := repo.InProgress(ctx)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	status := &operationStatus{OperationState: state, Conflicts: []string{}}
+	unmerged, err := repo.Unmerged(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range unmerged {
+		status.Conflicts = append(status.Conflicts, e.Path)
+	}
+	return status, nil
+}
+
+// driveOperation runs action on what is in progress in repo. The merge of a
+// merge session is completed or aborted through the session, so the session
+// records how it ended and its worktree is removed.
+func driveOperation(
+	ctx context.Context,
+	sessions *mergesession.Manager,
+	session *merge_session.MergeSession,
+	repo gitrepo.GitRepo,
+	action gitrepo.Action,
+) error {
+	if session != nil && action != gitrepo.ActionSkip {
+		state, err := repo.InProgress(ctx)
+		if err != nil {
+			return err
+		}
+		if state != nil && state.Operation == gitrepo.OperationMerge {
+			if action == gitrepo.ActionAbort {
+				_, err = sessions.Abort(ctx, session)
+			} else {
+				_, err = sessions.Complete(ctx, session)
+			}
+			return err
+		}
+	}
+
+	unlock, err := gitrepo.Lock(ctx, repo.Path())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = gitrepo.Drive(ctx, repo, action)
+	return err
+}
+
+func operationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gitrepo.ErrNoOperation),
+		errors.Is(err, gitrepo.ErrUnresolved),
+		errors.Is(err, gitrepo.ErrCannotSkip):
+		return http.StatusConflict
+	default:
+		return sessionErrorStatus(err)
+	}
+}
@@ -0,0 +1,181 @@
+package github
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/tahminator/go-react-template/database/repository/merge_session"
+	"github.com/tahminator/go-react-template/mergesession"
+	"github.com/tahminator/go-react-template/utils"
+)
+
+// sessionRoutes registers the merge session endpoints. A session merges one
+// branch of a clone into another; nothing is committed until it completes.
+func sessionRoutes(r *gin.RouterGroup, sessions *mergesession.Manager) {
+	// --- POST /github/merge/sessions
+	// the merge runs in a worktree of its own; pass the returned id as
+	// sessionId to the file and merge endpoints to work in it. owner
+	// defaults to the user's GitHub username, matching the file tree.
+	// sourceBranch is taken from origin when it has one, so it may equal
+	// targetBranch to merge a branch's upstream into it.
+	r.POST("/merge/sessions", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+		type req struct {
+			RepoName     string `json:"repoName"`
+			Owner        string `json:"owner"`
+			SourceBranch string `json:"sourceBranch"`
+			TargetBranch string `json:"targetBranch"`
+		}
+		var body req
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json body"})
+			return
+		}
+		repoName := strings.TrimSpace(body.RepoName)
+		if err := validateSlug(repoName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+			return
+		}
+		owner := strings.TrimSpace(body.Owner)
+		if owner == "" && ao.User.GithubUsername != nil {
+			owner = strings.TrimSpace(*ao.User.GithubUsername)
+		}
+		if err := validateSlug(owner); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+			return
+		}
+
+		if st, err := os.Stat(mergesession.ClonePath(ao.User.Id, owner, repoName)); err != nil || !st.IsDir() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "repo not found on disk"})
+			return
+		}
+
+		state, err := sessions.Start(c.Request.Context(), mergesession.StartOptions{
+			UserId:       ao.User.Id,
+			Owner:        owner,
+			Repo:         repoName,
+			SourceBranch: strings.TrimSpace(body.SourceBranch),
+			TargetBranch: strings.TrimSpace(body.TargetBranch),
+		})
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), utils.Failure(err.Error()))
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("ok", state))
+	})
+
+	// --- GET /github/merge/sessions?repoName=...&owner=...
+	r.GET("/merge/sessions", func(c *gin.Context) {
+		ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+		repoName := strings.TrimSpace(c.Query("repoName"))
+		if err := validateSlug(repoName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid repoName"})
+			return
+		}
+		owner := strings.TrimSpace(c.Query("owner"))
+		if owner == "" && ao.User.GithubUsername != nil {
+			owner = strings.TrimSpace(*ao.User.GithubUsername)
+		}
+		if err := validateSlug(owner); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid owner"})
+			return
+		}
+
+		list, err := sessions.List(c.Request.Context(), ao.User.Id, owner, repoName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, utils.Failure("failed to load merge sessions"))
+			return
+		}
+
+		c.JSON(http.StatusOK, utils.Success("ok", list))
+	})
+
+	// --- GET /github/merge/sessions/:id
+	// reports the session and the conflicts left in its worktree
+	r.GET("/merge/sessions/:id", func(c *gin.Context) {
+		state, ok := loadSession(c, sessions)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, utils.Success("ok", state))
+	})
+
+	// --- POST /github/merge/sessions/:id/{abort,resume,complete}
+	actions := map[string]func(*gin.Context, *merge_session.MergeSession) (*mergesession.State, error){
+		"abort": func(c *gin.Context, s *merge_session.MergeSession) (*mergesession.State, error) {
+			return sessions.Abort(c.Request.Context(), s)
+		},
+		"resume": func(c *gin.Context, s *merge_session.MergeSession) (*mergesession.State, error) {
+			return sessions.Resume(c.Request.Context(), s)
+		},
+		"complete": func(c *gin.Context, s *merge_session.MergeSession) (*mergesession.State, error) {
+			return sessions.Complete(c.Request.Context(), s)
+		},
+	}
+	for name, action := range actions {
+		r.POST("/merge/sessions/:id/"+name, func(c *gin.Context) {
+			current, ok := loadSession(c, sessions)
+			if !ok {
+				return
+			}
+			state, err := action(c, current.MergeSession)
+			if err != nil {
+				c.JSON(sessionErrorStatus(err), utils.Failure(err.Error()))
+				return
+			}
+			c.JSON(http.StatusOK, utils.Success("ok", state))
+		})
+	}
+}
+
+// loadSession reads the :id session of the signed-in user, writing the
+// error response itself if there is none.
+func loadSession(c *gin.Context, sessions *mergesession.Manager) (*mergesession.State, bool) {
+	ao := c.MustGet("ao").(*utils.AuthenticationObject)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return nil, false
+	}
+	state, err := sessions.Get(c.Request.Context(), ao.User.Id, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.Failure("failed to load merge session"))
+		return nil, false
+	}
+	if state == nil {
+		c.JSON(http.StatusNotFound, utils.Failure("merge session not found"))
+		return nil, false
+	}
+	return state, true
+}
+
+func sessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, mergesession.ErrInvalidBranch),
+		errors.Is(err, mergesession.ErrInvalidSession):
+		return http.StatusBadRequest
+	case errors.Is(err, mergesession.ErrNoBranch),
+		errors.Is(err, mergesession.ErrNoSession):
+		return http.StatusNotFound
+	case errors.Is(err, merge_session.ErrSessionInProgress),
+		errors.Is(err, mergesession.ErrDirtyTree),
+		errors.Is(err, mergesession.ErrBusy),
+		errors.Is(err, mergesession.ErrFinished),
+		errors.Is(err, mergesession.ErrUnresolved),
+		errors.Is(err, mergesession.ErrTargetMoved),
+		errors.Is(err, mergesession.ErrNotStarted),
+		errors.Is(err, mergesession.ErrNotMerging):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,332 @@
+package conflict
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Git writes markers of exactly this many characters unless the
+// conflict-marker-size attribute says otherwise.
+const MarkerSize = 7
+
+const (
+	oursMarker   = "<<<<<<<"
+	baseMarker   = "|||||||"
+	sepMarker    = "======="
+	theirsMarker = ">>>>>>>"
+)
+
+type RegionKind string
+
+const (
+	RegionClean    RegionKind = "clean"
+	RegionConflict RegionKind = "conflict"
+)
+
+// Range, Hunk, Region and File are sent as-is by the resolution endpoints, so
+// their JSON names are snake_case like the rest of those bodies.
+
+// Range locates a span of the original file. Lines are 1-based and inclusive,
+// bytes are 0-based and half-open so that content[StartByte:EndByte] is the span.
+// An empty side has EndLine == StartLine-1.
+type Range struct {
+	StartLine int `json:"start_line"`
+	EndLine   int `json:"end_line"`
+	StartByte int `json:"start_byte"`
+	EndByte   int `json:"end_byte"`
+}
+
+// Hunk is a single <<<<<<< ... >>>>>>> block. Base is only populated when the
+// file was written with merge.conflictStyle=diff3 or zdiff3.
+type Hunk struct {
+	Index int `json:"index"`
+
+	Ours   string `json:"ours"`
+	Base   string `json:"base"`
+	Theirs string `json:"theirs"`
+
+	OursLabel   string `json:"ours_label"`
+	BaseLabel   string `json:"base_label"`
+	TheirsLabel string `json:"theirs_label"`
+	HasBase     bool   `json:"has_base"`
+
+	// Range covers the hunk including all marker lines.
+	Range       Range `json:"range"`
+	OursRange   Range `json:"ours_range"`
+	BaseRange   Range `json:"base_range"`
+	TheirsRange Range `json:"theirs_range"`
+
+	// raw marker lines (with their line endings) so Render is byte-exact
+	oursLine   string
+	baseLine   string
+	sepLine    string
+	theirsLine string
+}
+
+// Region is either a run of clean text or one conflict hunk.
+type Region struct {
+	Kind  RegionKind `json:"kind"`
+	Text  string     `json:"text,omitempty"`
+	Hunk  *Hunk      `json:"hunk,omitempty"`
+	Range Range      `json:"range"`
+}
+
+// File is a parsed file as an ordered list of regions. Rendering every region in
+// order reproduces the input exactly.
+type File struct {
+	Regions []Region `json:"regions"`
+	Hunks   []*Hunk  `json:"hunks"`
+}
+
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+type state int
+
+const (
+	stateClean state = iota
+	stateOurs
+	stateBase
+	stateTheirs
+)
+
+// Parse splits content into clean regions and conflict hunks. Both the plain
+// merge style and diff3/zdiff3 (with a ||||||| base section) are understood;
+// zdiff3 only differs in how much common text git hoists out of the hunk.
+func Parse(content string) (*File, error) {
+	f := &File{}
+
+	var (
+		st        = stateClean
+		cur       *Hunk
+		section   strings.Builder
+		secStart  Range
+		clean     strings.Builder
+		cleanFrom = Range{StartLine: 1}
+		line      = 0
+		offset    = 0
+	)
+
+	flushClean := func(endLine, endByte int) {
+		if clean.Len() == 0 {
+			return
+		}
+		r := cleanFrom
+		r.EndLine = endLine
+		r.EndByte = endByte
+		f.Regions = append(f.Regions, Region{Kind: RegionClean, Text: clean.String(), Range: r})
+		clean.Reset()
+	}
+
+	closeSection := func(endLine, endByte int) (string, Range) {
+		r := secStart
+		r.EndLine = endLine
+		r.EndByte = endByte
+		s := section.String()
+		section.Reset()
+		return s, r
+	}
+
+	for offset < len(content) {
+		next := strings.IndexByte(content[offset:], '\n')
+		var raw string
+		if next < 0 {
+			raw = content[offset:]
+		} else {
+			raw = content[offset : offset+next+1]
+		}
+		line++
+		start := offset
+		end := offset + len(raw)
+		offset = end
+
+		switch st {
+		case stateClean:
+			if isMarker(raw, oursMarker) {
+				flushClean(line-1, start)
+				cur = &Hunk{
+					Index:     len(f.Hunks),
+					OursLabel: markerLabel(raw),
+					oursLine:  raw,
+					Range:     Range{StartLine: line, StartByte: start},
+				}
+				secStart = Range{StartLine: line + 1, StartByte: end}
+				st = stateOurs
+				continue
+			}
+			if clean.Len() == 0 {
+				cleanFrom = Range{StartLine: line, StartByte: start}
+			}
+			clean.WriteString(raw)
+
+		case stateOurs:
+			switch {
+			case isMarker(raw, baseMarker):
+				cur.Ours, cur.OursRange = closeSection(line-1, start)
+				cur.HasBase = true
+				cur.BaseLabel = markerLabel(raw)
+				cur.baseLine = raw
+				secStart = Range{StartLine: line + 1, StartByte: end}
+				st = stateBase
+			case isSeparator(raw):
+				cur.Ours, cur.OursRange = closeSection(line-1, start)
+				cur.sepLine = raw
+				secStart = Range{StartLine: line + 1, StartByte: end}
+				st = stateTheirs
+			case isMarker(raw, oursMarker):
+				return nil, &ParseError{Line: line, Message: "nested conflict marker"}
+			case isMarker(raw, theirsMarker):
+				return nil, &ParseError{Line: line, Message: "missing ======= separator"}
+			default:
+				section.WriteString(raw)
+			}
+
+		case stateBase:
+			switch {
+			case isSeparator(raw):
+				cur.Base, cur.BaseRange = closeSection(line-1, start)
+				cur.sepLine = raw
+				secStart = Range{StartLine: line + 1, StartByte: end}
+				st = stateTheirs
+			case isMarker(raw, oursMarker), isMarker(raw, baseMarker):
+				return nil, &ParseError{Line: line, Message: "unexpected marker in base section"}
+			case isMarker(raw, theirsMarker):
+				return nil, &ParseError{Line: line, Message: "missing ======= separator"}
+			default:
+				section.WriteString(raw)
+			}
+
+		case stateTheirs:
+			switch {
+			case isMarker(raw, theirsMarker):
+				cur.Theirs, cur.TheirsRange = closeSection(line-1, start)
+				cur.TheirsLabel = markerLabel(raw)
+				cur.theirsLine = raw
+				cur.Range.EndLine = line
+				cur.Range.EndByte = end
+				f.Hunks = append(f.Hunks, cur)
+				f.Regions = append(f.Regions, Region{Kind: RegionConflict, Hunk: cur, Range: cur.Range})
+				cur = nil
+				st = stateClean
+			case isMarker(raw, oursMarker), isMarker(raw, baseMarker):
+				return nil, &ParseError{Line: line, Message: "unexpected marker in theirs section"}
+			default:
+				section.WriteString(raw)
+			}
+		}
+	}
+
+	if st != stateClean {
+		return nil, &ParseError{Line: cur.Range.StartLine, Message: "unterminated conflict hunk"}
+	}
+	flushClean(line, offset)
+
+	return f, nil
+}
+
+// HasConflicts reports whether the file contained at least one hunk.
+func (f *File) HasConflicts() bool {
+	return len(f.Hunks) > 0
+}
+
+// Render writes the file back out, byte-for-byte identical to the parsed input.
+func (f *File) Render() string {
+	var sb strings.Builder
+	for _, r := range f.Regions {
+		if r.Kind == RegionClean {
+			sb.WriteString(r.Text)
+			continue
+		}
+		sb.WriteString(r.Hunk.Render())
+	}
+	return sb.String()
+}
+
+// Render writes the hunk back out with its original markers.
+func (h *Hunk) Render() string {
+	var sb strings.Builder
+	sb.WriteString(h.oursLine)
+	sb.WriteString(h.Ours)
+	if h.HasBase {
+		sb.WriteString(h.baseLine)
+		sb.WriteString(h.Base)
+	}
+	sb.WriteString(h.sepLine)
+	sb.WriteString(h.Theirs)
+	sb.WriteString(h.theirsLine)
+	return sb.String()
+}
+
+// RenderOursAligned writes every hunk as its ours side and blanks the marker,
+// base and theirs lines, so the result reads as the HEAD version of the file
+// while each kept line stays at its line number in the conflicted file.
+func (f *File) RenderOursAligned() string {
+	var sb strings.Builder
+	for _, r := range f.Regions {
+		if r.Kind == RegionClean {
+			sb.WriteString(r.Text)
+			continue
+		}
+		h := r.Hunk
+		sb.WriteString(blankLines(h.oursLine))
+		sb.WriteString(h.Ours)
+		if h.HasBase {
+			sb.WriteString(blankLines(h.baseLine + h.Base))
+		}
+		sb.WriteString(blankLines(h.sepLine + h.Theirs + h.theirsLine))
+	}
+	return sb.String()
+}
+
+func blankLines(s string) string {
+	return strings.Repeat("\n", strings.Count(s, "\n"))
+}
+
+// HasMarkers is a cheap check for any line that looks like a conflict marker.
+// It is stricter than a substring search so that e.g. markdown underlines made
+// of '=' characters on their own don't count unless they sit between markers.
+func HasMarkers(content string) bool {
+	for _, ln := range strings.SplitAfter(content, "\n") {
+		if isMarker(ln, oursMarker) || isMarker(ln, theirsMarker) || isMarker(ln, baseMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+// LineMarker returns the conflict marker a line starts with, or "" if it is
+// not a marker line. A separator is reported as well, though on its own it is
+// as likely to be text; HasMarkers ignores it.
+func LineMarker(line string) string {
+	for _, m := range []string{oursMarker, baseMarker, theirsMarker} {
+		if isMarker(line, m) {
+			return m
+		}
+	}
+	if isSeparator(line) {
+		return sepMarker
+	}
+	return ""
+}
+
+func isMarker(raw, marker string) bool {
+	if !strings.HasPrefix(raw, marker) {
+		return false
+	}
+	rest := raw[len(marker):]
+	return rest == "" || rest[0] == ' ' || rest[0] == '\n' || rest[0] == '\r'
+}
+
+func isSeparator(raw string) bool {
+	return strings.TrimRight(raw, "\r\n") == sepMarker
+}
+
+func markerLabel(raw string) string {
+	return strings.TrimSpace(raw[MarkerSize:])
+}
@@ -0,0 +1,232 @@
+package conflict
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+const merged = "package main\n" +
+	"<<<<<<< HEAD\n" +
+	"x := 1\n" +
+	"=======\n" +
+	"x := 2\n" +
+	">>>>>>> feature\n" +
+	"func f() {}\n"
+
+const diff3 = "a\n" +
+	"<<<<<<< HEAD\n" +
+	"ours\n" +
+	"||||||| merged common ancestors\n" +
+	"base\n" +
+	"=======\n" +
+	"theirs\n" +
+	">>>>>>> feature\n" +
+	"b\n" +
+	"<<<<<<< HEAD\n" +
+	"=======\n" +
+	"added\n" +
+	">>>>>>> feature"
+
+func TestParseRoundTrips(t *testing.T) {
+	for name, content := range map[string]string{
+		"merge":       merged,
+		"diff3":       diff3,
+		"crlf":        "a\r\n<<<<<<< HEAD\r\nx\r\n=======\r\ny\r\n>>>>>>> feature\r\nb\r\n",
+		"clean":       "no conflicts here\n=======\n",
+		"empty":       "",
+		"no newline":  "<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> feature",
+		"touching":    "<<<<<<< a\nx\n=======\ny\n>>>>>>> b\n<<<<<<< a\nz\n=======\nw\n>>>>>>> b\n",
+		"label space": "<<<<<<< HEAD\n=======\n>>>>>>> 1a2b3c (commit message)\n",
+	} {
+		f, err := Parse(content)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := f.Render(); got != content {
+			t.Errorf("%s: Render() = %q, want %q", name, got, content)
+		}
+		if got := f.Resolve(nil); got != content {
+			t.Errorf("%s: Resolve(nil) = %q, want %q", name, got, content)
+		}
+		for _, r := range f.Regions {
+			if r.Kind == RegionClean && content[r.Range.StartByte:r.Range.EndByte] != r.Text {
+				t.Errorf("%s: clean range %+v does not cover %q", name, r.Range, r.Text)
+			}
+		}
+	}
+}
+
+func TestParseHunks(t *testing.T) {
+	f, err := Parse(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Hunks) != 1 || len(f.Regions) != 3 {
+		t.Fatalf("got %d hunks in %d regions", len(f.Hunks), len(f.Regions))
+	}
+	h := f.Hunks[0]
+	if h.Ours != "x := 1\n" || h.Theirs != "x := 2\n" || h.HasBase {
+		t.Errorf("sides: %+v", h)
+	}
+	if h.OursLabel != "HEAD" || h.TheirsLabel != "feature" {
+		t.Errorf("labels: %q, %q", h.OursLabel, h.TheirsLabel)
+	}
+	if h.Range != (Range{StartLine: 2, EndLine: 6, StartByte: 13, EndByte: 64}) {
+		t.Errorf("range: %+v", h.Range)
+	}
+	if h.OursRange.StartLine != 3 || h.OursRange.EndLine != 3 || merged[h.OursRange.StartByte:h.OursRange.EndByte] != h.Ours {
+		t.Errorf("ours range: %+v", h.OursRange)
+	}
+	if h.TheirsRange.StartLine != 5 || merged[h.TheirsRange.StartByte:h.TheirsRange.EndByte] != h.Theirs {
+		t.Errorf("theirs range: %+v", h.TheirsRange)
+	}
+}
+
+func TestParseDiff3Base(t *testing.T) {
+	f, err := Parse(diff3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Hunks) != 2 {
+		t.Fatalf("got %d hunks", len(f.Hunks))
+	}
+
+	h := f.Hunks[0]
+	if !h.HasBase || h.Base != "base\n" || h.BaseLabel != "merged common ancestors" {
+		t.Errorf("base: %+v", h)
+	}
+	if h.BaseRange.StartLine != 5 || diff3[h.BaseRange.StartByte:h.BaseRange.EndByte] != "base\n" {
+		t.Errorf("base range: %+v", h.BaseRange)
+	}
+
+	// an empty side still has a range, ending on the line before it starts
+	h = f.Hunks[1]
+	if h.HasBase || h.Ours != "" || h.Theirs != "added\n" {
+		t.Errorf("second hunk: %+v", h)
+	}
+	if h.OursRange.EndLine != h.OursRange.StartLine-1 || h.OursRange.StartByte != h.OursRange.EndByte {
+		t.Errorf("empty ours range: %+v", h.OursRange)
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	f, err := Parse("<<<<<<< HEAD\r\nx\r\n||||||| base\r\no\r\n=======\r\ny\r\n>>>>>>> feature\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := f.Hunks[0]
+	if h.Ours != "x\r\n" || h.Base != "o\r\n" || h.Theirs != "y\r\n" {
+		t.Errorf("sides: %q %q %q", h.Ours, h.Base, h.Theirs)
+	}
+	if h.OursLabel != "HEAD" || h.BaseLabel != "base" || h.TheirsLabel != "feature" {
+		t.Errorf("labels keep the carriage return: %q %q %q", h.OursLabel, h.BaseLabel, h.TheirsLabel)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		line    int
+	}{
+		"unterminated":     {"a\n<<<<<<< HEAD\nx\n=======\ny\n", 2},
+		"no separator":     {"<<<<<<< HEAD\nx\n>>>>>>> feature\n", 3},
+		"nested":           {"<<<<<<< HEAD\n<<<<<<< HEAD\n", 2},
+		"base after sep":   {"<<<<<<< HEAD\n=======\n||||||| base\n>>>>>>> feature\n", 3},
+		"base no sep":      {"<<<<<<< HEAD\n||||||| base\n>>>>>>> feature\n", 3},
+		"second base":      {"<<<<<<< HEAD\n||||||| a\n||||||| b\n", 3},
+		"open in theirs":   {"<<<<<<< HEAD\n=======\n<<<<<<< HEAD\n", 3},
+		"ours only marker": {"<<<<<<<\n", 1},
+	} {
+		_, err := Parse(tc.content)
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%s: expected a *ParseError, got %v", name, err)
+			continue
+		}
+		if pe.Line != tc.line {
+			t.Errorf("%s: error on line %d, want %d (%v)", name, pe.Line, tc.line, pe)
+		}
+	}
+
+	// lines that only resemble markers are text
+	for _, content := range []string{
+		"<<<<<<<< eight\n",
+		">>>>>>> stray close\n",
+		"  <<<<<<< indented\n",
+		"=======\n",
+	} {
+		f, err := Parse(content)
+		if err != nil || f.HasConflicts() {
+			t.Errorf("Parse(%q) = %+v, %v", content, f, err)
+		}
+	}
+}
+
+func TestJSONFieldNames(t *testing.T) {
+	f, err := Parse("<<<<<<< ours\na\n||||||| base\nb\n=======\nc\n>>>>>>> theirs\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(f.Hunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hunk map[string]json.RawMessage
+	if err := json.Unmarshal(data, &hunk); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"index", "ours", "base", "theirs",
+		"ours_label", "base_label", "theirs_label", "has_base",
+		"range", "ours_range", "base_range", "theirs_range",
+	}
+	for _, key := range want {
+		if _, ok := hunk[key]; !ok {
+			t.Errorf("hunk has no %q: %s", key, data)
+		}
+	}
+	if len(hunk) != len(want) {
+		t.Errorf("unexpected hunk fields: %s", data)
+	}
+
+	var r map[string]int
+	if err := json.Unmarshal(hunk["range"], &r); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range r {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"end_byte", "end_line", "start_byte", "start_line"}) {
+		t.Errorf("range fields: %v", keys)
+	}
+}
+
+func TestLineMarker(t *testing.T) {
+	for line, want := range map[string]string{
+		"<<<<<<< HEAD":       "<<<<<<<",
+		"<<<<<<<\r":          "<<<<<<<",
+		"||||||| base":       "|||||||",
+		"=======":            "=======",
+		"=======\r\n":        "=======",
+		">>>>>>> feature":    ">>>>>>>",
+		"<<<<<<<<":           "",
+		"======= x":          "",
+		" <<<<<<< indented":  "",
+		"x := 1 // <<<<<<< ": "",
+	} {
+		if got := LineMarker(line); got != want {
+			t.Errorf("LineMarker(%q) = %q, want %q", line, got, want)
+		}
+	}
+
+	if HasMarkers("Title\n=======\n") {
+		t.Error("a heading underline is not a conflict")
+	}
+	if !HasMarkers("a\n>>>>>>> theirs\n") {
+		t.Error("a stray closing marker is a conflict")
+	}
+}
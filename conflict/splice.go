@@ -0,0 +1,126 @@
+package conflict
+
+import "strings"
+
+// Resolve splices resolved hunk text back between the untouched clean regions.
+// Hunks with no entry in resolved keep their original markers.
+func (f *File) Resolve(resolved map[int]string) string {
+	var sb strings.Builder
+	for _, r := range f.Regions {
+		if r.Kind == RegionClean {
+			sb.WriteString(r.Text)
+			continue
+		}
+		if text, ok := resolved[r.Hunk.Index]; ok {
+			sb.WriteString(text)
+			continue
+		}
+		sb.WriteString(r.Hunk.Render())
+	}
+	return sb.String()
+}
+
+// ContextBefore returns up to n lines of text preceding the hunk. Earlier hunks
+// are included in their rendered (marker) form.
+func (f *File) ContextBefore(h *Hunk, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	var before strings.Builder
+	for _, r := range f.Regions {
+		if r.Kind == RegionConflict && r.Hunk == h {
+			break
+		}
+		if r.Kind == RegionClean {
+			before.WriteString(r.Text)
+		} else {
+			before.WriteString(r.Hunk.Render())
+		}
+	}
+
+	lines := strings.SplitAfter(before.String(), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "")
+}
+
+// ContextAfter returns up to n lines of text following the hunk.
+func (f *File) ContextAfter(h *Hunk, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	var after strings.Builder
+	found := false
+	for _, r := range f.Regions {
+		if !found {
+			found = r.Kind == RegionConflict && r.Hunk == h
+			continue
+		}
+		if r.Kind == RegionClean {
+			after.WriteString(r.Text)
+		} else {
+			after.WriteString(r.Hunk.Render())
+		}
+	}
+
+	lines := strings.SplitAfter(after.String(), "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return strings.Join(lines, "")
+}
+
+// Match is the inverse of Resolve: it finds the text each hunk was replaced
+// with in resolved, by locating the clean regions in order. It reports false
+// when a clean region is missing or two hunks have no clean text between
+// them, since the split between them would be a guess. Each clean region is
+// matched at its first occurrence after the previous one.
+func (f *File) Match(resolved string) (map[int]string, bool) {
+	out := make(map[int]string, len(f.Hunks))
+	var pending *Hunk
+	pos := 0
+	for i, r := range f.Regions {
+		if r.Kind == RegionConflict {
+			if pending != nil {
+				return nil, false
+			}
+			pending = r.Hunk
+			continue
+		}
+
+		var at int
+		switch {
+		case i == 0:
+			if !strings.HasPrefix(resolved, r.Text) {
+				return nil, false
+			}
+			at = 0
+		case i == len(f.Regions)-1:
+			at = len(resolved) - len(r.Text)
+			if at < pos || !strings.HasSuffix(resolved, r.Text) {
+				return nil, false
+			}
+		default:
+			n := strings.Index(resolved[pos:], r.Text)
+			if n < 0 {
+				return nil, false
+			}
+			at = pos + n
+		}
+		if pending != nil {
+			out[pending.Index] = resolved[pos:at]
+			pending = nil
+		}
+		pos = at + len(r.Text)
+	}
+	if pending != nil {
+		out[pending.Index] = resolved[pos:]
+	}
+	return out, true
+}
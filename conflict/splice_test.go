@@ -0,0 +1,57 @@
+package conflict
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	f, err := Parse(diff3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := f.Resolve(map[int]string{0: "ours\ntheirs\n", 1: ""})
+	if want := "a\nours\ntheirs\nb\n"; got != want {
+		t.Errorf("Resolve() = %q, want %q", got, want)
+	}
+
+	// hunks without a resolution keep their markers
+	got = f.Resolve(map[int]string{1: "added\n"})
+	if want := diff3[:len(diff3)-len("<<<<<<< HEAD\n=======\nadded\n>>>>>>> feature")] + "added\n"; got != want {
+		t.Errorf("partial Resolve() = %q, want %q", got, want)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	f, err := Parse(diff3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, resolved := range []map[int]string{
+		{0: "ours\ntheirs\n", 1: "added\n"},
+		{0: "", 1: ""},
+		{0: "a\n", 1: "b\nadded\n"}, // resolutions may repeat the clean text
+	} {
+		got, ok := f.Match(f.Resolve(resolved))
+		if !ok || !maps.Equal(got, resolved) {
+			t.Errorf("Match(Resolve(%v)) = %v, %v", resolved, got, ok)
+		}
+	}
+
+	if _, ok := f.Match("a\nx\n"); ok {
+		t.Error("matched a text missing a clean region")
+	}
+	if _, ok := f.Match("x\nb\ny"); ok {
+		t.Error("matched a text that does not start with the first clean region")
+	}
+
+	// with no clean text between two hunks there is no telling where one
+	// resolution ends and the next begins
+	f, err = Parse("<<<<<<< a\nx\n=======\ny\n>>>>>>> b\n<<<<<<< a\nz\n=======\nw\n>>>>>>> b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Match("x\nz\n"); ok {
+		t.Error("matched adjacent hunks")
+	}
+}
@@ -0,0 +1,88 @@
+package conflict
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strategy is a deterministic way of resolving a hunk without a model.
+type Strategy string
+
+const (
+	StrategyOurs   Strategy = "ours"
+	StrategyTheirs Strategy = "theirs"
+	StrategyUnion  Strategy = "union"
+	StrategyBase   Strategy = "base"
+)
+
+// Rule names the check that let AutoResolve pick a side on its own.
+type Rule string
+
+const (
+	RuleIdentical          Rule = "identical"
+	RuleTheirsEqualsBase   Rule = "theirs_equals_base"
+	RuleOursEqualsBase     Rule = "ours_equals_base"
+	RuleTrailingWhitespace Rule = "trailing_whitespace"
+)
+
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(strings.ToLower(strings.TrimSpace(s))); st {
+	case StrategyOurs, StrategyTheirs, StrategyUnion, StrategyBase:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown strategy %q", s)
+	}
+}
+
+// Apply resolves the hunk with the given strategy. Union keeps ours followed by
+// theirs, the same as git's union merge driver.
+func (h *Hunk) Apply(s Strategy) (string, error) {
+	switch s {
+	case StrategyOurs:
+		return h.Ours, nil
+	case StrategyTheirs:
+		return h.Theirs, nil
+	case StrategyUnion:
+		ours := h.Ours
+		if ours != "" && !strings.HasSuffix(ours, "\n") {
+			ours += "\n"
+		}
+		return ours + h.Theirs, nil
+	case StrategyBase:
+		if !h.HasBase {
+			return "", fmt.Errorf("hunk %d has no base section; re-run the merge with merge.conflictStyle=diff3", h.Index)
+		}
+		return h.Base, nil
+	default:
+		return "", fmt.Errorf("unknown strategy %q", s)
+	}
+}
+
+// AutoResolve applies the rules that are always safe: both sides made the same
+// change, only one side changed relative to base, or the sides only differ in
+// trailing whitespace and line endings. Indentation is never ignored, since it
+// is syntax in Python, YAML and Makefiles. ok is false when the hunk needs a
+// real decision.
+func (h *Hunk) AutoResolve() (text string, rule Rule, ok bool) {
+	switch {
+	case h.Ours == h.Theirs:
+		return h.Ours, RuleIdentical, true
+	case h.HasBase && h.Theirs == h.Base:
+		return h.Ours, RuleTheirsEqualsBase, true
+	case h.HasBase && h.Ours == h.Base:
+		return h.Theirs, RuleOursEqualsBase, true
+	case trimLineEnds(h.Ours) == trimLineEnds(h.Theirs):
+		return h.Ours, RuleTrailingWhitespace, true
+	}
+	return "", "", false
+}
+
+// trimLineEnds drops the spaces, tabs and carriage return at the end of each
+// line, leaving indentation and the space within lines as they are.
+func trimLineEnds(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
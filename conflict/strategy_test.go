@@ -0,0 +1,112 @@
+package conflict
+
+import "testing"
+
+func TestParseStrategy(t *testing.T) {
+	for in, want := range map[string]Strategy{
+		"ours":     StrategyOurs,
+		" Theirs ": StrategyTheirs,
+		"UNION":    StrategyUnion,
+		"base":     StrategyBase,
+	} {
+		if got, err := ParseStrategy(in); err != nil || got != want {
+			t.Errorf("ParseStrategy(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseStrategy("both"); err == nil {
+		t.Error("expected an unknown strategy to fail")
+	}
+}
+
+func TestApply(t *testing.T) {
+	h := &Hunk{Ours: "a\n", Theirs: "b\n", Base: "o\n", HasBase: true}
+	for _, tc := range []struct {
+		strategy Strategy
+		want     string
+	}{
+		{StrategyOurs, "a\n"},
+		{StrategyTheirs, "b\n"},
+		{StrategyUnion, "a\nb\n"},
+		{StrategyBase, "o\n"},
+	} {
+		if got, err := h.Apply(tc.strategy); err != nil || got != tc.want {
+			t.Errorf("%s: got %q, %v", tc.strategy, got, err)
+		}
+	}
+
+	// union never glues the last line of ours to the first of theirs
+	h = &Hunk{Ours: "a", Theirs: "b\n"}
+	if got, _ := h.Apply(StrategyUnion); got != "a\nb\n" {
+		t.Errorf("union: got %q", got)
+	}
+	if got, _ := (&Hunk{Theirs: "b\n"}).Apply(StrategyUnion); got != "b\n" {
+		t.Errorf("union with empty ours: got %q", got)
+	}
+	if _, err := h.Apply(StrategyBase); err == nil {
+		t.Error("base of a hunk without one must fail")
+	}
+}
+
+func TestAutoResolve(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hunk Hunk
+		want string
+		rule Rule
+		ok   bool
+	}{
+		{
+			name: "identical",
+			hunk: Hunk{Ours: "x\n", Theirs: "x\n"},
+			want: "x\n", rule: RuleIdentical, ok: true,
+		},
+		{
+			name: "only ours changed",
+			hunk: Hunk{Ours: "new\n", Base: "old\n", Theirs: "old\n", HasBase: true},
+			want: "new\n", rule: RuleTheirsEqualsBase, ok: true,
+		},
+		{
+			name: "only theirs changed",
+			hunk: Hunk{Ours: "old\n", Base: "old\n", Theirs: "new\n", HasBase: true},
+			want: "new\n", rule: RuleOursEqualsBase, ok: true,
+		},
+		{
+			name: "trailing spaces",
+			hunk: Hunk{Ours: "x = 1  \ny\n", Theirs: "x = 1\ny\t\n"},
+			want: "x = 1  \ny\n", rule: RuleTrailingWhitespace, ok: true,
+		},
+		{
+			name: "line endings",
+			hunk: Hunk{Ours: "a\nb\n", Theirs: "a\r\nb\r\n"},
+			want: "a\nb\n", rule: RuleTrailingWhitespace, ok: true,
+		},
+		{
+			name: "python indentation",
+			hunk: Hunk{Ours: "if x:\n    y()\n", Theirs: "if x:\ny()\n"},
+		},
+		{
+			name: "makefile tab",
+			hunk: Hunk{Ours: "all:\n\tgo build\n", Theirs: "all:\n    go build\n"},
+		},
+		{
+			name: "space within a line",
+			hunk: Hunk{Ours: "a b\n", Theirs: "ab\n"},
+		},
+		{
+			name: "both changed",
+			hunk: Hunk{Ours: "a\n", Base: "o\n", Theirs: "b\n", HasBase: true},
+		},
+		{
+			// without a base there is no telling which side changed
+			name: "no base",
+			hunk: Hunk{Ours: "a\n", Theirs: "b\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, rule, ok := tc.hunk.AutoResolve()
+			if got != tc.want || rule != tc.rule || ok != tc.ok {
+				t.Errorf("got %q, %q, %v; want %q, %q, %v", got, rule, ok, tc.want, tc.rule, tc.ok)
+			}
+		})
+	}
+}
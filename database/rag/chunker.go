@@ -0,0 +1,386 @@
+package rag
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Adjacent anonymous blocks are merged up to TargetChunkLines; any single
+	// block longer than MaxChunkLines is split into windows of that size.
+	TargetChunkLines = 40
+	MaxChunkLines    = 80
+)
+
+const (
+	ChunkTypeCode     = "code"
+	ChunkTypeComment  = "comment"
+	ChunkTypeImport   = "import"
+	ChunkTypeFunction = "function"
+	ChunkTypeType     = "type"
+)
+
+// block is a run of lines [start, end] (1-based, inclusive) within a text.
+type block struct {
+	start, end int
+	kind       string
+	symbol     string
+}
+
+// chunkText splits content into chunks along syntax boundaries. Go files are
+// split on top-level declarations; everything else, and Go that does not
+// parse, falls back to brace/indent heuristics. firstLine is the file line
+// content starts at, so regions of a larger file keep true line numbers.
+func chunkText(path, content string, firstLine int) []Chunk {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var blocks []block
+	if strings.EqualFold(filepath.Ext(path), ".go") {
+		blocks = goBlocks(path, content)
+	}
+	if blocks == nil {
+		blocks = mergeBlocks(heuristicBlocks(lines))
+	}
+
+	var chunks []Chunk
+	for _, b := range splitBlocks(blocks) {
+		text := strings.Join(lines[b.start-1:b.end], "")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		chunks = append(chunks, Chunk{
+			Content:   text,
+			Source:    path,
+			LineStart: firstLine + b.start - 1,
+			LineEnd:   firstLine + b.end - 1,
+			ChunkType: b.kind,
+			Symbol:    b.symbol,
+		})
+	}
+	return chunks
+}
+
+// goBlocks returns one block for the package clause and imports and one per
+// top-level declaration, each including its doc comment. Free-floating
+// comments between declarations become their own blocks. It returns nil if
+// the file does not parse.
+func goBlocks(path, content string) []block {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, content, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	line := func(p token.Pos) int { return fset.Position(p).Line }
+
+	var blocks []block
+	header := block{start: 1, end: line(f.Name.End()), kind: ChunkTypeImport}
+	var decls []ast.Decl
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			header.end = line(gd.End())
+			continue
+		}
+		decls = append(decls, d)
+	}
+	blocks = append(blocks, header)
+
+	covered := header.end
+	for _, d := range decls {
+		start := d.Pos()
+		var b block
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			b = block{kind: ChunkTypeFunction, symbol: funcSymbol(d)}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+			b = block{kind: ChunkTypeCode, symbol: genDeclSymbol(d)}
+			if d.Tok == token.TYPE {
+				b.kind = ChunkTypeType
+			}
+		default:
+			continue
+		}
+		b.start, b.end = line(start), line(d.End())
+
+		// comments between the previous declaration and this one's doc
+		if gap := commentBlock(f, fset, covered+1, b.start-1); gap != nil {
+			blocks = append(blocks, *gap)
+		}
+		blocks = append(blocks, b)
+		covered = b.end
+	}
+	if gap := commentBlock(f, fset, covered+1, fset.File(f.Pos()).LineCount()); gap != nil {
+		blocks = append(blocks, *gap)
+	}
+
+	return blocks
+}
+
+// commentBlock spans the comments that lie entirely within lines [from, to].
+func commentBlock(f *ast.File, fset *token.FileSet, from, to int) *block {
+	var b *block
+	for _, cg := range f.Comments {
+		start, end := fset.Position(cg.Pos()).Line, fset.Position(cg.End()).Line
+		if start < from || end > to {
+			continue
+		}
+		if b == nil {
+			b = &block{start: start, kind: ChunkTypeComment}
+		}
+		b.end = end
+	}
+	return b
+}
+
+func funcSymbol(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return d.Name.Name
+	}
+	recv := d.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	switch r := recv.(type) {
+	case *ast.IndexExpr:
+		recv = r.X
+	case *ast.IndexListExpr:
+		recv = r.X
+	}
+	if ident, ok := recv.(*ast.Ident); ok {
+		return ident.Name + "." + d.Name.Name
+	}
+	return d.Name.Name
+}
+
+func genDeclSymbol(d *ast.GenDecl) string {
+	var names []string
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			names = append(names, s.Name.Name)
+		case *ast.ValueSpec:
+			for _, n := range s.Names {
+				names = append(names, n.Name)
+			}
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+var (
+	goMethodPattern = regexp.MustCompile(`^func\s*\(\s*(?:\w+\s+)?\*?(\w+)(?:\[[^\]]*\])?\s*\)\s*(\w+)`)
+	functionPattern = regexp.MustCompile(`^(?:(?:export|default|public|private|protected|static|async|pub(?:\([a-z]+\))?|override|final|abstract|inline|virtual)\s+)*(?:function\*?|def|fn|func|fun|sub)\s+([A-Za-z_$][\w$]*)`)
+	arrowPattern    = regexp.MustCompile(`^(?:export\s+)?(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:\([^)]*\)|[A-Za-z_$][\w$]*)\s*(?::[^=]+)?=>`)
+	typePattern     = regexp.MustCompile(`^(?:(?:export|default|public|private|protected|abstract|final|sealed|pub(?:\([a-z]+\))?|data)\s+)*(?:class|interface|struct|enum|trait|type|impl|module|namespace|record)\s+([A-Za-z_$][\w$]*)`)
+	importPattern   = regexp.MustCompile(`^(?:import\b|from\s+\S+\s+import\b|#include\b|using\s+[\w.]+;|use\s+[\w:]+|require\b|package\s+[\w.]+;?\s*$)`)
+	commentPrefixes = []string{"//", "#", "/*", "*", "--", ";", "<!--", `"""`}
+)
+
+// heuristicBlocks groups lines into top-level blocks: a block starts at a
+// non-blank line with no indentation while no bracket is open, and absorbs
+// the indented and bracketed lines that follow. Comment lines directly
+// above a block belong to it.
+func heuristicBlocks(lines []string) []block {
+	var blocks []block
+	var cur *block
+	depth := 0
+	pendingComment := 0
+
+	for i, raw := range lines {
+		n := i + 1
+		trimmed := strings.TrimSpace(raw)
+		topLevel := depth <= 0 && trimmed != "" && raw[0] != ' ' && raw[0] != '\t' && !isCloser(trimmed)
+
+		if topLevel && isComment(trimmed) {
+			if pendingComment == 0 {
+				pendingComment = n
+			}
+			continue
+		}
+
+		if topLevel {
+			if cur != nil {
+				blocks = append(blocks, *cur)
+			}
+			start := n
+			if pendingComment > 0 {
+				start = pendingComment
+			} else if cur == nil && n > 1 {
+				start = 1
+			}
+			cur = &block{start: start, end: n}
+			cur.kind, cur.symbol = classifyLine(trimmed)
+		} else if cur == nil && trimmed != "" {
+			cur = &block{start: 1, end: n, kind: ChunkTypeCode}
+		}
+		pendingComment = 0
+		if cur != nil && trimmed != "" {
+			cur.end = n
+		}
+		depth += bracketDelta(trimmed)
+	}
+
+	if cur != nil {
+		blocks = append(blocks, *cur)
+	}
+	if pendingComment > 0 {
+		blocks = append(blocks, block{start: pendingComment, end: len(lines), kind: ChunkTypeComment})
+	} else if len(blocks) > 0 {
+		blocks[len(blocks)-1].end = len(lines)
+	}
+	if len(blocks) == 0 {
+		blocks = append(blocks, block{start: 1, end: len(lines), kind: ChunkTypeComment})
+	}
+
+	// blocks own the blank lines that follow them, so ranges are contiguous
+	for i := 0; i+1 < len(blocks); i++ {
+		blocks[i].end = blocks[i+1].start - 1
+	}
+	return blocks
+}
+
+// mergeBlocks joins runs of adjacent blocks without a symbol (statements,
+// imports, markdown paragraphs, config keys) up to TargetChunkLines.
+func mergeBlocks(blocks []block) []block {
+	var out []block
+	for _, b := range blocks {
+		if len(out) > 0 {
+			last := &out[len(out)-1]
+			if last.symbol == "" && b.symbol == "" && b.end-last.start+1 <= TargetChunkLines {
+				if last.kind != b.kind {
+					last.kind = ChunkTypeCode
+				}
+				last.end = b.end
+				continue
+			}
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// splitBlocks cuts blocks longer than MaxChunkLines into windows that keep
+// the block's kind and symbol.
+func splitBlocks(blocks []block) []block {
+	var out []block
+	for _, b := range blocks {
+		for start := b.start; start <= b.end; start += MaxChunkLines {
+			part := b
+			part.start = start
+			part.end = min(start+MaxChunkLines-1, b.end)
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// classifyLine guesses what a top-level block is from its first line.
+func classifyLine(line string) (kind, symbol string) {
+	if m := goMethodPattern.FindStringSubmatch(line); m != nil {
+		return ChunkTypeFunction, m[1] + "." + m[2]
+	}
+	if m := functionPattern.FindStringSubmatch(line); m != nil {
+		return ChunkTypeFunction, m[1]
+	}
+	if m := arrowPattern.FindStringSubmatch(line); m != nil {
+		return ChunkTypeFunction, m[1]
+	}
+	if m := typePattern.FindStringSubmatch(line); m != nil {
+		return ChunkTypeType, m[1]
+	}
+	if importPattern.MatchString(line) {
+		return ChunkTypeImport, ""
+	}
+	return ChunkTypeCode, ""
+}
+
+// classifyText classifies a fragment that is not a whole file, such as one
+// side of a conflict hunk, by its first significant line.
+func classifyText(text string) string {
+	allComments := true
+	kind := ""
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if isComment(trimmed) {
+			continue
+		}
+		allComments = false
+		if kind == "" {
+			kind, _ = classifyLine(trimmed)
+		}
+	}
+	switch {
+	case kind != "":
+		return kind
+	case allComments && strings.TrimSpace(text) != "":
+		return ChunkTypeComment
+	default:
+		return ChunkTypeCode
+	}
+}
+
+func isComment(trimmed string) bool {
+	for _, p := range commentPrefixes {
+		if strings.HasPrefix(trimmed, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCloser(trimmed string) bool {
+	switch trimmed[0] {
+	case '}', ')', ']':
+		return true
+	}
+	return false
+}
+
+// bracketDelta counts opening minus closing brackets on a line, skipping
+// string literals and trailing // comments well enough for chunking.
+func bracketDelta(line string) int {
+	delta := 0
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '"', '\'', '`':
+			quote = ch
+		case '/':
+			if i+1 < len(line) && line[i+1] == '/' {
+				return delta
+			}
+		case '{', '(', '[':
+			delta++
+		case '}', ')', ']':
+			delta--
+		}
+	}
+	return delta
+}
@@ -0,0 +1,212 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type chunkWant struct {
+	start, end int
+	kind       string
+	symbol     string
+}
+
+func checkChunks(t *testing.T, chunks []Chunk, want []chunkWant) {
+	t.Helper()
+	var got []chunkWant
+	for _, c := range chunks {
+		got = append(got, chunkWant{c.LineStart, c.LineEnd, c.ChunkType, c.Symbol})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("chunks:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestChunkGoDeclarations(t *testing.T) {
+	src := `package server
+
+import (
+	"net/http"
+)
+
+// Server serves the API.
+type Server struct {
+	mux *http.ServeMux
+}
+
+// a note between declarations
+
+var (
+	a, b = 1, 2
+)
+
+// Start begins serving.
+func (s *Server) Start() error {
+	return nil
+}
+
+func (l *List[T]) Len() int { return 0 }
+
+func main() {}
+`
+	chunks := chunkText("server.go", src, 10)
+	checkChunks(t, chunks, []chunkWant{
+		{10, 14, ChunkTypeImport, ""},
+		{16, 19, ChunkTypeType, "Server"},
+		{21, 21, ChunkTypeComment, ""},
+		{23, 25, ChunkTypeCode, "a,b"},
+		{27, 30, ChunkTypeFunction, "Server.Start"},
+		{32, 32, ChunkTypeFunction, "List.Len"},
+		{34, 34, ChunkTypeFunction, "main"},
+	})
+	if !strings.HasPrefix(chunks[4].Content, "// Start begins serving.\nfunc") {
+		t.Errorf("the doc comment is not part of its function: %q", chunks[4].Content)
+	}
+}
+
+func TestChunkGoThatDoesNotParse(t *testing.T) {
+	// a conflict side is rarely a whole file, so the heuristics take over
+	src := "func (s *Server) Stop() {\n\ts.done()\n}\n\nfunc helper() {}\n"
+	checkChunks(t, chunkText("server.go", src, 1), []chunkWant{
+		{1, 4, ChunkTypeFunction, "Server.Stop"},
+		{5, 5, ChunkTypeFunction, "helper"},
+	})
+}
+
+func TestChunkHeuristicBlocks(t *testing.T) {
+	src := `import { api } from "./api";
+import x from "x";
+
+// fetchUser loads one user.
+export async function fetchUser(id) {
+  return api.get(
+    "/users/" + id,
+  );
+}
+
+export const toName = (user) =>
+  user.name;
+
+class Cache {
+  get(key) {}
+}
+
+const limit = {
+  max: "}",
+};
+`
+	checkChunks(t, chunkText("users.js", src, 1), []chunkWant{
+		{1, 3, ChunkTypeImport, ""},
+		{4, 10, ChunkTypeFunction, "fetchUser"},
+		{11, 13, ChunkTypeFunction, "toName"},
+		{14, 17, ChunkTypeType, "Cache"},
+		{18, 20, ChunkTypeCode, ""},
+	})
+}
+
+func TestChunkIndentedLanguages(t *testing.T) {
+	src := "import os\n\n\nclass Repo:\n    def path(self):\n        return os.getcwd()\n\n# helpers\ndef main():\n    pass\n"
+	checkChunks(t, chunkText("repo.py", src, 1), []chunkWant{
+		{1, 3, ChunkTypeImport, ""},
+		{4, 7, ChunkTypeType, "Repo"},
+		{8, 10, ChunkTypeFunction, "main"},
+	})
+}
+
+func TestChunkMergesAndSplitsBlocks(t *testing.T) {
+	// short anonymous blocks are merged up to TargetChunkLines
+	var sb strings.Builder
+	for i := range TargetChunkLines + 5 {
+		fmt.Fprintf(&sb, "key%d: value\n", i)
+	}
+	checkChunks(t, chunkText("config.yaml", sb.String(), 1), []chunkWant{
+		{1, TargetChunkLines, ChunkTypeCode, ""},
+		{TargetChunkLines + 1, TargetChunkLines + 5, ChunkTypeCode, ""},
+	})
+
+	// a long declaration is cut into MaxChunkLines windows that keep its symbol
+	sb.Reset()
+	sb.WriteString("def long():\n")
+	for range MaxChunkLines + 9 {
+		sb.WriteString("    x += 1\n")
+	}
+	checkChunks(t, chunkText("long.py", sb.String(), 1), []chunkWant{
+		{1, MaxChunkLines, ChunkTypeFunction, "long"},
+		{MaxChunkLines + 1, MaxChunkLines + 10, ChunkTypeFunction, "long"},
+	})
+}
+
+func TestChunkRangesCoverTheText(t *testing.T) {
+	for path, src := range map[string]string{
+		"leading.js":  "\n\n  indented();\nfunction f() {\n}\n\n// trailing\n",
+		"comments.sh": "# one\n# two\n",
+		"blank.txt":   "\n\n\n",
+	} {
+		lines := strings.SplitAfter(src, "\n")
+		lines = lines[:len(lines)-1]
+		var rebuilt strings.Builder
+		next := 1
+		for _, c := range chunkText(path, src, 1) {
+			if c.LineStart < next {
+				t.Errorf("%s: chunk %d-%d overlaps the one before", path, c.LineStart, c.LineEnd)
+			}
+			for _, ln := range lines[next-1 : c.LineStart-1] {
+				rebuilt.WriteString(ln)
+			}
+			rebuilt.WriteString(c.Content)
+			next = c.LineEnd + 1
+		}
+		for _, ln := range lines[min(next-1, len(lines)):] {
+			rebuilt.WriteString(ln)
+		}
+		if rebuilt.String() != src {
+			t.Errorf("%s: chunks rebuild %q, want %q", path, rebuilt.String(), src)
+		}
+	}
+}
+
+func TestClassifyLine(t *testing.T) {
+	for line, want := range map[string]chunkWant{
+		"func (r *Repo[T]) Get(id int) {":        {kind: ChunkTypeFunction, symbol: "Repo.Get"},
+		"pub(crate) fn parse(input: &str) {":     {kind: ChunkTypeFunction, symbol: "parse"},
+		"export default async function* gen() {": {kind: ChunkTypeFunction, symbol: "gen"},
+		"const handler = async (req) => {":       {kind: ChunkTypeFunction, symbol: "handler"},
+		"export abstract class Shape {":          {kind: ChunkTypeType, symbol: "Shape"},
+		"impl Display for Point {":               {kind: ChunkTypeType, symbol: "Display"},
+		"from typing import List":                {kind: ChunkTypeImport},
+		"#include <stdio.h>":                     {kind: ChunkTypeImport},
+		"const limit = 10;":                      {kind: ChunkTypeCode},
+	} {
+		kind, symbol := classifyLine(line)
+		if kind != want.kind || symbol != want.symbol {
+			t.Errorf("classifyLine(%q) = %q, %q, want %q, %q", line, kind, symbol, want.kind, want.symbol)
+		}
+	}
+
+	for text, want := range map[string]string{
+		"// a\n# b\n":               ChunkTypeComment,
+		"// leading\nfunc f() {}\n": ChunkTypeFunction,
+		"\n\n":                      ChunkTypeCode,
+	} {
+		if got := classifyText(text); got != want {
+			t.Errorf("classifyText(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestBracketDelta(t *testing.T) {
+	for line, want := range map[string]int{
+		"func f() {":             1,
+		"})":                     -2,
+		`s := "{{" + '}'`:        0,
+		"x := `(` // {":          0,
+		`re := "\"{"`:            0,
+		"call(a, [b, {c: 1}])":   0,
+		"return map[string]int{": 1,
+	} {
+		if got := bracketDelta(line); got != want {
+			t.Errorf("bracketDelta(%q) = %d, want %d", line, got, want)
+		}
+	}
+}
@@ -2,24 +2,21 @@ package rag
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"os"
-	"sort"
+	"log"
 	"strings"
 
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
 	"github.com/tahminator/go-react-template/database"
-	"google.golang.org/genai"
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
 )
 
 const (
-	EmbedModel   = "text-embedding-004"
-	EmbedDim     = 768
-	ChunkSize    = 300
-	ChunkOverlap = 30
-	BatchSize    = 50
+	// EmbedDim is the size of the vector columns embeddings are stored in.
+	EmbedDim  = 768
+	BatchSize = 50
 )
 
 type FileContent struct {
@@ -35,267 +32,169 @@ type Chunk struct {
 	LineStart       int
 	LineEnd         int
 	ChunkType       string
+	Symbol          string
 }
 
-type RepoChunk struct {
-	RepoHash  string    `db:"repo_hash"`
-	Source    string    `db:"source"`
-	Chunk     string    `db:"chunk"`
-	Embedding []float64 `db:"embedding"`
-}
-
-func RepoHash(files []FileContent) string {
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Path < files[j].Path
-	})
-
-	fileData := make([]map[string]string, len(files))
-	for i, f := range files {
-		fileData[i] = map[string]string{
-			"path":    f.Path,
-			"content": f.Content,
-		}
-	}
-
-	data, _ := json.Marshal(fileData)
-	hash := md5.Sum(data)
-	return hex.EncodeToString(hash[:])
-}
-
-func splitTextIntoChunks(text string, chunkSize, overlap int) []string {
-	if len(text) <= chunkSize {
-		return []string{text}
-	}
-
-	var chunks []string
-	start := 0
-
-	for start < len(text) {
-		end := start + chunkSize
-		if end > len(text) {
-			end = len(text)
-		}
-
-		if end < len(text) {
-			lastSpace := strings.LastIndex(text[start:end], " ")
-			if lastSpace > 0 {
-				end = start + lastSpace
-			}
-		}
-
-		chunk := text[start:end]
-		chunks = append(chunks, chunk)
-
-		start = end - overlap
-		if start >= len(text) {
-			break
-		}
-	}
-
-	return chunks
-}
-
-func detectConflictMarkers(text string) (bool, string, []int) {
-	lines := strings.Split(text, "\n")
-	var conflictLines []int
-	hasConflicts := false
-	conflictType := ""
-
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "<<<<<<<") {
-			hasConflicts = true
-			conflictType = "ours"
-			conflictLines = append(conflictLines, i)
-		} else if strings.HasPrefix(line, "=======") {
-			conflictType = "theirs"
-			conflictLines = append(conflictLines, i)
-		} else if strings.HasPrefix(line, ">>>>>>>") {
-			conflictType = "end"
-			conflictLines = append(conflictLines, i)
-		}
-	}
-
-	return hasConflicts, conflictType, conflictLines
-}
-
-func analyzeCodeChunk(content string) string {
-	content = strings.TrimSpace(content)
-
-	if strings.Contains(content, "<<<<<<<") || strings.Contains(content, "=======") || strings.Contains(content, ">>>>>>>") {
-		return "conflict_marker"
-	}
-
-	if strings.HasPrefix(content, "import ") || strings.HasPrefix(content, "package ") {
-		return "import"
-	}
-
-	if strings.Contains(content, "func ") && strings.Contains(content, "(") {
-		return "function"
-	}
-
-	if strings.HasPrefix(content, "//") || strings.HasPrefix(content, "/*") {
-		return "comment"
-	}
-
-	return "code"
+// BlobSha is the git blob id of content, as `git hash-object` computes it.
+func BlobSha(content string) string {
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte(content)).String()
 }
 
 func createChunksFromFiles(files []FileContent) []Chunk {
 	var chunks []Chunk
 
 	for _, file := range files {
-		hasConflicts, _, _ := detectConflictMarkers(file.Content)
-
-		if hasConflicts {
-			chunks = append(chunks, createConflictChunks(file)...)
-		} else {
-			fileChunks := splitTextIntoChunks(file.Content, ChunkSize, ChunkOverlap)
-			for i, chunk := range fileChunks {
-				chunkType := analyzeCodeChunk(chunk)
-				chunks = append(chunks, Chunk{
-					Content:   chunk,
-					Source:    file.Path,
-					FileType:  "context",
-					LineStart: i * (ChunkSize - ChunkOverlap),
-					LineEnd:   (i+1)*(ChunkSize-ChunkOverlap) + ChunkOverlap,
-					ChunkType: chunkType,
-				})
-			}
+		parsed, err := conflict.Parse(file.Content)
+		if err == nil && parsed.HasConflicts() {
+			chunks = append(chunks, createConflictChunks(file, parsed)...)
+			continue
+		}
+
+		for _, chunk := range chunkText(file.Path, file.Content, 1) {
+			chunk.FileType = "context"
+			chunks = append(chunks, chunk)
 		}
 	}
 
 	return chunks
 }
 
-func createConflictChunks(file FileContent) []Chunk {
+// createConflictChunks emits one chunk per clean region and one per side of
+// every hunk, so "ours", "base" and "theirs" can be retrieved independently.
+// Sides with no text (a deletion on one branch) get no chunk: there is nothing
+// to embed.
+func createConflictChunks(file FileContent, parsed *conflict.File) []Chunk {
 	var chunks []Chunk
-	lines := strings.Split(file.Content, "\n")
-
-	var currentSection []string
-	var sectionType string
-	var lineStart int
-
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		if strings.HasPrefix(trimmed, "<<<<<<<") {
 
-			if len(currentSection) > 0 {
-				chunks = append(chunks, createChunkFromSection(currentSection, file.Path, sectionType, lineStart, i-1))
+	for _, region := range parsed.Regions {
+		if region.Kind == conflict.RegionClean {
+			for _, chunk := range chunkText(file.Path, region.Text, region.Range.StartLine) {
+				chunk.FileType = "conflict"
+				chunk.ConflictSection = "clean"
+				chunks = append(chunks, chunk)
 			}
-			currentSection = []string{line}
-			sectionType = "ours"
-			lineStart = i
-		} else if strings.HasPrefix(trimmed, "=======") {
-
-			if len(currentSection) > 0 {
-				chunks = append(chunks, createChunkFromSection(currentSection, file.Path, sectionType, lineStart, i-1))
-			}
-			currentSection = []string{line}
-			sectionType = "theirs"
-			lineStart = i
-		} else if strings.HasPrefix(trimmed, ">>>>>>>") {
+			continue
+		}
 
-			if len(currentSection) > 0 {
-				chunks = append(chunks, createChunkFromSection(currentSection, file.Path, sectionType, lineStart, i-1))
+		h := region.Hunk
+		sides := []struct {
+			content, section string
+			r                conflict.Range
+		}{
+			{h.Ours, "ours", h.OursRange},
+			{h.Base, "base", h.BaseRange},
+			{h.Theirs, "theirs", h.TheirsRange},
+		}
+		for _, side := range sides {
+			if strings.TrimSpace(side.content) == "" {
+				continue
 			}
-			currentSection = []string{line}
-			sectionType = "end"
-			lineStart = i
-		} else {
-			currentSection = append(currentSection, line)
+			chunks = append(chunks, createChunkFromSection(side.content, file.Path, side.section, side.r))
 		}
 	}
 
-	if len(currentSection) > 0 {
-		chunks = append(chunks, createChunkFromSection(currentSection, file.Path, sectionType, lineStart, len(lines)-1))
-	}
-
 	return chunks
 }
 
-func createChunkFromSection(section []string, source, sectionType string, lineStart, lineEnd int) Chunk {
-	content := strings.Join(section, "\n")
-	chunkType := analyzeCodeChunk(content)
-
+func createChunkFromSection(content, source, sectionType string, r conflict.Range) Chunk {
 	return Chunk{
 		Content:         content,
 		Source:          source,
 		FileType:        "conflict",
 		ConflictSection: sectionType,
-		LineStart:       lineStart,
-		LineEnd:         lineEnd,
-		ChunkType:       chunkType,
+		LineStart:       r.StartLine,
+		LineEnd:         r.EndLine,
+		ChunkType:       classifyText(content),
 	}
 }
 
-func getEmbeddings(ctx context.Context, texts []string) ([][]float64, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set")
+// IndexResult describes one indexing run.
+type IndexResult struct {
+	Embedder string
+	Files    int
+	Chunks   int
+	// Embedded files were new or changed and re-chunked; Unchanged files kept
+	// their rows; Removed files were deleted from the tree and purged.
+	Embedded  int
+	Unchanged int
+	Removed   int
+}
+
+// IndexFiles brings the repo's chunks in line with files. Chunks are keyed by
+// (repo, path, blob sha): files whose blob is already indexed are skipped,
+// changed files have their rows replaced and paths that disappeared are
+// purged. A repo indexed by a different embedder is rebuilt from scratch,
+// since vectors from different models are not comparable.
+func IndexFiles(ctx context.Context, embedder Embedder, chunksRepo repo_chunks.RepoChunksRepository, repoId uuid.UUID, fileContents []FileContent) (*IndexResult, error) {
+	result := &IndexResult{
+		Embedder: embedder.Name(),
+		Files:    len(fileContents),
 	}
 
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey: apiKey,
-	})
+	blobs, err := chunksRepo.GetFileBlobs(ctx, repoId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+		return nil, err
 	}
 
-	var allEmbeddings [][]float64
-
-	for i := 0; i < len(texts); i += BatchSize {
-		end := i + BatchSize
-		if end > len(texts) {
-			end = len(texts)
+	indexed := map[string]string{}
+	for _, b := range blobs {
+		if b.Embedder != embedder.Name() {
+			log.Printf("repo %s has chunks from %s, re-embedding with %s", repoId, b.Embedder, embedder.Name())
+			if err := chunksRepo.DeleteRepoChunks(ctx, repoId); err != nil {
+				return nil, fmt.Errorf("failed to delete stale chunks: %w", err)
+			}
+			indexed = map[string]string{}
+			break
 		}
-
-		batchTexts := texts[i:end]
-		contents := make([]*genai.Content, len(batchTexts))
-		for j, text := range batchTexts {
-			contents[j] = genai.NewContentFromText(text, genai.RoleUser)
+		indexed[b.Source] = b.BlobSha
+	}
+
+	current := map[string]bool{}
+	var changed []FileContent
+	var shas []string
+	for _, f := range fileContents {
+		current[f.Path] = true
+		sha := BlobSha(f.Content)
+		if prev, ok := indexed[f.Path]; ok && prev == sha {
+			result.Unchanged++
+			continue
 		}
+		changed = append(changed, f)
+		shas = append(shas, sha)
+	}
 
-		embedding, err := client.Models.EmbedContent(ctx, EmbedModel, contents, &genai.EmbedContentConfig{
-			TaskType: "RETRIEVAL_DOCUMENT",
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to get embeddings for batch: %w", err)
+	for source := range indexed {
+		if current[source] {
+			continue
 		}
-
-		for _, emb := range embedding.Embeddings {
-			embeddingValues := make([]float64, len(emb.Values))
-			for j, val := range emb.Values {
-				embeddingValues[j] = float64(val)
-			}
-			allEmbeddings = append(allEmbeddings, embeddingValues)
+		if err := chunksRepo.DeleteFileChunks(ctx, repoId, source); err != nil {
+			return nil, err
 		}
+		result.Removed++
 	}
 
-	return allEmbeddings, nil
-}
-
-func EmbedRepoPgVector(ctx context.Context, fileContents []FileContent) (string, error) {
-	pool, err := database.GetPool()
-	if err != nil {
-		return "", fmt.Errorf("failed to get database pool: %w", err)
+	for i, f := range changed {
+		if err := embedFile(ctx, embedder, chunksRepo, repoId, f, shas[i]); err != nil {
+			return nil, err
+		}
+		result.Embedded++
 	}
 
-	repoHash := RepoHash(fileContents)
-	var exists bool
-	err = pool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM repo_chunks WHERE repo_hash = $1 LIMIT 1)", repoHash).Scan(&exists)
+	result.Chunks, err = chunksRepo.GetRepoChunksCount(ctx, repoId)
 	if err != nil {
-		return "", fmt.Errorf("failed to check if repo hash exists: %w", err)
+		return nil, err
 	}
 
-	if exists {
-		return fmt.Sprintf("Using cached pgvector embeddings for %d files.", len(fileContents)), nil
-	}
+	return result, nil
+}
 
-	chunks := createChunksFromFiles(fileContents)
+// embedFile replaces a file's chunks. Everything is embedded before the old
+// rows are touched, and the swap is one transaction, so a failure leaves the
+// file as it was and the next run tries it again.
+func embedFile(ctx context.Context, embedder Embedder, chunksRepo repo_chunks.RepoChunksRepository, repoId uuid.UUID, file FileContent, blobSha string) error {
+	chunks := createChunksFromFiles([]FileContent{file})
 	if len(chunks) == 0 {
-		return "No content to embed", nil
+		return chunksRepo.DeleteFileChunks(ctx, repoId, file.Path)
 	}
 
 	texts := make([]string, len(chunks))
@@ -303,26 +202,44 @@ func EmbedRepoPgVector(ctx context.Context, fileContents []FileContent) (string,
 		texts[i] = chunk.Content
 	}
 
-	embeddings, err := getEmbeddings(ctx, texts)
+	embeddings, err := embedder.EmbedDocuments(ctx, texts)
 	if err != nil {
-		return "", fmt.Errorf("failed to get embeddings: %w", err)
+		return fmt.Errorf("failed to get embeddings for %s: %w", file.Path, err)
 	}
 
-	// Insert chunks one by one to avoid connection issues
+	rows := make([]repo_chunks.RepoChunk, len(chunks))
 	for i, chunk := range chunks {
-		// Convert embedding to pgvector format
-		embeddingValues := make([]string, len(embeddings[i]))
-		for j, val := range embeddings[i] {
-			embeddingValues[j] = fmt.Sprintf("%.6f", val)
+		rows[i] = repo_chunks.RepoChunk{
+			RepoId:          repoId,
+			Source:          chunk.Source,
+			BlobSha:         blobSha,
+			Chunk:           chunk.Content,
+			Embedding:       embeddings[i],
+			FileType:        chunk.FileType,
+			ConflictSection: chunk.ConflictSection,
+			LineStart:       chunk.LineStart,
+			LineEnd:         chunk.LineEnd,
+			ChunkType:       chunk.ChunkType,
+			Symbol:          chunk.Symbol,
+			Embedder:        embedder.Name(),
+			EmbeddingDim:    embedder.Dimension(),
 		}
-		embeddingStr := "[" + strings.Join(embeddingValues, ",") + "]"
+	}
 
-		_, err := pool.Exec(ctx, "INSERT INTO repo_chunks (repo_hash, source, chunk, embedding, file_type, conflict_section, line_start, line_end, chunk_type) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
-			repoHash, chunk.Source, chunk.Content, embeddingStr, chunk.FileType, chunk.ConflictSection, chunk.LineStart, chunk.LineEnd, chunk.ChunkType)
-		if err != nil {
-			return "", fmt.Errorf("failed to insert chunk %d: %w", i, err)
-		}
+	return chunksRepo.ReplaceFileChunks(ctx, repoId, file.Path, rows)
+}
+
+func EmbedRepoPgVector(ctx context.Context, embedder Embedder, repoId uuid.UUID, fileContents []FileContent) (string, error) {
+	pool, err := database.GetPool()
+	if err != nil {
+		return "", fmt.Errorf("failed to get database pool: %w", err)
+	}
+
+	result, err := IndexFiles(ctx, embedder, repo_chunks.NewPostgresRepoChunksRepository(pool, embedder), repoId, fileContents)
+	if err != nil {
+		return "", err
 	}
 
-	return fmt.Sprintf("Embedded %d chunks from %d files into Postgres.", len(chunks), len(fileContents)), nil
+	return fmt.Sprintf("Embedded %d of %d files (%d unchanged, %d removed), %d chunks in Postgres with %s.",
+		result.Embedded, result.Files, result.Unchanged, result.Removed, result.Chunks, result.Embedder), nil
 }
@@ -0,0 +1,15 @@
+package rag
+
+import "testing"
+
+func TestConflictChunksSkipEmptySides(t *testing.T) {
+	content := "a\n<<<<<<< HEAD\n||||||| base\nold\n=======\nnew\n>>>>>>> theirs\nb\n"
+	for _, c := range createChunksFromFiles([]FileContent{{Path: "f.go", Content: content}}) {
+		if c.Content == "" {
+			t.Errorf("empty %s chunk", c.ConflictSection)
+		}
+		if c.ConflictSection == "ours" {
+			t.Errorf("the deleted ours side was chunked: %+v", c)
+		}
+	}
+}
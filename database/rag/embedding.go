@@ -0,0 +1,229 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
+	"github.com/tahminator/go-react-template/llm"
+)
+
+const LocalEmbedderName = "local-ngram-v1"
+
+// Embedder turns text into vectors for indexing and retrieval. Its name and
+// dimension are stored with every chunk so vectors from different models are
+// never compared against each other.
+type Embedder interface {
+	Name() string
+	Dimension() int
+	EmbedDocuments(ctx context.Context, texts []string) ([][]float64, error)
+	EmbedQuery(ctx context.Context, query string) ([]float64, error)
+}
+
+// NewEmbedderFromEnv picks the embedder from RAG_EMBEDDER: "provider" (default)
+// embeds with the LLM provider, "local" uses LocalEmbedder and needs no network.
+func NewEmbedderFromEnv(provider llm.LLMProvider) (Embedder, error) {
+	switch kind := strings.ToLower(os.Getenv("RAG_EMBEDDER")); kind {
+	case "", "provider":
+		return NewProviderEmbedder(provider), nil
+	case "local":
+		return NewLocalEmbedder(EmbedDim), nil
+	default:
+		return nil, fmt.Errorf("unknown RAG_EMBEDDER %q", kind)
+	}
+}
+
+// ProviderEmbedder embeds with an LLMProvider, reusing the provider's client.
+type ProviderEmbedder struct {
+	provider llm.LLMProvider
+	name     string
+	dim      int
+}
+
+// NewProviderEmbedder expects vectors of the size the provider requests,
+// or EmbedDim if it leaves the size to the model.
+func NewProviderEmbedder(provider llm.LLMProvider) *ProviderEmbedder {
+	name := provider.Name()
+	if m, ok := provider.(interface{ EmbedModel() string }); ok {
+		name += "/" + m.EmbedModel()
+	}
+	dim := EmbedDim
+	if d, ok := provider.(interface{ EmbedDimensions() int }); ok && d.EmbedDimensions() > 0 {
+		dim = d.EmbedDimensions()
+	}
+	return &ProviderEmbedder{
+		provider: provider,
+		name:     name,
+		dim:      dim,
+	}
+}
+
+// CheckEmbedder makes sure an embedder's vectors fit the vector(EmbedDim)
+// columns. It embeds a probe, so a model that ignores the requested size is
+// caught before anything is indexed rather than on every index run.
+func CheckEmbedder(ctx context.Context, e Embedder) error {
+	if e.Dimension() != EmbedDim {
+		return fmt.Errorf("%s embeds %d dimensions but the schema stores %d", e.Name(), e.Dimension(), EmbedDim)
+	}
+	v, err := e.EmbedQuery(ctx, "dimension check")
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.Name(), err)
+	}
+	if len(v) != EmbedDim {
+		return fmt.Errorf("%s returned %d-dimensional embeddings but the schema stores %d", e.Name(), len(v), EmbedDim)
+	}
+	return nil
+}
+
+func (e *ProviderEmbedder) Name() string {
+	return e.name
+}
+
+func (e *ProviderEmbedder) Dimension() int {
+	return e.dim
+}
+
+func (e *ProviderEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float64, error) {
+	return e.embed(ctx, texts, llm.EmbedTaskDocument)
+}
+
+func (e *ProviderEmbedder) EmbedQuery(ctx context.Context, query string) ([]float64, error) {
+	embeddings, err := e.embed(ctx, []string{query}, llm.EmbedTaskQuery)
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (e *ProviderEmbedder) embed(ctx context.Context, texts []string, task llm.EmbedTask) ([][]float64, error) {
+	embeddings, err := e.provider.Embed(ctx, texts, task)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("%s returned %d embeddings for %d texts", e.name, len(embeddings), len(texts))
+	}
+	for _, v := range embeddings {
+		if len(v) != e.dim {
+			return nil, fmt.Errorf("%s returned %d-dimensional embeddings, expected %d", e.name, len(v), e.dim)
+		}
+	}
+	return embeddings, nil
+}
+
+// LocalEmbedder is an offline embedder: lowercased word tokens, identifier
+// sub-words and character trigrams are hashed into a fixed number of buckets,
+// weighted by log term frequency and L2 normalised. It has no notion of
+// meaning, but shared identifiers and spelling put related code close together.
+type LocalEmbedder struct {
+	dim int
+}
+
+func NewLocalEmbedder(dim int) *LocalEmbedder {
+	if dim <= 0 {
+		dim = EmbedDim
+	}
+	return &LocalEmbedder{dim: dim}
+}
+
+func (e *LocalEmbedder) Name() string {
+	return LocalEmbedderName
+}
+
+func (e *LocalEmbedder) Dimension() int {
+	return e.dim
+}
+
+func (e *LocalEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		out[i] = e.vector(text)
+	}
+	return out, nil
+}
+
+func (e *LocalEmbedder) EmbedQuery(ctx context.Context, query string) ([]float64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.vector(query), nil
+}
+
+func (e *LocalEmbedder) vector(text string) []float64 {
+	counts := map[uint32]float64{}
+	add := func(feature string) {
+		h := fnv.New32a()
+		h.Write([]byte(feature))
+		counts[h.Sum32()%uint32(e.dim)]++
+	}
+
+	for _, word := range tokenize(text) {
+		lower := strings.ToLower(word)
+		add("w:" + lower)
+		if parts := splitIdentifier(word); len(parts) > 1 {
+			for _, p := range parts {
+				add("w:" + strings.ToLower(p))
+			}
+		}
+		padded := " " + lower + " "
+		for i := 0; i+3 <= len(padded); i++ {
+			add("c:" + padded[i:i+3])
+		}
+	}
+
+	vec := make([]float64, e.dim)
+	var norm float64
+	for bucket, n := range counts {
+		w := 1 + math.Log(n)
+		vec[bucket] = w
+		norm += w * w
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vec {
+			vec[i] /= norm
+		}
+	}
+	return vec
+}
+
+// tokenize splits text into runs of letters, digits and underscores.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// splitIdentifier breaks camelCase and snake_case identifiers into words.
+func splitIdentifier(word string) []string {
+	var parts []string
+	for _, piece := range strings.Split(word, "_") {
+		start := 0
+		runes := []rune(piece)
+		for i := 1; i < len(runes); i++ {
+			if unicode.IsUpper(runes[i]) && !unicode.IsUpper(runes[i-1]) {
+				parts = append(parts, string(runes[start:i]))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			parts = append(parts, string(runes[start:]))
+		}
+	}
+	return parts
+}
+
+var (
+	_ Embedder             = new(ProviderEmbedder)
+	_ Embedder             = new(LocalEmbedder)
+	_ repo_chunks.Embedder = new(ProviderEmbedder)
+	_ repo_chunks.Embedder = new(LocalEmbedder)
+)
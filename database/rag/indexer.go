@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+)
+
+const (
+	DefaultIndexWorkers = 2
+
+	indexQueueSize = 64
+	indexTimeout   = 10 * time.Minute
+)
+
+// IndexJob asks for the working tree at Path to be indexed on behalf of the
+// user's Owner/Repo checkout.
+type IndexJob struct {
+	UserId uuid.UUID
+	Owner  string
+	Repo   string
+	Path   string
+}
+
+func (j IndexJob) key() string {
+	return j.UserId.String() + "/" + j.Owner + "/" + j.Repo
+}
+
+// RepoIndexer is what the routers depend on to schedule indexing, report its
+// progress and change a repo's settings.
+type RepoIndexer interface {
+	Enqueue(ctx context.Context, job IndexJob) (*repo_index.RepoIndex, error)
+	Status(ctx context.Context, userId uuid.UUID, owner, repo string) (*repo_index.RepoIndex, error)
+	SetLearnFromAccepted(ctx context.Context, id uuid.UUID, enabled bool) (*repo_index.RepoIndex, error)
+}
+
+// Indexer embeds working trees and builds their symbol tables in the
+// background. Jobs for a repo that is already waiting in the queue are
+// coalesced.
+type Indexer struct {
+	embedder    Embedder
+	chunksRepo  repo_chunks.RepoChunksRepository
+	indexRepo   repo_index.RepoIndexRepository
+	symbolsRepo repo_symbols.RepoSymbolsRepository
+	jobs        chan IndexJob
+	workers     sync.WaitGroup
+
+	mu     sync.Mutex
+	queued map[string]bool
+}
+
+func NewIndexer(
+	embedder Embedder,
+	chunksRepo repo_chunks.RepoChunksRepository,
+	indexRepo repo_index.RepoIndexRepository,
+	symbolsRepo repo_symbols.RepoSymbolsRepository,
+) *Indexer {
+	return &Indexer{
+		embedder:    embedder,
+		chunksRepo:  chunksRepo,
+		indexRepo:   indexRepo,
+		symbolsRepo: symbolsRepo,
+		jobs:        make(chan IndexJob, indexQueueSize),
+		queued:      map[string]bool{},
+	}
+}
+
+// Start launches the workers. They stop when ctx is cancelled; Wait blocks
+// until they have.
+func (ix *Indexer) Start(ctx context.Context, workers int) {
+	for range max(workers, 1) {
+		ix.workers.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-ix.jobs:
+					ix.run(ctx, job)
+				}
+			}
+		})
+	}
+}
+
+func (ix *Indexer) Wait() {
+	ix.workers.Wait()
+}
+
+func (ix *Indexer) Enqueue(ctx context.Context, job IndexJob) (*repo_index.RepoIndex, error) {
+	index, err := ix.indexRepo.MarkPending(ctx, job.UserId, job.Owner, job.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.queued[job.key()] {
+		return index, nil
+	}
+
+	select {
+	case ix.jobs <- job:
+		ix.queued[job.key()] = true
+		return index, nil
+	default:
+		msg := "indexing queue is full"
+		index.Status = repo_index.StatusFailed
+		index.Error = &msg
+		if _, err := ix.indexRepo.UpdateRepoIndex(ctx, index); err != nil {
+			return nil, fmt.Errorf("failed to enqueue %s: %s, and failed to record it: %w", job.key(), msg, err)
+		}
+		return nil, fmt.Errorf("failed to enqueue %s: %s", job.key(), msg)
+	}
+}
+
+func (ix *Indexer) Status(ctx context.Context, userId uuid.UUID, owner, repo string) (*repo_index.RepoIndex, error) {
+	return ix.indexRepo.GetRepoIndex(ctx, userId, owner, repo)
+}
+
+func (ix *Indexer) SetLearnFromAccepted(ctx context.Context, id uuid.UUID, enabled bool) (*repo_index.RepoIndex, error) {
+	return ix.indexRepo.SetLearnFromAccepted(ctx, id, enabled)
+}
+
+func (ix *Indexer) run(ctx context.Context, job IndexJob) {
+	ix.mu.Lock()
+	delete(ix.queued, job.key())
+	ix.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, indexTimeout)
+	defer cancel()
+
+	index, err := ix.indexRepo.GetRepoIndex(ctx, job.UserId, job.Owner, job.Repo)
+	if err != nil || index == nil {
+		log.Printf("indexer: no status row for %s: %v", job.key(), err)
+		return
+	}
+
+	started := time.Now()
+	index.Status = repo_index.StatusRunning
+	index.StartedAt = &started
+	index.FinishedAt = nil
+	index.Error = nil
+	if index, err = ix.indexRepo.UpdateRepoIndex(ctx, index); err != nil {
+		log.Printf("indexer: %s: %v", job.key(), err)
+		return
+	}
+
+	result, err := ix.index(ctx, index.Id, job)
+
+	finished := time.Now()
+	index.FinishedAt = &finished
+	if err != nil {
+		msg := err.Error()
+		index.Status = repo_index.StatusFailed
+		index.Error = &msg
+		log.Printf("indexer: %s failed: %v", job.key(), err)
+	} else {
+		index.Status = repo_index.StatusDone
+		index.CommitSha = headCommit(job.Path)
+		index.Embedder = &result.Embedder
+		index.FileCount = result.Files
+		index.ChunkCount = result.Chunks
+	}
+
+	// the job context may have expired; the outcome must still be recorded,
+	// unless the repo was queued again meanwhile and that run will record its
+	// own
+	if finished, err := ix.indexRepo.FinishRepoIndex(context.WithoutCancel(ctx), index); err != nil {
+		log.Printf("indexer: %s: %v", job.key(), err)
+	} else if finished == nil {
+		log.Printf("indexer: %s was queued again while running; result dropped", job.key())
+	}
+}
+
+func (ix *Indexer) index(ctx context.Context, repoId uuid.UUID, job IndexJob) (*IndexResult, error) {
+	files, err := LoadWorkingTree(job.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read working tree: %w", err)
+	}
+	result, err := IndexFiles(ctx, ix.embedder, ix.chunksRepo, repoId, files)
+	if err != nil {
+		return nil, err
+	}
+	if err := IndexSymbols(ctx, ix.symbolsRepo, repoId, files); err != nil {
+		return nil, fmt.Errorf("failed to index symbols: %w", err)
+	}
+	return result, nil
+}
+
+// headCommit is the commit the indexed working tree is checked out at. During
+// a merge the tree also holds the incoming changes and conflict markers.
+func headCommit(path string) *string {
+	repo, err := gogit.PlainOpen(path)
+	if err != nil {
+		return nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil
+	}
+	sha := head.Hash().String()
+	return &sha
+}
+
+var _ RepoIndexer = new(Indexer)
@@ -2,298 +2,237 @@ package rag
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
-	"github.com/tahminator/go-react-template/database"
-	"google.golang.org/genai"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
 )
 
-type SimilarChunk struct {
-	Source          string  `db:"source"`
-	Chunk           string  `db:"chunk"`
-	Distance        float64 `db:"distance"`
-	FileType        string  `db:"file_type"`
-	ConflictSection string  `db:"conflict_section"`
-	LineStart       int     `db:"line_start"`
-	LineEnd         int     `db:"line_end"`
-	ChunkType       string  `db:"chunk_type"`
-}
-
-func getQueryEmbedding(ctx context.Context, query string) ([]float64, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set")
-	}
-
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey: apiKey,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
-	}
+const (
+	// rrfK dampens the advantage of the very top ranks in reciprocal rank
+	// fusion; 60 is the constant from the original RRF paper.
+	rrfK = 60
 
-	content := genai.NewContentFromText(query, genai.RoleUser)
-	embedding, err := client.Models.EmbedContent(ctx, EmbedModel, []*genai.Content{content}, &genai.EmbedContentConfig{
-		TaskType: "RETRIEVAL_QUERY",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get query embedding: %w", err)
-	}
+	// each source returns this many times k candidates before fusion
+	candidateFactor = 4
 
-	embeddingValues := make([]float64, len(embedding.Embeddings[0].Values))
-	for i, val := range embedding.Embeddings[0].Values {
-		embeddingValues[i] = float64(val)
-	}
+	maxQueryIdentifiers = 32
+	minIdentifierLength = 3
+)
 
-	return embeddingValues, nil
+// RetrievalWeights scale how much each source contributes to the fused rank.
+// A zero weight turns that source off.
+type RetrievalWeights struct {
+	Vector  float64 `json:"vector"`
+	Lexical float64 `json:"lexical"`
 }
 
-func SimilarChunks(ctx context.Context, query, repoHash string, k int) ([]SimilarChunk, error) {
-	queryEmbedding, err := getQueryEmbedding(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get query embedding: %w", err)
-	}
-
-	// Convert embedding to pgvector format
-	embeddingValues := make([]string, len(queryEmbedding))
-	for i, val := range queryEmbedding {
-		embeddingValues[i] = fmt.Sprintf("%.6f", val)
-	}
-	embeddingStr := "[" + strings.Join(embeddingValues, ",") + "]"
-
-	pool, err := database.GetPool()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get database pool: %w", err)
-	}
-
-	rows, err := pool.Query(ctx, `
-		SELECT source, chunk, embedding <-> $1 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
-		FROM repo_chunks
-		WHERE repo_hash = $2
-		ORDER BY embedding <-> $1
-		LIMIT $3
-	`, embeddingStr, repoHash, k)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query similar chunks: %w", err)
-	}
-	defer rows.Close()
-
-	var chunks []SimilarChunk
-	for rows.Next() {
-		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan chunk row: %w", err)
-		}
-		chunks = append(chunks, chunk)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over rows: %w", err)
-	}
-
-	return chunks, nil
+func DefaultRetrievalWeights() RetrievalWeights {
+	return RetrievalWeights{Vector: 1, Lexical: 1}
 }
 
-func SimilarChunksWithThreshold(ctx context.Context, query, repoHash string, k int, maxDistance float64) ([]SimilarChunk, error) {
-	queryEmbedding, err := getQueryEmbedding(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get query embedding: %w", err)
-	}
-
-	// Convert embedding to pgvector format
-	embeddingValues := make([]string, len(queryEmbedding))
-	for i, val := range queryEmbedding {
-		embeddingValues[i] = fmt.Sprintf("%.6f", val)
-	}
-	embeddingStr := "[" + strings.Join(embeddingValues, ",") + "]"
-
-	pool, err := database.GetPool()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get database pool: %w", err)
-	}
-
-	rows, err := pool.Query(ctx, `
-		SELECT source, chunk, embedding <-> $1 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
-		FROM repo_chunks
-		WHERE repo_hash = $2 AND embedding <-> $1 < $4
-		ORDER BY embedding <-> $1
-		LIMIT $3
-	`, embeddingStr, repoHash, k, maxDistance)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query similar chunks: %w", err)
-	}
-	defer rows.Close()
-
-	var chunks []SimilarChunk
-	for rows.Next() {
-		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan chunk row: %w", err)
+// RetrievalWeightsFromEnv reads RAG_VECTOR_WEIGHT and RAG_LEXICAL_WEIGHT,
+// defaulting each to 1.
+func RetrievalWeightsFromEnv() (RetrievalWeights, error) {
+	weights := DefaultRetrievalWeights()
+	for name, w := range map[string]*float64{
+		"RAG_VECTOR_WEIGHT":  &weights.Vector,
+		"RAG_LEXICAL_WEIGHT": &weights.Lexical,
+	} {
+		raw := os.Getenv(name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 {
+			return weights, fmt.Errorf("invalid %s %q: must be a non-negative number", name, raw)
 		}
-		chunks = append(chunks, chunk)
+		*w = v
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	if weights.Vector == 0 && weights.Lexical == 0 {
+		return weights, fmt.Errorf("RAG_VECTOR_WEIGHT and RAG_LEXICAL_WEIGHT cannot both be 0")
 	}
-
-	return chunks, nil
+	return weights, nil
 }
 
-func GetRepoChunksCount(ctx context.Context, repoHash string) (int, error) {
-	pool, err := database.GetPool()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get database pool: %w", err)
-	}
-
-	var count int
-	err = pool.QueryRow(ctx, "SELECT COUNT(*) FROM repo_chunks WHERE repo_hash = $1", repoHash).Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to count repo chunks: %w", err)
-	}
-
-	return count, nil
+// RetrievalQuery is what to look for: Text is embedded for vector search and
+// Identifiers are matched lexically.
+type RetrievalQuery struct {
+	Text        string
+	Identifiers []string
 }
 
-func DeleteRepoChunks(ctx context.Context, repoHash string) error {
-	pool, err := database.GetPool()
-	if err != nil {
-		return fmt.Errorf("failed to get database pool: %w", err)
-	}
+// Retriever combines vector similarity with lexical identifier matches.
+// Embeddings find code that reads alike; exact identifiers find the renamed
+// function or changed struct field a conflict is actually about.
+type Retriever struct {
+	chunksRepo repo_chunks.RepoChunksRepository
+	weights    RetrievalWeights
+}
 
-	_, err = pool.Exec(ctx, "DELETE FROM repo_chunks WHERE repo_hash = $1", repoHash)
-	if err != nil {
-		return fmt.Errorf("failed to delete repo chunks: %w", err)
+func NewRetriever(chunksRepo repo_chunks.RepoChunksRepository, weights RetrievalWeights) *Retriever {
+	return &Retriever{
+		chunksRepo: chunksRepo,
+		weights:    weights,
 	}
-
-	return nil
 }
 
-// GetConflictChunks retrieves chunks specifically related to merge conflicts
-func GetConflictChunks(ctx context.Context, repoHash string) ([]SimilarChunk, error) {
-	pool, err := database.GetPool()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get database pool: %w", err)
+// Retrieve returns the k best chunks fused by weighted reciprocal rank. Score
+// holds the fused score; Distance is only meaningful for chunks the vector
+// search also returned. A failing source is logged and skipped unless every
+// enabled source failed.
+func (r *Retriever) Retrieve(ctx context.Context, repoId uuid.UUID, q RetrievalQuery, k int) ([]repo_chunks.SimilarChunk, error) {
+	if k <= 0 {
+		return nil, nil
 	}
+	candidates := k * candidateFactor
 
-	rows, err := pool.Query(ctx, `
-		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
-		FROM repo_chunks
-		WHERE repo_hash = $1 AND file_type = 'conflict'
-		ORDER BY line_start
-	`, repoHash)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query conflict chunks: %w", err)
+	var vector, lexical []repo_chunks.SimilarChunk
+	var vectorErr, lexicalErr error
+	if r.weights.Vector > 0 && strings.TrimSpace(q.Text) != "" {
+		vector, vectorErr = r.chunksRepo.GetSimilarChunks(ctx, q.Text, repoId, candidates)
 	}
-	defer rows.Close()
-
-	var chunks []SimilarChunk
-	for rows.Next() {
-		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan conflict chunk row: %w", err)
-		}
-		chunks = append(chunks, chunk)
+	if r.weights.Lexical > 0 && len(q.Identifiers) > 0 {
+		lexical, lexicalErr = r.chunksRepo.GetLexicalChunks(ctx, repoId, q.Identifiers, candidates)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over conflict chunk rows: %w", err)
+	switch {
+	case vectorErr != nil && lexicalErr != nil:
+		return nil, errors.Join(vectorErr, lexicalErr)
+	case vectorErr != nil:
+		if len(lexical) == 0 {
+			return nil, vectorErr
+		}
+		log.Printf("retriever: vector search failed, using lexical matches only: %v", vectorErr)
+	case lexicalErr != nil:
+		if len(vector) == 0 {
+			return nil, lexicalErr
+		}
+		log.Printf("retriever: lexical search failed, using vector matches only: %v", lexicalErr)
 	}
 
-	return chunks, nil
+	return fuse(k, fusedList{vector, r.weights.Vector}, fusedList{lexical, r.weights.Lexical}), nil
 }
 
-// GetContextChunks retrieves context chunks around conflicts for better understanding
-func GetContextChunks(ctx context.Context, repoHash, source string, lineStart, lineEnd int, contextLines int) ([]SimilarChunk, error) {
-	pool, err := database.GetPool()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get database pool: %w", err)
-	}
-
-	startBound := lineStart - contextLines
-	endBound := lineEnd + contextLines
-
-	rows, err := pool.Query(ctx, `
-		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
-		FROM repo_chunks
-		WHERE repo_hash = $1 AND source = $2 
-		AND (
-			(line_start >= $3 AND line_start <= $4) OR
-			file_type = 'context'
-		)
-		ORDER BY line_start
-	`, repoHash, source, startBound, endBound)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query context chunks: %w", err)
-	}
-	defer rows.Close()
+type fusedList struct {
+	chunks []repo_chunks.SimilarChunk
+	weight float64
+}
 
-	var chunks []SimilarChunk
-	for rows.Next() {
-		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan context chunk row: %w", err)
+// fuse scores every chunk by the sum of weight/(rrfK+rank) over the lists it
+// appears in and returns the top k. Ties keep first-seen order.
+func fuse(k int, lists ...fusedList) []repo_chunks.SimilarChunk {
+	var out []repo_chunks.SimilarChunk
+	index := map[string]int{}
+	for _, list := range lists {
+		for rank, chunk := range list.chunks {
+			key := chunkKey(chunk)
+			i, ok := index[key]
+			if !ok {
+				i = len(out)
+				index[key] = i
+				chunk.Score = 0
+				out = append(out, chunk)
+			}
+			out[i].Score += list.weight / float64(rrfK+rank+1)
 		}
-		chunks = append(chunks, chunk)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over context chunk rows: %w", err)
-	}
+	slices.SortStableFunc(out, func(a, b repo_chunks.SimilarChunk) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	return out[:min(k, len(out))]
+}
 
-	return chunks, nil
+func chunkKey(c repo_chunks.SimilarChunk) string {
+	return fmt.Sprintf("%s:%d-%d:%s:%s", c.Source, c.LineStart, c.LineEnd, c.FileType, c.ConflictSection)
 }
 
-// GetSimilarFunctions retrieves similar function definitions for conflict resolution
-func GetSimilarFunctions(ctx context.Context, query, repoHash string, k int) ([]SimilarChunk, error) {
-	queryEmbedding, err := getQueryEmbedding(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get query embedding: %w", err)
-	}
+// keywords common to the languages we index; they match nearly every chunk
+// and say nothing about what a conflict changed.
+var keywords = map[string]bool{
+	"and": true, "async": true, "await": true, "bool": true, "break": true,
+	"case": true, "catch": true, "chan": true, "class": true, "const": true,
+	"continue": true, "def": true, "default": true, "defer": true, "elif": true,
+	"else": true, "enum": true, "err": true, "error": true, "export": true,
+	"extends": true, "false": true, "final": true, "finally": true, "for": true,
+	"from": true, "func": true, "function": true, "go": true, "goto": true,
+	"if": true, "implements": true, "import": true, "int": true, "interface": true,
+	"let": true, "map": true, "new": true, "nil": true, "none": true,
+	"not": true, "null": true, "package": true, "pass": true, "private": true,
+	"protected": true, "public": true, "range": true, "return": true, "self": true,
+	"static": true, "string": true, "struct": true, "super": true, "switch": true,
+	"this": true, "throw": true, "true": true, "try": true, "type": true,
+	"undefined": true, "var": true, "void": true, "while": true, "with": true,
+	"yield": true,
+}
 
-	// Convert embedding to pgvector format
-	embeddingValues := make([]string, len(queryEmbedding))
-	for i, val := range queryEmbedding {
-		embeddingValues[i] = fmt.Sprintf("%.6f", val)
-	}
-	embeddingStr := "[" + strings.Join(embeddingValues, ",") + "]"
+// ConflictIdentifiers extracts the identifiers worth matching lexically from
+// the conflict hunks. Identifiers that appear on only some sides, such as a
+// renamed function or a changed field, come first; identifiers every side
+// shares follow. Keywords, numbers and very short names are dropped.
+func ConflictIdentifiers(parsed *conflict.File) []string {
+	if parsed == nil {
+		return nil
+	}
+
+	var changed, shared []string
+	seen := map[string]bool{}
+	for _, h := range parsed.Hunks {
+		sides := []map[string]bool{identifierSet(h.Ours), identifierSet(h.Theirs)}
+		if h.HasBase {
+			sides = append(sides, identifierSet(h.Base))
+		}
 
-	pool, err := database.GetPool()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get database pool: %w", err)
+		for _, text := range []string{h.Ours, h.Theirs, h.Base} {
+			for _, ident := range tokenize(text) {
+				if seen[ident] || !isQueryIdentifier(ident) {
+					continue
+				}
+				seen[ident] = true
+
+				onAll := true
+				for _, side := range sides {
+					onAll = onAll && side[ident]
+				}
+				if onAll {
+					shared = append(shared, ident)
+				} else {
+					changed = append(changed, ident)
+				}
+			}
+		}
 	}
 
-	rows, err := pool.Query(ctx, `
-		SELECT source, chunk, embedding <-> $1 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
-		FROM repo_chunks
-		WHERE repo_hash = $2 AND chunk_type = 'function'
-		ORDER BY embedding <-> $1
-		LIMIT $3
-	`, embeddingStr, repoHash, k)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query similar functions: %w", err)
-	}
-	defer rows.Close()
+	out := append(changed, shared...)
+	return out[:min(len(out), maxQueryIdentifiers)]
+}
 
-	var chunks []SimilarChunk
-	for rows.Next() {
-		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan function chunk row: %w", err)
-		}
-		chunks = append(chunks, chunk)
+func identifierSet(text string) map[string]bool {
+	set := map[string]bool{}
+	for _, ident := range tokenize(text) {
+		set[ident] = true
 	}
+	return set
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over function chunk rows: %w", err)
+func isQueryIdentifier(ident string) bool {
+	if len(ident) < minIdentifierLength || keywords[strings.ToLower(ident)] {
+		return false
 	}
-
-	return chunks, nil
+	r := ident[0]
+	return r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= 0x80
 }
@@ -0,0 +1,501 @@
+package rag
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/conflict"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+)
+
+const (
+	maxSignatureLines = 30
+	maxSnippetLength  = 200
+)
+
+// IndexSymbols rebuilds the repo's Go definition and reference tables when
+// any Go file was added, changed or removed since the last build. Type
+// information crosses files, so the whole repo is extracted again rather than
+// just the files that changed.
+func IndexSymbols(ctx context.Context, symbolsRepo repo_symbols.RepoSymbolsRepository, repoId uuid.UUID, files []FileContent) error {
+	var goFiles []FileContent
+	var current []repo_symbols.SymbolFile
+	for _, f := range files {
+		if path.Ext(f.Path) != ".go" {
+			continue
+		}
+		goFiles = append(goFiles, f)
+		current = append(current, repo_symbols.SymbolFile{Source: f.Path, BlobSha: BlobSha(f.Content)})
+	}
+
+	stored, err := symbolsRepo.GetSymbolFiles(ctx, repoId)
+	if err != nil {
+		return err
+	}
+	if sameSymbolFiles(stored, current) {
+		return nil
+	}
+
+	defs, refs := ExtractGoSymbols(goFiles, modulePath(files))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return symbolsRepo.ReplaceRepoSymbols(ctx, repoId, current, defs, refs)
+}
+
+func sameSymbolFiles(a, b []repo_symbols.SymbolFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byPath := func(x, y repo_symbols.SymbolFile) int { return strings.Compare(x.Source, y.Source) }
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, byPath)
+	slices.SortFunc(b, byPath)
+	return slices.Equal(a, b)
+}
+
+// modulePath reads the module path from a root go.mod, if there is one, so
+// imports of the repo's own packages can be resolved.
+func modulePath(files []FileContent) string {
+	for _, f := range files {
+		if f.Path != "go.mod" {
+			continue
+		}
+		for _, line := range strings.Split(f.Content, "\n") {
+			if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+				return strings.Trim(strings.TrimSpace(rest), `"`)
+			}
+		}
+	}
+	return ""
+}
+
+// goPackage is the files of one package clause in one directory.
+type goPackage struct {
+	dir   string
+	name  string
+	files []*ast.File
+	paths []string
+	lines map[string][]string
+
+	pkg  *types.Package
+	info *types.Info
+}
+
+// symbolExtractor type-checks the repo's packages from source. Imports of the
+// repo's own packages are checked on demand; everything else resolves to an
+// empty package, so only repo-local definitions and uses are recorded.
+type symbolExtractor struct {
+	fset     *token.FileSet
+	module   string
+	packages []*goPackage
+	byDir    map[string]*goPackage
+	checking map[string]bool
+	external map[string]*types.Package
+}
+
+// ExtractGoSymbols returns the definitions in files and every use of them.
+// Files with conflict markers are read as their ours side; files that still
+// do not parse are skipped. Type errors are ignored so that a repo with
+// unresolved imports still yields what can be resolved.
+func ExtractGoSymbols(files []FileContent, module string) ([]repo_symbols.Definition, []repo_symbols.Reference) {
+	x := &symbolExtractor{
+		fset:     token.NewFileSet(),
+		module:   module,
+		byDir:    map[string]*goPackage{},
+		checking: map[string]bool{},
+		external: map[string]*types.Package{},
+	}
+
+	units := map[string]*goPackage{}
+	for _, fc := range files {
+		content := fc.Content
+		if conflict.HasMarkers(content) {
+			parsed, err := conflict.Parse(content)
+			if err != nil {
+				continue
+			}
+			content = parsed.RenderOursAligned()
+		}
+		f, err := parser.ParseFile(x.fset, fc.Path, content, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+
+		dir := path.Dir(fc.Path)
+		key := dir + "\x00" + f.Name.Name
+		unit, ok := units[key]
+		if !ok {
+			unit = &goPackage{dir: dir, name: f.Name.Name, lines: map[string][]string{}}
+			units[key] = unit
+			x.packages = append(x.packages, unit)
+		}
+		unit.files = append(unit.files, f)
+		unit.paths = append(unit.paths, fc.Path)
+		unit.lines[fc.Path] = strings.Split(content, "\n")
+	}
+
+	sort.Slice(x.packages, func(i, j int) bool {
+		a, b := x.packages[i], x.packages[j]
+		if a.dir != b.dir {
+			return a.dir < b.dir
+		}
+		return a.name < b.name
+	})
+	for _, p := range x.packages {
+		// the package an import of this directory resolves to; external
+		// test packages only ever import it
+		if _, ok := x.byDir[p.dir]; !ok && !strings.HasSuffix(p.name, "_test") {
+			x.byDir[p.dir] = p
+		}
+	}
+
+	for _, p := range x.packages {
+		x.check(p)
+	}
+
+	var defs []repo_symbols.Definition
+	owners := map[types.Object]repo_symbols.Definition{}
+	for _, p := range x.packages {
+		defs = append(defs, x.definitions(p, owners)...)
+	}
+
+	var refs []repo_symbols.Reference
+	for _, p := range x.packages {
+		refs = append(refs, x.references(p, owners)...)
+	}
+	return defs, refs
+}
+
+func (x *symbolExtractor) check(p *goPackage) *types.Package {
+	if p.pkg != nil {
+		return p.pkg
+	}
+	key := p.dir + "\x00" + p.name
+	if x.checking[key] {
+		// import cycle; the real package is still being checked
+		return types.NewPackage(x.importPath(p.dir), p.name)
+	}
+	x.checking[key] = true
+	defer delete(x.checking, key)
+
+	p.info = &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+	conf := types.Config{
+		Importer:    x,
+		Error:       func(error) {},
+		FakeImportC: true,
+	}
+	p.pkg, _ = conf.Check(x.importPath(p.dir), x.fset, p.files, p.info)
+	return p.pkg
+}
+
+func (x *symbolExtractor) importPath(dir string) string {
+	switch {
+	case x.module == "":
+		return dir
+	case dir == ".":
+		return x.module
+	default:
+		return x.module + "/" + dir
+	}
+}
+
+// Import implements types.Importer.
+func (x *symbolExtractor) Import(importPath string) (*types.Package, error) {
+	if x.module != "" {
+		dir := ""
+		if importPath == x.module {
+			dir = "."
+		} else if rest, ok := strings.CutPrefix(importPath, x.module+"/"); ok {
+			dir = rest
+		}
+		if p, ok := x.byDir[dir]; ok {
+			return x.check(p), nil
+		}
+	}
+
+	if pkg, ok := x.external[importPath]; ok {
+		return pkg, nil
+	}
+	pkg := types.NewPackage(importPath, path.Base(importPath))
+	pkg.MarkComplete()
+	x.external[importPath] = pkg
+	return pkg, nil
+}
+
+// definitions records the package's declarations and, in owners, maps each
+// declared object to its definition so uses can be traced back to it.
+func (x *symbolExtractor) definitions(p *goPackage, owners map[types.Object]repo_symbols.Definition) []repo_symbols.Definition {
+	var defs []repo_symbols.Definition
+	add := func(ident *ast.Ident, source, name string, kind repo_symbols.Kind, node ast.Node, signature string) {
+		obj := p.info.Defs[ident]
+		if obj == nil || ident.Name == "_" {
+			return
+		}
+		def := repo_symbols.Definition{
+			Source:    source,
+			Dir:       p.dir,
+			Package:   p.name,
+			Name:      name,
+			ShortName: ident.Name,
+			Kind:      kind,
+			Signature: signature,
+			LineStart: x.fset.Position(node.Pos()).Line,
+			LineEnd:   x.fset.Position(node.End()).Line,
+		}
+		owners[obj] = def
+		defs = append(defs, def)
+	}
+
+	for i, f := range p.files {
+		source, lines := p.paths[i], p.lines[p.paths[i]]
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				kind := repo_symbols.KindFunc
+				if d.Recv != nil {
+					kind = repo_symbols.KindMethod
+				}
+				end := d.End()
+				if d.Body != nil {
+					end = d.Body.Lbrace
+				}
+				add(d.Name, source, funcSymbol(d), kind, d, x.text(lines, d.Pos(), end))
+
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						add(s.Name, source, s.Name.Name, repo_symbols.KindType, s, x.text(lines, s.Pos(), s.End()))
+						x.members(p, s, source, lines, add)
+					case *ast.ValueSpec:
+						kind := repo_symbols.KindVar
+						if d.Tok == token.CONST {
+							kind = repo_symbols.KindConst
+						}
+						for _, name := range s.Names {
+							add(name, source, name.Name, kind, s, x.text(lines, s.Pos(), s.End()))
+						}
+					}
+				}
+			}
+		}
+	}
+	return defs
+}
+
+// members records struct fields and interface methods as "Type.Member".
+func (x *symbolExtractor) members(
+	p *goPackage,
+	s *ast.TypeSpec,
+	source string,
+	lines []string,
+	add func(*ast.Ident, string, string, repo_symbols.Kind, ast.Node, string),
+) {
+	var fields *ast.FieldList
+	kind := repo_symbols.KindField
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		fields = t.Fields
+	case *ast.InterfaceType:
+		fields = t.Methods
+		kind = repo_symbols.KindMethod
+	}
+	if fields == nil {
+		return
+	}
+	for _, field := range fields.List {
+		for _, name := range field.Names {
+			add(name, source, s.Name.Name+"."+name.Name, kind, field, x.text(lines, field.Pos(), field.End()))
+		}
+	}
+}
+
+// references records every use of a repo definition, noting whether it is
+// the callee of a call and which declaration it sits in.
+func (x *symbolExtractor) references(p *goPackage, owners map[types.Object]repo_symbols.Definition) []repo_symbols.Reference {
+	var refs []repo_symbols.Reference
+	for i, f := range p.files {
+		source, lines := p.paths[i], p.lines[p.paths[i]]
+
+		callees := map[*ast.Ident]bool{}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if ident := calleeIdent(call.Fun); ident != nil {
+					callees[ident] = true
+				}
+			}
+			return true
+		})
+
+		for _, decl := range f.Decls {
+			caller := declSymbol(decl)
+			ast.Inspect(decl, func(n ast.Node) bool {
+				ident, ok := n.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				obj := p.info.Uses[ident]
+				if obj == nil {
+					return true
+				}
+				if origin := originObject(obj); origin != nil {
+					obj = origin
+				}
+				def, ok := owners[obj]
+				if !ok {
+					return true
+				}
+				line := x.fset.Position(ident.Pos()).Line
+				snippet := ""
+				if line-1 < len(lines) {
+					snippet = strings.TrimSpace(lines[line-1])
+				}
+				if len(snippet) > maxSnippetLength {
+					snippet = snippet[:maxSnippetLength]
+				}
+				refs = append(refs, repo_symbols.Reference{
+					Source:  source,
+					Line:    line,
+					Caller:  caller,
+					Snippet: snippet,
+					IsCall:  callees[ident],
+					DefDir:  def.Dir,
+					DefName: def.Name,
+				})
+				return true
+			})
+		}
+	}
+	return refs
+}
+
+// originObject maps a method or field of an instantiated generic type back to
+// the generic declaration.
+func originObject(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Func:
+		return o.Origin()
+	case *types.Var:
+		return o.Origin()
+	}
+	return nil
+}
+
+func calleeIdent(fun ast.Expr) *ast.Ident {
+	for {
+		switch f := fun.(type) {
+		case *ast.ParenExpr:
+			fun = f.X
+		case *ast.IndexExpr:
+			fun = f.X
+		case *ast.IndexListExpr:
+			fun = f.X
+		case *ast.Ident:
+			return f
+		case *ast.SelectorExpr:
+			return f.Sel
+		default:
+			return nil
+		}
+	}
+}
+
+func declSymbol(decl ast.Decl) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return funcSymbol(d)
+	case *ast.GenDecl:
+		return genDeclSymbol(d)
+	}
+	return ""
+}
+
+// text returns the source lines spanning [from, to], capped at
+// maxSignatureLines.
+func (x *symbolExtractor) text(lines []string, from, to token.Pos) string {
+	start, end := x.fset.Position(from).Line, x.fset.Position(to).Line
+	if start < 1 || end > len(lines) || start > end {
+		return ""
+	}
+	truncated := end-start+1 > maxSignatureLines
+	if truncated {
+		end = start + maxSignatureLines - 1
+	}
+	text := strings.TrimRight(strings.Join(lines[start-1:end], "\n"), " \t{\r\n")
+	if truncated {
+		text += "\n\t// ..."
+	}
+	return text
+}
+
+// ChangedFunctions names the functions either side of a hunk touches: those
+// declared inside a hunk, and the function a hunk sits in when the conflict
+// is within a body. Go methods are named "Recv.Method".
+func ChangedFunctions(parsed *conflict.File) []string {
+	if parsed == nil {
+		return nil
+	}
+
+	var names []string
+	add := func(name string) {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	var before []string
+	for _, r := range parsed.Regions {
+		if r.Kind == conflict.RegionClean {
+			before = append(before, strings.Split(r.Text, "\n")...)
+			continue
+		}
+
+		h := r.Hunk
+		declared := false
+		for _, side := range []string{h.Ours, h.Base, h.Theirs} {
+			for _, line := range strings.Split(side, "\n") {
+				if kind, symbol := classifyLine(strings.TrimSpace(line)); kind == ChunkTypeFunction {
+					add(symbol)
+					declared = true
+				}
+			}
+		}
+		if !declared {
+			add(enclosingFunction(before))
+		}
+		before = append(before, strings.Split(h.Ours, "\n")...)
+	}
+	return names
+}
+
+// enclosingFunction walks back from the end of lines to the top-level
+// function declaration they are inside, if any.
+func enclosingFunction(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := lines[i]
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if kind, symbol := classifyLine(trimmed); kind == ChunkTypeFunction {
+			return symbol
+		}
+		if !isComment(trimmed) {
+			// a closing brace or other top-level code: not inside a function
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package rag
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// MaxIndexFileSize skips generated bundles, lockfiles and other blobs that
+// would crowd out real source in retrieval.
+const MaxIndexFileSize = 512 * 1024
+
+// vendoredDirs are never indexed, whether or not .gitignore lists them.
+var vendoredDirs = map[string]bool{
+	".git":         true,
+	"vendor":       true,
+	"node_modules": true,
+	"third_party":  true,
+	".venv":        true,
+	"__pycache__":  true,
+}
+
+// LoadWorkingTree reads every indexable file under root: paths ignored by the
+// repo's .gitignore files, vendored directories, binaries and oversized files
+// are skipped. Paths are returned relative to root with forward slashes.
+func LoadWorkingTree(root string) ([]FileContent, error) {
+	patterns, err := gitignore.ReadPatterns(osfs.New(root), nil)
+	if err != nil {
+		return nil, err
+	}
+	matcher := gitignore.NewMatcher(patterns)
+
+	var files []FileContent
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+
+		if d.IsDir() {
+			if vendoredDirs[d.Name()] || matcher.Match(parts, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// the .git of a linked worktree is a file pointing at the clone
+		if d.Name() == ".git" || !d.Type().IsRegular() || matcher.Match(parts, false) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > MaxIndexFileSize {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if isBinary(data) {
+			return nil
+		}
+
+		files = append(files, FileContent{
+			Path:    filepath.ToSlash(rel),
+			Content: string(data),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// isBinary uses git's heuristic of a NUL byte in the first 8000 bytes, and
+// also rejects content that is not valid UTF-8.
+func isBinary(data []byte) bool {
+	head := data[:min(len(data), 8000)]
+	return bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(data)
+}
@@ -0,0 +1,31 @@
+package accepted_resolutions
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AcceptedResolution is one conflict hunk as a person accepted it. Proposal
+// is what the model suggested, if a suggestion was shown; Edited is set when
+// the accepted text differs from it.
+type AcceptedResolution struct {
+	Id        uuid.UUID `db:"id" json:"id"`
+	RepoId    uuid.UUID `db:"repo_id" json:"repo_id"`
+	Source    string    `db:"source" json:"source"`
+	HunkIndex int       `db:"hunk_index" json:"hunk_index"`
+	Ours      string    `db:"ours" json:"ours"`
+	Base      *string   `db:"base" json:"base"`
+	Theirs    string    `db:"theirs" json:"theirs"`
+	Proposal  *string   `db:"proposal" json:"proposal"`
+	Final     string    `db:"final" json:"final"`
+	Edited    bool      `db:"edited" json:"edited"`
+	AuthorId  uuid.UUID `db:"author_id" json:"author_id"`
+	Author    string    `db:"author" json:"author"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type SimilarResolution struct {
+	AcceptedResolution
+	Distance float64 `db:"distance" json:"distance"`
+}
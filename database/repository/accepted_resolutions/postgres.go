@@ -0,0 +1,136 @@
+package accepted_resolutions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresAcceptedResolutionsRepository struct {
+	db       *pgxpool.Pool
+	embedder Embedder
+}
+
+func NewPostgresAcceptedResolutionsRepository(db *pgxpool.Pool, embedder Embedder) *PostgresAcceptedResolutionsRepository {
+	return &PostgresAcceptedResolutionsRepository{
+		db:       db,
+		embedder: embedder,
+	}
+}
+
+func (repo *PostgresAcceptedResolutionsRepository) InsertResolutions(ctx context.Context, resolutions []AcceptedResolution) error {
+	if len(resolutions) == 0 {
+		return nil
+	}
+	if repo.embedder == nil {
+		return fmt.Errorf("no embedder configured for accepted resolutions")
+	}
+
+	texts := make([]string, len(resolutions))
+	for i, r := range resolutions {
+		texts[i] = EmbeddingText(r.Ours, r.Theirs)
+	}
+	embeddings, err := repo.embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed accepted resolutions: %w", err)
+	}
+	if len(embeddings) != len(resolutions) {
+		return fmt.Errorf("%s returned %d embeddings for %d resolutions", repo.embedder.Name(), len(embeddings), len(resolutions))
+	}
+
+	query := `
+		INSERT INTO accepted_resolutions
+			(repo_id, source, hunk_index, ours, base, theirs, proposal, final, edited, author_id, author, embedding, embedder)
+		VALUES
+			(@repoId, @source, @hunkIndex, @ours, @base, @theirs, @proposal, @final, @edited, @authorId, @author, @embedding, @embedder)
+	`
+
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to insert accepted resolutions: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for i, r := range resolutions {
+		_, err := tx.Exec(ctx, query, pgx.NamedArgs{
+			"repoId":    r.RepoId,
+			"source":    r.Source,
+			"hunkIndex": r.HunkIndex,
+			"ours":      r.Ours,
+			"base":      r.Base,
+			"theirs":    r.Theirs,
+			"proposal":  r.Proposal,
+			"final":     r.Final,
+			"edited":    r.Edited,
+			"authorId":  r.AuthorId,
+			"author":    r.Author,
+			"embedding": vectorLiteral(embeddings[i]),
+			"embedder":  repo.embedder.Name(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert accepted resolution: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to insert accepted resolutions: %w", err)
+	}
+	return nil
+}
+
+func (repo *PostgresAcceptedResolutionsRepository) GetSimilarResolutions(ctx context.Context, repoId uuid.UUID, query string, k int) ([]SimilarResolution, error) {
+	if repo.embedder == nil {
+		return nil, fmt.Errorf("no embedder configured for accepted resolutions")
+	}
+	embedding, err := repo.embedder.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get query embedding: %w", err)
+	}
+	if len(embedding) != repo.embedder.Dimension() {
+		return nil, fmt.Errorf("%s returned a %d-dimensional query embedding, expected %d", repo.embedder.Name(), len(embedding), repo.embedder.Dimension())
+	}
+
+	sql := `
+		SELECT
+			a.id, a.repo_id, a.source, a.hunk_index, a.ours, a.base, a.theirs, a.proposal, a.final,
+			a.edited, a.author_id, a.author, a.created_at, a.embedding <-> @embedding AS distance
+		FROM
+			accepted_resolutions a
+			JOIN repo_index r ON r.id = a.repo_id
+		WHERE
+			a.repo_id = @repoId AND a.embedder = @embedder AND r.learn_from_accepted
+		ORDER BY
+			a.embedding <-> @embedding
+		LIMIT @k
+	`
+
+	rows, err := repo.db.Query(ctx, sql, pgx.NamedArgs{
+		"repoId":    repoId,
+		"embedding": vectorLiteral(embedding),
+		"embedder":  repo.embedder.Name(),
+		"k":         k,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get similar accepted resolutions: %w", err)
+	}
+
+	resolutions, err := pgx.CollectRows(rows, pgx.RowToStructByName[SimilarResolution])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get similar accepted resolutions: %w", err)
+	}
+	return resolutions, nil
+}
+
+func vectorLiteral(embedding []float64) string {
+	values := make([]string, len(embedding))
+	for i, val := range embedding {
+		values[i] = fmt.Sprintf("%.6f", val)
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+var _ AcceptedResolutionsRepository = new(PostgresAcceptedResolutionsRepository)
@@ -0,0 +1,31 @@
+package accepted_resolutions
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Embedder embeds resolutions when they are stored and the query when they
+// are searched. Only resolutions stored under the same name are searched.
+type Embedder interface {
+	Name() string
+	Dimension() int
+	EmbedDocuments(ctx context.Context, texts []string) ([][]float64, error)
+	EmbedQuery(ctx context.Context, query string) ([]float64, error)
+}
+
+type AcceptedResolutionsRepository interface {
+	// InsertResolutions embeds the sides of each resolution and stores them.
+	InsertResolutions(ctx context.Context, resolutions []AcceptedResolution) error
+	// GetSimilarResolutions returns up to k resolutions of the repo whose
+	// sides are closest to query, nearest first. It returns none when the
+	// repo has learning from accepted resolutions turned off.
+	GetSimilarResolutions(ctx context.Context, repoId uuid.UUID, query string, k int) ([]SimilarResolution, error)
+}
+
+// EmbeddingText is the text a resolution is embedded as. Queries should be
+// built the same way from the hunk being resolved.
+func EmbeddingText(ours, theirs string) string {
+	return ours + "\n" + theirs
+}
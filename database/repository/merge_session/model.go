@@ -0,0 +1,34 @@
+package merge_session
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+	StatusAborted    Status = "aborted"
+	StatusFailed     Status = "failed"
+)
+
+type MergeSession struct {
+	Id           uuid.UUID  `db:"id" json:"id"`
+	UserId       uuid.UUID  `db:"user_id" json:"user_id"`
+	Owner        string     `db:"owner" json:"owner"`
+	Repo         string     `db:"repo" json:"repo"`
+	SourceBranch string     `db:"source_branch" json:"source_branch"`
+	TargetBranch string     `db:"target_branch" json:"target_branch"`
+	Worktree     string     `db:"worktree" json:"-"`
+	BaseCommit   *string    `db:"base_commit" json:"base_commit"`
+	SourceCommit *string    `db:"source_commit" json:"source_commit"`
+	MergeCommit  *string    `db:"merge_commit" json:"merge_commit"`
+	Status       Status     `db:"status" json:"status"`
+	Error        *string    `db:"error" json:"error"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+	FinishedAt   *time.Time `db:"finished_at" json:"finished_at"`
+}
@@ -0,0 +1,164 @@
+package merge_session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresMergeSessionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresMergeSessionRepository(db *pgxpool.Pool) *PostgresMergeSessionRepository {
+	return &PostgresMergeSessionRepository{
+		db: db,
+	}
+}
+
+func (repo *PostgresMergeSessionRepository) CreateMergeSession(ctx context.Context, session *MergeSession) (*MergeSession, error) {
+	query := `
+		INSERT INTO merge_sessions
+			(user_id, owner, repo, source_branch, target_branch, worktree, status)
+		VALUES
+			(@userId, @owner, @repo, @sourceBranch, @targetBranch, @worktree, @status)
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"userId":       session.UserId,
+		"owner":        session.Owner,
+		"repo":         session.Repo,
+		"sourceBranch": session.SourceBranch,
+		"targetBranch": session.TargetBranch,
+		"worktree":     session.Worktree,
+		"status":       session.Status,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merge session: %w", err)
+	}
+
+	created, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[MergeSession])
+	if isUniqueViolation(err) {
+		return nil, ErrSessionInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merge session: %w", err)
+	}
+
+	return &created, nil
+}
+
+func (repo *PostgresMergeSessionRepository) UpdateMergeSession(ctx context.Context, session *MergeSession) (*MergeSession, error) {
+	query := `
+		UPDATE merge_sessions SET
+			worktree = @worktree,
+			base_commit = @baseCommit,
+			source_commit = @sourceCommit,
+			merge_commit = @mergeCommit,
+			status = @status,
+			error = @error,
+			updated_at = NOW(),
+			finished_at = @finishedAt
+		WHERE
+			id = @id
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"id":           session.Id,
+		"worktree":     session.Worktree,
+		"baseCommit":   session.BaseCommit,
+		"sourceCommit": session.SourceCommit,
+		"mergeCommit":  session.MergeCommit,
+		"status":       session.Status,
+		"error":        session.Error,
+		"finishedAt":   session.FinishedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update merge session: %w", err)
+	}
+
+	updated, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[MergeSession])
+	if isUniqueViolation(err) {
+		return nil, ErrSessionInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update merge session: %w", err)
+	}
+
+	return &updated, nil
+}
+
+func (repo *PostgresMergeSessionRepository) GetMergeSession(ctx context.Context, id uuid.UUID) (*MergeSession, error) {
+	query := `
+		SELECT
+			*
+		FROM
+			merge_sessions
+		WHERE
+			id = @id
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"id": id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge session: %w", err)
+	}
+
+	session, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[MergeSession])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (repo *PostgresMergeSessionRepository) GetMergeSessions(ctx context.Context, userId uuid.UUID, owner, repoName string) ([]MergeSession, error) {
+	query := `
+		SELECT
+			*
+		FROM
+			merge_sessions
+		WHERE
+			user_id = @userId AND owner = @owner AND repo = @repo
+		ORDER BY
+			created_at DESC
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"userId": userId,
+		"owner":  owner,
+		"repo":   repoName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge sessions: %w", err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByName[MergeSession])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// isUniqueViolation reports whether err is uq_merge_sessions_active
+// refusing a second session in progress.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+var _ MergeSessionRepository = new(PostgresMergeSessionRepository)
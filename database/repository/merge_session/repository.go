@@ -0,0 +1,22 @@
+package merge_session
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrSessionInProgress is returned when creating a session, or putting one
+// back in progress, while another session of the same branches is in
+// progress.
+var ErrSessionInProgress = errors.New("a merge session of these branches is already in progress for this repo")
+
+type MergeSessionRepository interface {
+	CreateMergeSession(ctx context.Context, session *MergeSession) (*MergeSession, error)
+	UpdateMergeSession(ctx context.Context, session *MergeSession) (*MergeSession, error)
+	// GetMergeSession returns nil if there is no such session.
+	GetMergeSession(ctx context.Context, id uuid.UUID) (*MergeSession, error)
+	// GetMergeSessions lists the user's sessions of a repo, newest first.
+	GetMergeSessions(ctx context.Context, userId uuid.UUID, owner, repo string) ([]MergeSession, error)
+}
@@ -1,8 +1,11 @@
 package repo_chunks
 
+import "github.com/google/uuid"
+
 type RepoChunk struct {
-	RepoHash        string    `db:"repo_hash" json:"repo_hash"`
+	RepoId          uuid.UUID `db:"repo_id" json:"repo_id"`
 	Source          string    `db:"source" json:"source"`
+	BlobSha         string    `db:"blob_sha" json:"blob_sha"`
 	Chunk           string    `db:"chunk" json:"chunk"`
 	Embedding       []float64 `db:"embedding" json:"embedding"`
 	FileType        string    `db:"file_type" json:"file_type"`
@@ -10,6 +13,9 @@ type RepoChunk struct {
 	LineStart       int       `db:"line_start" json:"line_start"`
 	LineEnd         int       `db:"line_end" json:"line_end"`
 	ChunkType       string    `db:"chunk_type" json:"chunk_type"`
+	Symbol          string    `db:"symbol" json:"symbol"`
+	Embedder        string    `db:"embedder" json:"embedder"`
+	EmbeddingDim    int       `db:"embedding_dim" json:"embedding_dim"`
 }
 
 type SimilarChunk struct {
@@ -21,4 +27,14 @@ type SimilarChunk struct {
 	LineStart       int     `db:"line_start" json:"line_start"`
 	LineEnd         int     `db:"line_end" json:"line_end"`
 	ChunkType       string  `db:"chunk_type" json:"chunk_type"`
+	Symbol          string  `db:"symbol" json:"symbol"`
+	// Score is set by lexical and hybrid retrieval; higher is more relevant.
+	Score float64 `db:"score" json:"score"`
+}
+
+// FileBlob is the git blob a file's chunks were built from.
+type FileBlob struct {
+	Source   string `db:"source" json:"source"`
+	BlobSha  string `db:"blob_sha" json:"blob_sha"`
+	Embedder string `db:"embedder" json:"embedder"`
 }
@@ -3,49 +3,67 @@ package repo_chunks
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"unicode"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type PostgresRepoChunksRepository struct {
-	db *pgxpool.Pool
+	db       *pgxpool.Pool
+	embedder Embedder
 }
 
-func NewPostgresRepoChunksRepository(db *pgxpool.Pool) *PostgresRepoChunksRepository {
+func NewPostgresRepoChunksRepository(db *pgxpool.Pool, embedder Embedder) *PostgresRepoChunksRepository {
 	return &PostgresRepoChunksRepository{
-		db: db,
+		db:       db,
+		embedder: embedder,
 	}
 }
 
-func (repo *PostgresRepoChunksRepository) InsertChunk(ctx context.Context, chunk *RepoChunk) error {
-	embeddingValues := make([]string, len(chunk.Embedding))
-	for i, val := range chunk.Embedding {
-		embeddingValues[i] = fmt.Sprintf("%.6f", val)
+func (repo *PostgresRepoChunksRepository) ReplaceFileChunks(ctx context.Context, repoId uuid.UUID, source string, chunks []RepoChunk) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to replace file chunks: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM repo_chunks WHERE repo_id = $1 AND source = $2", repoId, source); err != nil {
+		return fmt.Errorf("failed to delete file chunks: %w", err)
 	}
-	embeddingStr := "[" + strings.Join(embeddingValues, ",") + "]"
 
 	query := `
-		INSERT INTO repo_chunks (repo_hash, source, chunk, embedding, file_type, conflict_section, line_start, line_end, chunk_type)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO repo_chunks (repo_id, source, blob_sha, chunk, embedding, file_type, conflict_section, line_start, line_end, chunk_type, symbol, embedder, embedding_dim)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
+	for i, chunk := range chunks {
+		_, err := tx.Exec(ctx, query,
+			repoId, source, chunk.BlobSha, chunk.Chunk, vectorLiteral(chunk.Embedding),
+			chunk.FileType, chunk.ConflictSection, chunk.LineStart, chunk.LineEnd, chunk.ChunkType, chunk.Symbol,
+			chunk.Embedder, len(chunk.Embedding))
+		if err != nil {
+			return fmt.Errorf("failed to insert chunk %d of %s: %w", i, source, err)
+		}
+	}
 
-	_, err := repo.db.Exec(ctx, query,
-		chunk.RepoHash, chunk.Source, chunk.Chunk, embeddingStr,
-		chunk.FileType, chunk.ConflictSection, chunk.LineStart, chunk.LineEnd, chunk.ChunkType)
-
-	return err
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to replace file chunks: %w", err)
+	}
+	return nil
 }
 
-func (repo *PostgresRepoChunksRepository) GetConflictChunks(ctx context.Context, repoHash string) ([]SimilarChunk, error) {
+func (repo *PostgresRepoChunksRepository) GetConflictChunks(ctx context.Context, repoId uuid.UUID) ([]SimilarChunk, error) {
 	query := `
-		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
+		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type, symbol
 		FROM repo_chunks
-		WHERE repo_hash = $1 AND file_type = 'conflict'
+		WHERE repo_id = $1 AND file_type = 'conflict'
 		ORDER BY line_start
 	`
 
-	rows, err := repo.db.Query(ctx, query, repoHash)
+	rows, err := repo.db.Query(ctx, query, repoId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query conflict chunks: %w", err)
 	}
@@ -54,7 +72,7 @@ func (repo *PostgresRepoChunksRepository) GetConflictChunks(ctx context.Context,
 	var chunks []SimilarChunk
 	for rows.Next() {
 		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
+		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType, &chunk.Symbol)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan conflict chunk row: %w", err)
 		}
@@ -64,14 +82,14 @@ func (repo *PostgresRepoChunksRepository) GetConflictChunks(ctx context.Context,
 	return chunks, nil
 }
 
-func (repo *PostgresRepoChunksRepository) GetContextChunks(ctx context.Context, repoHash, source string, lineStart, lineEnd, contextLines int) ([]SimilarChunk, error) {
+func (repo *PostgresRepoChunksRepository) GetContextChunks(ctx context.Context, repoId uuid.UUID, source string, lineStart, lineEnd, contextLines int) ([]SimilarChunk, error) {
 	startBound := lineStart - contextLines
 	endBound := lineEnd + contextLines
 
 	query := `
-		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type
+		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type, symbol
 		FROM repo_chunks
-		WHERE repo_hash = $1 AND source = $2 
+		WHERE repo_id = $1 AND source = $2 
 		AND (
 			(line_start >= $3 AND line_start <= $4) OR
 			file_type = 'context'
@@ -79,7 +97,7 @@ func (repo *PostgresRepoChunksRepository) GetContextChunks(ctx context.Context,
 		ORDER BY line_start
 	`
 
-	rows, err := repo.db.Query(ctx, query, repoHash, source, startBound, endBound)
+	rows, err := repo.db.Query(ctx, query, repoId, source, startBound, endBound)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query context chunks: %w", err)
 	}
@@ -88,7 +106,7 @@ func (repo *PostgresRepoChunksRepository) GetContextChunks(ctx context.Context,
 	var chunks []SimilarChunk
 	for rows.Next() {
 		var chunk SimilarChunk
-		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType)
+		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType, &chunk.Symbol)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan context chunk row: %w", err)
 		}
@@ -98,37 +116,236 @@ func (repo *PostgresRepoChunksRepository) GetContextChunks(ctx context.Context,
 	return chunks, nil
 }
 
-func (repo *PostgresRepoChunksRepository) GetSimilarChunks(ctx context.Context, query, repoHash string, k int) ([]SimilarChunk, error) {
-	// This would need the embedding vector for similarity search
-	// For now, return empty - this should be implemented with proper embedding search
-	return []SimilarChunk{}, nil
+func (repo *PostgresRepoChunksRepository) GetSimilarChunks(ctx context.Context, query string, repoId uuid.UUID, k int) ([]SimilarChunk, error) {
+	embedding, err := repo.embedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `
+		SELECT source, chunk, embedding <-> $1 AS distance, file_type, conflict_section, line_start, line_end, chunk_type, symbol
+		FROM repo_chunks
+		WHERE repo_id = $2 AND embedder = $4
+		ORDER BY embedding <-> $1
+		LIMIT $3
+	`
+
+	return repo.querySimilar(ctx, sql, repoId, embedding, repoId, k, repo.embedder.Name())
+}
+
+func (repo *PostgresRepoChunksRepository) GetSimilarChunksWithThreshold(ctx context.Context, query string, repoId uuid.UUID, k int, maxDistance float64) ([]SimilarChunk, error) {
+	embedding, err := repo.embedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `
+		SELECT source, chunk, embedding <-> $1 AS distance, file_type, conflict_section, line_start, line_end, chunk_type, symbol
+		FROM repo_chunks
+		WHERE repo_id = $2 AND embedder = $5 AND embedding <-> $1 < $4
+		ORDER BY embedding <-> $1
+		LIMIT $3
+	`
+
+	return repo.querySimilar(ctx, sql, repoId, embedding, repoId, k, maxDistance, repo.embedder.Name())
+}
+
+func (repo *PostgresRepoChunksRepository) GetSimilarFunctions(ctx context.Context, query string, repoId uuid.UUID, k int) ([]SimilarChunk, error) {
+	embedding, err := repo.embedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `
+		SELECT source, chunk, embedding <-> $1 AS distance, file_type, conflict_section, line_start, line_end, chunk_type, symbol
+		FROM repo_chunks
+		WHERE repo_id = $2 AND embedder = $4 AND chunk_type = 'function'
+		ORDER BY embedding <-> $1
+		LIMIT $3
+	`
+
+	return repo.querySimilar(ctx, sql, repoId, embedding, repoId, k, repo.embedder.Name())
+}
+
+func (repo *PostgresRepoChunksRepository) GetLexicalChunks(ctx context.Context, repoId uuid.UUID, terms []string, k int) ([]SimilarChunk, error) {
+	terms = lexicalTerms(terms)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// full-text rank over the lexemes column, plus the best trigram
+	// similarity between the chunk's symbol and any term so a renamed or
+	// near-identical declaration still ranks
+	sql := `
+		SELECT source, chunk, 0.0 AS distance, file_type, conflict_section, line_start, line_end, chunk_type, symbol,
+			ts_rank_cd(lexemes, q) + COALESCE((SELECT MAX(similarity(symbol, t)) FROM unnest($3::text[]) AS t), 0) AS score
+		FROM repo_chunks, to_tsquery('simple', $2) AS q
+		WHERE repo_id = $1 AND (lexemes @@ q OR symbol % ANY($3::text[]))
+		ORDER BY score DESC
+		LIMIT $4
+	`
+
+	rows, err := repo.db.Query(ctx, sql, repoId, tsQuery(terms), terms, k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lexical chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []SimilarChunk
+	for rows.Next() {
+		var chunk SimilarChunk
+		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType, &chunk.Symbol, &chunk.Score)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lexical chunk row: %w", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return chunks, nil
+}
+
+// lexicalTerms keeps the identifier characters of each term, lowercased and
+// deduplicated, so they are safe to splice into a tsquery.
+func lexicalTerms(terms []string) []string {
+	var out []string
+	for _, term := range terms {
+		term = strings.ToLower(strings.Map(func(r rune) rune {
+			if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, term))
+		if term != "" && !slices.Contains(out, term) {
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// tsQuery ORs the terms together: a chunk matches if it mentions any of them.
+func tsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = "'" + term + "'"
+	}
+	return strings.Join(quoted, " | ")
+}
+
+func (repo *PostgresRepoChunksRepository) embedQuery(ctx context.Context, query string) (string, error) {
+	if repo.embedder == nil {
+		return "", fmt.Errorf("no embedder configured for similarity search")
+	}
+
+	embedding, err := repo.embedder.EmbedQuery(ctx, query)
+	if err != nil {
+		return "", fmt.Errorf("failed to get query embedding: %w", err)
+	}
+	if len(embedding) != repo.embedder.Dimension() {
+		return "", fmt.Errorf("%s returned a %d-dimensional query embedding, expected %d", repo.embedder.Name(), len(embedding), repo.embedder.Dimension())
+	}
+
+	return vectorLiteral(embedding), nil
 }
 
-func (repo *PostgresRepoChunksRepository) GetSimilarChunksWithThreshold(ctx context.Context, query, repoHash string, k int, maxDistance float64) ([]SimilarChunk, error) {
-	// This would need the embedding vector for similarity search
-	// For now, return empty - this should be implemented with proper embedding search
-	return []SimilarChunk{}, nil
+// querySimilar runs a similarity query. An empty result for a repo that only
+// has chunks from other embedders is reported as ErrEmbedderMismatch rather
+// than silently returning no context.
+func (repo *PostgresRepoChunksRepository) querySimilar(ctx context.Context, sql string, repoId uuid.UUID, args ...any) ([]SimilarChunk, error) {
+	rows, err := repo.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query similar chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []SimilarChunk
+	for rows.Next() {
+		var chunk SimilarChunk
+		err := rows.Scan(&chunk.Source, &chunk.Chunk, &chunk.Distance, &chunk.FileType, &chunk.ConflictSection, &chunk.LineStart, &chunk.LineEnd, &chunk.ChunkType, &chunk.Symbol)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan chunk row: %w", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	if len(chunks) == 0 {
+		embedders, err := repo.GetRepoEmbedders(ctx, repoId)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedders) > 0 && !slices.Contains(embedders, repo.embedder.Name()) {
+			return nil, fmt.Errorf("%w: repo %s has %v, configured %s", ErrEmbedderMismatch, repoId, embedders, repo.embedder.Name())
+		}
+	}
+
+	return chunks, nil
 }
 
-func (repo *PostgresRepoChunksRepository) GetSimilarFunctions(ctx context.Context, query, repoHash string, k int) ([]SimilarChunk, error) {
-	// This would need the embedding vector for similarity search
-	// For now, return empty - this should be implemented with proper embedding search
-	return []SimilarChunk{}, nil
+// vectorLiteral formats an embedding in pgvector's text input format.
+func vectorLiteral(embedding []float64) string {
+	values := make([]string, len(embedding))
+	for i, val := range embedding {
+		values[i] = fmt.Sprintf("%.6f", val)
+	}
+	return "[" + strings.Join(values, ",") + "]"
 }
 
-func (repo *PostgresRepoChunksRepository) GetRepoChunksCount(ctx context.Context, repoHash string) (int, error) {
+func (repo *PostgresRepoChunksRepository) GetRepoChunksCount(ctx context.Context, repoId uuid.UUID) (int, error) {
 	var count int
-	err := repo.db.QueryRow(ctx, "SELECT COUNT(*) FROM repo_chunks WHERE repo_hash = $1", repoHash).Scan(&count)
+	err := repo.db.QueryRow(ctx, "SELECT COUNT(*) FROM repo_chunks WHERE repo_id = $1", repoId).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count repo chunks: %w", err)
 	}
 	return count, nil
 }
 
-func (repo *PostgresRepoChunksRepository) DeleteRepoChunks(ctx context.Context, repoHash string) error {
-	_, err := repo.db.Exec(ctx, "DELETE FROM repo_chunks WHERE repo_hash = $1", repoHash)
+func (repo *PostgresRepoChunksRepository) GetRepoEmbedders(ctx context.Context, repoId uuid.UUID) ([]string, error) {
+	rows, err := repo.db.Query(ctx, "SELECT DISTINCT embedder FROM repo_chunks WHERE repo_id = $1 ORDER BY embedder", repoId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repo embedders: %w", err)
+	}
+
+	embedders, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repo embedders: %w", err)
+	}
+	return embedders, nil
+}
+
+func (repo *PostgresRepoChunksRepository) GetFileBlobs(ctx context.Context, repoId uuid.UUID) ([]FileBlob, error) {
+	rows, err := repo.db.Query(ctx, "SELECT DISTINCT source, blob_sha, embedder FROM repo_chunks WHERE repo_id = $1", repoId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file blobs: %w", err)
+	}
+
+	blobs, err := pgx.CollectRows(rows, pgx.RowToStructByName[FileBlob])
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file blobs: %w", err)
+	}
+	return blobs, nil
+}
+
+func (repo *PostgresRepoChunksRepository) DeleteFileChunks(ctx context.Context, repoId uuid.UUID, source string) error {
+	_, err := repo.db.Exec(ctx, "DELETE FROM repo_chunks WHERE repo_id = $1 AND source = $2", repoId, source)
+	if err != nil {
+		return fmt.Errorf("failed to delete file chunks: %w", err)
+	}
+	return nil
+}
+
+func (repo *PostgresRepoChunksRepository) DeleteRepoChunks(ctx context.Context, repoId uuid.UUID) error {
+	_, err := repo.db.Exec(ctx, "DELETE FROM repo_chunks WHERE repo_id = $1", repoId)
 	if err != nil {
 		return fmt.Errorf("failed to delete repo chunks: %w", err)
 	}
 	return nil
 }
+
+var _ RepoChunksRepository = new(PostgresRepoChunksRepository)
@@ -2,15 +2,39 @@ package repo_chunks
 
 import (
 	"context"
+	"errors"
+
+	"github.com/google/uuid"
 )
 
+// ErrEmbedderMismatch is returned by similarity searches when the repo was
+// only indexed by embedders other than the configured one.
+var ErrEmbedderMismatch = errors.New("repo was indexed with a different embedder")
+
+// Embedder turns a retrieval query into the vector space the chunks were
+// indexed in. Only chunks stored under the same name are searched.
+type Embedder interface {
+	Name() string
+	Dimension() int
+	EmbedQuery(ctx context.Context, query string) ([]float64, error)
+}
+
 type RepoChunksRepository interface {
-	InsertChunk(ctx context.Context, chunk *RepoChunk) error
-	GetConflictChunks(ctx context.Context, repoHash string) ([]SimilarChunk, error)
-	GetContextChunks(ctx context.Context, repoHash, source string, lineStart, lineEnd, contextLines int) ([]SimilarChunk, error)
-	GetSimilarChunks(ctx context.Context, query, repoHash string, k int) ([]SimilarChunk, error)
-	GetSimilarChunksWithThreshold(ctx context.Context, query, repoHash string, k int, maxDistance float64) ([]SimilarChunk, error)
-	GetSimilarFunctions(ctx context.Context, query, repoHash string, k int) ([]SimilarChunk, error)
-	GetRepoChunksCount(ctx context.Context, repoHash string) (int, error)
-	DeleteRepoChunks(ctx context.Context, repoHash string) error
+	// ReplaceFileChunks swaps a file's chunks for chunks in one transaction,
+	// so a file is never left half indexed under a new blob sha.
+	ReplaceFileChunks(ctx context.Context, repoId uuid.UUID, source string, chunks []RepoChunk) error
+	GetConflictChunks(ctx context.Context, repoId uuid.UUID) ([]SimilarChunk, error)
+	GetContextChunks(ctx context.Context, repoId uuid.UUID, source string, lineStart, lineEnd, contextLines int) ([]SimilarChunk, error)
+	GetSimilarChunks(ctx context.Context, query string, repoId uuid.UUID, k int) ([]SimilarChunk, error)
+	GetSimilarChunksWithThreshold(ctx context.Context, query string, repoId uuid.UUID, k int, maxDistance float64) ([]SimilarChunk, error)
+	GetSimilarFunctions(ctx context.Context, query string, repoId uuid.UUID, k int) ([]SimilarChunk, error)
+	// GetLexicalChunks ranks chunks by whole-identifier matches on terms,
+	// best first. Score holds the match rank; Distance is not set.
+	GetLexicalChunks(ctx context.Context, repoId uuid.UUID, terms []string, k int) ([]SimilarChunk, error)
+	GetRepoChunksCount(ctx context.Context, repoId uuid.UUID) (int, error)
+	GetRepoEmbedders(ctx context.Context, repoId uuid.UUID) ([]string, error)
+	// GetFileBlobs lists the blob each indexed file was chunked from.
+	GetFileBlobs(ctx context.Context, repoId uuid.UUID) ([]FileBlob, error)
+	DeleteFileChunks(ctx context.Context, repoId uuid.UUID, source string) error
+	DeleteRepoChunks(ctx context.Context, repoId uuid.UUID) error
 }
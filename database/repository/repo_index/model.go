@@ -0,0 +1,35 @@
+package repo_index
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+type RepoIndex struct {
+	Id         uuid.UUID  `db:"id" json:"id"`
+	UserId     uuid.UUID  `db:"user_id" json:"user_id"`
+	Owner      string     `db:"owner" json:"owner"`
+	Repo       string     `db:"repo" json:"repo"`
+	Status     Status     `db:"status" json:"status"`
+	CommitSha  *string    `db:"commit_sha" json:"commit_sha"`
+	Embedder   *string    `db:"embedder" json:"embedder"`
+	FileCount  int        `db:"file_count" json:"file_count"`
+	ChunkCount int        `db:"chunk_count" json:"chunk_count"`
+	Error      *string    `db:"error" json:"error"`
+	QueuedAt   time.Time  `db:"queued_at" json:"queued_at"`
+	StartedAt  *time.Time `db:"started_at" json:"started_at"`
+	FinishedAt *time.Time `db:"finished_at" json:"finished_at"`
+	// LearnFromAccepted records accepted resolutions of the repo and offers
+	// them to the model as examples.
+	LearnFromAccepted bool `db:"learn_from_accepted" json:"learn_from_accepted"`
+}
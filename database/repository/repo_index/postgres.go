@@ -0,0 +1,211 @@
+package repo_index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresRepoIndexRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepoIndexRepository(db *pgxpool.Pool) *PostgresRepoIndexRepository {
+	return &PostgresRepoIndexRepository{
+		db: db,
+	}
+}
+
+func (repo *PostgresRepoIndexRepository) MarkPending(ctx context.Context, userId uuid.UUID, owner, repoName string) (*RepoIndex, error) {
+	query := `
+		INSERT INTO repo_index
+			(user_id, owner, repo, status)
+		VALUES
+			(@userId, @owner, @repo, 'pending')
+		ON CONFLICT (user_id, owner, repo) DO UPDATE SET
+			status = 'pending',
+			error = NULL,
+			queued_at = NOW(),
+			started_at = NULL,
+			finished_at = NULL
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"userId": userId,
+		"owner":  owner,
+		"repo":   repoName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark repo index pending: %w", err)
+	}
+
+	index, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RepoIndex])
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark repo index pending: %w", err)
+	}
+
+	return &index, nil
+}
+
+func (repo *PostgresRepoIndexRepository) UpdateRepoIndex(ctx context.Context, index *RepoIndex) (*RepoIndex, error) {
+	query := `
+		UPDATE repo_index SET
+			status = @status,
+			commit_sha = @commitSha,
+			embedder = @embedder,
+			file_count = @fileCount,
+			chunk_count = @chunkCount,
+			error = @error,
+			started_at = @startedAt,
+			finished_at = @finishedAt
+		WHERE
+			id = @id
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"id":         index.Id,
+		"status":     index.Status,
+		"commitSha":  index.CommitSha,
+		"embedder":   index.Embedder,
+		"fileCount":  index.FileCount,
+		"chunkCount": index.ChunkCount,
+		"error":      index.Error,
+		"startedAt":  index.StartedAt,
+		"finishedAt": index.FinishedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update repo index: %w", err)
+	}
+
+	updated, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RepoIndex])
+	if err != nil {
+		return nil, fmt.Errorf("failed to update repo index: %w", err)
+	}
+
+	return &updated, nil
+}
+
+func (repo *PostgresRepoIndexRepository) FinishRepoIndex(ctx context.Context, index *RepoIndex) (*RepoIndex, error) {
+	query := `
+		UPDATE repo_index SET
+			status = @status,
+			commit_sha = @commitSha,
+			embedder = @embedder,
+			file_count = @fileCount,
+			chunk_count = @chunkCount,
+			error = @error,
+			finished_at = @finishedAt
+		WHERE
+			id = @id AND status = 'running' AND started_at = @startedAt
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"id":         index.Id,
+		"status":     index.Status,
+		"commitSha":  index.CommitSha,
+		"embedder":   index.Embedder,
+		"fileCount":  index.FileCount,
+		"chunkCount": index.ChunkCount,
+		"error":      index.Error,
+		"startedAt":  index.StartedAt,
+		"finishedAt": index.FinishedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish repo index: %w", err)
+	}
+
+	finished, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RepoIndex])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish repo index: %w", err)
+	}
+
+	return &finished, nil
+}
+
+func (repo *PostgresRepoIndexRepository) GetRepoIndex(ctx context.Context, userId uuid.UUID, owner, repoName string) (*RepoIndex, error) {
+	query := `
+		SELECT
+			*
+		FROM
+			repo_index
+		WHERE
+			user_id = @userId AND owner = @owner AND repo = @repo
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"userId": userId,
+		"owner":  owner,
+		"repo":   repoName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo index: %w", err)
+	}
+
+	index, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RepoIndex])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo index: %w", err)
+	}
+
+	return &index, nil
+}
+
+func (repo *PostgresRepoIndexRepository) GetRepoIndexById(ctx context.Context, id uuid.UUID) (*RepoIndex, error) {
+	rows, err := repo.db.Query(ctx, "SELECT * FROM repo_index WHERE id = $1", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo index: %w", err)
+	}
+
+	index, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RepoIndex])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo index: %w", err)
+	}
+
+	return &index, nil
+}
+
+func (repo *PostgresRepoIndexRepository) SetLearnFromAccepted(ctx context.Context, id uuid.UUID, enabled bool) (*RepoIndex, error) {
+	query := `
+		UPDATE repo_index SET
+			learn_from_accepted = @enabled
+		WHERE
+			id = @id
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"id":      id,
+		"enabled": enabled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update repo index settings: %w", err)
+	}
+
+	updated, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RepoIndex])
+	if err != nil {
+		return nil, fmt.Errorf("failed to update repo index settings: %w", err)
+	}
+
+	return &updated, nil
+}
+
+var _ RepoIndexRepository = new(PostgresRepoIndexRepository)
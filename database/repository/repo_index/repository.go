@@ -0,0 +1,24 @@
+package repo_index
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type RepoIndexRepository interface {
+	// MarkPending creates the row for the repo or resets an existing one to
+	// pending, keeping the results of the previous run until the next finishes.
+	MarkPending(ctx context.Context, userId uuid.UUID, owner, repo string) (*RepoIndex, error)
+	UpdateRepoIndex(ctx context.Context, index *RepoIndex) (*RepoIndex, error)
+	// FinishRepoIndex records the outcome of the run that set the row running
+	// at index.StartedAt. It returns nil, writing nothing, if the repo was
+	// queued or started again since, so a stale result never hides the run
+	// still to come.
+	FinishRepoIndex(ctx context.Context, index *RepoIndex) (*RepoIndex, error)
+	// GetRepoIndex returns nil if the repo has never been queued.
+	GetRepoIndex(ctx context.Context, userId uuid.UUID, owner, repo string) (*RepoIndex, error)
+	// GetRepoIndexById returns nil if there is no such row.
+	GetRepoIndexById(ctx context.Context, id uuid.UUID) (*RepoIndex, error)
+	SetLearnFromAccepted(ctx context.Context, id uuid.UUID, enabled bool) (*RepoIndex, error)
+}
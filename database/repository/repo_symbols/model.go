@@ -0,0 +1,51 @@
+package repo_symbols
+
+import "github.com/google/uuid"
+
+type Kind string
+
+const (
+	KindFunc   Kind = "func"
+	KindMethod Kind = "method"
+	KindType   Kind = "type"
+	KindField  Kind = "field"
+	KindVar    Kind = "var"
+	KindConst  Kind = "const"
+)
+
+// SymbolFile is the git blob a file's symbols were extracted from.
+type SymbolFile struct {
+	Source  string `db:"source" json:"source"`
+	BlobSha string `db:"blob_sha" json:"blob_sha"`
+}
+
+// Definition is a package-level declaration, method or struct field. Name is
+// qualified by its receiver or owning type ("Server.Start"); ShortName is the
+// bare identifier.
+type Definition struct {
+	Id        uuid.UUID `db:"id" json:"id"`
+	RepoId    uuid.UUID `db:"repo_id" json:"repo_id"`
+	Source    string    `db:"source" json:"source"`
+	Dir       string    `db:"dir" json:"dir"`
+	Package   string    `db:"package" json:"package"`
+	Name      string    `db:"name" json:"name"`
+	ShortName string    `db:"short_name" json:"short_name"`
+	Kind      Kind      `db:"kind" json:"kind"`
+	Signature string    `db:"signature" json:"signature"`
+	LineStart int       `db:"line_start" json:"line_start"`
+	LineEnd   int       `db:"line_end" json:"line_end"`
+}
+
+// Reference is a use of a Definition, identified by the definition's package
+// directory and qualified name.
+type Reference struct {
+	Id      uuid.UUID `db:"id" json:"id"`
+	RepoId  uuid.UUID `db:"repo_id" json:"repo_id"`
+	Source  string    `db:"source" json:"source"`
+	Line    int       `db:"line" json:"line"`
+	Caller  string    `db:"caller" json:"caller"`
+	Snippet string    `db:"snippet" json:"snippet"`
+	IsCall  bool      `db:"is_call" json:"is_call"`
+	DefDir  string    `db:"def_dir" json:"def_dir"`
+	DefName string    `db:"def_name" json:"def_name"`
+}
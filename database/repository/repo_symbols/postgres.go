@@ -0,0 +1,151 @@
+package repo_symbols
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresRepoSymbolsRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepoSymbolsRepository(db *pgxpool.Pool) *PostgresRepoSymbolsRepository {
+	return &PostgresRepoSymbolsRepository{
+		db: db,
+	}
+}
+
+func (repo *PostgresRepoSymbolsRepository) GetSymbolFiles(ctx context.Context, repoId uuid.UUID) ([]SymbolFile, error) {
+	rows, err := repo.db.Query(ctx, "SELECT source, blob_sha FROM repo_symbol_files WHERE repo_id = $1", repoId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query symbol files: %w", err)
+	}
+
+	files, err := pgx.CollectRows(rows, pgx.RowToStructByName[SymbolFile])
+	if err != nil {
+		return nil, fmt.Errorf("failed to query symbol files: %w", err)
+	}
+	return files, nil
+}
+
+func (repo *PostgresRepoSymbolsRepository) ReplaceRepoSymbols(ctx context.Context, repoId uuid.UUID, files []SymbolFile, defs []Definition, refs []Reference) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to replace repo symbols: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"repo_symbol_files", "repo_symbols", "repo_symbol_refs"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE repo_id = $1", repoId); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"repo_symbol_files"},
+		[]string{"repo_id", "source", "blob_sha"},
+		pgx.CopyFromSlice(len(files), func(i int) ([]any, error) {
+			f := files[i]
+			return []any{repoId, f.Source, f.BlobSha}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert symbol files: %w", err)
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"repo_symbols"},
+		[]string{"repo_id", "source", "dir", "package", "name", "short_name", "kind", "signature", "line_start", "line_end"},
+		pgx.CopyFromSlice(len(defs), func(i int) ([]any, error) {
+			d := defs[i]
+			return []any{repoId, d.Source, d.Dir, d.Package, d.Name, d.ShortName, string(d.Kind), d.Signature, d.LineStart, d.LineEnd}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert symbols: %w", err)
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"repo_symbol_refs"},
+		[]string{"repo_id", "source", "line", "caller", "snippet", "is_call", "def_dir", "def_name"},
+		pgx.CopyFromSlice(len(refs), func(i int) ([]any, error) {
+			r := refs[i]
+			return []any{repoId, r.Source, r.Line, r.Caller, r.Snippet, r.IsCall, r.DefDir, r.DefName}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert symbol references: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to replace repo symbols: %w", err)
+	}
+	return nil
+}
+
+func (repo *PostgresRepoSymbolsRepository) GetDefinitions(ctx context.Context, repoId uuid.UUID, names []string, limit int) ([]Definition, error) {
+	query := `
+		SELECT
+			*
+		FROM
+			repo_symbols
+		WHERE
+			repo_id = @repoId AND (name = ANY(@names) OR short_name = ANY(@names))
+		ORDER BY
+			kind = 'field', source, line_start
+		LIMIT
+			@limit
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"repoId": repoId,
+		"names":  names,
+		"limit":  limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get definitions: %w", err)
+	}
+
+	defs, err := pgx.CollectRows(rows, pgx.RowToStructByName[Definition])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get definitions: %w", err)
+	}
+	return defs, nil
+}
+
+func (repo *PostgresRepoSymbolsRepository) GetReferences(ctx context.Context, repoId uuid.UUID, dir string, names []string, limit int) ([]Reference, error) {
+	query := `
+		SELECT
+			*
+		FROM
+			repo_symbol_refs
+		WHERE
+			repo_id = @repoId AND def_dir = @dir AND def_name = ANY(@names)
+		ORDER BY
+			is_call DESC, source, line
+		LIMIT
+			@limit
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"repoId": repoId,
+		"dir":    dir,
+		"names":  names,
+		"limit":  limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get references: %w", err)
+	}
+
+	refs, err := pgx.CollectRows(rows, pgx.RowToStructByName[Reference])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get references: %w", err)
+	}
+	return refs, nil
+}
+
+var _ RepoSymbolsRepository = new(PostgresRepoSymbolsRepository)
@@ -0,0 +1,20 @@
+package repo_symbols
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type RepoSymbolsRepository interface {
+	// GetSymbolFiles lists the blob each Go file's symbols were built from.
+	GetSymbolFiles(ctx context.Context, repoId uuid.UUID) ([]SymbolFile, error)
+	// ReplaceRepoSymbols swaps the repo's symbol tables for the given rows
+	// atomically.
+	ReplaceRepoSymbols(ctx context.Context, repoId uuid.UUID, files []SymbolFile, defs []Definition, refs []Reference) error
+	// GetDefinitions finds definitions whose qualified or bare name is in names.
+	GetDefinitions(ctx context.Context, repoId uuid.UUID, names []string, limit int) ([]Definition, error)
+	// GetReferences finds uses of the named definitions declared in dir,
+	// call sites first.
+	GetReferences(ctx context.Context, repoId uuid.UUID, dir string, names []string, limit int) ([]Reference, error)
+}
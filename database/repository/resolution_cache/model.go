@@ -0,0 +1,27 @@
+package resolution_cache
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Resolution is a cached model resolution of one conflict hunk, shared by
+// everyone resolving conflicts in Owner/Repo. The structured fields are empty
+// for plain-text resolutions.
+type Resolution struct {
+	Id            uuid.UUID  `db:"id" json:"id"`
+	Owner         string     `db:"owner" json:"owner"`
+	Repo          string     `db:"repo" json:"repo"`
+	Fingerprint   string     `db:"fingerprint" json:"fingerprint"`
+	PromptVersion string     `db:"prompt_version" json:"prompt_version"`
+	Model         string     `db:"model" json:"model"`
+	Resolved      string     `db:"resolved" json:"resolved"`
+	ModelStrategy string     `db:"model_strategy" json:"model_strategy"`
+	Rationale     string     `db:"rationale" json:"rationale"`
+	Confidence    *float64   `db:"confidence" json:"confidence"`
+	Risks         []string   `db:"risks" json:"risks"`
+	Hits          int        `db:"hits" json:"hits"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	LastHitAt     *time.Time `db:"last_hit_at" json:"last_hit_at"`
+}
@@ -0,0 +1,121 @@
+package resolution_cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresResolutionCacheRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresResolutionCacheRepository(db *pgxpool.Pool) *PostgresResolutionCacheRepository {
+	return &PostgresResolutionCacheRepository{
+		db: db,
+	}
+}
+
+func (repo *PostgresResolutionCacheRepository) GetResolutions(ctx context.Context, owner, repoName string, fingerprints []string) ([]Resolution, error) {
+	query := `
+		UPDATE
+			resolution_cache
+		SET
+			hits = hits + 1,
+			last_hit_at = NOW()
+		WHERE
+			owner = @owner AND repo = @repo AND fingerprint = ANY(@fingerprints)
+		RETURNING
+			*
+	`
+
+	rows, err := repo.db.Query(ctx, query, pgx.NamedArgs{
+		"owner":        owner,
+		"repo":         repoName,
+		"fingerprints": fingerprints,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached resolutions: %w", err)
+	}
+
+	resolutions, err := pgx.CollectRows(rows, pgx.RowToStructByName[Resolution])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached resolutions: %w", err)
+	}
+	return resolutions, nil
+}
+
+func (repo *PostgresResolutionCacheRepository) PutResolution(ctx context.Context, resolution *Resolution) error {
+	query := `
+		INSERT INTO resolution_cache
+			(owner, repo, fingerprint, prompt_version, model, resolved, model_strategy, rationale, confidence, risks)
+		VALUES
+			(@owner, @repo, @fingerprint, @promptVersion, @model, @resolved, @modelStrategy, @rationale, @confidence, @risks)
+		ON CONFLICT (owner, repo, fingerprint) DO UPDATE SET
+			prompt_version = EXCLUDED.prompt_version,
+			model = EXCLUDED.model,
+			resolved = EXCLUDED.resolved,
+			model_strategy = EXCLUDED.model_strategy,
+			rationale = EXCLUDED.rationale,
+			confidence = EXCLUDED.confidence,
+			risks = EXCLUDED.risks,
+			hits = 0,
+			created_at = NOW(),
+			last_hit_at = NULL
+		RETURNING
+			id, hits, created_at
+	`
+
+	risks := resolution.Risks
+	if risks == nil {
+		risks = []string{}
+	}
+	err := repo.db.QueryRow(ctx, query, pgx.NamedArgs{
+		"owner":         resolution.Owner,
+		"repo":          resolution.Repo,
+		"fingerprint":   resolution.Fingerprint,
+		"promptVersion": resolution.PromptVersion,
+		"model":         resolution.Model,
+		"resolved":      resolution.Resolved,
+		"modelStrategy": resolution.ModelStrategy,
+		"rationale":     resolution.Rationale,
+		"confidence":    resolution.Confidence,
+		"risks":         risks,
+	}).Scan(&resolution.Id, &resolution.Hits, &resolution.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to cache resolution: %w", err)
+	}
+	return nil
+}
+
+func (repo *PostgresResolutionCacheRepository) DeleteRepoResolutions(ctx context.Context, owner, repoName string) (int64, error) {
+	tag, err := repo.db.Exec(ctx, "DELETE FROM resolution_cache WHERE owner = $1 AND repo = $2", owner, repoName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge resolution cache: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (repo *PostgresResolutionCacheRepository) EvictResolutions(ctx context.Context, owner, repoName string, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM
+			resolution_cache
+		WHERE
+			owner = @owner AND repo = @repo AND COALESCE(last_hit_at, created_at) < @before
+	`
+
+	tag, err := repo.db.Exec(ctx, query, pgx.NamedArgs{
+		"owner":  owner,
+		"repo":   repoName,
+		"before": before,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to evict cached resolutions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+var _ ResolutionCacheRepository = new(PostgresResolutionCacheRepository)
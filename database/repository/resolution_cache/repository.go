@@ -0,0 +1,21 @@
+package resolution_cache
+
+import (
+	"context"
+	"time"
+)
+
+type ResolutionCacheRepository interface {
+	// GetResolutions returns the cached resolutions among fingerprints and
+	// counts a hit on each.
+	GetResolutions(ctx context.Context, owner, repo string, fingerprints []string) ([]Resolution, error)
+	// PutResolution stores a resolution, replacing any under the same
+	// fingerprint.
+	PutResolution(ctx context.Context, resolution *Resolution) error
+	// DeleteRepoResolutions purges the repo's cache and reports how many
+	// entries were removed.
+	DeleteRepoResolutions(ctx context.Context, owner, repo string) (int64, error)
+	// EvictResolutions removes the repo's entries not hit, nor stored, since
+	// before and reports how many there were.
+	EvictResolutions(ctx context.Context, owner, repo string, before time.Time) (int64, error)
+}
@@ -0,0 +1,142 @@
+// Package gitexec runs git without a shell: arguments are passed as an argv
+// slice, so paths and URLs never need quoting, and everything it logs has
+// credentials redacted.
+package gitexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a command that sets no timeout of its own.
+	DefaultTimeout = 2 * time.Minute
+
+	// output beyond this is cut from the log, not from the Result
+	maxLoggedOutput = 2048
+)
+
+// Cmd is one git invocation.
+type Cmd struct {
+	// Dir is the working directory, normally the repository root.
+	Dir  string
+	Args []string
+	// Env holds KEY=VALUE pairs set on top of the process environment.
+	Env []string
+	// Timeout overrides DefaultTimeout; the context may end it sooner.
+	Timeout time.Duration
+}
+
+// Result describes a finished command. Args and Stderr have credentials
+// redacted; Stdout is left as git wrote it so callers can parse it.
+type Result struct {
+	Args     []string      `json:"args"`
+	Dir      string        `json:"dir"`
+	ExitCode int           `json:"exit_code"` // -1 if git did not run to completion
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Error is returned for a command that failed to start, timed out or exited
+// non-zero. Result is always set.
+type Error struct {
+	Result *Result
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("git %s: %v", strings.Join(e.Result.Args, " "), e.Err)
+	if line, _, _ := strings.Cut(strings.TrimSpace(e.Result.Stderr), "\n"); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode is the exit code of the command behind err, 0 if err is nil and
+// -1 if it did not come from Run.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var gitErr *Error
+	if errors.As(err, &gitErr) {
+		return gitErr.Result.ExitCode
+	}
+	return -1
+}
+
+// Run runs git with args in dir.
+func Run(ctx context.Context, dir string, args ...string) (*Result, error) {
+	return Cmd{Dir: dir, Args: args}.Run(ctx)
+}
+
+// Run runs the command and waits for it. The returned Result is never nil,
+// so output is available even when an error is returned.
+func (c Cmd) Run(ctx context.Context) (*Result, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// never wait on a credential prompt nobody can answer
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, c.Env...)
+
+	started := time.Now()
+	err := cmd.Run()
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = Redact(arg)
+	}
+	result := &Result{
+		Args:     args,
+		Dir:      c.Dir,
+		ExitCode: -1,
+		Stdout:   stdout.String(),
+		Stderr:   Redact(stderr.String()),
+		Duration: time.Since(started),
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		log.Printf("git %s (in %s): %v after %s\nstdout: %s\nstderr: %s",
+			strings.Join(args, " "), c.Dir, err, result.Duration.Round(time.Millisecond),
+			clip(Redact(result.Stdout)), clip(result.Stderr))
+		return result, &Error{Result: result, Err: err}
+	}
+	log.Printf("git %s (in %s): ok after %s", strings.Join(args, " "), c.Dir, result.Duration.Round(time.Millisecond))
+	return result, nil
+}
+
+func clip(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxLoggedOutput {
+		return s[:maxLoggedOutput] + "... (truncated)"
+	}
+	return s
+}
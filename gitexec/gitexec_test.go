@@ -0,0 +1,76 @@
+package gitexec
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRunTimesOut(t *testing.T) {
+	// a git daemon that accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+	defer func() {
+		ln.Close()
+		select {
+		case conn := <-conns:
+			conn.Close()
+		default:
+		}
+	}()
+
+	started := time.Now()
+	result, err := Cmd{
+		Dir:     t.TempDir(),
+		Args:    []string{"ls-remote", "git://" + ln.Addr().String() + "/repo.git"},
+		Timeout: 200 * time.Millisecond,
+	}.Run(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("git ran for %s after its timeout", elapsed)
+	}
+	if result == nil || result.ExitCode != -1 || ExitCode(err) != -1 {
+		t.Errorf("result of a killed command: %+v", result)
+	}
+}
+
+func TestRunEndsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := Run(ctx, t.TempDir(), "version")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the command to be cancelled, got %v", err)
+	}
+	if result == nil || result.ExitCode != -1 {
+		t.Errorf("result of a cancelled command: %+v", result)
+	}
+}
+
+func TestRunReportsExitCode(t *testing.T) {
+	result, err := Run(context.Background(), t.TempDir(), "rev-parse", "HEAD")
+	var gitErr *Error
+	if !errors.As(err, &gitErr) || gitErr.Result != result {
+		t.Fatalf("expected an *Error carrying the result, got %v", err)
+	}
+	if result.ExitCode <= 0 || result.Stderr == "" {
+		t.Errorf("result: %+v", result)
+	}
+
+	result, err = Run(context.Background(), t.TempDir(), "version")
+	if err != nil || result.ExitCode != 0 || ExitCode(err) != 0 {
+		t.Fatalf("git version: %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,22 @@
+package gitexec
+
+import "regexp"
+
+// credentialPatterns match secrets that end up in git arguments and output:
+// userinfo in remote URLs, GitHub tokens and authorization headers.
+var credentialPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://)[^/@\s]+@`), "${1}***@"},
+	{regexp.MustCompile(`\b(gh[pousr]_|github_pat_)[A-Za-z0-9_]+`), "${1}***"},
+	{regexp.MustCompile(`(?i)(authorization:\s*[a-z]+\s+)\S+`), "${1}***"},
+}
+
+// Redact masks credentials in s so it can be logged or returned to a client.
+func Redact(s string) string {
+	for _, p := range credentialPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
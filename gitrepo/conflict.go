@@ -0,0 +1,108 @@
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrNotConflicted is returned by ReadConflict for a path with no unmerged
+// index entries.
+var ErrNotConflicted = errors.New("path is not conflicted")
+
+// Side is one index stage of a conflicted path. Content is empty for binary
+// blobs. Commit is the commit the side comes from, when it is known.
+type Side struct {
+	Blob
+	Content string      `json:"content"`
+	Binary  bool        `json:"binary"`
+	Commit  *CommitInfo `json:"commit"`
+}
+
+// Conflict holds the three versions of a conflicted path as git recorded
+// them, whatever has since been done to the working-tree file. A side is nil
+// when the path does not exist on it.
+type Conflict struct {
+	Path   string `json:"path"`
+	Base   *Side  `json:"base"`
+	Ours   *Side  `json:"ours"`
+	Theirs *Side  `json:"theirs"`
+}
+
+// ReadConflict reads the stage 1, 2 and 3 blobs of path from the index and
+// attributes them to the commits of the operation in progress: ours is
+// always HEAD. In a merge theirs is MERGE_HEAD and base their merge base. A
+// rebase or cherry-pick applies a commit, which is theirs, onto its parent,
+// the base. A revert applies a commit backwards, so the commit is the base
+// and its parent is theirs.
+func ReadConflict(ctx context.Context, repo GitRepo, path string) (*Conflict, error) {
+	entries, err := repo.Unmerged(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entry *UnmergedEntry
+	for i := range entries {
+		if entries[i].Path == path {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotConflicted)
+	}
+
+	ours, _ := repo.Commit(ctx, "HEAD")
+	var base, theirs *CommitInfo
+	if state, _ := repo.InProgress(ctx); state != nil && state.Head != "" {
+		switch state.Operation {
+		case OperationMerge:
+			theirs, _ = repo.Commit(ctx, state.Head)
+			if ours != nil && theirs != nil {
+				if hash, err := repo.MergeBase(ctx, ours.Hash, theirs.Hash); err == nil {
+					base, _ = repo.Commit(ctx, hash)
+				}
+			}
+		case OperationRebase, OperationCherryPick:
+			theirs, _ = repo.Commit(ctx, state.Head)
+			base, _ = repo.Commit(ctx, state.Head+"^")
+		case OperationRevert:
+			base, _ = repo.Commit(ctx, state.Head)
+			theirs, _ = repo.Commit(ctx, state.Head+"^")
+		}
+	}
+
+	out := &Conflict{Path: path}
+	for _, s := range []struct {
+		blob   *Blob
+		commit *CommitInfo
+		side   **Side
+	}{
+		{entry.Base, base, &out.Base},
+		{entry.Ours, ours, &out.Ours},
+		{entry.Theirs, theirs, &out.Theirs},
+	} {
+		if s.blob == nil {
+			continue
+		}
+		data, err := repo.ReadBlob(ctx, s.blob.Hash)
+		if err != nil {
+			return nil, err
+		}
+		side := &Side{Blob: *s.blob, Commit: s.commit}
+		if isBinary(data) {
+			side.Binary = true
+		} else {
+			side.Content = string(data)
+		}
+		*s.side = side
+	}
+	return out, nil
+}
+
+// isBinary uses git's heuristic: a NUL byte in the first 8000 bytes. Text
+// that is not UTF-8 cannot be returned as a JSON string either.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 || !utf8.Valid(data)
+}
@@ -0,0 +1,342 @@
+package gitrepo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/tahminator/go-react-template/gitexec"
+)
+
+// GoGitRepo is a GitRepo backed by go-git.
+type GoGitRepo struct {
+	path string
+	repo *gogit.Repository
+}
+
+// Open opens the repository whose working tree is at path. Linked worktrees
+// are supported.
+func Open(path string) (*GoGitRepo, error) {
+	repo, err := gogit.PlainOpenWithOptions(path, &gogit.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %s: %w", path, err)
+	}
+	return &GoGitRepo{path: path, repo: repo}, nil
+}
+
+func (r *GoGitRepo) Path() string {
+	return r.path
+}
+
+func (r *GoGitRepo) Status(ctx context.Context) ([]FileStatus, error) {
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worktree: %w", err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status: %w", err)
+	}
+
+	out := make([]FileStatus, 0, len(status))
+	for path, s := range status {
+		out = append(out, FileStatus{Path: path, Staging: string(s.Staging), Worktree: string(s.Worktree)})
+	}
+	slices.SortFunc(out, func(a, b FileStatus) int { return strings.Compare(a.Path, b.Path) })
+	return out, nil
+}
+
+func (r *GoGitRepo) Unmerged(ctx context.Context) ([]UnmergedEntry, error) {
+	idx, err := r.repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	var out []UnmergedEntry
+	for _, e := range idx.Entries {
+		// go-git's index.Merged constant is wrong; merged entries are stage 0
+		if e.Stage == 0 {
+			continue
+		}
+		if len(out) == 0 || out[len(out)-1].Path != e.Name {
+			out = append(out, UnmergedEntry{Path: e.Name})
+		}
+		blob := &Blob{Hash: e.Hash.String(), Mode: e.Mode.String()}
+		switch entry := &out[len(out)-1]; e.Stage {
+		case index.AncestorMode:
+			entry.Base = blob
+		case index.OurMode:
+			entry.Ours = blob
+		case index.TheirMode:
+			entry.Theirs = blob
+		}
+	}
+	return out, nil
+}
+
+func (r *GoGitRepo) ReadBlob(ctx context.Context, hash string) ([]byte, error) {
+	if !plumbing.IsHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash %q", hash)
+	}
+	blob, err := r.repo.BlobObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	rd, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	defer rd.Close()
+	return io.ReadAll(rd)
+}
+
+func (r *GoGitRepo) ResolveRef(ctx context.Context, rev string) (string, error) {
+	// go-git reads pseudo-refs as plain refs; FETCH_HEAD holds a line per
+	// fetched branch, which it cannot parse
+	if slices.Contains(pseudoRefs, rev) {
+		return r.readPseudoRef(rev)
+	}
+
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(rev))
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "", fmt.Errorf("%s: %w", rev, ErrNoRef)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+	return hash.String(), nil
+}
+
+// pseudoRefs are the refs git keeps as files in the git dir while an
+// operation is in progress.
+var pseudoRefs = []string{"FETCH_HEAD", "MERGE_HEAD", "REBASE_HEAD", "CHERRY_PICK_HEAD", "REVERT_HEAD"}
+
+// readPseudoRef returns the first hash in a file such as FETCH_HEAD in the
+// git dir.
+func (r *GoGitRepo) readPseudoRef(name string) (string, error) {
+	storage, ok := r.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", fmt.Errorf("%s: %w", name, ErrNoRef)
+	}
+	f, err := storage.Filesystem().Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s: %w", name, ErrNoRef)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return "", fmt.Errorf("%s: %w", name, ErrNoRef)
+	}
+	hash, _, _ := strings.Cut(sc.Text(), "\t")
+	hash = strings.TrimSpace(hash)
+	if !plumbing.IsHash(hash) {
+		return "", fmt.Errorf("%s holds no commit: %q", name, sc.Text())
+	}
+	return hash, nil
+}
+
+func (r *GoGitRepo) Commit(ctx context.Context, rev string) (*CommitInfo, error) {
+	commit, err := r.commit(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	message, _, _ := strings.Cut(commit.Message, "\n")
+	return &CommitInfo{
+		Hash:        commit.Hash.String(),
+		Author:      commit.Author.Name,
+		AuthorEmail: commit.Author.Email,
+		AuthoredAt:  commit.Author.When,
+		Message:     message,
+	}, nil
+}
+
+func (r *GoGitRepo) MergeBase(ctx context.Context, a, b string) (string, error) {
+	ca, err := r.commit(ctx, a)
+	if err != nil {
+		return "", err
+	}
+	cb, err := r.commit(ctx, b)
+	if err != nil {
+		return "", err
+	}
+	bases, err := ca.MergeBase(cb)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the merge base of %s and %s: %w", a, b, err)
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("%s and %s have no common ancestor: %w", a, b, ErrNoRef)
+	}
+	return bases[0].Hash.String(), nil
+}
+
+func (r *GoGitRepo) MidMerge(ctx context.Context) (bool, error) {
+	_, err := r.ResolveRef(ctx, "MERGE_HEAD")
+	if errors.Is(err, ErrNoRef) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// InProgress recognises operations by the state files git leaves in the git
+// dir, as `git status` does. A rebase is checked first since the commands it
+// runs for each step leave their own files behind.
+func (r *GoGitRepo) InProgress(ctx context.Context) (*OperationState, error) {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		branch, ok, err := r.readGitFile(dir + "/head-name")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		state := &OperationState{Operation: OperationRebase, Branch: strings.TrimPrefix(branch, "refs/heads/")}
+		state.Onto, _, _ = r.readGitFile(dir + "/onto")
+		// rebase-merge counts with msgnum and end, rebase-apply with next
+		// and last
+		for _, f := range []struct {
+			name string
+			n    *int
+		}{
+			{"msgnum", &state.Step}, {"next", &state.Step},
+			{"end", &state.Total}, {"last", &state.Total},
+		} {
+			if v, ok, _ := r.readGitFile(dir + "/" + f.name); ok {
+				fmt.Sscan(v, f.n)
+			}
+		}
+		if head, err := r.readPseudoRef("REBASE_HEAD"); err == nil {
+			state.Head = head
+		} else if head, ok, _ := r.readGitFile(dir + "/stopped-sha"); ok {
+			state.Head = head
+		}
+		return state, nil
+	}
+
+	for _, op := range []struct {
+		ref       string
+		operation Operation
+	}{
+		{"MERGE_HEAD", OperationMerge},
+		{"CHERRY_PICK_HEAD", OperationCherryPick},
+		{"REVERT_HEAD", OperationRevert},
+	} {
+		head, err := r.readPseudoRef(op.ref)
+		if errors.Is(err, ErrNoRef) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		state := &OperationState{Operation: op.operation, Head: head}
+		// the todo list of a sequence still holds the current commit
+		if todo, ok, _ := r.readGitFile("sequencer/todo"); ok {
+			for _, line := range strings.Split(todo, "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					state.Remaining++
+				}
+			}
+			state.Remaining = max(state.Remaining-1, 0)
+		}
+		return state, nil
+	}
+	return nil, nil
+}
+
+// readGitFile returns the trimmed content of a file in the git dir and
+// whether it exists.
+func (r *GoGitRepo) readGitFile(name string) (string, bool, error) {
+	storage, ok := r.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", false, nil
+	}
+	f, err := storage.Filesystem().Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+func (r *GoGitRepo) ChangedFiles(ctx context.Context, from, to string) ([]string, error) {
+	fromTree, err := r.tree(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := r.tree(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTreeWithOptions(ctx, fromTree, toTree, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s..%s: %w", from, to, err)
+	}
+
+	var paths []string
+	for _, c := range changes {
+		if c.To.Name != "" {
+			paths = append(paths, c.To.Name)
+		} else {
+			paths = append(paths, c.From.Name)
+		}
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths), nil
+}
+
+func (r *GoGitRepo) commit(ctx context.Context, rev string) (*object.Commit, error) {
+	hash, err := r.ResolveRef(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", rev, err)
+	}
+	return commit, nil
+}
+
+func (r *GoGitRepo) tree(ctx context.Context, rev string) (*object.Tree, error) {
+	commit, err := r.commit(ctx, rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree of %s: %w", rev, err)
+	}
+	return tree, nil
+}
+
+// Add runs `git add`: go-git's Worktree.Add updates one stage of an unmerged
+// path in place instead of replacing all three with the resolved file, which
+// corrupts the index mid-merge.
+func (r *GoGitRepo) Add(ctx context.Context, paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := gitexec.Run(ctx, r.path, append([]string{"add", "--"}, paths...)...)
+	return err
+}
+
+var _ GitRepo = new(GoGitRepo)
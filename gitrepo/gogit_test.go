@@ -0,0 +1,289 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestRepo creates a repository whose main branch has one commit with
+// the given files.
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	for _, k := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(k, "Delta Test")
+	}
+	for _, k := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(k, "delta@example.com")
+	}
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+
+	dir := t.TempDir()
+	git(t, dir, "init", "-q", "-b", "main")
+	commitFiles(t, dir, "initial", files)
+	return dir
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// gitStops runs a command that is expected to stop on conflicts.
+func gitStops(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("git %s should have stopped on conflicts:\n%s", strings.Join(args, " "), out)
+	}
+}
+
+// commitFiles writes files, deleting those with no content, and commits them.
+func commitFiles(t *testing.T, dir, message string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if content == "" {
+			git(t, dir, "rm", "-q", name)
+			continue
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git(t, dir, "add", name)
+	}
+	git(t, dir, "commit", "-q", "-m", message)
+}
+
+func openTestRepo(t *testing.T, dir string) *GoGitRepo {
+	t.Helper()
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestUnmergedStages(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"both.txt":    "base\n",
+		"deleted.txt": "base\n",
+		"same.txt":    "base\n",
+	})
+	git(t, dir, "checkout", "-q", "-b", "feature")
+	commitFiles(t, dir, "theirs", map[string]string{
+		"both.txt":    "theirs\n",
+		"deleted.txt": "",
+		"added.txt":   "theirs\n",
+	})
+	git(t, dir, "checkout", "-q", "main")
+	commitFiles(t, dir, "ours", map[string]string{
+		"both.txt":    "ours\n",
+		"deleted.txt": "ours\n",
+		"added.txt":   "ours\n",
+		"same.txt":    "ours\n",
+	})
+	gitStops(t, dir, "merge", "feature")
+
+	ctx := context.Background()
+	repo := openTestRepo(t, dir)
+	entries, err := repo.Unmerged(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// git ls-files -u prints "<mode> <hash> <stage>\t<path>" per stage
+	want := map[string]*UnmergedEntry{}
+	var order []string
+	for _, line := range strings.Split(git(t, dir, "ls-files", "-u"), "\n") {
+		meta, path, _ := strings.Cut(line, "\t")
+		f := strings.Fields(meta)
+		if want[path] == nil {
+			want[path] = &UnmergedEntry{Path: path}
+			order = append(order, path)
+		}
+		blob := &Blob{Hash: f[1], Mode: "0" + f[0]}
+		switch f[2] {
+		case "1":
+			want[path].Base = blob
+		case "2":
+			want[path].Ours = blob
+		case "3":
+			want[path].Theirs = blob
+		}
+	}
+	if len(order) != 3 {
+		t.Fatalf("expected three conflicted paths, git lists %v", order)
+	}
+
+	if len(entries) != len(order) {
+		t.Fatalf("got %d entries, want %v", len(entries), order)
+	}
+	for i, e := range entries {
+		w := want[order[i]]
+		if e.Path != w.Path || !sameBlob(e.Base, w.Base) || !sameBlob(e.Ours, w.Ours) || !sameBlob(e.Theirs, w.Theirs) {
+			t.Errorf("entry %d: got %s %v %v %v, want %s %v %v %v", i, e.Path, e.Base, e.Ours, e.Theirs, w.Path, w.Base, w.Ours, w.Theirs)
+		}
+	}
+
+	// both sides added added.txt, and feature deleted deleted.txt
+	for _, e := range entries {
+		switch e.Path {
+		case "added.txt":
+			if e.Base != nil {
+				t.Errorf("added.txt has a base: %v", e.Base)
+			}
+		case "deleted.txt":
+			if e.Theirs != nil || e.Base == nil || e.Ours == nil {
+				t.Errorf("deleted.txt: %+v", e)
+			}
+		}
+	}
+
+	ours, err := repo.ReadBlob(ctx, want["both.txt"].Ours.Hash)
+	if err != nil || string(ours) != "ours\n" {
+		t.Errorf("ReadBlob(ours) = %q, %v", ours, err)
+	}
+}
+
+func sameBlob(a, b *Blob) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestResolveFetchHead(t *testing.T) {
+	upstream := newTestRepo(t, map[string]string{"a.txt": "a\n"})
+	git(t, upstream, "checkout", "-q", "-b", "feature")
+	commitFiles(t, upstream, "feature", map[string]string{"a.txt": "feature\n"})
+
+	dir := newTestRepo(t, map[string]string{"b.txt": "b\n"})
+	git(t, dir, "fetch", "-q", upstream, "feature", "main")
+	fetchHead, err := os.ReadFile(filepath.Join(dir, ".git", "FETCH_HEAD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(fetchHead), "\n"); n != 2 {
+		t.Fatalf("expected FETCH_HEAD to list two branches:\n%s", fetchHead)
+	}
+
+	ctx := context.Background()
+	repo := openTestRepo(t, dir)
+	got, err := repo.ResolveRef(ctx, "FETCH_HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := git(t, upstream, "rev-parse", "feature"); got != want {
+		t.Errorf("FETCH_HEAD resolved to %s, want the first branch fetched, %s", got, want)
+	}
+	if commit, err := repo.Commit(ctx, "FETCH_HEAD"); err != nil || commit.Message != "feature" {
+		t.Errorf("Commit(FETCH_HEAD) = %+v, %v", commit, err)
+	}
+
+	if _, err := repo.ResolveRef(ctx, "MERGE_HEAD"); !errors.Is(err, ErrNoRef) {
+		t.Errorf("a missing MERGE_HEAD: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".git", "MERGE_HEAD"), []byte("not a hash\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ResolveRef(ctx, "MERGE_HEAD"); err == nil || errors.Is(err, ErrNoRef) {
+		t.Errorf("a corrupt MERGE_HEAD: %v", err)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"kept.txt":    "kept\n",
+		"edited.txt":  "before\n",
+		"removed.txt": "removed\n",
+	})
+	commitFiles(t, dir, "change", map[string]string{
+		"edited.txt":  "after\n",
+		"removed.txt": "",
+		"added.txt":   "added\n",
+	})
+	repo := openTestRepo(t, dir)
+
+	paths, err := repo.ChangedFiles(context.Background(), "HEAD~1", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"added.txt", "edited.txt", "removed.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("changed files %v, want %v", paths, want)
+	}
+
+	if paths, err := repo.ChangedFiles(context.Background(), "HEAD", "HEAD"); err != nil || len(paths) != 0 {
+		t.Errorf("HEAD..HEAD: %v, %v", paths, err)
+	}
+	if _, err := repo.ChangedFiles(context.Background(), "HEAD", "missing"); !errors.Is(err, ErrNoRef) {
+		t.Errorf("diff against a missing revision: %v", err)
+	}
+}
+
+func TestInProgress(t *testing.T) {
+	ctx := context.Background()
+	dir := newTestRepo(t, map[string]string{"a.txt": "base\n"})
+	repo := openTestRepo(t, dir)
+	if state, err := repo.InProgress(ctx); err != nil || state != nil {
+		t.Fatalf("a clean tree: %+v, %v", state, err)
+	}
+
+	git(t, dir, "checkout", "-q", "-b", "feature")
+	commitFiles(t, dir, "feature 1", map[string]string{"b.txt": "one\n"})
+	commitFiles(t, dir, "feature 2", map[string]string{"a.txt": "feature\n"})
+	commitFiles(t, dir, "feature 3", map[string]string{"c.txt": "three\n"})
+	git(t, dir, "checkout", "-q", "main")
+	commitFiles(t, dir, "main", map[string]string{"a.txt": "main\n"})
+	onto := git(t, dir, "rev-parse", "main")
+	picked := git(t, dir, "rev-parse", "feature~1")
+
+	gitStops(t, dir, "merge", "feature")
+	state, err := repo.InProgress(ctx)
+	if err != nil || state == nil || state.Operation != OperationMerge || state.Head != git(t, dir, "rev-parse", "feature") {
+		t.Errorf("merge: %+v, %v", state, err)
+	}
+	if mid, err := repo.MidMerge(ctx); err != nil || !mid {
+		t.Errorf("MidMerge() = %v, %v", mid, err)
+	}
+	git(t, dir, "merge", "--abort")
+
+	gitStops(t, dir, "cherry-pick", "feature~1", "feature")
+	state, err = repo.InProgress(ctx)
+	if err != nil || state == nil || state.Operation != OperationCherryPick || state.Head != picked || state.Remaining != 1 {
+		t.Errorf("cherry-pick: %+v, %v", state, err)
+	}
+	git(t, dir, "cherry-pick", "--abort")
+
+	// the apply backend keeps its state in rebase-apply, counting with next
+	// and last, the merge backend in rebase-merge with msgnum and end
+	for backend, stateDir := range map[string]string{"--apply": "rebase-apply", "--merge": "rebase-merge"} {
+		git(t, dir, "checkout", "-q", "feature")
+		gitStops(t, dir, "rebase", backend, "main")
+		if _, err := os.Stat(filepath.Join(dir, ".git", stateDir)); err != nil {
+			t.Fatalf("rebase %s: %v", backend, err)
+		}
+		state, err = repo.InProgress(ctx)
+		if err != nil || state == nil {
+			t.Fatalf("rebase %s: %+v, %v", backend, state, err)
+		}
+		want := OperationState{Operation: OperationRebase, Head: picked, Step: 2, Total: 3, Branch: "feature", Onto: onto}
+		if *state != want {
+			t.Errorf("rebase %s:\n got %+v\nwant %+v", backend, *state, want)
+		}
+		git(t, dir, "rebase", "--abort")
+	}
+}
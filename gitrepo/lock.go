@@ -0,0 +1,64 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// treeLock is the lock on one working tree. refs counts the requests holding
+// or waiting for it, so the entry is dropped once the last one is done and
+// the locks of removed worktrees do not pile up.
+type treeLock struct {
+	held chan struct{}
+	refs int
+}
+
+var (
+	locksMu sync.Mutex
+	// locks holds the lock of each working tree in use, keyed by absolute
+	// path.
+	locks = map[string]*treeLock{}
+)
+
+// Lock serialises the requests of this process that change the working tree
+// or index at path, so that one's `git add` cannot race another's merge. It
+// waits until the lock is free or ctx is done, and returns the function that
+// releases the lock, which may be called again, e.g. deferred after an early
+// release.
+func Lock(ctx context.Context, path string) (func(), error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = filepath.Clean(path)
+	}
+
+	locksMu.Lock()
+	l, ok := locks[key]
+	if !ok {
+		l = &treeLock{held: make(chan struct{}, 1)}
+		locks[key] = l
+	}
+	l.refs++
+	locksMu.Unlock()
+
+	select {
+	case l.held <- struct{}{}:
+		return sync.OnceFunc(func() {
+			<-l.held
+			release(key, l)
+		}), nil
+	case <-ctx.Done():
+		release(key, l)
+		return nil, fmt.Errorf("waiting for %s: %w", path, ctx.Err())
+	}
+}
+
+func release(key string, l *treeLock) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(locks, key)
+	}
+}
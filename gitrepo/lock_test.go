@@ -0,0 +1,55 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockWaitEndsWithContext(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := Lock(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Lock(ctx, dir); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+
+	unlock()
+	unlock() // released twice is a no-op
+	again, err := Lock(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again()
+}
+
+func TestLockIsDroppedOnceReleased(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := Lock(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := Lock(context.Background(), dir)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- next
+	}()
+	unlock()
+	(<-acquired)()
+
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if len(locks) != 0 {
+		t.Errorf("released locks are kept: %v", locks)
+	}
+}
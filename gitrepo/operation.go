@@ -0,0 +1,118 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tahminator/go-react-template/gitexec"
+)
+
+// Operation is a git command that can stop on conflicts and be continued.
+type Operation string
+
+const (
+	OperationMerge      Operation = "merge"
+	OperationRebase     Operation = "rebase"
+	OperationCherryPick Operation = "cherry-pick"
+	OperationRevert     Operation = "revert"
+)
+
+// ParseOperation accepts the name of an Operation.
+func ParseOperation(s string) (Operation, error) {
+	switch op := Operation(s); op {
+	case OperationMerge, OperationRebase, OperationCherryPick, OperationRevert:
+		return op, nil
+	}
+	return "", fmt.Errorf("unknown operation %q", s)
+}
+
+// Action drives an operation in progress.
+type Action string
+
+const (
+	ActionContinue Action = "continue"
+	ActionSkip     Action = "skip"
+	ActionAbort    Action = "abort"
+)
+
+var (
+	ErrNoOperation = errors.New("no merge, rebase, cherry-pick or revert is in progress")
+	ErrUnresolved  = errors.New("conflicts are not all resolved")
+	ErrCannotSkip  = errors.New("a merge has no commit to skip")
+)
+
+// OperationState describes the operation a working tree is in the middle
+// of.
+type OperationState struct {
+	Operation Operation `json:"operation"`
+	// Head is the commit being merged, replayed, picked or reverted.
+	Head string `json:"head"`
+	// Step and Total count the commits of a rebase, Step being the one
+	// being replayed. They are zero for other operations.
+	Step  int `json:"step"`
+	Total int `json:"total"`
+	// Remaining counts the commits a cherry-pick or revert of several
+	// commits has left after Head.
+	Remaining int `json:"remaining"`
+	// Branch is the branch being rebased and Onto the commit it is being
+	// rebased onto.
+	Branch string `json:"branch,omitempty"`
+	Onto   string `json:"onto,omitempty"`
+}
+
+// Drive continues, skips or aborts the operation in progress with the git
+// CLI and returns the state it leaves, nil once the operation is over. A
+// rebase or sequence that stops on the conflicts of its next commit is not
+// an error.
+func Drive(ctx context.Context, repo GitRepo, action Action) (*OperationState, error) {
+	state, err := repo.InProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrNoOperation
+	}
+
+	switch action {
+	case ActionContinue:
+		unmerged, err := repo.Unmerged(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(unmerged) > 0 {
+			return nil, ErrUnresolved
+		}
+	case ActionSkip:
+		if state.Operation == OperationMerge {
+			return nil, ErrCannotSkip
+		}
+	case ActionAbort:
+	default:
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+
+	// continuing commits with the message git prepared; there is nobody to
+	// edit it
+	res, runErr := gitexec.Cmd{
+		Dir:  repo.Path(),
+		Args: []string{string(state.Operation), "--" + string(action)},
+		Env:  []string{"GIT_EDITOR=true"},
+	}.Run(ctx)
+
+	next, err := repo.InProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if runErr != nil {
+		unmerged, err := repo.Unmerged(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil || next.Head == state.Head || len(unmerged) == 0 {
+			return nil, fmt.Errorf("git %s --%s failed: %s", state.Operation, action, strings.TrimSpace(res.Stderr))
+		}
+	}
+	return next, nil
+}
@@ -0,0 +1,74 @@
+// Package gitrepo reads repositories in process with go-git. Operations
+// go-git does not implement correctly are run with the git CLI through
+// gitexec.
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoRef is returned when a ref or pseudo-ref such as MERGE_HEAD does not
+// exist.
+var ErrNoRef = errors.New("no such ref")
+
+// CommitInfo describes a commit. Message is its first line.
+type CommitInfo struct {
+	Hash        string    `json:"hash"`
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email"`
+	AuthoredAt  time.Time `json:"authored_at"`
+	Message     string    `json:"message"`
+}
+
+// FileStatus is a path's status as in `git status --porcelain`: Staging and
+// Worktree are the X and Y status letters.
+type FileStatus struct {
+	Path     string `json:"path"`
+	Staging  string `json:"staging"`
+	Worktree string `json:"worktree"`
+}
+
+// Blob is an index entry's object.
+type Blob struct {
+	Hash string `json:"hash"`
+	Mode string `json:"mode"`
+}
+
+// UnmergedEntry is a conflicted path with its index stages: 1 is the merge
+// base, 2 is ours and 3 is theirs. A stage is nil when the path does not
+// exist on that side, e.g. Base for a file both sides added.
+type UnmergedEntry struct {
+	Path   string `json:"path"`
+	Base   *Blob  `json:"base"`
+	Ours   *Blob  `json:"ours"`
+	Theirs *Blob  `json:"theirs"`
+}
+
+type GitRepo interface {
+	// Path is the root of the working tree.
+	Path() string
+	// Status lists the paths that differ from HEAD or the index.
+	Status(ctx context.Context) ([]FileStatus, error)
+	// Unmerged lists conflicted paths in index order.
+	Unmerged(ctx context.Context) ([]UnmergedEntry, error)
+	// ReadBlob returns the content of a blob.
+	ReadBlob(ctx context.Context, hash string) ([]byte, error)
+	// ResolveRef resolves a revision, including pseudo-refs such as
+	// FETCH_HEAD and MERGE_HEAD, to a commit hash.
+	ResolveRef(ctx context.Context, rev string) (string, error)
+	// Commit describes the commit rev resolves to.
+	Commit(ctx context.Context, rev string) (*CommitInfo, error)
+	// MergeBase returns the best common ancestor of two revisions.
+	MergeBase(ctx context.Context, a, b string) (string, error)
+	// MidMerge reports whether a merge is in progress.
+	MidMerge(ctx context.Context) (bool, error)
+	// InProgress describes the merge, rebase, cherry-pick or revert in
+	// progress, or returns nil if there is none.
+	InProgress(ctx context.Context) (*OperationState, error)
+	// ChangedFiles lists the paths that differ between two revisions.
+	ChangedFiles(ctx context.Context, from, to string) ([]string, error)
+	// Add stages paths, resolving any conflict on them.
+	Add(ctx context.Context, paths ...string) error
+}
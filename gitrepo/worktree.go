@@ -0,0 +1,54 @@
+package gitrepo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tahminator/go-react-template/gitexec"
+)
+
+// AddWorktree checks commit out at path as a linked worktree of the clone at
+// dir, sharing its object store. HEAD is detached, so any branch can be
+// checked out by several worktrees at once. The worktree is locked with
+// reason, so `git worktree prune` leaves it alone even while path is
+// unreachable.
+func AddWorktree(ctx context.Context, dir, path, commit, reason string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		return fmt.Errorf("failed to create worktree parent: %w", err)
+	}
+	res, err := gitexec.Run(ctx, dir, "worktree", "add", "--quiet", "--detach", "--lock", "--reason", reason, abs, commit)
+	if err != nil {
+		return fmt.Errorf("git worktree add failed: %s", strings.TrimSpace(res.Stderr))
+	}
+	return nil
+}
+
+// RemoveWorktree deletes a linked worktree of the clone at dir, whatever it
+// holds, along with its administrative files. A worktree that is already
+// gone is not an error.
+func RemoveWorktree(ctx context.Context, dir, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(abs); err == nil {
+		// a locked worktree needs --force twice
+		if res, err := gitexec.Run(ctx, dir, "worktree", "remove", "--force", "--force", abs); err != nil {
+			return fmt.Errorf("git worktree remove failed: %s", strings.TrimSpace(res.Stderr))
+		}
+	} else {
+		// prune skips locked worktrees even when their directory is gone
+		gitexec.Run(ctx, dir, "worktree", "unlock", abs)
+	}
+	if res, err := gitexec.Run(ctx, dir, "worktree", "prune"); err != nil {
+		return fmt.Errorf("git worktree prune failed: %s", strings.TrimSpace(res.Stderr))
+	}
+	return nil
+}
@@ -68,6 +68,8 @@ export default function RootPage() {
       newFileData: resolvedCodeContent,
       fullPath: selectedFile?.fullPath ?? "",
       repoName: REPO_NAME,
+      conflictContent: currentEditorContent,
+      proposal: resolvedCode,
     });
 
     setResolvedCode(""); // Clear the modal
@@ -1,7 +1,20 @@
-import type { CodeDirectory, CodeFile } from "@/lib/api/types/code";
+import type { ApiResponder } from "@/lib/api/common/responder";
+import type {
+  CodeDirectory,
+  CodeFile,
+  ConflictSides,
+} from "@/lib/api/types/code";
 
-export async function getFileTree(repoName: string) {
-  const res = await fetch(`/api/file/tree/generate?repoName=${repoName}`);
+/**
+ * Reads the file tree of a clone, or of a merge session's worktree when
+ * sessionId is given. Only a merge in progress marks files as conflicted.
+ */
+export async function getFileTree(repoName: string, sessionId?: string) {
+  const params = new URLSearchParams({ repoName });
+  if (sessionId) {
+    params.set("sessionId", sessionId);
+  }
+  const res = await fetch(`/api/file/tree/generate?${params}`);
 
   // TODO - Re-write endpoint to use ApiResponder.
   if (!res.ok) {
@@ -11,13 +24,18 @@ export async function getFileTree(repoName: string) {
   return (await res.json()) as (CodeFile | CodeDirectory)[];
 }
 
+/** Reads a file of a clone, or of a merge session's worktree. */
 export async function getFile(
   githubUsername: string,
   githubRepo: string,
   filePath: string,
+  sessionId?: string,
 ) {
+  const query = sessionId
+    ? `?sessionId=${encodeURIComponent(sessionId)}`
+    : "";
   const res = await fetch(
-    `/api/file/data/${githubUsername}/${githubRepo}/${filePath}`,
+    `/api/file/data/${githubUsername}/${githubRepo}/${filePath}${query}`,
   );
 
   if (!res.ok) {
@@ -26,3 +44,26 @@ export async function getFile(
 
   return await res.text();
 }
+
+/**
+ * Reads the base, ours and theirs versions of a conflicted file from the git
+ * index, so they are available even after the working copy was edited.
+ */
+export async function getConflictSides(
+  repoName: string,
+  filePath: string,
+  sessionId?: string,
+) {
+  const params = new URLSearchParams({ repoName });
+  if (sessionId) {
+    params.set("sessionId", sessionId);
+  }
+  const res = await fetch(`/api/file/conflict/${filePath}?${params}`);
+
+  const json = (await res.json()) as ApiResponder<ConflictSides>;
+  if (!json.success) {
+    throw new Error("failed to get conflict sides");
+  }
+
+  return json.payload;
+}
@@ -1,14 +1,26 @@
+import type { ApiResponder } from "@/lib/api/common/responder";
+import type {
+  GitOperationAction,
+  GitOperationState,
+  MergeSession,
+  MergeSessionAction,
+  MergeSessionState,
+} from "@/lib/api/types/merge";
+
 export async function commitRepository(
   repoName: string,
   newFileData: string,
   path: string,
+  // writes the file into a merge session's worktree and completes the
+  // session instead of committing the clone
+  sessionId?: string,
 ) {
   const res = await fetch("/api/github/commit", {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
     },
-    body: JSON.stringify({ repoName, newFileData, path }),
+    body: JSON.stringify({ repoName, newFileData, path, sessionId }),
   });
 
   if (!res.ok) {
@@ -21,13 +33,21 @@ export async function commitRepository(
   return await res.json();
 }
 
-export async function declineMerge(fullPath: string, repoName: string) {
+/**
+ * Aborts what stopped on conflicts. With sessionId, aborts that merge
+ * session and removes its worktree.
+ */
+export async function declineMerge(
+  fullPath: string,
+  repoName: string,
+  sessionId?: string,
+) {
   const res = await fetch("/api/github/merge/decline", {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
     },
-    body: JSON.stringify({ fullPath, repoName }),
+    body: JSON.stringify({ fullPath, repoName, sessionId }),
   });
 
   if (!res.ok) {
@@ -44,6 +64,12 @@ export async function acceptMerge(
   newFileData: string,
   fullPath: string,
   repoName: string,
+  // the conflicted file and the suggested resolution, so the server can
+  // learn how the conflict was resolved
+  conflictContent?: string,
+  proposal?: string,
+  // stages the file in a merge session's worktree instead of the clone
+  sessionId?: string,
 ) {
   const res = await fetch("/api/github/merge/accept", {
     method: "POST",
@@ -54,6 +80,9 @@ export async function acceptMerge(
       newFileData,
       fullPath,
       repoName,
+      conflictContent,
+      proposal,
+      sessionId,
     }),
   });
 
@@ -63,3 +92,109 @@ export async function acceptMerge(
 
   return await res.json();
 }
+
+/**
+ * Starts merging sourceBranch into targetBranch. The merge is left
+ * uncommitted until the session is completed.
+ */
+export async function startMergeSession(
+  repoName: string,
+  sourceBranch: string,
+  targetBranch: string,
+) {
+  const res = await fetch("/api/github/merge/sessions", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ repoName, sourceBranch, targetBranch }),
+  });
+
+  const json = (await res.json()) as ApiResponder<MergeSessionState>;
+  if (!json.success) {
+    throw new Error(json.message || "Failed to start merge");
+  }
+
+  return json.payload;
+}
+
+export async function getMergeSessions(repoName: string) {
+  const res = await fetch(
+    `/api/github/merge/sessions?repoName=${encodeURIComponent(repoName)}`,
+  );
+
+  const json = (await res.json()) as ApiResponder<MergeSession[]>;
+  if (!json.success) {
+    throw new Error("Failed to get merge sessions");
+  }
+
+  return json.payload;
+}
+
+export async function getMergeSession(id: string) {
+  const res = await fetch(`/api/github/merge/sessions/${id}`);
+
+  const json = (await res.json()) as ApiResponder<MergeSessionState>;
+  if (!json.success) {
+    throw new Error("Failed to get merge session");
+  }
+
+  return json.payload;
+}
+
+export async function updateMergeSession(
+  id: string,
+  action: MergeSessionAction,
+) {
+  const res = await fetch(`/api/github/merge/sessions/${id}/${action}`, {
+    method: "POST",
+  });
+
+  const json = (await res.json()) as ApiResponder<MergeSessionState>;
+  if (!json.success) {
+    throw new Error(json.message || `Failed to ${action} merge`);
+  }
+
+  return json.payload;
+}
+
+/** Reports the merge, rebase, cherry-pick or revert in progress, if any. */
+export async function getOperation(repoName: string, sessionId?: string) {
+  const params = new URLSearchParams({ repoName });
+  if (sessionId) {
+    params.set("sessionId", sessionId);
+  }
+  const res = await fetch(`/api/github/operation?${params}`);
+
+  const json = (await res.json()) as ApiResponder<GitOperationState | null>;
+  if (!json.success) {
+    throw new Error("Failed to get repository state");
+  }
+
+  return json.payload;
+}
+
+/**
+ * Continues, skips or aborts the operation in progress and returns what is in
+ * progress afterwards, such as the next step of a rebase.
+ */
+export async function driveOperation(
+  repoName: string,
+  action: GitOperationAction,
+  sessionId?: string,
+) {
+  const res = await fetch(`/api/github/operation/${action}`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ repoName, sessionId }),
+  });
+
+  const json = (await res.json()) as ApiResponder<GitOperationState | null>;
+  if (!json.success) {
+    throw new Error(json.message || `Failed to ${action}`);
+  }
+
+  return json.payload;
+}
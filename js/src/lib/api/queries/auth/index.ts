@@ -10,9 +10,9 @@ export const useAuthQuery = () =>
     queryFn: getAuth,
   });
 
-export const useFileTreeQuery = (repoName: string) =>
+export const useFileTreeQuery = (repoName: string, sessionId?: string) =>
   useQuery({
-    queryKey: ["tree"],
-    queryFn: () => getFileTree(repoName),
+    queryKey: ["tree", sessionId],
+    queryFn: () => getFileTree(repoName, sessionId),
       refetchOnWindowFocus: false,
   });
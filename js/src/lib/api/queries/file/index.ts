@@ -5,11 +5,13 @@ import { getFile } from "../../fetchers/file";
 export const useFileQuery = (
   githubUsername: string,
   githubRepo: string,
-  filePath?: string
+  filePath?: string,
+  sessionId?: string
 ) =>
   useQuery({
-    queryKey: ["file", githubUsername, githubRepo, filePath],
-    // eslint-disable-next-line @typescript-eslint/no-non-null-assertion
-    queryFn: () => getFile(githubUsername, githubRepo, filePath!),
+    queryKey: ["file", githubUsername, githubRepo, filePath, sessionId],
+    queryFn: () =>
+      // eslint-disable-next-line @typescript-eslint/no-non-null-assertion
+      getFile(githubUsername, githubRepo, filePath!, sessionId),
     enabled: !!filePath,
   });
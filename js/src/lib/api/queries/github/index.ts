@@ -1,9 +1,17 @@
 import { useMutation, useQueryClient } from "@tanstack/react-query";
 
+import type {
+  GitOperationAction,
+  MergeSessionAction,
+} from "@/lib/api/types/merge";
+
 import {
   acceptMerge,
   commitRepository,
   declineMerge,
+  driveOperation,
+  startMergeSession,
+  updateMergeSession,
 } from "../../fetchers/github";
 
 export const useCommitRepositoryMutation = () => {
@@ -14,11 +22,13 @@ export const useCommitRepositoryMutation = () => {
       repoName,
       newFileData,
       path,
+      sessionId,
     }: {
       repoName: string;
       newFileData: string;
       path: string;
-    }) => commitRepository(repoName, newFileData, path),
+      sessionId?: string;
+    }) => commitRepository(repoName, newFileData, path, sessionId),
     onSettled: () => {
       queryClient.invalidateQueries({});
     },
@@ -32,10 +42,12 @@ export const useDeclineMergeMutation = () => {
     mutationFn: ({
       fullPath,
       repoName,
+      sessionId,
     }: {
       fullPath: string;
       repoName: string;
-    }) => declineMerge(fullPath, repoName),
+      sessionId?: string;
+    }) => declineMerge(fullPath, repoName, sessionId),
     onSettled: () => {
       queryClient.invalidateQueries({});
     },
@@ -50,11 +62,75 @@ export const useAcceptMergeMutation = () => {
       newFileData,
       fullPath,
       repoName,
+      conflictContent,
+      proposal,
+      sessionId,
     }: {
       newFileData: string;
       fullPath: string;
       repoName: string;
-    }) => acceptMerge(newFileData, fullPath, repoName),
+      conflictContent?: string;
+      proposal?: string;
+      sessionId?: string;
+    }) =>
+      acceptMerge(
+        newFileData,
+        fullPath,
+        repoName,
+        conflictContent,
+        proposal,
+        sessionId,
+      ),
+    onSettled: () => {
+      queryClient.invalidateQueries();
+    },
+  });
+};
+
+export const useStartMergeSessionMutation = () => {
+  const queryClient = useQueryClient();
+
+  return useMutation({
+    mutationFn: ({
+      repoName,
+      sourceBranch,
+      targetBranch,
+    }: {
+      repoName: string;
+      sourceBranch: string;
+      targetBranch: string;
+    }) => startMergeSession(repoName, sourceBranch, targetBranch),
+    onSettled: () => {
+      queryClient.invalidateQueries();
+    },
+  });
+};
+
+export const useMergeSessionMutation = () => {
+  const queryClient = useQueryClient();
+
+  return useMutation({
+    mutationFn: ({ id, action }: { id: string; action: MergeSessionAction }) =>
+      updateMergeSession(id, action),
+    onSettled: () => {
+      queryClient.invalidateQueries();
+    },
+  });
+};
+
+export const useDriveOperationMutation = () => {
+  const queryClient = useQueryClient();
+
+  return useMutation({
+    mutationFn: ({
+      repoName,
+      action,
+      sessionId,
+    }: {
+      repoName: string;
+      action: GitOperationAction;
+      sessionId?: string;
+    }) => driveOperation(repoName, action, sessionId),
     onSettled: () => {
       queryClient.invalidateQueries();
     },
@@ -30,3 +30,28 @@ export type CodeDirectory = {
   fullPath: string;
   subDirectories?: (CodeDirectory | CodeFile)[];
 };
+
+export type CommitInfo = {
+  hash: string;
+  author: string;
+  author_email: string;
+  authored_at: string;
+  message: string;
+};
+
+/** One index stage of a conflicted file; content is empty for binaries. */
+export type ConflictSide = {
+  hash: string;
+  mode: string;
+  content: string;
+  binary: boolean;
+  commit: CommitInfo | null;
+};
+
+/** A side is null when the file does not exist on it. */
+export type ConflictSides = {
+  path: string;
+  base: ConflictSide | null;
+  ours: ConflictSide | null;
+  theirs: ConflictSide | null;
+};
@@ -0,0 +1,54 @@
+export type MergeSessionStatus =
+  | "in_progress"
+  | "completed"
+  | "aborted"
+  | "failed";
+
+export type MergeSession = {
+  id: string;
+  user_id: string;
+  owner: string;
+  repo: string;
+  source_branch: string;
+  target_branch: string;
+  base_commit: string | null;
+  source_commit: string | null;
+  merge_commit: string | null;
+  status: MergeSessionStatus;
+  error: string | null;
+  created_at: string;
+  updated_at: string;
+  finished_at: string | null;
+};
+
+/**
+ * A session, the conflicts still left in its worktree and the files the
+ * source branch changes.
+ */
+export type MergeSessionState = MergeSession & {
+  merging: boolean;
+  conflicts: string[];
+  incoming: string[];
+};
+
+export type MergeSessionAction = "abort" | "resume" | "complete";
+
+export type GitOperation = "merge" | "rebase" | "cherry-pick" | "revert";
+
+export type GitOperationAction = "continue" | "skip" | "abort";
+
+/**
+ * What a clone is in the middle of. step and total count the commits of a
+ * rebase; remaining counts what a cherry-pick or revert of several commits
+ * has left.
+ */
+export type GitOperationState = {
+  operation: GitOperation;
+  head: string;
+  step: number;
+  total: number;
+  remaining: number;
+  branch?: string;
+  onto?: string;
+  conflicts: string[];
+};
@@ -1,71 +1,201 @@
 import { useState, useCallback } from "react";
 
+export type StreamEventType =
+  | "progress"
+  | "hunk_started"
+  | "token"
+  | "hunk_resolved"
+  | "validation"
+  | "error"
+  | "done";
+
+export interface StreamEvent {
+  id: number;
+  type: StreamEventType;
+  data: unknown;
+}
+
+interface TokenData {
+  hunk?: number;
+  text: string;
+}
+
+interface ErrorData {
+  source: "model" | "request";
+  message: string;
+}
+
+interface DoneData {
+  content: string;
+}
+
 interface UseStreamOptions {
   onChunk?: (chunk: string) => void;
+  onEvent?: (event: StreamEvent) => void;
   onComplete?: (fullText: string) => void;
   onError?: (error: Error) => void;
 }
 
+export class StreamError extends Error {
+  source: ErrorData["source"];
+
+  constructor(data: ErrorData) {
+    super(data.message);
+    this.name = "StreamError";
+    this.source = data.source;
+  }
+}
+
+const STREAM_ENDPOINT = "/api/gemini/resolve-conflicts-file-stream";
+const RESUME_ENDPOINT = "/api/gemini/streams";
+const MAX_RESUME_ATTEMPTS = 3;
+
+/**
+ * Parses a Server-Sent Events body, calling onEvent for every complete event.
+ * Comment lines (heartbeats) are skipped.
+ */
+async function readEvents(
+  response: Response,
+  onEvent: (event: StreamEvent) => void,
+) {
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  const reader = response.body?.getReader();
+  if (!reader) {
+    throw new Error("No response body reader available");
+  }
+
+  const decoder = new TextDecoder();
+  let buffer = "";
+
+  while (true) {
+    const { done, value } = await reader.read();
+    if (done) {
+      return;
+    }
+
+    buffer += decoder.decode(value, { stream: true });
+    let end: number;
+    while ((end = buffer.indexOf("\n\n")) >= 0) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+
+      let id = 0;
+      let type = "";
+      let data = "";
+      for (const line of block.split("\n")) {
+        if (line.startsWith(":")) {
+          continue;
+        }
+        const sep = line.indexOf(":");
+        const field = sep < 0 ? line : line.slice(0, sep);
+        const value = sep < 0 ? "" : line.slice(sep + 1).replace(/^ /, "");
+        if (field === "id") id = Number(value);
+        if (field === "event") type = value;
+        if (field === "data") data += value;
+      }
+      if (type) {
+        onEvent({ id, type: type as StreamEventType, data: JSON.parse(data) });
+      }
+    }
+  }
+}
+
 export function useStream(options: UseStreamOptions = {}) {
   const [isStreaming, setIsStreaming] = useState(false);
   const [streamedText, setStreamedText] = useState("");
   const [error, setError] = useState<Error | null>(null);
 
-  const STREAM_ENDPOINT = "/api/gemini/resolve-conflicts-file-stream";
-
   const startStream = useCallback(
     async (streamOptions?: {
       conflictContent?: string;
       filePath?: string;
       userQuery?: string;
-      repoHash?: string;
+      repoId?: string;
     }) => {
       setIsStreaming(true);
       setStreamedText("");
       setError(null);
 
+      let fullText = "";
+      let streamId = "";
+      let lastEventId = 0;
+      let finished = false;
+
+      const handleEvent = (event: StreamEvent) => {
+        lastEventId = event.id;
+        options.onEvent?.(event);
+
+        switch (event.type) {
+          case "progress":
+            streamId = (event.data as { stream_id: string }).stream_id;
+            break;
+          case "token": {
+            const { text } = event.data as TokenData;
+            fullText += text;
+            setStreamedText(fullText);
+            options.onChunk?.(text);
+            break;
+          }
+          case "error":
+            finished = true;
+            throw new StreamError(event.data as ErrorData);
+          case "done":
+            finished = true;
+            options.onComplete?.((event.data as DoneData).content);
+            break;
+        }
+      };
+
       try {
         const response = await fetch(STREAM_ENDPOINT, {
           method: "POST",
           headers: {
             "Content-Type": "application/json",
-            Accept: "text/plain",
+            Accept: "text/event-stream",
           },
           body: JSON.stringify({
             conflict_content: streamOptions?.conflictContent || "",
             file_path: streamOptions?.filePath || "",
             user_query: streamOptions?.userQuery || "",
-            repo_hash: streamOptions?.repoHash || "",
+            repo_id: streamOptions?.repoId || "",
           }),
         });
 
-        if (!response.ok) {
-          throw new Error(`HTTP error! status: ${response.status}`);
-        }
-
-        const reader = response.body?.getReader();
-        if (!reader) {
-          throw new Error("No response body reader available");
-        }
-
-        const decoder = new TextDecoder();
-        let fullText = "";
-
-        while (true) {
-          const { done, value } = await reader.read();
+        streamId = response.headers.get("X-Stream-Id") ?? "";
 
-          if (done) {
+        // the server keeps generating when the connection drops, so pick
+        // up from the last event seen instead of starting over
+        for (let attempt = 0; ; attempt++) {
+          try {
+            await readEvents(
+              attempt === 0
+                ? response
+                : await fetch(`${RESUME_ENDPOINT}/${streamId}`, {
+                    headers: {
+                      Accept: "text/event-stream",
+                      "Last-Event-ID": String(lastEventId),
+                    },
+                  }),
+              handleEvent,
+            );
+          } catch (err) {
+            if (
+              err instanceof StreamError ||
+              !streamId ||
+              attempt >= MAX_RESUME_ATTEMPTS
+            ) {
+              throw err;
+            }
+          }
+          if (finished) {
             break;
           }
-
-          const chunk = decoder.decode(value, { stream: true });
-          fullText += chunk;
-
-          setStreamedText(fullText);
-          options.onChunk?.(chunk);
+          if (!streamId || attempt >= MAX_RESUME_ATTEMPTS) {
+            throw new Error("Stream ended before the resolution finished");
+          }
         }
-
-        options.onComplete?.(fullText);
       } catch (err) {
         const error =
           err instanceof Error ? err : new Error("Unknown error occurred");
@@ -75,7 +205,7 @@ export function useStream(options: UseStreamOptions = {}) {
         setIsStreaming(false);
       }
     },
-    [options]
+    [options],
   );
 
   const reset = useCallback(() => {
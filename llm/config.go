@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// NewFromEnv builds the deployment's provider.
+//
+//	LLM_PROVIDER         gemini (default) | openai | fake
+//	LLM_MODEL            default model for generation
+//	LLM_TEMPERATURE      default sampling temperature
+//	LLM_THINKING_BUDGET  default thinking budget (gemini only)
+//	LLM_EMBED_MODEL      embedding model
+//	LLM_EMBED_DIMENSIONS embedding size to request, 768 by default to match
+//	                     the vector columns; 0 leaves it to the model
+//	GEMINI_API_KEY       required for gemini
+//	OPENAI_BASE_URL      openai-compatible server, defaults to api.openai.com
+//	OPENAI_API_KEY       bearer token for the openai-compatible server
+//	OPENAI_TIMEOUT       how long a request may take, e.g. 90s; 10m by default
+func NewFromEnv(ctx context.Context) (LLMProvider, error) {
+	defaults := Options{
+		Model: os.Getenv("LLM_MODEL"),
+	}
+	if v := os.Getenv("LLM_TEMPERATURE"); v != "" {
+		t, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLM_TEMPERATURE: %w", err)
+		}
+		temperature := float32(t)
+		defaults.Temperature = &temperature
+	}
+	if v := os.Getenv("LLM_THINKING_BUDGET"); v != "" {
+		b, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLM_THINKING_BUDGET: %w", err)
+		}
+		budget := int32(b)
+		defaults.ThinkingBudget = &budget
+	}
+	embedModel := os.Getenv("LLM_EMBED_MODEL")
+	embedDim := DefaultEmbedDimensions
+	if v := os.Getenv("LLM_EMBED_DIMENSIONS"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid LLM_EMBED_DIMENSIONS %q", v)
+		}
+		embedDim = d
+	}
+
+	switch provider := strings.ToLower(os.Getenv("LLM_PROVIDER")); provider {
+	case "", "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("GEMINI_API_KEY environment variable is required")
+		}
+		client, err := genai.NewClient(ctx, &genai.ClientConfig{
+			APIKey:  apiKey,
+			Backend: genai.BackendGeminiAPI,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+		}
+		return NewGeminiProvider(client, defaults, embedModel, embedDim), nil
+	case "openai":
+		openai := NewOpenAIProvider(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), defaults, embedModel, embedDim)
+		if v := os.Getenv("OPENAI_TIMEOUT"); v != "" {
+			timeout, err := time.ParseDuration(v)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid OPENAI_TIMEOUT %q", v)
+			}
+			openai = openai.WithTimeout(timeout)
+		}
+		return openai, nil
+	case "fake":
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", provider)
+	}
+}
@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestOptionsMerge(t *testing.T) {
+	defaults := Options{Model: "default-model", Temperature: ptr(float32(0.2)), ThinkingBudget: ptr(int32(512))}
+	schema := map[string]any{"type": "object"}
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		want Options
+	}{
+		{"unset", Options{}, defaults},
+		{"model", Options{Model: "other"}, Options{Model: "other", Temperature: defaults.Temperature, ThinkingBudget: defaults.ThinkingBudget}},
+		{"zero temperature", Options{Temperature: ptr(float32(0))}, Options{Model: "default-model", Temperature: ptr(float32(0)), ThinkingBudget: defaults.ThinkingBudget}},
+		{"zero thinking budget", Options{ThinkingBudget: ptr(int32(0))}, Options{Model: "default-model", Temperature: defaults.Temperature, ThinkingBudget: ptr(int32(0))}},
+		{"schema", Options{ResponseSchema: schema}, Options{Model: "default-model", Temperature: defaults.Temperature, ThinkingBudget: defaults.ThinkingBudget, ResponseSchema: schema}},
+	} {
+		got := tc.opts.Merge(defaults)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Merge = %s, want %s", tc.name, describe(got), describe(tc.want))
+		}
+	}
+
+	if got := (Options{}).Merge(Options{}); !reflect.DeepEqual(got, Options{}) {
+		t.Errorf("merging nothing set anything: %s", describe(got))
+	}
+}
+
+func describe(o Options) string {
+	s := "{model " + o.Model
+	if o.Temperature != nil {
+		s += fmt.Sprintf(", temperature %g", *o.Temperature)
+	}
+	if o.ThinkingBudget != nil {
+		s += fmt.Sprintf(", thinking budget %d", *o.ThinkingBudget)
+	}
+	if o.ResponseSchema != nil {
+		s += fmt.Sprintf(", schema %v", o.ResponseSchema)
+	}
+	return s + "}"
+}
+
+func TestGeminiProviderDefaults(t *testing.T) {
+	p := NewGeminiProvider(nil, Options{}, "", 0)
+	if p.DefaultModel() != DefaultGeminiModel || p.EmbedModel() != DefaultGeminiEmbedModel {
+		t.Errorf("models %q and %q", p.DefaultModel(), p.EmbedModel())
+	}
+	// thinking is off unless the deployment turns it on
+	cfg := p.config(Options{}.Merge(p.defaults))
+	if cfg.ThinkingConfig == nil || cfg.ThinkingConfig.ThinkingBudget == nil || *cfg.ThinkingConfig.ThinkingBudget != 0 {
+		t.Errorf("default thinking config %+v", cfg.ThinkingConfig)
+	}
+
+	p = NewGeminiProvider(nil, Options{Model: "gemini-pro", Temperature: ptr(float32(0.5)), ThinkingBudget: ptr(int32(1024))}, "embed", 256)
+	cfg = p.config(Options{ThinkingBudget: ptr(int32(64)), ResponseSchema: map[string]any{"type": "object"}}.Merge(p.defaults))
+	if *cfg.Temperature != 0.5 || *cfg.ThinkingConfig.ThinkingBudget != 64 {
+		t.Errorf("temperature %v, thinking budget %v", *cfg.Temperature, *cfg.ThinkingConfig.ThinkingBudget)
+	}
+	if cfg.ResponseMIMEType != "application/json" || cfg.ResponseJsonSchema == nil {
+		t.Errorf("schema was not passed on: %q %v", cfg.ResponseMIMEType, cfg.ResponseJsonSchema)
+	}
+	if p.DefaultModel() != "gemini-pro" || p.EmbedModel() != "embed" || p.EmbedDimensions() != 256 {
+		t.Errorf("configured provider %q %q %d", p.DefaultModel(), p.EmbedModel(), p.EmbedDimensions())
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	for _, k := range []string{"LLM_MODEL", "LLM_TEMPERATURE", "LLM_THINKING_BUDGET", "LLM_EMBED_MODEL", "LLM_EMBED_DIMENSIONS", "OPENAI_BASE_URL", "OPENAI_API_KEY", "OPENAI_TIMEOUT", "GEMINI_API_KEY"} {
+		t.Setenv(k, "")
+	}
+
+	t.Setenv("LLM_PROVIDER", "openai")
+	p, err := NewFromEnv(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	openai := p.(*OpenAIProvider)
+	if openai.baseURL != DefaultOpenAIBaseURL || openai.DefaultModel() != DefaultOpenAIModel ||
+		openai.EmbedModel() != DefaultOpenAIEmbedModel || openai.EmbedDimensions() != DefaultEmbedDimensions {
+		t.Errorf("defaults: %s %s %s %d", openai.baseURL, openai.DefaultModel(), openai.EmbedModel(), openai.EmbedDimensions())
+	}
+	if openai.defaults.Temperature != nil || openai.defaults.ThinkingBudget != nil || openai.httpClient.Timeout != DefaultOpenAITimeout {
+		t.Errorf("unset options were given values: %s, timeout %s", describe(openai.defaults), openai.httpClient.Timeout)
+	}
+
+	t.Setenv("LLM_MODEL", "local-model")
+	t.Setenv("LLM_TEMPERATURE", "0.3")
+	t.Setenv("LLM_THINKING_BUDGET", "128")
+	t.Setenv("LLM_EMBED_DIMENSIONS", "0")
+	t.Setenv("OPENAI_BASE_URL", "http://localhost:11434/v1/")
+	t.Setenv("OPENAI_TIMEOUT", "90s")
+	if p, err = NewFromEnv(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	openai = p.(*OpenAIProvider)
+	want := Options{Model: "local-model", Temperature: ptr(float32(0.3)), ThinkingBudget: ptr(int32(128))}
+	if !reflect.DeepEqual(openai.defaults, want) || openai.baseURL != "http://localhost:11434/v1" ||
+		openai.EmbedDimensions() != 0 || openai.httpClient.Timeout.Seconds() != 90 {
+		t.Errorf("configured: %s %s %d %s", describe(openai.defaults), openai.baseURL, openai.EmbedDimensions(), openai.httpClient.Timeout)
+	}
+
+	for k, v := range map[string]string{
+		"LLM_TEMPERATURE":      "warm",
+		"LLM_THINKING_BUDGET":  "lots",
+		"LLM_EMBED_DIMENSIONS": "-1",
+		"OPENAI_TIMEOUT":       "0s",
+		"LLM_PROVIDER":         "claude",
+	} {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv(k, v)
+			if _, err := NewFromEnv(context.Background()); err == nil {
+				t.Errorf("%s=%s was accepted", k, v)
+			}
+		})
+	}
+
+	t.Setenv("LLM_PROVIDER", "gemini")
+	if _, err := NewFromEnv(context.Background()); err == nil {
+		t.Errorf("gemini without GEMINI_API_KEY was accepted")
+	}
+}
@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"iter"
+	"math"
+	"strings"
+	"sync"
+)
+
+const DefaultFakeDim = 768
+
+type FakeCall struct {
+	Prompt  string
+	Options Options
+}
+
+// FakeProvider is a deterministic, offline provider for tests. Replies come from
+// Respond when set, otherwise Responses are handed out in order and the last
+// one repeats. Embeddings are hashed bag-of-words vectors, so identical text
+// always embeds identically and overlapping text lands close together.
+type FakeProvider struct {
+	Respond   func(prompt string) (string, error)
+	Responses []string
+	Dim       int
+
+	mu    sync.Mutex
+	calls []FakeCall
+	next  int
+}
+
+func NewFakeProvider(responses ...string) *FakeProvider {
+	return &FakeProvider{
+		Responses: responses,
+		Dim:       DefaultFakeDim,
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// DefaultModel names the model used when a request does not pick one.
+func (p *FakeProvider) DefaultModel() string {
+	return "fake"
+}
+
+// EmbedModel names the model behind Embed.
+func (p *FakeProvider) EmbedModel() string {
+	return "fake"
+}
+
+// EmbedDimensions is the size of the embeddings Embed returns.
+func (p *FakeProvider) EmbedDimensions() int {
+	if p.Dim <= 0 {
+		return DefaultFakeDim
+	}
+	return p.Dim
+}
+
+// Calls returns every prompt the provider has seen, oldest first.
+func (p *FakeProvider) Calls() []FakeCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]FakeCall(nil), p.calls...)
+}
+
+func (p *FakeProvider) reply(prompt string, opts Options) (string, error) {
+	p.mu.Lock()
+	p.calls = append(p.calls, FakeCall{Prompt: prompt, Options: opts})
+	respond := p.Respond
+	var scripted string
+	if respond == nil && len(p.Responses) > 0 {
+		scripted = p.Responses[min(p.next, len(p.Responses)-1)]
+		p.next++
+	}
+	p.mu.Unlock()
+
+	if respond != nil {
+		return respond(prompt)
+	}
+	return scripted, nil
+}
+
+func (p *FakeProvider) Generate(ctx context.Context, prompt string, opts Options) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return p.reply(prompt, opts)
+}
+
+// Stream yields the reply one line at a time.
+func (p *FakeProvider) Stream(ctx context.Context, prompt string, opts Options) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		text, err := p.Generate(ctx, prompt, opts)
+		if err != nil {
+			yield("", err)
+			return
+		}
+		for _, line := range strings.SplitAfter(text, "\n") {
+			if line == "" {
+				continue
+			}
+			if !yield(line, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (p *FakeProvider) Embed(ctx context.Context, texts []string, task EmbedTask) ([][]float64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dim := p.Dim
+	if dim <= 0 {
+		dim = DefaultFakeDim
+	}
+
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		vec := make([]float64, dim)
+		for _, tok := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(tok))
+			vec[h.Sum32()%uint32(dim)]++
+		}
+		var norm float64
+		for _, v := range vec {
+			norm += v * v
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for j := range vec {
+				vec[j] /= norm
+			}
+		}
+		out[i] = vec
+	}
+	return out, nil
+}
+
+var _ LLMProvider = new(FakeProvider)
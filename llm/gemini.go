@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+const (
+	DefaultGeminiModel      = "gemini-2.5-flash"
+	DefaultGeminiEmbedModel = "text-embedding-004"
+
+	// EmbedContent rejects larger batches
+	geminiEmbedBatchSize = 50
+)
+
+type GeminiProvider struct {
+	client     *genai.Client
+	defaults   Options
+	embedModel string
+	embedDim   int
+}
+
+// NewGeminiProvider builds a provider on client. embedDim is requested as
+// the output dimensionality of every embedding; 0 leaves it to the model.
+func NewGeminiProvider(client *genai.Client, defaults Options, embedModel string, embedDim int) *GeminiProvider {
+	if defaults.Model == "" {
+		defaults.Model = DefaultGeminiModel
+	}
+	if defaults.ThinkingBudget == nil {
+		thinkingBudget := int32(0)
+		defaults.ThinkingBudget = &thinkingBudget
+	}
+	if embedModel == "" {
+		embedModel = DefaultGeminiEmbedModel
+	}
+	return &GeminiProvider{
+		client:     client,
+		defaults:   defaults,
+		embedModel: embedModel,
+		embedDim:   embedDim,
+	}
+}
+
+func (p *GeminiProvider) Name() string {
+	return "gemini"
+}
+
+// DefaultModel names the model used when a request does not pick one.
+func (p *GeminiProvider) DefaultModel() string {
+	return p.defaults.Model
+}
+
+// EmbedModel names the model behind Embed.
+func (p *GeminiProvider) EmbedModel() string {
+	return p.embedModel
+}
+
+// EmbedDimensions is the size of the embeddings Embed requests, 0 if it
+// leaves it to the model.
+func (p *GeminiProvider) EmbedDimensions() int {
+	return p.embedDim
+}
+
+func (p *GeminiProvider) config(opts Options) *genai.GenerateContentConfig {
+	cfg := &genai.GenerateContentConfig{
+		Temperature: opts.Temperature,
+	}
+	if opts.ThinkingBudget != nil {
+		cfg.ThinkingConfig = &genai.ThinkingConfig{
+			ThinkingBudget: opts.ThinkingBudget,
+		}
+	}
+	if opts.ResponseSchema != nil {
+		cfg.ResponseMIMEType = "application/json"
+		cfg.ResponseJsonSchema = opts.ResponseSchema
+	}
+	return cfg
+}
+
+func (p *GeminiProvider) Generate(ctx context.Context, prompt string, opts Options) (string, error) {
+	var response strings.Builder
+	for text, err := range p.Stream(ctx, prompt, opts) {
+		if err != nil {
+			return "", err
+		}
+		response.WriteString(text)
+	}
+	return response.String(), nil
+}
+
+func (p *GeminiProvider) Stream(ctx context.Context, prompt string, opts Options) iter.Seq2[string, error] {
+	opts = opts.Merge(p.defaults)
+	return func(yield func(string, error) bool) {
+		stream := p.client.Models.GenerateContentStream(ctx, opts.Model, genai.Text(prompt), p.config(opts))
+		for resp, err := range stream {
+			if err != nil {
+				yield("", fmt.Errorf("streaming error: %w", err))
+				return
+			}
+			if resp == nil || resp.Text() == "" {
+				continue
+			}
+			if !yield(resp.Text(), nil) {
+				return
+			}
+		}
+	}
+}
+
+func (p *GeminiProvider) Embed(ctx context.Context, texts []string, task EmbedTask) ([][]float64, error) {
+	var all [][]float64
+
+	for i := 0; i < len(texts); i += geminiEmbedBatchSize {
+		end := min(i+geminiEmbedBatchSize, len(texts))
+
+		contents := make([]*genai.Content, 0, end-i)
+		for _, text := range texts[i:end] {
+			contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+		}
+
+		cfg := &genai.EmbedContentConfig{TaskType: string(task)}
+		if p.embedDim > 0 {
+			dim := int32(p.embedDim)
+			cfg.OutputDimensionality = &dim
+		}
+		resp, err := p.client.Models.EmbedContent(ctx, p.embedModel, contents, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get embeddings for batch: %w", err)
+		}
+
+		for _, emb := range resp.Embeddings {
+			values := make([]float64, len(emb.Values))
+			for j, v := range emb.Values {
+				values[j] = float64(v)
+			}
+			all = append(all, values)
+		}
+	}
+
+	return all, nil
+}
+
+var _ LLMProvider = new(GeminiProvider)
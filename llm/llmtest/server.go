@@ -0,0 +1,163 @@
+// Package llmtest runs a local OpenAI-compatible server with scripted replies so
+// the resolution flow can be exercised end to end without network access.
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/tahminator/go-react-template/llm"
+)
+
+type Request struct {
+	Path   string
+	Model  string
+	Prompt string
+	Stream bool
+	// ResponseFormat is the raw response_format of a chat request, if any.
+	ResponseFormat json.RawMessage
+}
+
+// Server answers /v1/chat/completions (streaming and not) and /v1/embeddings.
+// Completions come from the same scripting rules as llm.FakeProvider.
+type Server struct {
+	*httptest.Server
+
+	fake *llm.FakeProvider
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+func NewServer(responses ...string) *Server {
+	s := &Server{fake: llm.NewFakeProvider(responses...)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChat)
+	mux.HandleFunc("POST /v1/embeddings", s.handleEmbeddings)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Respond replaces the scripted replies with a function of the prompt.
+func (s *Server) Respond(fn func(prompt string) (string, error)) {
+	s.fake.Respond = fn
+}
+
+// Provider returns an OpenAI-compatible provider pointed at this server.
+func (s *Server) Provider() *llm.OpenAIProvider {
+	return llm.NewOpenAIProvider(s.URL+"/v1", "test-key", llm.Options{Model: "fake-model"}, "fake-embed", llm.DefaultEmbedDimensions)
+}
+
+// Requests returns every request received so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) record(r Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+		ResponseFormat json.RawMessage `json:"response_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var prompt strings.Builder
+	for _, m := range body.Messages {
+		prompt.WriteString(m.Content)
+	}
+	s.record(Request{
+		Path:           r.URL.Path,
+		Model:          body.Model,
+		Prompt:         prompt.String(),
+		Stream:         body.Stream,
+		ResponseFormat: body.ResponseFormat,
+	})
+
+	reply, err := s.fake.Generate(r.Context(), prompt.String(), llm.Options{Model: body.Model})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !body.Stream {
+		writeJSON(w, map[string]any{
+			"choices": []any{map[string]any{
+				"message": map[string]string{"role": "assistant", "content": reply},
+			}},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for _, line := range strings.SplitAfter(reply, "\n") {
+		if line == "" {
+			continue
+		}
+		chunk, _ := json.Marshal(map[string]any{
+			"choices": []any{map[string]any{
+				"delta": map[string]string{"content": line},
+			}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model      string   `json:"model"`
+		Input      []string `json:"input"`
+		Dimensions int      `json:"dimensions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.record(Request{Path: r.URL.Path, Model: body.Model, Prompt: strings.Join(body.Input, "\n")})
+
+	embedder := s.fake
+	if body.Dimensions > 0 {
+		embedder = &llm.FakeProvider{Dim: body.Dimensions}
+	}
+	vectors, err := embedder.Embed(context.Background(), body.Input, llm.EmbedTaskDocument)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]any, len(vectors))
+	for i, v := range vectors {
+		data[i] = map[string]any{"index": i, "embedding": v}
+	}
+	writeJSON(w, map[string]any{"data": data})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,263 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultOpenAIBaseURL    = "https://api.openai.com/v1"
+	DefaultOpenAIModel      = "gpt-4o-mini"
+	DefaultOpenAIEmbedModel = "text-embedding-3-small"
+
+	// DefaultOpenAITimeout bounds a whole request, streamed body included,
+	// so it is as long as a resolution stream may run.
+	DefaultOpenAITimeout = 10 * time.Minute
+)
+
+// OpenAIProvider talks to any server implementing the OpenAI chat completions
+// and embeddings endpoints (OpenAI itself, vLLM, Ollama, LM Studio, ...).
+type OpenAIProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	defaults   Options
+	embedModel string
+	embedDim   int
+}
+
+// NewOpenAIProvider builds a provider for the server at baseURL. embedDim is
+// sent as the dimensions of every embedding request; 0 leaves it to the
+// model, which servers that do not support the parameter need.
+func NewOpenAIProvider(baseURL, apiKey string, defaults Options, embedModel string, embedDim int) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	if defaults.Model == "" {
+		defaults.Model = DefaultOpenAIModel
+	}
+	if embedModel == "" {
+		embedModel = DefaultOpenAIEmbedModel
+	}
+	return &OpenAIProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: DefaultOpenAITimeout},
+		defaults:   defaults,
+		embedModel: embedModel,
+		embedDim:   embedDim,
+	}
+}
+
+// WithTimeout returns a copy of the provider whose requests give up after d,
+// streamed body included.
+func (p *OpenAIProvider) WithTimeout(d time.Duration) *OpenAIProvider {
+	clone := *p
+	clone.httpClient = &http.Client{Timeout: d}
+	return &clone
+}
+
+func (p *OpenAIProvider) Name() string {
+	return "openai"
+}
+
+// DefaultModel names the model used when a request does not pick one.
+func (p *OpenAIProvider) DefaultModel() string {
+	return p.defaults.Model
+}
+
+// EmbedModel names the model behind Embed.
+func (p *OpenAIProvider) EmbedModel() string {
+	return p.embedModel
+}
+
+// EmbedDimensions is the size of the embeddings Embed requests, 0 if it
+// leaves it to the model.
+func (p *OpenAIProvider) EmbedDimensions() int {
+	return p.embedDim
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+	Strict bool           `json:"strict"`
+}
+
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    *float32              `json:"temperature,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+type openAIEmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+type openAIEmbedResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (p *OpenAIProvider) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (p *OpenAIProvider) chatRequest(prompt string, opts Options, stream bool) openAIChatRequest {
+	opts = opts.Merge(p.defaults)
+	// thinking budget has no OpenAI-compatible equivalent and is ignored
+	req := openAIChatRequest{
+		Model:       opts.Model,
+		Messages:    []openAIMessage{{Role: "user", Content: prompt}},
+		Temperature: opts.Temperature,
+		Stream:      stream,
+	}
+	if opts.ResponseSchema != nil {
+		req.ResponseFormat = &openAIResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &openAIJSONSchema{
+				Name:   "response",
+				Schema: opts.ResponseSchema,
+				Strict: true,
+			},
+		}
+	}
+	return req
+}
+
+func (p *OpenAIProvider) Generate(ctx context.Context, prompt string, opts Options) (string, error) {
+	resp, err := p.post(ctx, "/chat/completions", p.chatRequest(prompt, opts, false))
+	if err != nil {
+		return "", fmt.Errorf("chat completion failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("failed to decode chat completion: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return "", fmt.Errorf("chat completion returned no choices")
+	}
+	return out.Choices[0].Message.Content, nil
+}
+
+func (p *OpenAIProvider) Stream(ctx context.Context, prompt string, opts Options) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		resp, err := p.post(ctx, "/chat/completions", p.chatRequest(prompt, opts, true))
+		if err != nil {
+			yield("", fmt.Errorf("streaming error: %w", err))
+			return
+		}
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			data, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				return
+			}
+
+			var chunk openAIChatResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				yield("", fmt.Errorf("streaming error: %w", err))
+				return
+			}
+			if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+				continue
+			}
+			if !yield(chunk.Choices[0].Delta.Content, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield("", fmt.Errorf("streaming error: %w", err))
+		}
+	}
+}
+
+func (p *OpenAIProvider) Embed(ctx context.Context, texts []string, task EmbedTask) ([][]float64, error) {
+	resp, err := p.post(ctx, "/embeddings", openAIEmbedRequest{Model: p.embedModel, Input: texts, Dimensions: p.embedDim})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out openAIEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(out.Data))
+	}
+
+	embeddings := make([][]float64, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
+
+var _ LLMProvider = new(OpenAIProvider)
@@ -0,0 +1,254 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// openAIServer records the requests it gets and answers each path with the
+// handler registered for it.
+type openAIServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+type recordedRequest struct {
+	path          string
+	authorization string
+	body          map[string]any
+}
+
+func newOpenAIServer(t *testing.T, handlers map[string]http.HandlerFunc) *openAIServer {
+	t.Helper()
+	s := &openAIServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("%s: request is not JSON: %s", r.URL.Path, data)
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, recordedRequest{r.URL.Path, r.Header.Get("Authorization"), body})
+		s.mu.Unlock()
+
+		handler, ok := handlers[r.URL.Path]
+		if !ok || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *openAIServer) last(t *testing.T) recordedRequest {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		t.Fatal("no request was made")
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func completion(w http.ResponseWriter, _ *http.Request) {
+	fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"resolved"}}]}`)
+}
+
+func TestOpenAIGenerateTranslatesOptions(t *testing.T) {
+	server := newOpenAIServer(t, map[string]http.HandlerFunc{
+		"/v1/chat/completions": completion,
+	})
+	p := NewOpenAIProvider(server.URL+"/v1/", "secret", Options{Model: "default-model", Temperature: ptr(float32(0.25)), ThinkingBudget: ptr(int32(99))}, "", 0)
+
+	for _, tc := range []struct {
+		name           string
+		opts           Options
+		model          string
+		temperature    float64
+		responseFormat bool
+	}{
+		{name: "defaults", model: "default-model", temperature: 0.25},
+		{name: "overrides", opts: Options{Model: "other", Temperature: ptr(float32(0))}, model: "other", temperature: 0},
+		{name: "schema", opts: Options{ResponseSchema: map[string]any{"type": "object"}}, model: "default-model", temperature: 0.25, responseFormat: true},
+	} {
+		got, err := p.Generate(context.Background(), "fix it", tc.opts)
+		if err != nil || got != "resolved" {
+			t.Fatalf("%s: Generate = %q, %v", tc.name, got, err)
+		}
+
+		req := server.last(t)
+		if req.path != "/v1/chat/completions" || req.authorization != "Bearer secret" {
+			t.Errorf("%s: %s with authorization %q", tc.name, req.path, req.authorization)
+		}
+		body := req.body
+		if body["model"] != tc.model {
+			t.Errorf("%s: model %v, want %s", tc.name, body["model"], tc.model)
+		}
+		// a zero temperature is sent, not dropped
+		if body["temperature"] != tc.temperature {
+			t.Errorf("%s: temperature %v, want %v", tc.name, body["temperature"], tc.temperature)
+		}
+		if _, ok := body["stream"]; ok {
+			t.Errorf("%s: a blocking request asked for a stream", tc.name)
+		}
+		messages, _ := json.Marshal(body["messages"])
+		if string(messages) != `[{"content":"fix it","role":"user"}]` {
+			t.Errorf("%s: messages %s", tc.name, messages)
+		}
+		// the thinking budget has no OpenAI equivalent
+		for _, key := range []string{"thinking_budget", "thinking"} {
+			if _, ok := body[key]; ok {
+				t.Errorf("%s: sent %s", tc.name, key)
+			}
+		}
+
+		format, ok := body["response_format"].(map[string]any)
+		if ok != tc.responseFormat {
+			t.Errorf("%s: response_format %v", tc.name, body["response_format"])
+			continue
+		}
+		if ok {
+			schema, _ := format["json_schema"].(map[string]any)
+			if format["type"] != "json_schema" || schema["strict"] != true || schema["name"] == "" {
+				t.Errorf("%s: response_format %v", tc.name, format)
+			}
+			if inner, _ := schema["schema"].(map[string]any); inner["type"] != "object" {
+				t.Errorf("%s: schema %v", tc.name, schema["schema"])
+			}
+		}
+	}
+}
+
+func TestOpenAIGenerateErrors(t *testing.T) {
+	server := newOpenAIServer(t, map[string]http.HandlerFunc{
+		"/overloaded/chat/completions": func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "model overloaded", http.StatusServiceUnavailable)
+		},
+		"/empty/chat/completions": func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprint(w, `{"choices":[]}`)
+		},
+		"/garbled/chat/completions": func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprint(w, `{"choices":`)
+		},
+	})
+
+	for prefix, want := range map[string]string{
+		"/overloaded": "returned 503: model overloaded",
+		"/empty":      "no choices",
+		"/garbled":    "failed to decode",
+	} {
+		p := NewOpenAIProvider(server.URL+prefix, "", Options{}, "", 0)
+		if _, err := p.Generate(context.Background(), "fix it", Options{}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want %q", prefix, err, want)
+		}
+		if req := server.last(t); req.authorization != "" || req.body["model"] != DefaultOpenAIModel {
+			t.Errorf("%s: authorization %q, model %v", prefix, req.authorization, req.body["model"])
+		}
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	server := newOpenAIServer(t, map[string]http.HandlerFunc{
+		"/chat/completions": func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, line := range []string{
+				`: keep-alive`,
+				`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+				`data: {"choices":[{"delta":{"content":"re"}}]}`,
+				``,
+				`data:{"choices":[{"delta":{"content":"solved"}}]}`,
+				`data: {"choices":[]}`,
+				`data: [DONE]`,
+				`data: {"choices":[{"delta":{"content":"after done"}}]}`,
+			} {
+				fmt.Fprintln(w, line)
+			}
+		},
+	})
+	p := NewOpenAIProvider(server.URL, "", Options{}, "", 0)
+
+	var chunks []string
+	for text, err := range p.Stream(context.Background(), "fix it", Options{Model: "streamer"}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, text)
+	}
+	if !slices.Equal(chunks, []string{"re", "solved"}) {
+		t.Errorf("chunks %q", chunks)
+	}
+	if body := server.last(t).body; body["stream"] != true || body["model"] != "streamer" {
+		t.Errorf("request %v", body)
+	}
+}
+
+func TestOpenAIStreamErrors(t *testing.T) {
+	server := newOpenAIServer(t, map[string]http.HandlerFunc{
+		"/garbled/chat/completions": func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"ok"}}]}`)
+			fmt.Fprintln(w, `data: {"choices":`)
+		},
+	})
+
+	for prefix, want := range map[string]string{
+		"/garbled": "streaming error",
+		"/missing": "returned 404",
+	} {
+		p := NewOpenAIProvider(server.URL+prefix, "", Options{}, "", 0)
+		var last error
+		for _, err := range p.Stream(context.Background(), "fix it", Options{}) {
+			last = err
+		}
+		if last == nil || !strings.Contains(last.Error(), want) {
+			t.Errorf("%s: error %v, want %q", prefix, last, want)
+		}
+	}
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	server := newOpenAIServer(t, map[string]http.HandlerFunc{
+		"/embeddings": func(w http.ResponseWriter, _ *http.Request) {
+			// answered out of order, as the API allows
+			fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}]}`)
+		},
+	})
+
+	p := NewOpenAIProvider(server.URL, "", Options{}, "", 2)
+	got, err := p.Embed(context.Background(), []string{"first", "second"}, EmbedTaskDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !slices.Equal(got[0], []float64{0.1, 0.2}) || !slices.Equal(got[1], []float64{0.3, 0.4}) {
+		t.Errorf("embeddings %v", got)
+	}
+	body := server.last(t).body
+	input, _ := json.Marshal(body["input"])
+	if body["model"] != DefaultOpenAIEmbedModel || body["dimensions"] != 2.0 || string(input) != `["first","second"]` {
+		t.Errorf("request %v", body)
+	}
+
+	// 0 dimensions leaves the size to the model
+	if _, err := NewOpenAIProvider(server.URL, "", Options{}, "embedder", 0).Embed(context.Background(), []string{"a", "b"}, EmbedTaskQuery); err != nil {
+		t.Fatal(err)
+	}
+	if body := server.last(t).body; body["model"] != "embedder" || body["dimensions"] != nil {
+		t.Errorf("request %v", body)
+	}
+
+	// a count that does not match the input is refused
+	if _, err := p.Embed(context.Background(), []string{"only one"}, EmbedTaskQuery); err == nil || !strings.Contains(err.Error(), "expected 1 embeddings, got 2") {
+		t.Errorf("mismatched count: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package llm
+
+import (
+	"context"
+	"iter"
+)
+
+type EmbedTask string
+
+// DefaultEmbedDimensions is the embedding size requested when none is
+// configured. It is the size of the vector columns embeddings are stored in.
+const DefaultEmbedDimensions = 768
+
+const (
+	EmbedTaskDocument EmbedTask = "RETRIEVAL_DOCUMENT"
+	EmbedTaskQuery    EmbedTask = "RETRIEVAL_QUERY"
+)
+
+// Options tune a single generation. Zero values fall back to the provider's
+// deployment defaults, so a request only has to set what it wants to change.
+type Options struct {
+	Model          string   `json:"model,omitempty"`
+	Temperature    *float32 `json:"temperature,omitempty"`
+	ThinkingBudget *int32   `json:"thinking_budget,omitempty"`
+	// ResponseSchema, when set, asks for a JSON reply matching this JSON
+	// Schema. Callers must still validate the reply; not every model honours it.
+	ResponseSchema map[string]any `json:"-"`
+}
+
+// Merge returns o with any unset field taken from defaults.
+func (o Options) Merge(defaults Options) Options {
+	if o.Model == "" {
+		o.Model = defaults.Model
+	}
+	if o.Temperature == nil {
+		o.Temperature = defaults.Temperature
+	}
+	if o.ThinkingBudget == nil {
+		o.ThinkingBudget = defaults.ThinkingBudget
+	}
+	return o
+}
+
+// LLMProvider is the only way the rest of the server talks to a model.
+type LLMProvider interface {
+	Name() string
+	Generate(ctx context.Context, prompt string, opts Options) (string, error)
+	Stream(ctx context.Context, prompt string, opts Options) iter.Seq2[string, error]
+	Embed(ctx context.Context, texts []string, task EmbedTask) ([][]float64, error)
+}
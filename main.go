@@ -11,7 +11,8 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/tahminator/go-react-template/api"
 	"github.com/tahminator/go-react-template/database"
-	"google.golang.org/genai"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/llm"
 )
 
 const defaultPort = "8080"
@@ -43,23 +44,27 @@ func main() {
 	}
 	defer db.Close()
 
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		log.Fatalf("GEMINI_API_KEY environment variable is required")
+	provider, err := llm.NewFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to create LLM provider: %v", err)
+	}
+
+	embedder, err := rag.NewEmbedderFromEnv(provider)
+	if err != nil {
+		log.Fatalf("Failed to create embedder: %v", err)
+	}
+	if err := rag.CheckEmbedder(context.Background(), embedder); err != nil {
+		log.Fatalf("Embedding model does not match the database: %v", err)
 	}
 
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	weights, err := rag.RetrievalWeightsFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to create Gemini client: %v", err)
+		log.Fatalf("Failed to read retrieval weights: %v", err)
 	}
 
 	r := gin.Default()
 
-	api.NewRouter(r, db, client)
+	api.NewRouter(r, db, provider, embedder, weights)
 
 	if os.Getenv("ENV") == "production" {
 		r.Static("/", "./static")
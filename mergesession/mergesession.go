@@ -0,0 +1,601 @@
+// Package mergesession drives explicit merges of one branch into another.
+// A session starts the merge without committing it, the user resolves its
+// conflicts over any number of requests, and the session either completes,
+// committing the merge, or is aborted, leaving the target as it was.
+//
+// Each session merges in its own linked worktree of the user's clone, so a
+// user can have several merges of a repo in flight and none of them touch
+// the clone's working tree until one completes. The worktree is removed
+// when the session ends.
+package mergesession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/merge_session"
+	"github.com/tahminator/go-react-template/gitexec"
+	"github.com/tahminator/go-react-template/gitrepo"
+)
+
+var (
+	ErrInvalidBranch  = errors.New("invalid branch name")
+	ErrNoBranch       = errors.New("branch does not exist")
+	ErrDirtyTree      = errors.New("working tree has uncommitted changes")
+	ErrBusy           = errors.New("a merge, rebase, cherry-pick or revert is already in progress in the working tree")
+	ErrFinished       = errors.New("merge session has already finished")
+	ErrUnresolved     = errors.New("merge still has unresolved conflicts")
+	ErrTargetMoved    = errors.New("target branch has moved since the session started")
+	ErrNotStarted     = errors.New("merge session never started a merge; start a new one")
+	ErrNotMerging     = errors.New("no merge in progress; resume the session first")
+	ErrInvalidSession = errors.New("invalid session id")
+	ErrNoSession      = errors.New("merge session not found")
+)
+
+// State is a session together with what its working tree shows right now.
+type State struct {
+	*merge_session.MergeSession
+	// Merging is whether the merge is still in progress in the worktree.
+	Merging bool `json:"merging"`
+	// Conflicts lists the paths that are still unmerged.
+	Conflicts []string `json:"conflicts"`
+	// Incoming lists the paths the source branch changed since it forked
+	// from the target, whether they conflict or not.
+	Incoming []string `json:"incoming"`
+}
+
+// StartOptions names the clone to merge in and the branches to merge.
+type StartOptions struct {
+	UserId       uuid.UUID
+	Owner        string
+	Repo         string
+	SourceBranch string
+	TargetBranch string
+}
+
+// ClonePath is where a user's clone of owner/repo is on disk.
+func ClonePath(userId uuid.UUID, owner, repo string) string {
+	return filepath.Join("repos", userId.String(), owner, repo)
+}
+
+// WorktreePath is where a session's worktree is checked out. It is outside
+// the clone so that the clone's file tree never shows it.
+func WorktreePath(userId, sessionId uuid.UUID) string {
+	return filepath.Join("worktrees", userId.String(), sessionId.String())
+}
+
+type Manager struct {
+	sessions merge_session.MergeSessionRepository
+	indexer  rag.RepoIndexer
+}
+
+func NewManager(sessions merge_session.MergeSessionRepository, indexer rag.RepoIndexer) *Manager {
+	return &Manager{
+		sessions: sessions,
+		indexer:  indexer,
+	}
+}
+
+// Start records a session and merges the source branch into the target
+// without committing, in a new worktree at the target's commit. The source
+// is the remote-tracking branch when origin has one, so a branch can be
+// merged with its own upstream. A session whose merge could not be started
+// is returned with status failed; a source that is already merged
+// completes the session straight away.
+func (m *Manager) Start(ctx context.Context, opts StartOptions) (*State, error) {
+	clone := ClonePath(opts.UserId, opts.Owner, opts.Repo)
+	for _, branch := range []string{opts.SourceBranch, opts.TargetBranch} {
+		if err := checkBranchName(ctx, clone, branch); err != nil {
+			return nil, err
+		}
+	}
+
+	repo, err := gitrepo.Open(clone)
+	if err != nil {
+		return nil, err
+	}
+
+	// the row goes in first so two requests cannot both merge the same
+	// branches
+	session, err := m.sessions.CreateMergeSession(ctx, &merge_session.MergeSession{
+		UserId:       opts.UserId,
+		Owner:        opts.Owner,
+		Repo:         opts.Repo,
+		SourceBranch: opts.SourceBranch,
+		TargetBranch: opts.TargetBranch,
+		Status:       merge_session.StatusInProgress,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session.Worktree = WorktreePath(opts.UserId, session.Id)
+
+	// fetching and adding the worktree change the clone's refs; the merge
+	// itself only touches the worktree
+	unlock, err := gitrepo.Lock(ctx, clone)
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+	err = m.checkout(ctx, repo, session)
+	unlock()
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+
+	unlock, err = gitrepo.Lock(ctx, session.Worktree)
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+	defer unlock()
+	return m.merge(ctx, session)
+}
+
+// checkout fetches, records the commits a session merges and adds its
+// worktree at the base commit. A target that only origin has is created
+// locally, as `git checkout` would.
+func (m *Manager) checkout(ctx context.Context, repo gitrepo.GitRepo, session *merge_session.MergeSession) error {
+	if err := fetch(ctx, repo.Path()); err != nil {
+		return err
+	}
+
+	target := "refs/heads/" + session.TargetBranch
+	baseCommit, err := repo.ResolveRef(ctx, target)
+	if errors.Is(err, gitrepo.ErrNoRef) {
+		if res, err := gitexec.Run(ctx, repo.Path(), "branch", "--quiet", "--track", session.TargetBranch, "origin/"+session.TargetBranch); err != nil {
+			return fmt.Errorf("%s: %w: %s", session.TargetBranch, ErrNoBranch, strings.TrimSpace(res.Stderr))
+		}
+		baseCommit, err = repo.ResolveRef(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+	sourceCommit, err := resolveSource(ctx, repo, session.SourceBranch)
+	if err != nil {
+		return err
+	}
+	session.BaseCommit = &baseCommit
+	session.SourceCommit = &sourceCommit
+
+	return gitrepo.AddWorktree(ctx, repo.Path(), session.Worktree, baseCommit, "merge session "+session.Id.String())
+}
+
+// Get returns a session of the user and the state of its worktree, or nil
+// if the user has no such session.
+func (m *Manager) Get(ctx context.Context, userId, id uuid.UUID) (*State, error) {
+	session, err := m.sessions.GetMergeSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserId != userId {
+		return nil, nil
+	}
+	return m.state(ctx, session)
+}
+
+// List returns the user's sessions of a repo, newest first.
+func (m *Manager) List(ctx context.Context, userId uuid.UUID, owner, repo string) ([]merge_session.MergeSession, error) {
+	return m.sessions.GetMergeSessions(ctx, userId, owner, repo)
+}
+
+// Checkout returns the directory a request about the user's owner/repo
+// works in: the worktree of the session sessionId names, or the clone
+// itself when sessionId is empty. The session is nil for the clone. Only a
+// session in progress has a worktree.
+func (m *Manager) Checkout(ctx context.Context, userId uuid.UUID, owner, repo, sessionId string) (string, *merge_session.MergeSession, error) {
+	sessionId = strings.TrimSpace(sessionId)
+	if sessionId == "" {
+		return ClonePath(userId, owner, repo), nil, nil
+	}
+	id, err := uuid.Parse(sessionId)
+	if err != nil {
+		return "", nil, ErrInvalidSession
+	}
+	session, err := m.sessions.GetMergeSession(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if session == nil || session.UserId != userId || session.Owner != owner || session.Repo != repo {
+		return "", nil, ErrNoSession
+	}
+	if session.Status != merge_session.StatusInProgress {
+		return "", nil, ErrFinished
+	}
+	return session.Worktree, session, nil
+}
+
+// LockCheckout is Checkout for a request that writes to the directory. It
+// returns with the directory locked and, for a session, the session read
+// again under the lock, so one that finished meanwhile is refused with
+// ErrFinished rather than written into. The caller must call unlock.
+func (m *Manager) LockCheckout(ctx context.Context, userId uuid.UUID, owner, repo, sessionId string) (string, *merge_session.MergeSession, func(), error) {
+	dir, session, err := m.Checkout(ctx, userId, owner, repo, sessionId)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if session == nil {
+		unlock, err := gitrepo.Lock(ctx, dir)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		return dir, nil, unlock, nil
+	}
+	session, unlock, err := m.lockSession(ctx, session)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return session.Worktree, session, unlock, nil
+}
+
+// lockSession locks the worktree of a session in progress and returns the
+// session as it is once the lock is held.
+func (m *Manager) lockSession(ctx context.Context, session *merge_session.MergeSession) (*merge_session.MergeSession, func(), error) {
+	return m.lockSessionIn(ctx, session, merge_session.StatusInProgress)
+}
+
+// lockSessionIn is lockSession for a session in any of statuses.
+func (m *Manager) lockSessionIn(ctx context.Context, session *merge_session.MergeSession, statuses ...merge_session.Status) (*merge_session.MergeSession, func(), error) {
+	if !slices.Contains(statuses, session.Status) {
+		return nil, nil, ErrFinished
+	}
+	unlock, err := gitrepo.Lock(ctx, session.Worktree)
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := m.sessions.GetMergeSession(ctx, session.Id)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	if current == nil || !slices.Contains(statuses, current.Status) {
+		unlock()
+		return nil, nil, ErrFinished
+	}
+	return current, unlock, nil
+}
+
+// Abort stops a session's merge and removes its worktree. The target was
+// never touched, so no commit is undone.
+func (m *Manager) Abort(ctx context.Context, session *merge_session.MergeSession) (*State, error) {
+	session, unlock, err := m.lockSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return m.finish(ctx, session, merge_session.StatusAborted, nil)
+}
+
+// Resume picks a session back up. A merge still in progress is left as it
+// is; otherwise the recorded source commit is merged again in a fresh
+// worktree, provided the target has not moved since the session started.
+func (m *Manager) Resume(ctx context.Context, session *merge_session.MergeSession) (*State, error) {
+	session, unlock, err := m.lockSessionIn(ctx, session,
+		merge_session.StatusInProgress, merge_session.StatusAborted, merge_session.StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if session.BaseCommit == nil || session.SourceCommit == nil {
+		return nil, ErrNotStarted
+	}
+
+	if session.Status == merge_session.StatusInProgress {
+		if repo, err := gitrepo.Open(session.Worktree); err == nil {
+			if merging, err := repo.MidMerge(ctx); err != nil {
+				return nil, err
+			} else if merging {
+				return m.state(ctx, session)
+			}
+		}
+	}
+
+	clone := ClonePath(session.UserId, session.Owner, session.Repo)
+	repo, err := gitrepo.Open(clone)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTarget(ctx, repo, session); err != nil {
+		return nil, err
+	}
+
+	session.Status = merge_session.StatusInProgress
+	session.Error = nil
+	session.FinishedAt = nil
+	session.Worktree = WorktreePath(session.UserId, session.Id)
+	session, err = m.sessions.UpdateMergeSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockClone, err := gitrepo.Lock(ctx, clone)
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+	err = gitrepo.RemoveWorktree(ctx, clone, session.Worktree)
+	if err == nil {
+		err = gitrepo.AddWorktree(ctx, clone, session.Worktree, *session.BaseCommit, "merge session "+session.Id.String())
+	}
+	unlockClone()
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+	return m.merge(ctx, session)
+}
+
+// Complete commits a session's merge once every conflict is resolved and
+// moves the target branch to it. The clone's working tree follows when it
+// has the target checked out, which it must then have no changes to.
+func (m *Manager) Complete(ctx context.Context, session *merge_session.MergeSession) (*State, error) {
+	session, unlock, err := m.lockSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	repo, err := gitrepo.Open(session.Worktree)
+	if err != nil {
+		return nil, err
+	}
+	if merging, err := repo.MidMerge(ctx); err != nil {
+		return nil, err
+	} else if !merging {
+		return nil, ErrNotMerging
+	}
+	if unmerged, err := repo.Unmerged(ctx); err != nil {
+		return nil, err
+	} else if len(unmerged) > 0 {
+		return nil, ErrUnresolved
+	}
+
+	mergeCommit, err := commit(ctx, repo, session)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := m.finish(ctx, session, merge_session.StatusCompleted, &mergeCommit)
+	if err != nil {
+		return nil, err
+	}
+	m.index(ctx, session, ClonePath(session.UserId, session.Owner, session.Repo))
+	return state, nil
+}
+
+// commit commits the merge in a session's worktree and moves the target
+// branch of the clone to it.
+func commit(ctx context.Context, repo gitrepo.GitRepo, session *merge_session.MergeSession) (string, error) {
+	clone := ClonePath(session.UserId, session.Owner, session.Repo)
+	unlock, err := gitrepo.Lock(ctx, clone)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	cloneRepo, err := gitrepo.Open(clone)
+	if err != nil {
+		return "", err
+	}
+	if err := checkTarget(ctx, cloneRepo, session); err != nil {
+		return "", err
+	}
+	res, _ := gitexec.Run(ctx, clone, "symbolic-ref", "--quiet", "HEAD")
+	checkedOut := strings.TrimSpace(res.Stdout) == "refs/heads/"+session.TargetBranch
+	if checkedOut {
+		if err := checkClean(ctx, cloneRepo); err != nil {
+			return "", err
+		}
+	}
+
+	if res, err := gitexec.Run(ctx, repo.Path(), "commit", "--no-edit"); err != nil {
+		return "", fmt.Errorf("failed to commit merge: %s", strings.TrimSpace(res.Stderr))
+	}
+	mergeCommit, err := repo.ResolveRef(ctx, "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	// both only move the target if it is still at the base commit
+	if checkedOut {
+		res, err = gitexec.Run(ctx, clone, "merge", "--quiet", "--ff-only", mergeCommit)
+	} else {
+		res, err = gitexec.Run(ctx, clone, "update-ref", "refs/heads/"+session.TargetBranch, mergeCommit, *session.BaseCommit)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to move %s to the merge: %s", session.TargetBranch, strings.TrimSpace(res.Stderr))
+	}
+	return mergeCommit, nil
+}
+
+// merge runs the merge of a started session in its worktree and records how
+// it went.
+func (m *Manager) merge(ctx context.Context, session *merge_session.MergeSession) (*State, error) {
+	message := fmt.Sprintf("Merge branch '%s' into %s", session.SourceBranch, session.TargetBranch)
+	res, mergeErr := gitexec.Run(ctx, session.Worktree, "merge", "--no-ff", "--no-commit", "-m", message, *session.SourceCommit)
+
+	repo, err := gitrepo.Open(session.Worktree)
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+	merging, err := repo.MidMerge(ctx)
+	if err != nil {
+		return m.fail(ctx, session, err)
+	}
+	if !merging {
+		if mergeErr != nil {
+			return m.fail(ctx, session, fmt.Errorf("merge failed: %s", strings.TrimSpace(res.Stderr)))
+		}
+		// nothing to merge
+		return m.finish(ctx, session, merge_session.StatusCompleted, nil)
+	}
+
+	session, err = m.sessions.UpdateMergeSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	// conflicted files are indexed too, so retrieval is ready by the time
+	// the user opens one
+	m.index(ctx, session, session.Worktree)
+	return m.state(ctx, session)
+}
+
+// index re-indexes the repo of a session from the working tree at path.
+func (m *Manager) index(ctx context.Context, session *merge_session.MergeSession, path string) {
+	if _, err := m.indexer.Enqueue(ctx, rag.IndexJob{
+		UserId: session.UserId,
+		Owner:  session.Owner,
+		Repo:   session.Repo,
+		Path:   path,
+	}); err != nil {
+		log.Printf("failed to enqueue indexing for %s/%s: %v", session.Owner, session.Repo, err)
+	}
+}
+
+// finish ends a session and removes its worktree. A worktree that cannot be
+// removed is logged rather than keeping the session open.
+func (m *Manager) finish(ctx context.Context, session *merge_session.MergeSession, status merge_session.Status, mergeCommit *string) (*State, error) {
+	clone := ClonePath(session.UserId, session.Owner, session.Repo)
+	if session.Worktree != "" && filepath.Clean(session.Worktree) != filepath.Clean(clone) {
+		err := func() error {
+			unlock, err := gitrepo.Lock(ctx, clone)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			return gitrepo.RemoveWorktree(ctx, clone, session.Worktree)
+		}()
+		if err != nil {
+			log.Printf("failed to remove worktree of merge session %s: %v", session.Id, err)
+		}
+	}
+
+	now := time.Now()
+	session.Status = status
+	session.MergeCommit = mergeCommit
+	session.FinishedAt = &now
+	session, err := m.sessions.UpdateMergeSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return m.state(ctx, session)
+}
+
+// fail records why a session's merge could not go ahead. The session is
+// returned, not the error, since the failure is part of its state.
+func (m *Manager) fail(ctx context.Context, session *merge_session.MergeSession, cause error) (*State, error) {
+	message := gitexec.Redact(cause.Error())
+	session.Error = &message
+	return m.finish(ctx, session, merge_session.StatusFailed, nil)
+}
+
+func (m *Manager) state(ctx context.Context, session *merge_session.MergeSession) (*State, error) {
+	state := &State{MergeSession: session, Conflicts: []string{}, Incoming: []string{}}
+	if session.Status != merge_session.StatusInProgress {
+		return state, nil
+	}
+
+	repo, err := gitrepo.Open(session.Worktree)
+	if err != nil {
+		return nil, err
+	}
+	if state.Merging, err = repo.MidMerge(ctx); err != nil {
+		return nil, err
+	}
+	unmerged, err := repo.Unmerged(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range unmerged {
+		state.Conflicts = append(state.Conflicts, e.Path)
+	}
+	if session.BaseCommit != nil && session.SourceCommit != nil {
+		forkPoint, err := repo.MergeBase(ctx, *session.BaseCommit, *session.SourceCommit)
+		if err != nil {
+			return nil, err
+		}
+		incoming, err := repo.ChangedFiles(ctx, forkPoint, *session.SourceCommit)
+		if err != nil {
+			return nil, err
+		}
+		state.Incoming = append(state.Incoming, incoming...)
+	}
+	return state, nil
+}
+
+// checkBranchName rejects anything git would not accept as a branch name,
+// including names that would be read as options.
+func checkBranchName(ctx context.Context, dir, branch string) error {
+	if branch == "" || strings.HasPrefix(branch, "-") {
+		return fmt.Errorf("%q: %w", branch, ErrInvalidBranch)
+	}
+	if _, err := gitexec.Run(ctx, dir, "check-ref-format", "--branch", branch); err != nil {
+		return fmt.Errorf("%q: %w", branch, ErrInvalidBranch)
+	}
+	return nil
+}
+
+// checkTarget makes sure the target branch is still where the session
+// started from.
+func checkTarget(ctx context.Context, repo gitrepo.GitRepo, session *merge_session.MergeSession) error {
+	head, err := repo.ResolveRef(ctx, "refs/heads/"+session.TargetBranch)
+	if errors.Is(err, gitrepo.ErrNoRef) {
+		return fmt.Errorf("%s: %w", session.TargetBranch, ErrNoBranch)
+	}
+	if err != nil {
+		return err
+	}
+	if head != *session.BaseCommit {
+		return ErrTargetMoved
+	}
+	return nil
+}
+
+// checkClean makes sure the clone's working tree can follow a completed
+// merge without touching the user's changes. Untracked files are fine; git refuses to overwrite them.
+func checkClean(ctx context.Context, repo gitrepo.GitRepo) error {
+	if state, err := repo.InProgress(ctx); err != nil {
+		return err
+	} else if state != nil {
+		return ErrBusy
+	}
+	status, err := repo.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		if s.Staging != " " && s.Staging != "?" || s.Worktree != " " && s.Worktree != "?" {
+			return ErrDirtyTree
+		}
+	}
+	return nil
+}
+
+// fetch updates the remote-tracking branches. Clones are shallow, and a
+// merge needs the history back to the merge base.
+func fetch(ctx context.Context, dir string) error {
+	args := []string{"fetch", "--quiet", "origin"}
+	if res, err := gitexec.Run(ctx, dir, "rev-parse", "--is-shallow-repository"); err == nil && strings.TrimSpace(res.Stdout) == "true" {
+		args = append(args, "--unshallow")
+	}
+	if res, err := gitexec.Run(ctx, dir, args...); err != nil {
+		return fmt.Errorf("git fetch failed: %s", strings.TrimSpace(res.Stderr))
+	}
+	return nil
+}
+
+// resolveSource prefers origin's copy of a branch over the local one.
+func resolveSource(ctx context.Context, repo gitrepo.GitRepo, branch string) (string, error) {
+	hash, err := repo.ResolveRef(ctx, "refs/remotes/origin/"+branch)
+	if errors.Is(err, gitrepo.ErrNoRef) {
+		hash, err = repo.ResolveRef(ctx, "refs/heads/"+branch)
+	}
+	if errors.Is(err, gitrepo.ErrNoRef) {
+		return "", fmt.Errorf("%s: %w", branch, ErrNoBranch)
+	}
+	return hash, err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedResolutionsBecomeExamples(t *testing.T) {
+	h, session := newMergeHarness(t, nil, resolvedHunk)
+	index := h.waitIndexed()
+
+	// the model proposed keeping ours; the person merged both sides instead
+	proposal := strings.Replace(resolvedFile, `"hi, " + name + "!"`, `"hi " + name`, 1)
+	w := h.do(http.MethodPost, "/api/github/merge/accept", map[string]any{
+		"newFileData": resolvedFile,
+		"fullPath":    "main.go",
+		"repoName":    testRepoName,
+		"sessionId":   session.Id,
+		"proposal":    proposal,
+	})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"learned":1`) {
+		t.Fatalf("accept: status %d: %s", w.Code, w.Body.String())
+	}
+	if len(h.accepted.resolutions) != 1 {
+		t.Fatalf("expected one accepted resolution, got %+v", h.accepted.resolutions)
+	}
+	got := h.accepted.resolutions[0]
+	if got.RepoId != index.Id || got.Source != "main.go" || got.Author != testGithubUser ||
+		got.Ours != "\treturn \"hi \" + name\n" || got.Final != resolvedHunk ||
+		got.Proposal == nil || *got.Proposal != got.Ours || !got.Edited {
+		t.Errorf("accepted resolution = %+v", got)
+	}
+
+	prompt := func() string {
+		t.Helper()
+		w := h.do(http.MethodPost, "/api/gemini/resolve-hunks", map[string]any{
+			"conflict_content": conflictedFile,
+			"file_path":        "main.go",
+			"repo_id":          index.Id.String(),
+			"use_cache":        false,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("resolve-hunks: status %d: %s", w.Code, w.Body.String())
+		}
+		reqs := h.llm.Requests()
+		return reqs[len(reqs)-1].Prompt
+	}
+
+	p := prompt()
+	for _, want := range []string{
+		"PREVIOUSLY ACCEPTED RESOLUTIONS IN THIS REPO:",
+		"Example 1 from main.go, accepted after editing the suggested resolution:",
+		"ACCEPTED:\n\treturn \"hi, \" + name + \"!\"\n",
+	} {
+		if !strings.Contains(p, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, p)
+		}
+	}
+	if strings.Contains(p, "accepted by") || strings.Contains(p, testGithubUser+" ") {
+		t.Errorf("the prompt names who accepted the example:\n%s", p)
+	}
+
+	w = h.do(http.MethodPut, "/api/github/index/settings", map[string]any{
+		"repoName":          testRepoName,
+		"learnFromAccepted": false,
+	})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"learn_from_accepted":false`) {
+		t.Fatalf("settings: status %d: %s", w.Code, w.Body.String())
+	}
+	if p := prompt(); strings.Contains(p, "PREVIOUSLY ACCEPTED RESOLUTIONS") {
+		t.Errorf("examples must not be used with learning turned off:\n%s", p)
+	}
+
+	w = h.do(http.MethodPost, "/api/github/merge/accept", map[string]any{
+		"newFileData":     resolvedFile,
+		"fullPath":        "main.go",
+		"repoName":        testRepoName,
+		"sessionId":       session.Id,
+		"conflictContent": conflictedFile,
+	})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"learned":0`) {
+		t.Fatalf("accept with learning off: status %d: %s", w.Code, w.Body.String())
+	}
+}
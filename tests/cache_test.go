@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/api/gemini"
+	"github.com/tahminator/go-react-template/database/repository/session"
+)
+
+func TestResolveHunksReusesCachedResolutions(t *testing.T) {
+	h, _ := newMergeHarness(t, nil, resolvedHunk)
+	index := h.waitIndexed()
+
+	resolveIn := func(s *session.Session, repoId uuid.UUID, content, model string) gemini.HunkResolution {
+		t.Helper()
+		w := h.doAs(s, http.MethodPost, "/api/gemini/resolve-hunks", map[string]any{
+			"conflict_content": content,
+			"file_path":        "main.go",
+			"repo_id":          repoId.String(),
+			"model":            model,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("resolve-hunks: status %d: %s", w.Code, w.Body.String())
+		}
+		var body struct {
+			Payload gemini.HunkResolution `json:"payload"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Payload.Content != strings.ReplaceAll(resolvedFile, "\n", lineEnding(content)) {
+			t.Errorf("content:\n%q", body.Payload.Content)
+		}
+		return body.Payload
+	}
+	resolve := func(content, model string) gemini.HunkResolution {
+		t.Helper()
+		return resolveIn(h.session, index.Id, content, model)
+	}
+	completions := func() int {
+		n := 0
+		for _, r := range h.llm.Requests() {
+			if strings.HasSuffix(r.Path, "/chat/completions") {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := resolve(conflictedFile, ""); got.CacheHits != 0 || got.ModelResolved != 1 || got.Hunks[0].Cached {
+		t.Fatalf("first resolution cannot be cached: %+v", got)
+	}
+	// the same hunk again, also with other line endings, costs no model call
+	for _, content := range []string{conflictedFile, strings.ReplaceAll(conflictedFile, "\n", "\r\n")} {
+		got := resolve(content, "")
+		if got.CacheHits != 1 || !got.Hunks[0].Cached || got.Hunks[0].Strategy != gemini.StrategyAI {
+			t.Errorf("expected a cache hit, got %+v", got.Hunks[0])
+		}
+		if got.ModelResolved != 0 {
+			t.Errorf("a cache hit was counted as resolved by the model: %+v", got)
+		}
+	}
+	// a teammate's index of the same repo shares the cache
+	mate, mateSession := h.login("teammate")
+	teammate, _ := h.index.MarkPending(context.Background(), mate.Id, testGithubUser, testRepoName)
+	if got := resolveIn(mateSession, teammate.Id, conflictedFile, ""); got.CacheHits != 1 {
+		t.Errorf("a teammate must hit the cache, got %+v", got.Hunks[0])
+	}
+	if n := completions(); n != 1 {
+		t.Errorf("expected 1 completion, got %d", n)
+	}
+
+	// another model is a different key
+	if got := resolve(conflictedFile, "other-model"); got.CacheHits != 0 {
+		t.Errorf("a different model must miss the cache")
+	}
+
+	// purging is shared too, so it takes a login, the caller's own index and
+	// the repo's owner
+	req := httptest.NewRequest(http.MethodDelete, "/api/gemini/cache?repo_id="+index.Id.String(), nil)
+	w := httptest.NewRecorder()
+	h.engine.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous purge: status %d", w.Code)
+	}
+	if w := h.do(http.MethodDelete, "/api/gemini/cache?repo_id="+teammate.Id.String(), nil); w.Code != http.StatusNotFound {
+		t.Errorf("purge of a teammate's index: status %d", w.Code)
+	}
+	if w := h.doAs(mateSession, http.MethodDelete, "/api/gemini/cache?repo_id="+teammate.Id.String(), nil); w.Code != http.StatusForbidden {
+		t.Errorf("purge by a teammate who does not own the repo: status %d", w.Code)
+	}
+	if h.cache.size() != 2 {
+		t.Fatalf("refused purges must keep the cache, have %d entries", h.cache.size())
+	}
+
+	w = h.do(http.MethodDelete, "/api/gemini/cache?repo_id="+index.Id.String(), nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":2`) {
+		t.Fatalf("purge: status %d: %s", w.Code, w.Body.String())
+	}
+	if got := resolve(conflictedFile, ""); got.CacheHits != 0 {
+		t.Errorf("purged entries must not be reused")
+	}
+	if n := completions(); n != 3 {
+		t.Errorf("expected 3 completions, got %d", n)
+	}
+
+	// entries left unused for CacheMaxAge are evicted by the next write
+	h.cache.age(gemini.CacheMaxAge + time.Hour)
+	resolve(conflictedFile, "other-model")
+	if n := h.cache.size(); n != 1 {
+		t.Errorf("expected only the new entry to be kept, have %d", n)
+	}
+}
+
+func lineEnding(content string) string {
+	if strings.Contains(content, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
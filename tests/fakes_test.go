@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
+	"github.com/tahminator/go-react-template/database/repository/merge_session"
+	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
+	"github.com/tahminator/go-react-template/database/repository/session"
+	"github.com/tahminator/go-react-template/database/repository/user"
+)
+
+// --- in-memory repositories
+
+type memUsers struct{ users map[uuid.UUID]*user.User }
+
+func (m *memUsers) CreateUser(ctx context.Context, u *user.User) (*user.User, error) {
+	u.Id = uuid.New()
+	m.users[u.Id] = u
+	return u, nil
+}
+
+func (m *memUsers) UpdateUser(ctx context.Context, u *user.User) (*user.User, error) {
+	m.users[u.Id] = u
+	return u, nil
+}
+
+func (m *memUsers) GetUserById(ctx context.Context, id uuid.UUID) (*user.User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return u, nil
+}
+
+func (m *memUsers) GetUserByGoogleId(ctx context.Context, googleId string) (*user.User, error) {
+	for _, u := range m.users {
+		if u.GoogleId == googleId {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (m *memUsers) DeleteUserById(ctx context.Context, u *user.User) (*user.User, error) {
+	delete(m.users, u.Id)
+	return u, nil
+}
+
+type memSessions struct {
+	sessions map[uuid.UUID]*session.Session
+}
+
+func (m *memSessions) CreateSession(ctx context.Context, s *session.Session) (*session.Session, error) {
+	s.Id = uuid.New()
+	m.sessions[s.Id] = s
+	return s, nil
+}
+
+func (m *memSessions) UpdateSession(ctx context.Context, s *session.Session) (*session.Session, error) {
+	m.sessions[s.Id] = s
+	return s, nil
+}
+
+func (m *memSessions) GetSessionById(ctx context.Context, id uuid.UUID) (*session.Session, error) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session not found")
+	}
+	return s, nil
+}
+
+func (m *memSessions) GetSessionsByUserId(ctx context.Context) (*[]session.Session, error) {
+	var out []session.Session
+	for _, s := range m.sessions {
+		out = append(out, *s)
+	}
+	return &out, nil
+}
+
+func (m *memSessions) DeleteSession(ctx context.Context, s *session.Session) (*session.Session, error) {
+	delete(m.sessions, s.Id)
+	return s, nil
+}
+
+// memChunks stores inserted chunks. Vector similarity is not emulated;
+// lexical search ranks chunks by how many terms they mention as whole words.
+type memChunks struct {
+	mu     sync.Mutex
+	chunks []repo_chunks.RepoChunk
+}
+
+func (m *memChunks) ReplaceFileChunks(ctx context.Context, repoId uuid.UUID, source string, chunks []repo_chunks.RepoChunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunks = slices.DeleteFunc(m.chunks, func(c repo_chunks.RepoChunk) bool { return c.RepoId == repoId && c.Source == source })
+	m.chunks = append(m.chunks, chunks...)
+	return nil
+}
+
+func (m *memChunks) GetConflictChunks(ctx context.Context, repoId uuid.UUID) ([]repo_chunks.SimilarChunk, error) {
+	return nil, nil
+}
+
+func (m *memChunks) GetContextChunks(ctx context.Context, repoId uuid.UUID, source string, lineStart, lineEnd, contextLines int) ([]repo_chunks.SimilarChunk, error) {
+	return nil, nil
+}
+
+func (m *memChunks) GetSimilarChunks(ctx context.Context, query string, repoId uuid.UUID, k int) ([]repo_chunks.SimilarChunk, error) {
+	return nil, nil
+}
+
+func (m *memChunks) GetSimilarChunksWithThreshold(ctx context.Context, query string, repoId uuid.UUID, k int, maxDistance float64) ([]repo_chunks.SimilarChunk, error) {
+	return nil, nil
+}
+
+func (m *memChunks) GetSimilarFunctions(ctx context.Context, query string, repoId uuid.UUID, k int) ([]repo_chunks.SimilarChunk, error) {
+	return nil, nil
+}
+
+func (m *memChunks) GetLexicalChunks(ctx context.Context, repoId uuid.UUID, terms []string, k int) ([]repo_chunks.SimilarChunk, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []repo_chunks.SimilarChunk
+	for _, c := range m.chunks {
+		if c.RepoId != repoId {
+			continue
+		}
+		words := strings.FieldsFunc(strings.ToLower(c.Symbol+" "+c.Chunk), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		score := 0
+		for _, t := range terms {
+			if slices.Contains(words, strings.ToLower(t)) {
+				score++
+			}
+		}
+		if score > 0 {
+			out = append(out, repo_chunks.SimilarChunk{
+				Source:          c.Source,
+				Chunk:           c.Chunk,
+				FileType:        c.FileType,
+				ConflictSection: c.ConflictSection,
+				LineStart:       c.LineStart,
+				LineEnd:         c.LineEnd,
+				ChunkType:       c.ChunkType,
+				Symbol:          c.Symbol,
+				Score:           float64(score),
+			})
+		}
+	}
+	slices.SortStableFunc(out, func(a, b repo_chunks.SimilarChunk) int { return int(b.Score - a.Score) })
+	return out[:min(k, len(out))], nil
+}
+
+func (m *memChunks) GetRepoChunksCount(ctx context.Context, repoId uuid.UUID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, c := range m.chunks {
+		if c.RepoId == repoId {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memChunks) GetRepoEmbedders(ctx context.Context, repoId uuid.UUID) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []string
+	for _, c := range m.chunks {
+		if c.RepoId == repoId && !slices.Contains(out, c.Embedder) {
+			out = append(out, c.Embedder)
+		}
+	}
+	return out, nil
+}
+
+func (m *memChunks) GetFileBlobs(ctx context.Context, repoId uuid.UUID) ([]repo_chunks.FileBlob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []repo_chunks.FileBlob
+	for _, c := range m.chunks {
+		b := repo_chunks.FileBlob{Source: c.Source, BlobSha: c.BlobSha, Embedder: c.Embedder}
+		if c.RepoId == repoId && !slices.Contains(out, b) {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func (m *memChunks) DeleteFileChunks(ctx context.Context, repoId uuid.UUID, source string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunks = slices.DeleteFunc(m.chunks, func(c repo_chunks.RepoChunk) bool { return c.RepoId == repoId && c.Source == source })
+	return nil
+}
+
+func (m *memChunks) DeleteRepoChunks(ctx context.Context, repoId uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunks = slices.DeleteFunc(m.chunks, func(c repo_chunks.RepoChunk) bool { return c.RepoId == repoId })
+	return nil
+}
+
+func (m *memChunks) sources() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []string
+	for _, c := range m.chunks {
+		if !slices.Contains(out, c.Source) {
+			out = append(out, c.Source)
+		}
+	}
+	return out
+}
+
+type memIndex struct {
+	mu   sync.Mutex
+	rows map[string]*repo_index.RepoIndex
+}
+
+func (m *memIndex) MarkPending(ctx context.Context, userId uuid.UUID, owner, repo string) (*repo_index.RepoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := userId.String() + "/" + owner + "/" + repo
+	row, ok := m.rows[key]
+	if !ok {
+		row = &repo_index.RepoIndex{Id: uuid.New(), UserId: userId, Owner: owner, Repo: repo, LearnFromAccepted: true}
+		m.rows[key] = row
+	}
+	row.Status = repo_index.StatusPending
+	row.Error = nil
+	row.QueuedAt = time.Now()
+	out := *row
+	return &out, nil
+}
+
+func (m *memIndex) UpdateRepoIndex(ctx context.Context, index *repo_index.RepoIndex) (*repo_index.RepoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := index.UserId.String() + "/" + index.Owner + "/" + index.Repo
+	row := *index
+	// settings are only changed through SetLearnFromAccepted
+	if old, ok := m.rows[key]; ok {
+		row.LearnFromAccepted = old.LearnFromAccepted
+	}
+	m.rows[key] = &row
+	out := row
+	return &out, nil
+}
+
+func (m *memIndex) FinishRepoIndex(ctx context.Context, index *repo_index.RepoIndex) (*repo_index.RepoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := index.UserId.String() + "/" + index.Owner + "/" + index.Repo
+	old, ok := m.rows[key]
+	if !ok || old.Status != repo_index.StatusRunning || old.StartedAt == nil || index.StartedAt == nil || !old.StartedAt.Equal(*index.StartedAt) {
+		return nil, nil
+	}
+	row := *index
+	row.LearnFromAccepted = old.LearnFromAccepted
+	m.rows[key] = &row
+	out := row
+	return &out, nil
+}
+
+func (m *memIndex) GetRepoIndex(ctx context.Context, userId uuid.UUID, owner, repo string) (*repo_index.RepoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row, ok := m.rows[userId.String()+"/"+owner+"/"+repo]
+	if !ok {
+		return nil, nil
+	}
+	out := *row
+	return &out, nil
+}
+
+func (m *memIndex) GetRepoIndexById(ctx context.Context, id uuid.UUID) (*repo_index.RepoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, row := range m.rows {
+		if row.Id == id {
+			out := *row
+			return &out, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memIndex) SetLearnFromAccepted(ctx context.Context, id uuid.UUID, enabled bool) (*repo_index.RepoIndex, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, row := range m.rows {
+		if row.Id == id {
+			row.LearnFromAccepted = enabled
+			out := *row
+			return &out, nil
+		}
+	}
+	return nil, fmt.Errorf("no repo index %s", id)
+}
+
+func (m *memIndex) learning(id uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, row := range m.rows {
+		if row.Id == id {
+			return row.LearnFromAccepted
+		}
+	}
+	return false
+}
+
+// memSymbols holds the symbol tables from the last build.
+type memSymbols struct {
+	mu    sync.Mutex
+	files map[uuid.UUID][]repo_symbols.SymbolFile
+	defs  map[uuid.UUID][]repo_symbols.Definition
+	refs  map[uuid.UUID][]repo_symbols.Reference
+}
+
+func (m *memSymbols) GetSymbolFiles(ctx context.Context, repoId uuid.UUID) ([]repo_symbols.SymbolFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files[repoId], nil
+}
+
+func (m *memSymbols) ReplaceRepoSymbols(ctx context.Context, repoId uuid.UUID, files []repo_symbols.SymbolFile, defs []repo_symbols.Definition, refs []repo_symbols.Reference) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[repoId], m.defs[repoId], m.refs[repoId] = files, defs, refs
+	return nil
+}
+
+func (m *memSymbols) GetDefinitions(ctx context.Context, repoId uuid.UUID, names []string, limit int) ([]repo_symbols.Definition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []repo_symbols.Definition
+	for _, d := range m.defs[repoId] {
+		if len(out) < limit && (slices.Contains(names, d.Name) || slices.Contains(names, d.ShortName)) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (m *memSymbols) GetReferences(ctx context.Context, repoId uuid.UUID, dir string, names []string, limit int) ([]repo_symbols.Reference, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []repo_symbols.Reference
+	for _, r := range m.refs[repoId] {
+		if len(out) < limit && r.DefDir == dir && slices.Contains(names, r.DefName) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// memCache is the resolution cache, keyed by owner/repo and fingerprint.
+type memCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]resolution_cache.Resolution
+}
+
+func (m *memCache) GetResolutions(ctx context.Context, owner, repo string, fingerprints []string) ([]resolution_cache.Resolution, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := owner + "/" + repo
+	var out []resolution_cache.Resolution
+	for _, f := range fingerprints {
+		if r, ok := m.entries[key][f]; ok {
+			now := time.Now()
+			r.Hits++
+			r.LastHitAt = &now
+			m.entries[key][f] = r
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (m *memCache) PutResolution(ctx context.Context, r *resolution_cache.Resolution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := r.Owner + "/" + r.Repo
+	if m.entries[key] == nil {
+		m.entries[key] = map[string]resolution_cache.Resolution{}
+	}
+	r.Id = uuid.New()
+	r.CreatedAt = time.Now()
+	m.entries[key][r.Fingerprint] = *r
+	return nil
+}
+
+func (m *memCache) DeleteRepoResolutions(ctx context.Context, owner, repo string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.entries[owner+"/"+repo])
+	delete(m.entries, owner+"/"+repo)
+	return int64(n), nil
+}
+
+func (m *memCache) EvictResolutions(ctx context.Context, owner, repo string, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for f, r := range m.entries[owner+"/"+repo] {
+		used := r.CreatedAt
+		if r.LastHitAt != nil {
+			used = *r.LastHitAt
+		}
+		if used.Before(before) {
+			delete(m.entries[owner+"/"+repo], f)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// age moves every entry back by d, as if it was stored and last hit d ago.
+func (m *memCache) age(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entries := range m.entries {
+		for f, r := range entries {
+			r.CreatedAt = r.CreatedAt.Add(-d)
+			if r.LastHitAt != nil {
+				used := r.LastHitAt.Add(-d)
+				r.LastHitAt = &used
+			}
+			entries[f] = r
+		}
+	}
+}
+
+func (m *memCache) size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, entries := range m.entries {
+		n += len(entries)
+	}
+	return n
+}
+
+// memAccepted returns a repo's accepted resolutions newest first rather than
+// by similarity, honouring the repo's learning toggle in index.
+type memAccepted struct {
+	mu          sync.Mutex
+	index       *memIndex
+	resolutions []accepted_resolutions.AcceptedResolution
+}
+
+func (m *memAccepted) InsertResolutions(ctx context.Context, resolutions []accepted_resolutions.AcceptedResolution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range resolutions {
+		r.Id = uuid.New()
+		r.CreatedAt = time.Now()
+		m.resolutions = append(m.resolutions, r)
+	}
+	return nil
+}
+
+func (m *memAccepted) GetSimilarResolutions(ctx context.Context, repoId uuid.UUID, query string, k int) ([]accepted_resolutions.SimilarResolution, error) {
+	if !m.index.learning(repoId) {
+		return nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []accepted_resolutions.SimilarResolution
+	for _, r := range slices.Backward(m.resolutions) {
+		if r.RepoId == repoId && len(out) < k {
+			out = append(out, accepted_resolutions.SimilarResolution{AcceptedResolution: r})
+		}
+	}
+	return out, nil
+}
+
+// memMergeSessions keeps at most one session in progress per pair of
+// branches, as the partial unique index does.
+type memMergeSessions struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*merge_session.MergeSession
+}
+
+func (m *memMergeSessions) inProgress(s *merge_session.MergeSession) bool {
+	for _, other := range m.sessions {
+		if other.Id != s.Id && other.UserId == s.UserId && other.Owner == s.Owner && other.Repo == s.Repo &&
+			other.SourceBranch == s.SourceBranch && other.TargetBranch == s.TargetBranch &&
+			other.Status == merge_session.StatusInProgress {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memMergeSessions) CreateMergeSession(ctx context.Context, s *merge_session.MergeSession) (*merge_session.MergeSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inProgress(s) {
+		return nil, merge_session.ErrSessionInProgress
+	}
+	created := *s
+	created.Id = uuid.New()
+	created.CreatedAt = time.Now()
+	created.UpdatedAt = created.CreatedAt
+	m.sessions[created.Id] = &created
+	out := created
+	return &out, nil
+}
+
+func (m *memMergeSessions) UpdateMergeSession(ctx context.Context, s *merge_session.MergeSession) (*merge_session.MergeSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.Status == merge_session.StatusInProgress && m.inProgress(s) {
+		return nil, merge_session.ErrSessionInProgress
+	}
+	updated := *s
+	updated.UpdatedAt = time.Now()
+	m.sessions[s.Id] = &updated
+	out := updated
+	return &out, nil
+}
+
+func (m *memMergeSessions) GetMergeSession(ctx context.Context, id uuid.UUID) (*merge_session.MergeSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	out := *s
+	return &out, nil
+}
+
+func (m *memMergeSessions) GetMergeSessions(ctx context.Context, userId uuid.UUID, owner, repo string) ([]merge_session.MergeSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []merge_session.MergeSession
+	for _, s := range m.sessions {
+		if s.UserId == userId && s.Owner == owner && s.Repo == repo {
+			out = append(out, *s)
+		}
+	}
+	slices.SortFunc(out, func(a, b merge_session.MergeSession) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return out, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/gitrepo"
+)
+
+func TestFileTreeReportsConflicts(t *testing.T) {
+	h := newHarness(t)
+	h.setupConflict()
+
+	tree := func(query string) []any {
+		t.Helper()
+		w := h.do(http.MethodGet, "/api/file/tree/generate?repoName="+testRepoName+query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("tree: status %d: %s", w.Code, w.Body.String())
+		}
+		var nodes []any
+		if err := json.Unmarshal(w.Body.Bytes(), &nodes); err != nil {
+			t.Fatalf("tree: %v", err)
+		}
+		return nodes
+	}
+
+	// reading the tree must not start a merge
+	if mainGo := findFile(tree(""), "main.go"); mainGo == nil || mainGo["isConflicted"] != false {
+		t.Errorf("main.go should be clean before a merge starts: %v", mainGo)
+	}
+	if _, err := os.Stat(filepath.Join(h.repoPath, ".git", "MERGE_HEAD")); !os.IsNotExist(err) {
+		t.Fatalf("tree read left a merge in progress: %v", err)
+	}
+
+	session := h.startMerge()
+	nodes := tree("&sessionId=" + session.Id.String())
+	mainGo := findFile(nodes, "main.go")
+	if mainGo == nil {
+		t.Fatalf("main.go missing from tree: %v", nodes)
+	}
+	if mainGo["isConflicted"] != true {
+		t.Errorf("main.go should be conflicted: %v", mainGo)
+	}
+	if readme := findFile(nodes, "README.md"); readme == nil || readme["isConflicted"] != false {
+		t.Errorf("README.md should be present and clean: %v", readme)
+	}
+
+	if w := h.do(http.MethodGet, "/api/file/tree/generate?repoName="+testRepoName+"&sessionId="+uuid.NewString(), nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConflictSidesComeFromTheIndex(t *testing.T) {
+	h, session := newMergeHarness(t, nil)
+	query := "?repoName=" + testRepoName + "&sessionId=" + session.Id.String()
+
+	// a bad hand edit must not lose the original versions
+	h.writeFile(h.worktree(session), "main.go", "garbage\n")
+
+	w := h.do(http.MethodGet, "/api/file/conflict/main.go"+query, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("conflict: status %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Payload gitrepo.Conflict `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	got := body.Payload
+	if got.Base == nil || got.Ours == nil || got.Theirs == nil {
+		t.Fatalf("expected all three sides: %s", w.Body.String())
+	}
+	for _, side := range []struct {
+		name    string
+		side    *gitrepo.Side
+		content string
+		message string
+	}{
+		{"base", got.Base, baseFile, "base"},
+		{"ours", got.Ours, oursFile, "ours"},
+		{"theirs", got.Theirs, theirsFile, "theirs"},
+	} {
+		if side.side.Content != side.content {
+			t.Errorf("%s content:\n%s", side.name, side.side.Content)
+		}
+		if side.side.Commit == nil || side.side.Commit.Message != side.message {
+			t.Errorf("%s commit = %+v", side.name, side.side.Commit)
+		}
+	}
+
+	if w := h.do(http.MethodGet, "/api/file/conflict/README.md"+query, nil); w.Code != http.StatusNotFound {
+		t.Errorf("clean file: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := h.do(http.MethodGet, "/api/file/conflict/main.go?repoName="+testRepoName, nil); w.Code != http.StatusNotFound {
+		t.Errorf("the clone has no conflict: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := h.do(http.MethodGet, "/api/file/conflict/../../secret"+query, nil); w.Code == http.StatusOK {
+		t.Errorf("path outside the repo must be rejected: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/api/file"
+	"github.com/tahminator/go-react-template/api/gemini"
+	"github.com/tahminator/go-react-template/api/github"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/merge_session"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
+	"github.com/tahminator/go-react-template/database/repository/session"
+	"github.com/tahminator/go-react-template/database/repository/user"
+	"github.com/tahminator/go-react-template/llm/llmtest"
+	"github.com/tahminator/go-react-template/mergesession"
+)
+
+const (
+	testGithubUser = "octocat"
+	testRepoName   = "demo"
+)
+
+const baseFile = `package demo
+
+func Greet(name string) string {
+	return "hello " + name
+}
+`
+
+const oursFile = `package demo
+
+func Greet(name string) string {
+	return "hi " + name
+}
+`
+
+const theirsFile = `package demo
+
+func Greet(name string) string {
+	return "hello, " + name + "!"
+}
+`
+
+const resolvedFile = `package demo
+
+func Greet(name string) string {
+	return "hi, " + name + "!"
+}
+`
+
+// resolvedHunk is the model's resolution of the one hunk in conflictedFile.
+const resolvedHunk = "\treturn \"hi, \" + name + \"!\"\n"
+
+const conflictedFile = `package demo
+
+func Greet(name string) string {
+<<<<<<< HEAD
+	return "hi " + name
+=======
+	return "hello, " + name + "!"
+>>>>>>> origin/main
+}
+`
+
+type harness struct {
+	t        *testing.T
+	engine   *gin.Engine
+	llm      *llmtest.Server
+	chunks   *memChunks
+	symbols  *memSymbols
+	index    *memIndex
+	cache    *memCache
+	accepted *memAccepted
+	merges   *memMergeSessions
+	users    *memUsers
+	sessions *memSessions
+	user     *user.User
+	session  *session.Session
+	repoPath string
+	origin   string
+}
+
+func newHarness(t *testing.T, responses ...string) *harness {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	gin.SetMode(gin.TestMode)
+
+	// every endpoint resolves repos relative to the working directory
+	t.Chdir(t.TempDir())
+	for _, k := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(k, "Delta Test")
+	}
+	for _, k := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(k, "delta@example.com")
+	}
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+
+	users := &memUsers{users: map[uuid.UUID]*user.User{}}
+	sessions := &memSessions{sessions: map[uuid.UUID]*session.Session{}}
+
+	login := testGithubUser
+	token := "ghp_test_token_not_real_000000"
+	u, _ := users.CreateUser(context.Background(), &user.User{
+		GoogleId:       "google-1",
+		GithubUsername: &login,
+		GithubToken:    &token,
+	})
+	s, _ := sessions.CreateSession(context.Background(), &session.Session{
+		UserId:    u.Id,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	server := llmtest.NewServer(responses...)
+	t.Cleanup(server.Close)
+
+	chunks := &memChunks{}
+	symbols := &memSymbols{
+		files: map[uuid.UUID][]repo_symbols.SymbolFile{},
+		defs:  map[uuid.UUID][]repo_symbols.Definition{},
+		refs:  map[uuid.UUID][]repo_symbols.Reference{},
+	}
+	cache := &memCache{entries: map[string]map[string]resolution_cache.Resolution{}}
+	index := &memIndex{rows: map[string]*repo_index.RepoIndex{}}
+	accepted := &memAccepted{index: index}
+	indexer := rag.NewIndexer(rag.NewLocalEmbedder(rag.EmbedDim), chunks, index, symbols)
+	ctx, cancel := context.WithCancel(context.Background())
+	indexer.Start(ctx, 1)
+	t.Cleanup(func() {
+		cancel()
+		indexer.Wait()
+	})
+
+	mergeSessions := &memMergeSessions{sessions: map[uuid.UUID]*merge_session.MergeSession{}}
+
+	engine := gin.New()
+	r := engine.Group("/api")
+	gemini.NewRouter(r, users, sessions, server.Provider(), chunks, rag.NewRetriever(chunks, rag.DefaultRetrievalWeights()), symbols, index, cache, accepted)
+	manager := mergesession.NewManager(mergeSessions, indexer)
+	github.NewRouter(r, users, sessions, indexer, accepted, manager)
+	file.NewRouter(r, users, sessions, manager)
+
+	return &harness{
+		t:        t,
+		engine:   engine,
+		llm:      server,
+		chunks:   chunks,
+		symbols:  symbols,
+		index:    index,
+		cache:    cache,
+		accepted: accepted,
+		merges:   mergeSessions,
+		users:    users,
+		sessions: sessions,
+		user:     u,
+		session:  s,
+		repoPath: filepath.Join("repos", u.Id.String(), testGithubUser, testRepoName),
+		origin:   "origin.git",
+	}
+}
+
+func (h *harness) git(dir string, args ...string) string {
+	h.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		h.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func (h *harness) writeFile(dir, name, content string) {
+	h.t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// setupConflict creates an origin repository and a clone where main.go was
+// changed differently on both sides, so the next fetch+merge conflicts.
+func (h *harness) setupConflict() {
+	h.t.Helper()
+	h.git(".", "init", "-q", "-b", "main", h.origin)
+	h.writeFile(h.origin, "main.go", baseFile)
+	h.writeFile(h.origin, "README.md", "# demo\n")
+	h.git(h.origin, "add", ".")
+	h.git(h.origin, "commit", "-q", "-m", "base")
+
+	if err := os.MkdirAll(filepath.Dir(h.repoPath), 0o755); err != nil {
+		h.t.Fatal(err)
+	}
+	h.git(".", "clone", "-q", h.origin, h.repoPath)
+
+	h.writeFile(h.origin, "main.go", theirsFile)
+	h.git(h.origin, "commit", "-q", "-am", "theirs")
+
+	h.writeFile(h.repoPath, "main.go", oursFile)
+	h.git(h.repoPath, "commit", "-q", "-am", "ours")
+}
+
+// startMerge starts a merge session of origin's main into the clone's main
+// and returns it.
+func (h *harness) startMerge() mergesession.State {
+	h.t.Helper()
+	w := h.do(http.MethodPost, "/api/github/merge/sessions", map[string]any{
+		"repoName":     testRepoName,
+		"sourceBranch": "main",
+		"targetBranch": "main",
+	})
+	if w.Code != http.StatusOK {
+		h.t.Fatalf("start merge: status %d: %s", w.Code, w.Body.String())
+	}
+	return decodeSession(h.t, w)
+}
+
+// newMergeHarness sets up the conflicting clone, commits files to it on top
+// of ours (parent directories are created) and starts a merge session of
+// origin's main.
+func newMergeHarness(t *testing.T, files map[string]string, responses ...string) (*harness, mergesession.State) {
+	t.Helper()
+	h := newHarness(t, responses...)
+	h.setupConflict()
+	if len(files) > 0 {
+		for name, content := range files {
+			path := filepath.Join(h.repoPath, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			h.writeFile(h.repoPath, name, content)
+		}
+		// the worktree only has what is committed
+		h.git(h.repoPath, "add", ".")
+		h.git(h.repoPath, "commit", "-q", "-m", "fixtures")
+	}
+	return h, h.startMerge()
+}
+
+// worktree is where a session's merge is checked out.
+func (h *harness) worktree(session mergesession.State) string {
+	return mergesession.WorktreePath(h.user.Id, session.Id)
+}
+
+func decodeSession(t *testing.T, w *httptest.ResponseRecorder) mergesession.State {
+	t.Helper()
+	var body struct {
+		Payload mergesession.State `json:"payload"`
+	}
+	body.Payload.MergeSession = &merge_session.MergeSession{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("merge session: %v: %s", err, w.Body.String())
+	}
+	return body.Payload
+}
+
+// login signs in another user with the given GitHub login.
+func (h *harness) login(githubLogin string) (*user.User, *session.Session) {
+	h.t.Helper()
+	ctx := context.Background()
+	u, err := h.users.CreateUser(ctx, &user.User{GoogleId: "google-" + githubLogin, GithubUsername: &githubLogin})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	s, err := h.sessions.CreateSession(ctx, &session.Session{UserId: u.Id, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return u, s
+}
+
+func (h *harness) do(method, path string, body any) *httptest.ResponseRecorder {
+	h.t.Helper()
+	return h.doAs(h.session, method, path, body)
+}
+
+// doAs sends a request in session s; a nil session sends it anonymously.
+func (h *harness) doAs(s *session.Session, method, path string, body any) *httptest.ResponseRecorder {
+	h.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if s != nil {
+		req.AddCookie(&http.Cookie{Name: "session", Value: s.Id.String()})
+	}
+
+	w := httptest.NewRecorder()
+	h.engine.ServeHTTP(w, req)
+	return w
+}
+
+func findFile(nodes []any, fullPath string) map[string]any {
+	for _, n := range nodes {
+		m, ok := n.(map[string]any)
+		if !ok {
+			continue
+		}
+		if m["type"] == "FILE" && m["fullPath"] == fullPath {
+			return m
+		}
+		if sub, ok := m["subDirectories"].([]any); ok {
+			if found := findFile(sub, fullPath); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// waitIndexed polls the index status endpoint until the current run ends.
+func (h *harness) waitIndexed() repo_index.RepoIndex {
+	h.t.Helper()
+	var status struct {
+		Payload repo_index.RepoIndex `json:"payload"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := h.do(http.MethodGet, "/api/github/index?repoName="+testRepoName, nil)
+		if w.Code != http.StatusOK {
+			h.t.Fatalf("index status: %d: %s", w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			h.t.Fatal(err)
+		}
+		if s := status.Payload.Status; s == repo_index.StatusDone || s == repo_index.StatusFailed {
+			return status.Payload
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("indexing did not finish, last status %q", status.Payload.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type sseEvent struct {
+	id    int
+	event string
+	data  string
+}
+
+func (e sseEvent) decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(e.data), v); err != nil {
+		t.Fatalf("%s event: %v: %s", e.event, err, e.data)
+	}
+}
+
+// readEvents parses a Server-Sent Events body, skipping comments.
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(body, "\n\n") {
+		var e sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				fmt.Sscan(value, &e.id)
+			case "event":
+				e.event = value
+			case "data":
+				e.data = value
+			}
+		}
+		if e.event != "" {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		t.Fatalf("no events in stream:\n%s", body)
+	}
+	return events
+}
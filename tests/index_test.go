@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+)
+
+// blockingEmbedder holds up the first EmbedDocuments until release is closed.
+type blockingEmbedder struct {
+	rag.Embedder
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (e *blockingEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float64, error) {
+	e.once.Do(func() {
+		close(e.started)
+		<-e.release
+	})
+	return e.Embedder.EmbedDocuments(ctx, texts)
+}
+
+func TestIndexQueuedAgainWhileRunningStaysPending(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	index := &memIndex{rows: map[string]*repo_index.RepoIndex{}}
+	symbols := &memSymbols{
+		files: map[uuid.UUID][]repo_symbols.SymbolFile{},
+		defs:  map[uuid.UUID][]repo_symbols.Definition{},
+		refs:  map[uuid.UUID][]repo_symbols.Reference{},
+	}
+	embedder := &blockingEmbedder{
+		Embedder: rag.NewLocalEmbedder(rag.EmbedDim),
+		started:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	indexer := rag.NewIndexer(embedder, &memChunks{}, index, symbols)
+	ctx, cancel := context.WithCancel(context.Background())
+	indexer.Start(ctx, 1)
+
+	job := rag.IndexJob{UserId: uuid.New(), Owner: testGithubUser, Repo: testRepoName, Path: dir}
+	if _, err := indexer.Enqueue(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	<-embedder.started
+	// a newer checkout is queued while the first run is still embedding
+	if _, err := index.MarkPending(context.Background(), job.UserId, job.Owner, job.Repo); err != nil {
+		t.Fatal(err)
+	}
+	close(embedder.release)
+	// the worker finishes the run it is in before stopping
+	cancel()
+	indexer.Wait()
+
+	row, _ := index.GetRepoIndex(context.Background(), job.UserId, job.Owner, job.Repo)
+	if row.Status != repo_index.StatusPending {
+		t.Errorf("the stale run overwrote the re-queued repo with %q", row.Status)
+	}
+}
+
+func TestMergeSessionIndexesWorkingTree(t *testing.T) {
+	h, session := newMergeHarness(t, map[string]string{
+		".gitignore":                "secret.env\n",
+		"secret.env":                "TOKEN=abc\n",
+		"logo.png":                  "\x89PNG\x00\x00binary",
+		"node_modules/dep/index.js": "module.exports = 1\n",
+	})
+	worktree := h.worktree(session)
+	index := h.waitIndexed()
+	if index.Status != repo_index.StatusDone || index.ChunkCount == 0 {
+		t.Fatalf("unexpected index status: %+v", index)
+	}
+
+	sources := h.chunks.sources()
+	slices.Sort(sources)
+	if want := []string{".gitignore", "README.md", "main.go"}; !slices.Equal(sources, want) {
+		t.Errorf("indexed sources = %v, want %v", sources, want)
+	}
+
+	// edit one file and delete another: only their rows change once the
+	// merge lands in the clone
+	h.git(worktree, "rm", "-q", "README.md")
+	w := h.do(http.MethodPost, "/api/github/merge/accept", map[string]any{
+		"newFileData": resolvedFile,
+		"fullPath":    "main.go",
+		"repoName":    testRepoName,
+		"sessionId":   session.Id,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("accept: status %d: %s", w.Code, w.Body.String())
+	}
+	w = h.do(http.MethodPost, "/api/github/merge/sessions/"+session.Id.String()+"/complete", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("complete: status %d: %s", w.Code, w.Body.String())
+	}
+	h.waitIndexed()
+
+	sources = h.chunks.sources()
+	slices.Sort(sources)
+	if want := []string{".gitignore", "main.go"}; !slices.Equal(sources, want) {
+		t.Errorf("indexed sources after edit = %v, want %v", sources, want)
+	}
+	blobs, _ := h.chunks.GetFileBlobs(context.Background(), index.Id)
+	for _, b := range blobs {
+		if b.Source == "main.go" && b.BlobSha != rag.BlobSha(resolvedFile) {
+			t.Errorf("main.go still indexed at blob %s", b.BlobSha)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tahminator/go-react-template/gitrepo"
+)
+
+func TestRebaseAndCherryPickConflictsAreDriven(t *testing.T) {
+	h := newHarness(t, resolvedHunk)
+	h.setupConflict()
+	h.writeFile(h.repoPath, "NOTES.md", "notes\n")
+	h.git(h.repoPath, "add", "NOTES.md")
+	h.git(h.repoPath, "commit", "-q", "-m", "notes")
+	h.git(h.repoPath, "fetch", "-q", "origin")
+
+	operation := func() map[string]any {
+		t.Helper()
+		w := h.do(http.MethodGet, "/api/github/operation?repoName="+testRepoName, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("operation: status %d: %s", w.Code, w.Body.String())
+		}
+		var body struct {
+			Payload map[string]any `json:"payload"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Payload
+	}
+	if op := operation(); op != nil {
+		t.Fatalf("nothing should be in progress: %v", op)
+	}
+
+	// the first of two commits stops on conflicts
+	h.gitStops(h.repoPath, "rebase", "origin/main")
+	op := operation()
+	if op["operation"] != "rebase" || op["step"] != 1.0 || op["total"] != 2.0 || op["branch"] != "main" ||
+		!slices.Equal(op["conflicts"].([]any), []any{"main.go"}) {
+		t.Fatalf("rebase state: %v", op)
+	}
+
+	w := h.do(http.MethodGet, "/api/file/conflict/main.go?repoName="+testRepoName, nil)
+	var sides struct {
+		Payload gitrepo.Conflict `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &sides); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("conflict: status %d: %s", w.Code, w.Body.String())
+	}
+	// the commit being replayed is theirs, applied onto its parent
+	if s := sides.Payload.Theirs; s == nil || s.Content != oursFile || s.Commit == nil || s.Commit.Message != "ours" {
+		t.Errorf("theirs side: %+v", s)
+	}
+	if s := sides.Payload.Base; s == nil || s.Commit == nil || s.Commit.Message != "base" {
+		t.Errorf("base side: %+v", s)
+	}
+
+	conflicted, _ := os.ReadFile(filepath.Join(h.repoPath, "main.go"))
+	w = h.do(http.MethodPost, "/api/gemini/resolve-hunks", map[string]any{
+		"conflict_content": string(conflicted),
+		"file_path":        "main.go",
+		"operation":        "rebase",
+	})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `hi, \" + name + \"!`) {
+		t.Fatalf("resolve-hunks: status %d: %s", w.Code, w.Body.String())
+	}
+	if reqs := h.llm.Requests(); len(reqs) != 1 || !strings.Contains(reqs[0].Prompt, "This conflict stopped a rebase") {
+		t.Errorf("prompt should describe the rebase sides: %+v", reqs)
+	}
+
+	if w := h.do(http.MethodPost, "/api/github/operation/continue", map[string]any{"repoName": testRepoName}); w.Code != http.StatusConflict {
+		t.Errorf("continue with conflicts: status %d: %s", w.Code, w.Body.String())
+	}
+	w = h.do(http.MethodPost, "/api/github/merge/accept", map[string]any{
+		"newFileData": resolvedFile,
+		"fullPath":    "main.go",
+		"repoName":    testRepoName,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("accept: status %d: %s", w.Code, w.Body.String())
+	}
+	w = h.do(http.MethodPost, "/api/github/operation/continue", map[string]any{"repoName": testRepoName})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"payload":null`) {
+		t.Fatalf("continue: status %d: %s", w.Code, w.Body.String())
+	}
+	if log := h.git(h.repoPath, "log", "--format=%s", "-4"); log != "notes\nours\ntheirs\nbase\n" {
+		t.Errorf("rebased history:\n%s", log)
+	}
+
+	// declining a cherry-pick aborts it without touching history
+	h.writeFile(h.repoPath, "main.go", oursFile)
+	h.git(h.repoPath, "commit", "-q", "-am", "again")
+	head := strings.TrimSpace(h.git(h.repoPath, "rev-parse", "HEAD"))
+	h.gitStops(h.repoPath, "cherry-pick", "origin/main")
+	if op := operation(); op["operation"] != "cherry-pick" || op["remaining"] != 0.0 {
+		t.Fatalf("cherry-pick state: %v", op)
+	}
+	w = h.do(http.MethodPost, "/api/github/merge/decline", map[string]any{"repoName": testRepoName})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "cherry-pick-abort") {
+		t.Fatalf("decline: status %d: %s", w.Code, w.Body.String())
+	}
+	if got := strings.TrimSpace(h.git(h.repoPath, "rev-parse", "HEAD")); got != head {
+		t.Errorf("decline moved HEAD from %s to %s", head, got)
+	}
+	if w := h.do(http.MethodPost, "/api/github/merge/decline", map[string]any{"repoName": testRepoName}); w.Code != http.StatusConflict {
+		t.Errorf("decline with nothing in progress: status %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tahminator/go-react-template/api/gemini"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+)
+
+func TestResolveConflictsFileStream(t *testing.T) {
+	h, session := newMergeHarness(t, nil, resolvedFile)
+
+	conflicted, err := os.ReadFile(filepath.Join(h.worktree(session), "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(conflicted), "<<<<<<<") {
+		t.Fatalf("expected conflict markers in working tree:\n%s", conflicted)
+	}
+
+	w := h.do(http.MethodPost, "/api/gemini/resolve-conflicts-file-stream", map[string]any{
+		"conflict_content": string(conflicted),
+		"file_path":        "main.go",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream: status %d: %s", w.Code, w.Body.String())
+	}
+	events := readEvents(t, w.Body.String())
+	var streamed strings.Builder
+	for _, e := range events {
+		if e.event == gemini.EventToken {
+			var token gemini.TokenEvent
+			e.decode(t, &token)
+			streamed.WriteString(token.Text)
+		}
+	}
+	if got := streamed.String(); got != resolvedFile {
+		t.Errorf("streamed resolution mismatch:\n got: %q\nwant: %q", got, resolvedFile)
+	}
+
+	last := events[len(events)-1]
+	if last.event != gemini.EventDone {
+		t.Fatalf("stream must end with done, got %q", last.event)
+	}
+	var done gemini.DoneEvent
+	last.decode(t, &done)
+	if done.Content != resolvedFile {
+		t.Errorf("done content:\n%s", done.Content)
+	}
+	for i, e := range events {
+		if e.id != i+1 {
+			t.Errorf("event %d has id %d", i, e.id)
+		}
+	}
+	if !slices.ContainsFunc(events, func(e sseEvent) bool { return e.event == gemini.EventValidation }) {
+		t.Errorf("expected a validation event, got %+v", events)
+	}
+
+	reqs := h.llm.Requests()
+	if len(reqs) != 1 || !reqs[0].Stream {
+		t.Fatalf("expected one streaming completion request, got %+v", reqs)
+	}
+	if !strings.Contains(reqs[0].Prompt, `return "hi " + name`) || !strings.Contains(reqs[0].Prompt, `return "hello, " + name + "!"`) {
+		t.Errorf("prompt is missing one side of the conflict:\n%s", reqs[0].Prompt)
+	}
+}
+
+func TestResolveOnlyDrawsOnTheCallersRepo(t *testing.T) {
+	h, _ := newMergeHarness(t, nil, resolvedHunk)
+	index := h.waitIndexed()
+	_, stranger := h.login("stranger")
+
+	body := map[string]any{
+		"conflict_content": conflictedFile,
+		"file_path":        "main.go",
+		"repo_id":          index.Id.String(),
+	}
+	for _, path := range []string{
+		"/api/gemini/resolve-conflicts-file",
+		"/api/gemini/resolve-conflicts-file-stream",
+		"/api/gemini/resolve-hunks",
+		"/api/gemini/resolve-hunks-stream",
+	} {
+		if w := h.doAs(nil, http.MethodPost, path, body); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s anonymously: status %d", path, w.Code)
+		}
+		if w := h.doAs(stranger, http.MethodPost, path, body); w.Code != http.StatusNotFound {
+			t.Errorf("%s with another user's repo: status %d", path, w.Code)
+		}
+	}
+	if n := len(h.llm.Requests()); n != 0 {
+		t.Fatalf("refused requests reached the model %d time(s)", n)
+	}
+
+	if w := h.do(http.MethodPost, "/api/gemini/resolve-hunks", body); w.Code != http.StatusOK {
+		t.Errorf("the owner's request: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestResolveStreamRetrievesIdentifierMatches(t *testing.T) {
+	h, session := newMergeHarness(t, map[string]string{
+		"names.go": `package demo
+
+// formatName trims a name before it is greeted.
+func formatName(name string) string {
+	return strings.TrimSpace(name)
+}
+`,
+		"notes.txt": "unrelated text about nothing in particular\n",
+	}, resolvedFile)
+	index := h.waitIndexed()
+
+	conflicted, err := os.ReadFile(filepath.Join(h.worktree(session), "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := h.do(http.MethodPost, "/api/gemini/resolve-conflicts-file-stream", map[string]any{
+		"conflict_content": string(conflicted),
+		"file_path":        "main.go",
+		"repo_id":          index.Id.String(),
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream: status %d: %s", w.Code, w.Body.String())
+	}
+
+	reqs := h.llm.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected one completion request, got %d", len(reqs))
+	}
+	// the hunks mention name, so the helper declaring it is retrieved
+	// even though the fake store does no vector search
+	if !strings.Contains(reqs[0].Prompt, "from names.go:3-6 (formatName)") {
+		t.Errorf("prompt is missing the identifier match:\n%s", reqs[0].Prompt)
+	}
+	if strings.Contains(reqs[0].Prompt, "notes.txt") {
+		t.Errorf("prompt includes an unrelated file:\n%s", reqs[0].Prompt)
+	}
+}
+
+func TestResolveStreamIncludesCallersOfChangedFunction(t *testing.T) {
+	h, session := newMergeHarness(t, map[string]string{
+		"welcome.go": `package demo
+
+// Welcome greets every guest.
+func Welcome(guests []string) []string {
+	var out []string
+	for _, g := range guests {
+		out = append(out, Greet(g))
+	}
+	return out
+}
+`,
+	}, resolvedFile)
+	index := h.waitIndexed()
+	if index.Status != repo_index.StatusDone {
+		t.Fatalf("unexpected index status: %+v", index)
+	}
+
+	// main.go is mid-conflict but still yields its ours-side definitions
+	defs, _ := h.symbols.GetDefinitions(context.Background(), index.Id, []string{"Greet"}, 10)
+	if len(defs) != 1 || defs[0].Source != "main.go" || defs[0].LineStart != 3 {
+		t.Fatalf("Greet definitions = %+v", defs)
+	}
+
+	conflicted, err := os.ReadFile(filepath.Join(h.worktree(session), "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := h.do(http.MethodPost, "/api/gemini/resolve-conflicts-file-stream", map[string]any{
+		"conflict_content": string(conflicted),
+		"file_path":        "main.go",
+		"repo_id":          index.Id.String(),
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream: status %d: %s", w.Code, w.Body.String())
+	}
+
+	reqs := h.llm.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected one completion request, got %d", len(reqs))
+	}
+	if want := "- welcome.go:7 in Welcome uses Greet: out = append(out, Greet(g))"; !strings.Contains(reqs[0].Prompt, want) {
+		t.Errorf("prompt is missing the call site %q:\n%s", want, reqs[0].Prompt)
+	}
+}
+
+func TestResolveStreamKeepsPromptWithinBudget(t *testing.T) {
+	// every helper mentions name, so all of them are retrieved, and each is
+	// far too large for more than a couple to fit
+	helpers := map[string]string{}
+	for i := range 5 {
+		var body strings.Builder
+		fmt.Fprintf(&body, "package demo\n\nfunc helper%d(name string) string {\n", i)
+		for j := range 60 {
+			fmt.Fprintf(&body, "\tname = name + %q\n", fmt.Sprint(j))
+		}
+		body.WriteString("\treturn name\n}\n")
+		helpers[fmt.Sprintf("helper%d.go", i)] = body.String()
+	}
+	h, session := newMergeHarness(t, helpers, resolvedFile)
+	index := h.waitIndexed()
+
+	conflicted, err := os.ReadFile(filepath.Join(h.worktree(session), "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const budget = gemini.MinPromptBudget
+	w := h.do(http.MethodPost, "/api/gemini/resolve-conflicts-file-stream", map[string]any{
+		"conflict_content": string(conflicted),
+		"file_path":        "main.go",
+		"repo_id":          index.Id.String(),
+		"prompt_budget":    budget,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var stats gemini.PromptStats
+	for _, e := range readEvents(t, w.Body.String()) {
+		if e.event != gemini.EventProgress {
+			continue
+		}
+		var progress gemini.ProgressEvent
+		e.decode(t, &progress)
+		if progress.Prompt != nil {
+			stats = *progress.Prompt
+		}
+	}
+	if stats.Budget != budget || stats.Tokens > budget {
+		t.Errorf("prompt stats over budget: %+v", stats)
+	}
+
+	var retrieved *gemini.PromptSection
+	for i, s := range stats.Sections {
+		if s.Name == gemini.SectionRetrieved {
+			retrieved = &stats.Sections[i]
+		}
+	}
+	if retrieved == nil || retrieved.Items == 0 || retrieved.Dropped == 0 {
+		t.Fatalf("expected retrieved context to be trimmed, got %+v", stats.Sections)
+	}
+
+	reqs := h.llm.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected one completion request, got %d", len(reqs))
+	}
+	if got := gemini.EstimateTokens(reqs[0].Prompt); got > budget {
+		t.Errorf("prompt is %d tokens, over the %d budget", got, budget)
+	}
+	if !strings.Contains(reqs[0].Prompt, `return "hi " + name`) {
+		t.Errorf("the conflict itself must never be trimmed:\n%s", reqs[0].Prompt)
+	}
+}
@@ -13,8 +13,13 @@ import (
 	"github.com/tahminator/go-react-template/api/gemini"
 	"github.com/tahminator/go-react-template/database"
 	"github.com/tahminator/go-react-template/database/rag"
+	"github.com/tahminator/go-react-template/database/repository/accepted_resolutions"
 	"github.com/tahminator/go-react-template/database/repository/repo_chunks"
-	"google.golang.org/genai"
+	"github.com/tahminator/go-react-template/database/repository/repo_index"
+	"github.com/tahminator/go-react-template/database/repository/repo_symbols"
+	"github.com/tahminator/go-react-template/database/repository/resolution_cache"
+	"github.com/tahminator/go-react-template/database/repository/user"
+	"github.com/tahminator/go-react-template/llm"
 )
 
 func main() {
@@ -45,36 +50,52 @@ func main() {
 		Content: string(conflictText),
 	}
 
-	fmt.Println("=== RAG Workflow Test ===")
-	fmt.Println()
+	provider, err := llm.NewFromEnv(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create LLM provider: %v", err)
+	}
 
-	fmt.Println("1. Embedding repository...")
-	result, err := rag.EmbedRepoPgVector(ctx, []rag.FileContent{fileContent})
+	embedder, err := rag.NewEmbedderFromEnv(provider)
 	if err != nil {
-		log.Fatalf("Failed to embed repository: %v", err)
+		log.Fatalf("Failed to create embedder: %v", err)
 	}
-	fmt.Printf("Result: %s\n", result)
 
-	repoHash := rag.RepoHash([]rag.FileContent{fileContent})
-	fmt.Printf("Repository hash: %s\n", repoHash)
+	fmt.Println("=== RAG Workflow Test ===")
 	fmt.Println()
 
-	geminiClient, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey: apiKey,
-	})
+	pool, err := database.GetPool()
 	if err != nil {
-		log.Fatalf("Failed to create Gemini client: %v", err)
+		log.Fatalf("Failed to get database pool: %v", err)
 	}
 
-	pool, err := database.GetPool()
+	// chunks belong to an indexed repo, which belongs to a user
+	testUser, err := user.NewPostgresUserRepository(pool).GetUserByGoogleId(ctx, "test-rag")
 	if err != nil {
-		log.Fatalf("Failed to get database pool: %v", err)
+		testUser, err = user.NewPostgresUserRepository(pool).CreateUser(ctx, &user.User{GoogleId: "test-rag"})
+		if err != nil {
+			log.Fatalf("Failed to create test user: %v", err)
+		}
+	}
+	repoIndex, err := repo_index.NewPostgresRepoIndexRepository(pool).MarkPending(ctx, testUser.Id, "local", "testRepo")
+	if err != nil {
+		log.Fatalf("Failed to register test repo: %v", err)
 	}
-	repoChunksRepo := repo_chunks.NewPostgresRepoChunksRepository(pool)
-	geminiService := gemini.NewGeminiService(geminiClient, repoChunksRepo)
+	repoId := repoIndex.Id
+
+	fmt.Println("1. Embedding repository...")
+	result, err := rag.EmbedRepoPgVector(ctx, embedder, repoId, []rag.FileContent{fileContent})
+	if err != nil {
+		log.Fatalf("Failed to embed repository: %v", err)
+	}
+	fmt.Printf("Result: %s\n", result)
+	fmt.Printf("Repository id: %s\n", repoId)
+	fmt.Println()
+
+	repoChunksRepo := repo_chunks.NewPostgresRepoChunksRepository(pool, embedder)
+	geminiService := gemini.NewGeminiService(provider, repoChunksRepo, rag.NewRetriever(repoChunksRepo, rag.DefaultRetrievalWeights()), repo_symbols.NewPostgresRepoSymbolsRepository(pool), repo_index.NewPostgresRepoIndexRepository(pool), resolution_cache.NewPostgresResolutionCacheRepository(pool), accepted_resolutions.NewPostgresAcceptedResolutionsRepository(pool, embedder))
 
 	fmt.Println("2. Resolving merge conflicts to generate new file...")
-	resolvedContent, err := geminiService.ResolveConflictsToFile(ctx, string(conflictText), "testRepo/main.go", "resolve all merge conflicts in this Go code", repoHash)
+	resolvedContent, err := geminiService.ResolveConflictsToFile(ctx, string(conflictText), "testRepo/main.go", "resolve all merge conflicts in this Go code", repoIndex)
 	if err != nil {
 		log.Fatalf("Failed to resolve conflicts: %v", err)
 	}
@@ -94,7 +115,7 @@ func main() {
 	fmt.Println()
 
 	fmt.Println("3. Testing semantic search resolution...")
-	semanticResolved, err := geminiService.ResolveConflictsToFile(ctx, string(conflictText), "testRepo/main.go", "fix user validation conflicts", repoHash)
+	semanticResolved, err := geminiService.ResolveConflictsToFile(ctx, string(conflictText), "testRepo/main.go", "fix user validation conflicts", repoIndex)
 	if err != nil {
 		log.Printf("Semantic search resolution failed: %v", err)
 	} else {
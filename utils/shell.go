@@ -8,6 +8,10 @@ import (
 	"time"
 )
 
+// RunCommand runs cmdStr through bash and prints it and its output.
+//
+// Deprecated: build git commands with gitexec, which takes an argv slice and
+// redacts credentials from what it logs.
 func RunCommand(cmdStr string) (int, string, string, error) {
 	var stdout, stderr bytes.Buffer
 
@@ -0,0 +1,179 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	yamlparser "github.com/goccy/go-yaml/parser"
+	"github.com/tahminator/go-react-template/conflict"
+)
+
+const (
+	SourceMarkers = "markers"
+	SourceGo      = "go"
+	SourceJSON    = "json"
+	SourceYAML    = "yaml"
+)
+
+type Diagnostic struct {
+	Source  string `json:"source"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+type Result struct {
+	Path        string       `json:"path"`
+	Language    string       `json:"language"`
+	Valid       bool         `json:"valid"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Validate checks merged file content before it is staged. Leftover conflict
+// markers are rejected for every file; Go, JSON and YAML are additionally parsed.
+// Other languages only get the marker check.
+func Validate(path, content string) Result {
+	res := Result{
+		Path:        path,
+		Language:    Language(path),
+		Diagnostics: []Diagnostic{},
+	}
+
+	res.Diagnostics = append(res.Diagnostics, checkMarkers(content)...)
+
+	switch res.Language {
+	case SourceGo:
+		res.Diagnostics = append(res.Diagnostics, checkGo(path, content)...)
+	case SourceJSON:
+		res.Diagnostics = append(res.Diagnostics, checkJSON(content)...)
+	case SourceYAML:
+		res.Diagnostics = append(res.Diagnostics, checkYAML(content)...)
+	}
+
+	res.Valid = len(res.Diagnostics) == 0
+	return res
+}
+
+// Language returns the checker name for a path, or "" when only markers are checked.
+func Language(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return SourceGo
+	case ".json":
+		return SourceJSON
+	case ".yaml", ".yml":
+		return SourceYAML
+	default:
+		return ""
+	}
+}
+
+// checkMarkers uses the parser's own notion of a marker line, so whatever
+// validation accepts the parser reads as clean text. A lone ======= is valid
+// text (e.g. a markdown heading underline) and is only flagged when another
+// marker is present too.
+func checkMarkers(content string) []Diagnostic {
+	if !conflict.HasMarkers(content) {
+		return nil
+	}
+	var diags []Diagnostic
+	for i, line := range strings.Split(content, "\n") {
+		if m := conflict.LineMarker(line); m != "" {
+			diags = append(diags, Diagnostic{
+				Source:  SourceMarkers,
+				Line:    i + 1,
+				Column:  1,
+				Message: "leftover conflict marker " + m,
+			})
+		}
+	}
+	return diags
+}
+
+func checkGo(path, content string) []Diagnostic {
+	fset := token.NewFileSet()
+	_, err := parser.ParseFile(fset, path, content, parser.AllErrors)
+	if err == nil {
+		return nil
+	}
+
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		list.RemoveMultiples()
+		diags := make([]Diagnostic, 0, len(list))
+		for _, e := range list {
+			diags = append(diags, Diagnostic{
+				Source:  SourceGo,
+				Line:    e.Pos.Line,
+				Column:  e.Pos.Column,
+				Message: e.Msg,
+			})
+		}
+		return diags
+	}
+	return []Diagnostic{{Source: SourceGo, Line: 1, Message: err.Error()}}
+}
+
+func checkJSON(content string) []Diagnostic {
+	dec := json.NewDecoder(strings.NewReader(content))
+	var v any
+	err := dec.Decode(&v)
+	if err == nil {
+		// anything but whitespace after the first value is an error too,
+		// reported where that data starts
+		if rest := bytes.TrimLeft(remaining(dec, content), " \t\r\n"); len(rest) > 0 {
+			line, col := lineCol(content, len(content)-len(rest))
+			return []Diagnostic{{Source: SourceJSON, Line: line, Column: col, Message: "unexpected data after top-level value"}}
+		}
+		return nil
+	}
+
+	offset := len(content)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// Offset counts the bytes read, including the offending one
+		offset = max(0, int(syntaxErr.Offset)-1)
+	}
+	line, col := lineCol(content, offset)
+	return []Diagnostic{{Source: SourceJSON, Line: line, Column: col, Message: err.Error()}}
+}
+
+func remaining(dec *json.Decoder, content string) []byte {
+	off := int(dec.InputOffset())
+	if off >= len(content) {
+		return nil
+	}
+	return []byte(content[off:])
+}
+
+func checkYAML(content string) []Diagnostic {
+	_, err := yamlparser.ParseBytes([]byte(content), 0)
+	if err == nil {
+		return nil
+	}
+
+	var yErr yaml.Error
+	if errors.As(err, &yErr) && yErr.GetToken() != nil && yErr.GetToken().Position != nil {
+		pos := yErr.GetToken().Position
+		return []Diagnostic{{Source: SourceYAML, Line: pos.Line, Column: pos.Column, Message: yErr.GetMessage()}}
+	}
+	return []Diagnostic{{Source: SourceYAML, Line: 1, Message: err.Error()}}
+}
+
+// lineCol converts a byte offset into a 1-based line and column.
+func lineCol(content string, offset int) (int, int) {
+	if offset > len(content) {
+		offset = len(content)
+	}
+	before := content[:offset]
+	line := strings.Count(before, "\n") + 1
+	col := offset - strings.LastIndex(before, "\n")
+	return line, col
+}
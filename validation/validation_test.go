@@ -0,0 +1,146 @@
+package validation
+
+import (
+	"slices"
+	"testing"
+)
+
+// at is where a diagnostic points; 0 columns are not compared.
+type at struct {
+	line, column int
+}
+
+func positions(diags []Diagnostic, source string) ([]at, bool) {
+	var got []at
+	for _, d := range diags {
+		if d.Source != source || d.Message == "" {
+			return nil, false
+		}
+		got = append(got, at{d.Line, d.Column})
+	}
+	return got, true
+}
+
+func samePositions(got, want []at) bool {
+	return slices.EqualFunc(got, want, func(g, w at) bool {
+		return g.line == w.line && (w.column == 0 || g.column == w.column)
+	})
+}
+
+func TestCheckMarkers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []at
+	}{
+		{"clean", "package main\n\nfunc main() {}\n", nil},
+		{"empty", "", nil},
+		{"lone separator", "Title\n=======\n", nil},
+		{"marker mid-line", "x := \"<<<<<<< HEAD\"\n", nil},
+		{"too few characters", "<<<<<< HEAD\n======\n>>>>>> feature\n", nil},
+		{"conflict", "a\n<<<<<<< HEAD\nx\n=======\ny\n>>>>>>> feature\nb\n", []at{{2, 1}, {4, 1}, {6, 1}}},
+		{"crlf", "<<<<<<< HEAD\r\nx\r\n=======\r\ny\r\n>>>>>>> feature\r\n", []at{{1, 1}, {3, 1}, {5, 1}}},
+		{"diff3 base left behind", "x\n||||||| merged common ancestors\nbase\n", []at{{2, 1}}},
+		{"diff3 conflict", "<<<<<<< HEAD\nx\n||||||| base\nb\n=======\ny\n>>>>>>> feature\n", []at{{1, 1}, {3, 1}, {5, 1}, {7, 1}}},
+		{"separator next to a marker", "=======\ny\n>>>>>>> feature\n", []at{{1, 1}, {3, 1}}},
+		{"unlabelled end", "x\n>>>>>>>\n", []at{{2, 1}}},
+	} {
+		got, ok := positions(checkMarkers(tc.content), SourceMarkers)
+		if !ok || !samePositions(got, tc.want) {
+			t.Errorf("%s: diagnostics %+v, want markers at %v", tc.name, checkMarkers(tc.content), tc.want)
+		}
+	}
+}
+
+func TestCheckGo(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []at
+	}{
+		{"valid", "package main\n\nfunc main() {}\n", nil},
+		{"generics", "package p\n\nfunc Map[T, U any](s []T, f func(T) U) []U { return nil }\n", nil},
+		{"no package clause", "func main() {}\n", []at{{1, 1}}},
+		{"unclosed brace", "package main\n\nfunc main() {\n", []at{{3, 15}}},
+		{"bad statement", "package main\n\nfunc main() {\n\tx := \n}\n", []at{{5, 1}}},
+		{"conflict markers", "package main\n<<<<<<< HEAD\nvar x = 1\n=======\nvar x = 2\n>>>>>>> feature\n", []at{{2, 1}, {4, 1}, {6, 1}}},
+	} {
+		diags := checkGo("main.go", tc.content)
+		got, ok := positions(diags, SourceGo)
+		if !ok || !samePositions(got, tc.want) {
+			t.Errorf("%s: diagnostics %+v, want errors at %v", tc.name, diags, tc.want)
+		}
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []at
+	}{
+		{"object", "{\"a\": [1, 2, {\"b\": null}]}\n", nil},
+		{"scalar", "42", nil},
+		{"trailing whitespace", "{}\n\n  \n", nil},
+		{"empty", "", []at{{1, 1}}},
+		{"trailing comma", "{\n  \"a\": 1,\n}\n", []at{{3, 1}}},
+		{"second value", "{}\n{}\n", []at{{2, 1}}},
+		{"unterminated", "{\"a\": [1, 2\n", []at{{2, 1}}},
+		{"conflict markers", "{\n<<<<<<< HEAD\n  \"a\": 1\n=======\n  \"a\": 2\n>>>>>>> feature\n}\n", []at{{2, 1}}},
+	} {
+		diags := checkJSON(tc.content)
+		got, ok := positions(diags, SourceJSON)
+		if !ok || !samePositions(got, tc.want) {
+			t.Errorf("%s: diagnostics %+v, want errors at %v", tc.name, diags, tc.want)
+		}
+	}
+}
+
+func TestCheckYAML(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []at
+	}{
+		{"mapping", "a: 1\nb:\n  - x\n  - y\n", nil},
+		{"documents", "a: 1\n---\nb: 2\n", nil},
+		{"empty", "", nil},
+		{"bad indentation", "a:\n  b: 1\n c: 2\n", []at{{3, 0}}},
+		{"unclosed flow", "a: [1, 2\nb: 3\n", []at{{2, 1}}},
+		{"tab indentation", "a:\n\tb: 1\n", []at{{2, 0}}},
+	} {
+		diags := checkYAML(tc.content)
+		got, ok := positions(diags, SourceYAML)
+		if !ok || !samePositions(got, tc.want) {
+			t.Errorf("%s: diagnostics %+v, want errors at %v", tc.name, diags, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		path, content string
+		language      string
+		sources       []string
+	}{
+		{"main.go", "package main\n", SourceGo, nil},
+		{"config.JSON", "{}", SourceJSON, nil},
+		{"ci.yml", "on: push\n", SourceYAML, nil},
+		{"README.md", "# Title\n", "", nil},
+		{"README.md", "<<<<<<< HEAD\na\n=======\nb\n>>>>>>> feature\n", "", []string{SourceMarkers, SourceMarkers, SourceMarkers}},
+		{"main.go", "package main\n||||||| base\n", SourceGo, []string{SourceMarkers, SourceGo}},
+		{"data.json", "{", SourceJSON, []string{SourceJSON}},
+	} {
+		res := Validate(tc.path, tc.content)
+		var sources []string
+		for _, d := range res.Diagnostics {
+			sources = append(sources, d.Source)
+		}
+		if res.Path != tc.path || res.Language != tc.language || res.Valid != (len(tc.sources) == 0) || !slices.Equal(sources, tc.sources) {
+			t.Errorf("Validate(%q, %q) = %+v, want %s diagnostics %v", tc.path, tc.content, res, tc.language, tc.sources)
+		}
+		if res.Diagnostics == nil {
+			t.Errorf("Validate(%q): diagnostics must encode as [], not null", tc.path)
+		}
+	}
+}